@@ -0,0 +1,146 @@
+// Package asciicast reads and writes terminal recordings in the asciicast v2
+// format, which asciinema and most other players understand.
+package asciicast
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	Output = "o"
+	Input  = "i"
+	// Data is the new size as "COLSxROWS".
+	Resize = "r"
+	Marker = "m"
+)
+
+// Header is the first line of a recording.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Event is one line after the header, stored as [time, type, data].
+type Event struct {
+	// Seconds since the start of the recording.
+	Time float64
+	Type string
+	Data string
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Time, e.Type, e.Data})
+}
+
+func (e *Event) UnmarshalJSON(data []byte) error {
+	fields := []json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("event has %d fields, want 3", len(fields))
+	}
+	if err := json.Unmarshal(fields[0], &e.Time); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(fields[1], &e.Type); err != nil {
+		return err
+	}
+	return json.Unmarshal(fields[2], &e.Data)
+}
+
+// Writer appends events to a recording as they happen.
+type Writer struct {
+	mu    sync.Mutex
+	out   io.Writer
+	start time.Time
+}
+
+// NewWriter writes the header and starts the clock. A zero header
+// timestamp is filled in with the current time.
+func NewWriter(out io.Writer, header Header) (*Writer, error) {
+	start := time.Now()
+	header.Version = 2
+	if header.Timestamp == 0 {
+		header.Timestamp = start.Unix()
+	}
+	line, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(append(line, '\n')); err != nil {
+		return nil, err
+	}
+	return &Writer{out: out, start: start}, nil
+}
+
+// WriteEvent records data as an event of the given type, timed now.
+func (w *Writer) WriteEvent(eventType string, data string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	line, err := json.Marshal(Event{
+		// Microseconds are plenty, and keep the file readable.
+		Time: math.Round(time.Since(w.start).Seconds()*1e6) / 1e6,
+		Type: eventType,
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.out.Write(append(line, '\n'))
+	return err
+}
+
+// Recording is a whole recording read back into memory.
+type Recording struct {
+	Header Header
+	Events []Event
+}
+
+// Read parses a recording. A truncated last line, as left behind when the
+// server stops mid-session, is ignored.
+func Read(r io.Reader) (*Recording, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("empty recording")
+	}
+	rec := &Recording{Events: []Event{}}
+	if err := json.Unmarshal(scanner.Bytes(), &rec.Header); err != nil {
+		return nil, fmt.Errorf("bad header: %w", err)
+	}
+	if rec.Header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", rec.Header.Version)
+	}
+	var pending error
+	for scanner.Scan() {
+		if pending != nil {
+			return nil, pending
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		event := Event{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			pending = fmt.Errorf("bad event: %w", err)
+			continue
+		}
+		rec.Events = append(rec.Events, event)
+	}
+	return rec, scanner.Err()
+}
@@ -0,0 +1,106 @@
+package asciicast
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWriteRead(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Header{Width: 100, Height: 30, Title: "root@host", Env: map[string]string{"TERM": "xterm"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []Event{
+		{Type: Output, Data: "$ "},
+		{Type: Input, Data: "ls\r"},
+		{Type: Output, Data: "\x1b[01;34mdir\x1b[0m\r\n"},
+		{Type: Resize, Data: "120x40"},
+	}
+	for _, event := range events {
+		if err := w.WriteEvent(event.Type, event.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Header.Version != 2 || rec.Header.Width != 100 || rec.Header.Height != 30 || rec.Header.Timestamp == 0 {
+		t.Errorf("header read back as %+v", rec.Header)
+	}
+	if len(rec.Events) != len(events) {
+		t.Fatalf("%d events read back, want %d", len(rec.Events), len(events))
+	}
+	last := 0.0
+	for i, event := range rec.Events {
+		if event.Type != events[i].Type || event.Data != events[i].Data {
+			t.Errorf("event %d read back as %+v, want %+v", i, event, events[i])
+		}
+		if event.Time < last {
+			t.Errorf("event %d at %f, before the one ahead of it", i, event.Time)
+		}
+		last = event.Time
+	}
+}
+
+func TestEventJSON(t *testing.T) {
+	tests := []struct {
+		json    string
+		want    Event
+		wantErr bool
+	}{
+		{`[0.5, "o", "hello"]`, Event{0.5, Output, "hello"}, false},
+		{`[12, "r", "80x24"]`, Event{12, Resize, "80x24"}, false},
+		{`[0.5, "o"]`, Event{}, true},
+		{`["0.5", "o", "x"]`, Event{}, true},
+		{`{"time": 0.5}`, Event{}, true},
+	}
+	for _, test := range tests {
+		event := Event{}
+		err := json.Unmarshal([]byte(test.json), &event)
+		if (err != nil) != test.wantErr || (err == nil && event != test.want) {
+			t.Errorf("%s read as %+v, %v, want %+v, error %v", test.json, event, err, test.want, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		out, _ := json.Marshal(event)
+		again := Event{}
+		if json.Unmarshal(out, &again); again != event {
+			t.Errorf("%s doesn't survive a round trip: %s", test.json, out)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	header := `{"version":2,"width":80,"height":24}` + "\n"
+	tests := []struct {
+		name       string
+		recording  string
+		wantEvents int
+		wantErr    bool
+	}{
+		{"header only", header, 0, false},
+		{"events", header + `[0.1,"o","a"]` + "\n" + `[0.2,"o","b"]` + "\n", 2, false},
+		{"blank lines", header + "\n" + `[0.1,"o","a"]` + "\n\n", 1, false},
+		{"truncated last line", header + `[0.1,"o","a"]` + "\n" + `[0.2,"o","b`, 1, false},
+		{"bad line in the middle", header + `[0.1,"o"` + "\n" + `[0.2,"o","b"]` + "\n", 0, true},
+		{"empty", "", 0, true},
+		{"bad header", "not json\n", 0, true},
+		{"version 1", `{"version":1}` + "\n", 0, true},
+	}
+	for _, test := range tests {
+		rec, err := Read(strings.NewReader(test.recording))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err == nil && len(rec.Events) != test.wantEvents {
+			t.Errorf("%s: %d events, want %d", test.name, len(rec.Events), test.wantEvents)
+		}
+	}
+}
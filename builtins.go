@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/honeystats/ssh/files"
+)
+
+func init() {
+	registerCommand(clearCommand{commandSpec{
+		name:       "clear",
+		dir:        binDir,
+		completion: completeNothing,
+		help:       "clear [-x]\nClear the terminal screen.\n",
+	}})
+	registerCommand(pwdCommand{commandSpec{
+		name:       "pwd",
+		dir:        binDir,
+		builtin:    true,
+		completion: completeNothing,
+		help:       "pwd [-LP]\nPrint the name of the current working directory.\n",
+	}})
+	registerCommand(whoamiCommand{commandSpec{
+		name:       "whoami",
+		dir:        binDir,
+		completion: completeNothing,
+		help:       "whoami [OPTION]...\nPrint the user name associated with the current effective user ID.\n",
+	}})
+	registerCommand(exitCommand{commandSpec{
+		name:       "exit",
+		aliases:    []string{"logout"},
+		builtin:    true,
+		completion: completeNothing,
+		help:       "exit [n]\nExit the shell with a status of N. If N is omitted, the exit status\nis that of the last command executed.\n",
+	}})
+	registerCommand(whichCommand{commandSpec{
+		name:       "which",
+		dir:        binDir,
+		completion: completeCommands,
+		help:       "which [-a] filename ...\nWrite the full path of COMMAND(s) to standard output.\n",
+	}})
+	registerCommand(typeCommand{commandSpec{
+		name:       "type",
+		builtin:    true,
+		completion: completeCommands,
+		help:       "type [-afptP] name [name ...]\nDisplay information about command type.\n",
+	}})
+	registerCommand(helpCommand{commandSpec{
+		name:       "help",
+		builtin:    true,
+		completion: completeCommands,
+		help:       "help [-s] [pattern ...]\nDisplay information about builtin commands.\n",
+	}})
+}
+
+type clearCommand struct{ commandSpec }
+
+func (clearCommand) Run(inv *Invocation) int {
+	if !inv.State.hasPty() {
+		fmt.Fprint(inv.Stderr, "TERM environment variable not set.\n")
+		return 1
+	}
+	fmt.Fprint(inv.Stdout, "\033[H\033[2J\033[3J")
+	return 0
+}
+
+type pwdCommand struct{ commandSpec }
+
+func (pwdCommand) Run(inv *Invocation) int {
+	path := inv.State.pwd()
+	for _, arg := range inv.Args {
+		switch arg {
+		case "-P":
+			inv.State.view(func(root *files.FilesystemDir, cwd *files.FilesystemDir) {
+				path = cwd.Path()
+			})
+		case "-L":
+			path = inv.State.pwd()
+		}
+	}
+	fmt.Fprintf(inv.Stdout, "%s\n", path)
+	return 0
+}
+
+type whoamiCommand struct{ commandSpec }
+
+func (whoamiCommand) Run(inv *Invocation) int {
+	fmt.Fprintf(inv.Stdout, "%s\n", inv.Ctx.User())
+	return 0
+}
+
+// exit works out the status and tells the shell to stop. With no argument
+// the shell keeps the status of the command before.
+type exitCommand struct{ commandSpec }
+
+func (exitCommand) Run(inv *Invocation) int {
+	inv.Exit = true
+	if len(inv.Args) == 0 {
+		return 0
+	}
+	code, err := strconv.Atoi(inv.Args[0])
+	if err != nil {
+		fmt.Fprintf(inv.Stderr, "bash: %s: %s: numeric argument required\n", inv.Name, inv.Args[0])
+		return 2
+	}
+	return code & 0xff
+}
+
+type whichCommand struct{ commandSpec }
+
+func (whichCommand) Run(inv *Invocation) int {
+	status := 0
+	for _, name := range inv.Args {
+		if strings.HasPrefix(name, "-") {
+			continue
+		}
+		path := ""
+		if cmd, ok := lookupCommand(name); ok {
+			path = commandPath(cmd, name)
+		}
+		if path == "" {
+			status = 1
+			continue
+		}
+		fmt.Fprintf(inv.Stdout, "%s\n", path)
+	}
+	return status
+}
+
+type typeCommand struct{ commandSpec }
+
+func (typeCommand) Run(inv *Invocation) int {
+	flags, names := splitFlags(inv.Args)
+	terse := hasFlag(flags, "t")
+	pathOnly := hasFlag(flags, "p") || hasFlag(flags, "P")
+	status := 0
+	for _, name := range names {
+		cmd, ok := lookupCommand(name)
+		if !ok {
+			if !terse && !pathOnly {
+				fmt.Fprintf(inv.Stderr, "bash: type: %s: not found\n", name)
+			}
+			status = 1
+			continue
+		}
+		path := commandPath(cmd, name)
+		switch {
+		case pathOnly:
+			if path != "" && (!cmd.Builtin() || hasFlag(flags, "P")) {
+				fmt.Fprintf(inv.Stdout, "%s\n", path)
+			}
+		case terse && cmd.Builtin():
+			fmt.Fprint(inv.Stdout, "builtin\n")
+		case terse:
+			fmt.Fprint(inv.Stdout, "file\n")
+		case cmd.Builtin():
+			fmt.Fprintf(inv.Stdout, "%s is a shell builtin\n", name)
+		default:
+			fmt.Fprintf(inv.Stdout, "%s is %s\n", name, path)
+		}
+	}
+	return status
+}
+
+// help describes builtins only, as bash's does; programs answer --help
+// instead.
+type helpCommand struct{ commandSpec }
+
+func (helpCommand) Run(inv *Invocation) int {
+	flags, topics := splitFlags(inv.Args)
+	if len(topics) == 0 {
+		fmt.Fprint(inv.Stdout, "GNU bash, version 5.1.16(1)-release (x86_64-pc-linux-gnu)\n"+
+			"These shell commands are defined internally.  Type `help' to see this list.\n"+
+			"Type `help name' to find out more about the function `name'.\n\n")
+		for _, name := range commandNames() {
+			if cmd, _ := lookupCommand(name); cmd.Builtin() && name == cmd.Name() {
+				fmt.Fprintf(inv.Stdout, " %s\n", synopsis(cmd))
+			}
+		}
+		return 0
+	}
+	status := 0
+	for _, topic := range topics {
+		cmd, ok := lookupCommand(topic)
+		if !ok || !cmd.Builtin() {
+			fmt.Fprintf(inv.Stderr, "bash: help: no help topics match `%s'.  Try `help help' or `man -k %s' or `info %s'.\n", topic, topic, topic)
+			status = 1
+			continue
+		}
+		fmt.Fprintf(inv.Stdout, "%s: %s\n", topic, synopsis(cmd))
+		if hasFlag(flags, "s") {
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(description(cmd), "\n"), "\n") {
+			fmt.Fprintf(inv.Stdout, "    %s\n", line)
+		}
+	}
+	return status
+}
@@ -4,7 +4,9 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/honeystats/ssh/files"
 	"github.com/sirupsen/logrus"
@@ -18,11 +20,27 @@ func realPathToDir(startPath string, firstPass bool) (*files.FilesystemDir, erro
 	if !firstPass {
 		root.Name = filepath.Base(startPath)
 	}
+	rootInfo, err := os.Stat(startPath)
+	if err != nil {
+		return nil, err
+	}
+	root.Attrs = attrsFor(rootInfo)
 	dirFiles, err := ioutil.ReadDir(startPath)
 	if err != nil {
 		return nil, err
 	}
 	for _, file := range dirFiles {
+		if file.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(filepath.Join(startPath, file.Name()))
+			if err != nil {
+				return nil, err
+			}
+			root.Links = append(root.Links, &files.FilesystemLink{
+				Name:   file.Name(),
+				Target: target,
+			})
+			continue
+		}
 		if file.IsDir() {
 			path := filepath.Join(startPath, file.Name())
 			var res *files.FilesystemDir
@@ -40,6 +58,7 @@ func realPathToDir(startPath string, firstPass bool) (*files.FilesystemDir, erro
 			newFile := &files.FilesystemFile{
 				Name:    file.Name(),
 				Content: string(content),
+				Attrs:   attrsFor(file),
 			}
 			root.Files = append(root.Files, newFile)
 		}
@@ -47,6 +66,16 @@ func realPathToDir(startPath string, firstPass bool) (*files.FilesystemDir, erro
 	return root, nil
 }
 
+// Keeps the permissions and modification time of the real file.
+func attrsFor(info os.FileInfo) files.Attrs {
+	mtime := info.ModTime().UTC().Truncate(time.Second)
+	perm := files.PermissionsFromMode(info.Mode())
+	return files.Attrs{
+		Permissions: &perm,
+		Mtime:       &mtime,
+	}
+}
+
 func realPathToConfig(path string) (*files.FilesystemConfig, error) {
 	rootDir, err := realPathToDir(path, true)
 	if err != nil {
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/honeystats/ssh/quarantine"
+	"github.com/sirupsen/logrus"
+)
+
+var storeDir string
+var outPath string
+var password string
+
+func main() {
+	flag.StringVar(&storeDir, "dir", "samples", "quarantine directory to export")
+	flag.StringVar(&outPath, "out", "", "zip file to write")
+	flag.StringVar(&password, "password", "infected", "password to encrypt the zip with, empty for none")
+	flag.Parse()
+	if outPath == "" {
+		logrus.Fatalln("Missing out arg.")
+	}
+
+	store, err := quarantine.NewStore(storeDir)
+	if err != nil {
+		logrus.WithError(err).Fatalln("error opening quarantine")
+	}
+	out, err := os.OpenFile(outPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		logrus.WithError(err).Fatalln("error creating zip file")
+	}
+	if err := store.ExportZip(out, password); err != nil {
+		out.Close()
+		logrus.WithError(err).Fatalln("error exporting quarantine")
+	}
+	if err := out.Close(); err != nil {
+		logrus.WithError(err).Fatalln("error writing zip file")
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeystats/ssh/asciicast"
+	"github.com/sirupsen/logrus"
+)
+
+var recordingPath string
+var mode string
+var speed float64
+var idleLimit time.Duration
+var outPath string
+
+func main() {
+	flag.StringVar(&recordingPath, "recording", "", "asciicast recording to read")
+	flag.StringVar(&mode, "mode", "play", "one of play, commands, timing, text or html")
+	flag.Float64Var(&speed, "speed", 1, "playback speed multiplier")
+	flag.DurationVar(&idleLimit, "idle-limit", 0, "longest pause during playback, 0 for no limit")
+	flag.StringVar(&outPath, "out", "", "file to write to instead of stdout")
+	flag.Parse()
+	if recordingPath == "" {
+		logrus.Fatalln("Missing recording arg.")
+	}
+	if speed <= 0 {
+		logrus.Fatalln("speed must be positive.")
+	}
+
+	in, err := os.Open(recordingPath)
+	if err != nil {
+		logrus.WithError(err).Fatalln("error opening recording")
+	}
+	rec, err := asciicast.Read(in)
+	in.Close()
+	if err != nil {
+		logrus.WithError(err).Fatalln("error reading recording")
+	}
+
+	var out io.Writer = os.Stdout
+	if outPath != "" {
+		file, err := os.Create(outPath)
+		if err != nil {
+			logrus.WithError(err).Fatalln("error creating output file")
+		}
+		defer file.Close()
+		out = file
+	}
+
+	switch mode {
+	case "play":
+		play(out, rec)
+	case "commands":
+		for _, cmd := range reconstructCommands(rec) {
+			edited := ""
+			if cmd.Edited {
+				edited = "  (edited with arrow keys, tab or history, may not match)"
+			}
+			fmt.Fprintf(out, "%10.3f  %s%s\n", cmd.Time, strconv.Quote(cmd.Line), edited)
+		}
+	case "timing":
+		dumpTiming(out, rec)
+	case "text":
+		io.WriteString(out, renderText(rec))
+	case "html":
+		writeHTML(out, rec)
+	default:
+		logrus.Fatalf("Unknown mode %q.\n", mode)
+	}
+}
+
+// Writes the output events with the pauses between them, scaled by speed.
+func play(out io.Writer, rec *asciicast.Recording) {
+	last := 0.0
+	for _, event := range rec.Events {
+		if event.Type != asciicast.Output {
+			continue
+		}
+		pause := time.Duration((event.Time - last) / speed * float64(time.Second))
+		if idleLimit > 0 && pause > idleLimit {
+			pause = idleLimit
+		}
+		time.Sleep(pause)
+		last = event.Time
+		io.WriteString(out, event.Data)
+	}
+}
+
+type command struct {
+	Time float64
+	Line string
+	// Set when the line was changed in ways the input alone doesn't show.
+	Edited bool
+}
+
+// Rebuilds the entered command lines from keystrokes. Tab completion,
+// history and cursor movement happen on the server, so lines using them
+// are flagged rather than guessed at.
+func reconstructCommands(rec *asciicast.Recording) []command {
+	cmds := []command{}
+	line := []rune{}
+	edited := false
+	start := -1.0
+	for _, event := range rec.Events {
+		if event.Type != asciicast.Input {
+			continue
+		}
+		input := []rune(event.Data)
+		for i := 0; i < len(input); i++ {
+			if start < 0 {
+				start = event.Time
+			}
+			switch r := input[i]; r {
+			case '\r', '\n':
+				cmds = append(cmds, command{Time: start, Line: string(line), Edited: edited})
+				line, edited, start = []rune{}, false, -1
+			case '\x03': // Ctrl+C
+				cmds = append(cmds, command{Time: start, Line: string(line) + "^C", Edited: edited})
+				line, edited, start = []rune{}, false, -1
+			case '\x7f', '\x08':
+				if len(line) > 0 {
+					line = line[:len(line)-1]
+				}
+			case '\x15': // Ctrl+U
+				line = []rune{}
+			case '\x17': // Ctrl+W
+				end := len(line)
+				for end > 0 && line[end-1] == ' ' {
+					end--
+				}
+				for end > 0 && line[end-1] != ' ' {
+					end--
+				}
+				line = line[:end]
+			case '\x1b':
+				edited = true
+				// Skip the rest of the sequence, ESC [ params final.
+				if i+1 < len(input) && (input[i+1] == '[' || input[i+1] == 'O') {
+					i += 2
+					for i < len(input) && (input[i] < 0x40 || input[i] > 0x7e) {
+						i++
+					}
+				}
+			default:
+				if r == '\t' || r < ' ' {
+					edited = true
+					continue
+				}
+				line = append(line, r)
+			}
+		}
+	}
+	if len(line) > 0 {
+		cmds = append(cmds, command{Time: start, Line: string(line), Edited: edited})
+	}
+	return cmds
+}
+
+// Lists every keystroke with the time since the previous one, which tells
+// a human typing apart from a script pasting commands.
+func dumpTiming(out io.Writer, rec *asciicast.Recording) {
+	fmt.Fprintf(out, "%10s  %8s  %s\n", "time", "delta", "input")
+	last := -1.0
+	for _, event := range rec.Events {
+		if event.Type != asciicast.Input {
+			continue
+		}
+		delta := "-"
+		if last >= 0 {
+			delta = fmt.Sprintf("%.3f", event.Time-last)
+		}
+		fmt.Fprintf(out, "%10.3f  %8s  %s\n", event.Time, delta, strconv.Quote(event.Data))
+		last = event.Time
+	}
+}
+
+func renderText(rec *asciicast.Recording) string {
+	screen := newTranscript()
+	for _, event := range rec.Events {
+		if event.Type == asciicast.Output {
+			screen.Write(event.Data)
+		}
+	}
+	return screen.String()
+}
+
+func writeHTML(out io.Writer, rec *asciicast.Recording) {
+	title := rec.Header.Title
+	if title == "" {
+		title = recordingPath
+	}
+	started := time.Unix(rec.Header.Timestamp, 0).UTC().Format(time.RFC3339)
+	duration := 0.0
+	if len(rec.Events) > 0 {
+		duration = rec.Events[len(rec.Events)-1].Time
+	}
+	fmt.Fprintf(out, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { background: #1e1e1e; color: #ddd; font-family: sans-serif; }
+pre { background: #000; color: #ccc; padding: 1em; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>%s</h1>
+<p>Started %s, lasted %.1fs, terminal %dx%d.</p>
+<pre>%s</pre>
+</body>
+</html>
+`, html.EscapeString(title), html.EscapeString(title), started, duration,
+		rec.Header.Width, rec.Header.Height, html.EscapeString(renderText(rec)))
+}
+
+// transcript turns terminal output into plain text, applying the cursor
+// movement and erasing the shell does while editing a line and dropping
+// every other escape sequence.
+type transcript struct {
+	lines []string
+	line  []rune
+	col   int
+}
+
+func newTranscript() *transcript {
+	return &transcript{lines: []string{}, line: []rune{}}
+}
+
+func (t *transcript) put(r rune) {
+	if t.col < len(t.line) {
+		t.line[t.col] = r
+	} else {
+		for len(t.line) < t.col {
+			t.line = append(t.line, ' ')
+		}
+		t.line = append(t.line, r)
+	}
+	t.col++
+}
+
+func (t *transcript) newline() {
+	t.lines = append(t.lines, strings.TrimRight(string(t.line), " "))
+	t.line = []rune{}
+	t.col = 0
+}
+
+func (t *transcript) Write(data string) {
+	runes := []rune(data)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '\n':
+			t.newline()
+		case '\r':
+			t.col = 0
+		case '\b':
+			if t.col > 0 {
+				t.col--
+			}
+		case '\x1b':
+			if i+1 < len(runes) && runes[i+1] == ']' {
+				// OSC, e.g. a window title, ends with BEL or ESC \.
+				for i += 2; i < len(runes) && runes[i] != '\a'; i++ {
+					if runes[i] == '\x1b' && i+1 < len(runes) && runes[i+1] == '\\' {
+						i++
+						break
+					}
+				}
+				continue
+			}
+			if i+1 >= len(runes) || runes[i+1] != '[' {
+				i++
+				continue
+			}
+			j := i + 2
+			for j < len(runes) && (runes[j] < 0x40 || runes[j] > 0x7e) {
+				j++
+			}
+			if j < len(runes) {
+				t.csi(string(runes[i+2:j]), runes[j])
+			}
+			i = j
+		default:
+			if r >= ' ' || r == '\t' {
+				t.put(r)
+			}
+		}
+	}
+}
+
+func (t *transcript) csi(params string, final rune) {
+	n, err := strconv.Atoi(params)
+	if err != nil || n < 1 {
+		n = 1
+	}
+	switch final {
+	case 'D':
+		t.col -= n
+		if t.col < 0 {
+			t.col = 0
+		}
+	case 'C':
+		t.col += n
+	case 'K':
+		if t.col < len(t.line) {
+			t.line = t.line[:t.col]
+		}
+	case 'J':
+		// A cleared screen keeps its history in the transcript.
+		if params == "2" || params == "3" {
+			if len(t.line) > 0 {
+				t.newline()
+			}
+			t.line = []rune{}
+			t.col = 0
+		}
+	}
+}
+
+func (t *transcript) String() string {
+	lines := t.lines
+	if len(t.line) > 0 {
+		lines = append(lines, strings.TrimRight(string(t.line), " "))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
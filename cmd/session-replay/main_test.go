@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeystats/ssh/asciicast"
+)
+
+func inputRecording(inputs ...string) *asciicast.Recording {
+	rec := &asciicast.Recording{}
+	for i, input := range inputs {
+		rec.Events = append(rec.Events, asciicast.Event{Time: float64(i), Type: asciicast.Input, Data: input})
+	}
+	return rec
+}
+
+func TestReconstructCommands(t *testing.T) {
+	tests := []struct {
+		name   string
+		inputs []string
+		want   []command
+	}{
+		{"typed", []string{"l", "s", "\r"}, []command{{0, "ls", false}}},
+		{"pasted", []string{"uname -a\rid\r"}, []command{{0, "uname -a", false}, {0, "id", false}}},
+		{"backspace", []string{"lx\x7fs\r"}, []command{{0, "ls", false}}},
+		{"Ctrl+U", []string{"rm -rf /\x15ls\r"}, []command{{0, "ls", false}}},
+		{"Ctrl+W", []string{"cat /etc/shadow  \x17passwd\r"}, []command{{0, "cat passwd", false}}},
+		{"Ctrl+C", []string{"wget x", "\x03"}, []command{{0, "wget x^C", false}}},
+		{"arrow keys", []string{"\x1b[A\r"}, []command{{0, "", true}}},
+		{"tab", []string{"cat /et\t\r"}, []command{{0, "cat /et", true}}},
+		{"unfinished", []string{"\r", "exit"}, []command{{0, "", false}, {1, "exit", false}}},
+		{"output ignored", nil, []command{}},
+	}
+	for _, test := range tests {
+		rec := inputRecording(test.inputs...)
+		rec.Events = append(rec.Events, asciicast.Event{Time: 9, Type: asciicast.Output, Data: "ignored\r"})
+		got := reconstructCommands(rec)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: command %d is %+v, want %+v", test.name, i, got[i], test.want[i])
+			}
+		}
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	tests := []struct {
+		name   string
+		output []string
+		want   string
+	}{
+		{"plain", []string{"a\r\nb\r\n"}, "a\nb\n"},
+		{"split writes", []string{"$ l", "s\r\n"}, "$ ls\n"},
+		{"colours dropped", []string{"\x1b[01;34mdir\x1b[0m\r\n"}, "dir\n"},
+		{"backspace redraw", []string{"$ lx\b \b", "s\r\n"}, "$ ls\n"},
+		{"cursor left and erase", []string{"$ cat foo\x1b[3D\x1b[Kbar\r\n"}, "$ cat bar\n"},
+		{"cursor right", []string{"ab\x1b[2Dx\x1b[1Cc\r\n"}, "xbc\n"},
+		{"clear keeps history", []string{"before\r\n$ \x1b[H\x1b[2J\x1b[3J$ "}, "before\n$\n$\n"},
+		{"title", []string{"\x1b]0;root@host\x07x\r\n"}, "x\n"},
+		{"title ended by ST", []string{"\x1b]2;t\x1b\\x\r\n"}, "x\n"},
+		{"other escapes", []string{"\x1b=x\x1b>y\r\n"}, "xy\n"},
+	}
+	for _, test := range tests {
+		screen := newTranscript()
+		for _, output := range test.output {
+			screen.Write(output)
+		}
+		if got := screen.String(); got != test.want {
+			t.Errorf("%s: transcript %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDumpTiming(t *testing.T) {
+	var out strings.Builder
+	dumpTiming(&out, inputRecording("l", "s"))
+	want := "      time     delta  input\n" +
+		"     0.000         -  \"l\"\n" +
+		"     1.000     1.000  \"s\"\n"
+	if out.String() != want {
+		t.Errorf("timing is\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestWriteHTMLEscapes(t *testing.T) {
+	rec := &asciicast.Recording{
+		Header: asciicast.Header{Title: "<root>@host", Width: 80, Height: 24},
+		Events: []asciicast.Event{{Time: 1, Type: asciicast.Output, Data: "<script>alert(1)</script>\r\n"}},
+	}
+	var out strings.Builder
+	writeHTML(&out, rec)
+	if strings.Contains(out.String(), "<script>") || strings.Contains(out.String(), "<root>") {
+		t.Errorf("recorded output isn't escaped:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "&lt;script&gt;alert(1)&lt;/script&gt;") {
+		t.Errorf("recorded output missing:\n%s", out.String())
+	}
+}
+
+func TestPlay(t *testing.T) {
+	rec := &asciicast.Recording{Events: []asciicast.Event{
+		{Time: 0, Type: asciicast.Output, Data: "a"},
+		{Time: 0.5, Type: asciicast.Input, Data: "x"},
+		{Time: 10, Type: asciicast.Output, Data: "b"},
+	}}
+	tests := []struct {
+		speed     float64
+		idleLimit time.Duration
+		max       time.Duration
+	}{
+		{1000, 0, 100 * time.Millisecond},
+		{1, 10 * time.Millisecond, 100 * time.Millisecond},
+	}
+	for _, test := range tests {
+		speed, idleLimit = test.speed, test.idleLimit
+		var out strings.Builder
+		start := time.Now()
+		play(&out, rec)
+		if took := time.Since(start); took > test.max {
+			t.Errorf("speed %g, idle limit %s: playing took %s", test.speed, test.idleLimit, took)
+		}
+		if out.String() != "ab" {
+			t.Errorf("played %q, want only the output", out.String())
+		}
+	}
+	speed, idleLimit = 1, 0
+}
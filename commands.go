@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// Command is something that can be typed at the fake shell. Commands add
+// themselves to the registry with registerCommand, usually from an init
+// function next to their implementation, and the shell, tab completion,
+// which, type and help all find them there.
+type Command interface {
+	// Name is what the command is usually run as.
+	Name() string
+	// Aliases are other names that run the same command.
+	Aliases() []string
+	// Dir is where the program is installed, as which reports it, or "" for
+	// a shell builtin with no program behind it.
+	Dir() string
+	// Builtin reports whether the shell runs the command itself, as type
+	// reports it.
+	Builtin() bool
+	// Completion is what tab completes the command's arguments to.
+	Completion() Completion
+	// Help is a synopsis line followed by a description, shown by help for
+	// builtins and by --help for programs.
+	Help() string
+	// Run runs the command and returns its exit status.
+	Run(inv *Invocation) int
+}
+
+// Completion is a hint for tab completing a command's arguments.
+type Completion int
+
+const (
+	completeFiles Completion = iota
+	completeDirs
+	completeCommands
+	completeVariables
+	completeNothing
+)
+
+// Invocation is one run of a command.
+type Invocation struct {
+	Ctx   ssh.Context
+	State *SessionState
+	// Name is what the command was run as, which may be an alias.
+	Name   string
+	Args   []string
+	Stdin  string
+	Stdout io.Writer
+	Stderr io.Writer
+	// Env is the environment the command sees: the exported variables, and
+	// any assignments it was run with. Programs read variables from here
+	// rather than from the shell's.
+	Env map[string]string
+	// Exit is set by a command that ends the shell running it, as exit
+	// does.
+	Exit bool
+}
+
+// Writes a CmdResult out and returns its exit status, for commands that
+// build their output up in one.
+func (inv *Invocation) result(res CmdResult) int {
+	io.WriteString(inv.Stdout, res.Stdout)
+	io.WriteString(inv.Stderr, res.Stderr)
+	return res.ExitCode
+}
+
+// commandSpec holds what most commands have in common, so that a command
+// type can embed it and only write Run.
+type commandSpec struct {
+	name       string
+	aliases    []string
+	dir        string
+	builtin    bool
+	completion Completion
+	help       string
+}
+
+func (c commandSpec) Name() string           { return c.name }
+func (c commandSpec) Aliases() []string      { return c.aliases }
+func (c commandSpec) Dir() string            { return c.dir }
+func (c commandSpec) Builtin() bool          { return c.builtin }
+func (c commandSpec) Completion() Completion { return c.completion }
+func (c commandSpec) Help() string           { return c.help }
+
+// Programs most commands are found in.
+const binDir = "/usr/bin"
+
+// Commands by name and alias.
+var commands = map[string]Command{}
+
+func registerCommand(cmd Command) {
+	commands[cmd.Name()] = cmd
+	for _, alias := range cmd.Aliases() {
+		commands[alias] = cmd
+	}
+}
+
+func lookupCommand(name string) (Command, bool) {
+	cmd, ok := commands[name]
+	return cmd, ok
+}
+
+// Finds the command that running name runs in this session. A name with a
+// slash in it is a path, and finds a program only at the path which shows
+// for it; other names find builtins, and programs in a directory on PATH.
+// An empty PATH entry, as in an empty or unset PATH, is the current
+// directory, as in bash.
+func findCommand(state *SessionState, name string) (Command, bool) {
+	if strings.Contains(name, "/") {
+		p := name
+		if !path.IsAbs(p) {
+			p = path.Join(state.pwd(), p)
+		}
+		p = path.Clean(p)
+		cmd, ok := lookupCommand(path.Base(p))
+		if !ok || commandPath(cmd, path.Base(p)) != p {
+			return nil, false
+		}
+		return cmd, true
+	}
+	cmd, ok := lookupCommand(name)
+	if !ok || cmd.Builtin() {
+		return cmd, ok
+	}
+	search, _ := state.getVar("PATH")
+	for _, dir := range strings.Split(search, ":") {
+		if dir == "" {
+			dir = state.pwd()
+		}
+		if path.Clean(dir) == cmd.Dir() {
+			return cmd, true
+		}
+	}
+	return nil, false
+}
+
+// Every name a command can be run as, sorted.
+func commandNames() []string {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Where which finds a command run as name, or "" if it has no program.
+func commandPath(cmd Command, name string) string {
+	if cmd.Dir() == "" {
+		return ""
+	}
+	return path.Join(cmd.Dir(), name)
+}
+
+// The first line of a command's help.
+func synopsis(cmd Command) string {
+	return strings.SplitN(cmd.Help(), "\n", 2)[0]
+}
+
+// The rest of a command's help after the synopsis.
+func description(cmd Command) string {
+	parts := strings.SplitN(cmd.Help(), "\n", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// Runs one command with its arguments already expanded and assigns added
+// to its environment, returning its exit status and whether it asked the
+// shell to stop. Builtins see assigns as shell variables for the length of
+// the command, as bash's do.
+func runCmd(ctx ssh.Context, state *SessionState, args []string, st streams, assigns map[string]string) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	cmd, ok := findCommand(state, args[0])
+	switch {
+	case !ok && strings.Contains(args[0], "/"):
+		fmt.Fprintf(st.stderr, "bash: %s: No such file or directory\n", args[0])
+		return 127, false
+	case !ok:
+		fmt.Fprintf(st.stderr, "command not found: %s\n", args[0])
+		return 127, false
+	}
+	if !cmd.Builtin() && len(args) == 2 && args[1] == "--help" {
+		fmt.Fprintf(st.stdout, "Usage: %s\n%s", synopsis(cmd), description(cmd))
+		return 0, false
+	}
+	env := map[string]string{}
+	for _, name := range state.varNames(func(v *shellVar) bool { return v.exported && v.set }) {
+		env[name], _ = state.getVar(name)
+	}
+	for name, value := range assigns {
+		env[name] = value
+	}
+	inv := &Invocation{
+		Ctx:    ctx,
+		State:  state,
+		Name:   args[0],
+		Args:   args[1:],
+		Stdin:  st.stdin,
+		Stdout: st.stdout,
+		Stderr: st.stderr,
+		Env:    env,
+	}
+	if !cmd.Builtin() {
+		return cmd.Run(inv), false
+	}
+	res := withVars(state, assigns, func() CmdResult {
+		return CmdResult{ExitCode: cmd.Run(inv)}
+	})
+	return res.ExitCode, inv.Exit
+}
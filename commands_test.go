@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Records what it was run with, and exits when its first argument says to.
+type recordingCommand struct {
+	commandSpec
+	got *Invocation
+}
+
+func (c recordingCommand) Run(inv *Invocation) int {
+	*c.got = *inv
+	inv.Exit = len(inv.Args) > 0 && inv.Args[0] == "exit"
+	return 3
+}
+
+func TestRunCmd(t *testing.T) {
+	var got Invocation
+	registerCommand(recordingCommand{commandSpec{name: "test-program", dir: binDir, help: "test-program\n"}, &got})
+	registerCommand(recordingCommand{commandSpec{name: "test-builtin", builtin: true}, &got})
+	tests := []struct {
+		name       string
+		setup      string
+		args       []string
+		assigns    map[string]string
+		wantStatus int
+		wantExit   bool
+		wantEnv    map[string]string
+		wantNoEnv  []string
+	}{
+		{
+			name:       "exported variables only",
+			setup:      "SECRET=1; export SHOWN=2",
+			args:       []string{"test-program"},
+			wantStatus: 3,
+			wantEnv:    map[string]string{"SHOWN": "2", "USER": "root", "HOME": "/root"},
+			wantNoEnv:  []string{"SECRET", "OLDPWD"},
+		},
+		{
+			name:       "assignments are added",
+			setup:      "export HOME",
+			args:       []string{"test-program"},
+			assigns:    map[string]string{"HOME": "/elsewhere", "NEW": "x"},
+			wantStatus: 3,
+			wantEnv:    map[string]string{"HOME": "/elsewhere", "NEW": "x"},
+		},
+		{
+			name:       "a program can't end the shell",
+			args:       []string{"test-program", "exit"},
+			wantStatus: 3,
+		},
+		{
+			name:       "a builtin can",
+			args:       []string{"test-builtin", "exit"},
+			wantStatus: 3,
+			wantExit:   true,
+		},
+		{
+			name:       "not found",
+			args:       []string{"no-such-command"},
+			wantStatus: 127,
+		},
+		{
+			name:       "--help",
+			args:       []string{"test-program", "--help"},
+			wantStatus: 0,
+		},
+	}
+	for _, test := range tests {
+		got = Invocation{}
+		state := newTestState()
+		runTestShell(state, test.setup)
+		var out strings.Builder
+		status, exit := runCmd(newTestContext("root", "session"), state, test.args, streams{stdout: &out, stderr: &out}, test.assigns)
+		if status != test.wantStatus || exit != test.wantExit {
+			t.Errorf("%s: got status %d, exit %v, want %d, %v", test.name, status, exit, test.wantStatus, test.wantExit)
+		}
+		for name, want := range test.wantEnv {
+			if value, set := got.Env[name]; !set || value != want {
+				t.Errorf("%s: %s = %q in the environment, want %q", test.name, name, value, want)
+			}
+		}
+		for _, name := range test.wantNoEnv {
+			if _, set := got.Env[name]; set {
+				t.Errorf("%s: %s is in the environment", test.name, name)
+			}
+		}
+	}
+	delete(commands, "test-program")
+	delete(commands, "test-builtin")
+}
+
+func TestCommandPaths(t *testing.T) {
+	tests := []struct {
+		line       string
+		wantStdout string
+		wantStderr string
+		wantStatus int
+	}{
+		{"/usr/bin/whoami", "root\n", "", 0},
+		{"/usr/bin/../bin//whoami", "root\n", "", 0},
+		{"mkdir -p /usr/bin; cd /usr; bin/whoami", "root\n", "", 0},
+		{"mkdir -p /usr/bin; cd /usr/bin; ./whoami", "root\n", "", 0},
+		{"./whoami", "", "bash: ./whoami: No such file or directory\n", 127},
+		{"/bin/sh/whoami", "", "bash: /bin/sh/whoami: No such file or directory\n", 127},
+		{"/usr/bin/no-such-command", "", "bash: /usr/bin/no-such-command: No such file or directory\n", 127},
+		{"/usr/bin/cd /", "", "bash: /usr/bin/cd: No such file or directory\n", 127},
+		{"PATH=/usr/local/bin:/usr/bin; whoami", "root\n", "", 0},
+		{"PATH=/usr/bin/; whoami", "root\n", "", 0},
+		{"PATH=/sbin; whoami", "", "command not found: whoami\n", 127},
+		{"PATH=/sbin; echo builtins still run", "builtins still run\n", "", 0},
+		{"PATH=/sbin; /usr/bin/whoami", "root\n", "", 0},
+		{"PATH=/sbin; env whoami; echo $?", "127\n", "", 0},
+		{"mkdir -p /usr/bin; unset PATH; cd /usr/bin; whoami", "root\n", "", 0},
+		{"mkdir -p /usr/bin; PATH=/sbin:; cd /usr/bin; whoami", "root\n", "", 0},
+	}
+	for _, test := range tests {
+		stdout, stderr, status := runTestShell(newTestState(), test.line)
+		if stdout != test.wantStdout || (test.wantStderr != "" && stderr != test.wantStderr) || status != test.wantStatus {
+			t.Errorf("%q: got %q, %q, status %d, want %q, %q, status %d",
+				test.line, stdout, stderr, status, test.wantStdout, test.wantStderr, test.wantStatus)
+		}
+	}
+}
+
+func TestEnvironmentCommands(t *testing.T) {
+	tests := []struct {
+		line       string
+		wantStdout string
+		wantStatus int
+	}{
+		{"printenv HOME USER", "/root\nroot\n", 0},
+		{"X=1; printenv X", "", 1},
+		{"X=1 printenv X; printenv X", "1\n", 1},
+		{"export X=1; printenv X", "1\n", 0},
+		{"env X=2 Y=3 printenv X Y", "2\n3\n", 0},
+		{"X=1 env X=2 printenv X", "2\n", 0},
+		{"env -i X=2 printenv X", "2\n", 0},
+		{"env no-such-command", "", 127},
+		{"X=1 exit 5; echo unreachable", "", 5},
+		{"env exit 6; echo still", "still\n", 0},
+	}
+	for _, test := range tests {
+		stdout, _, status := runTestShell(newTestState(), test.line)
+		if stdout != test.wantStdout || status != test.wantStatus {
+			t.Errorf("%q: got %q, status %d, want %q, status %d", test.line, stdout, status, test.wantStdout, test.wantStatus)
+		}
+	}
+	// env lists exactly what printenv does.
+	state := newTestState()
+	printenv, _, _ := runTestShell(state, "LOCAL=1; export EXTRA=2; printenv")
+	env, _, _ := runTestShell(state, "env")
+	if env != printenv || !strings.Contains(env, "EXTRA=2\n") || strings.Contains(env, "LOCAL") {
+		t.Errorf("env printed %q and printenv %q", env, printenv)
+	}
+}
@@ -4,66 +4,294 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"strings"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esapi"
-	"github.com/gliderlabs/ssh"
 	"github.com/sirupsen/logrus"
 )
 
-var ES_CLIENT *elasticsearch.Client
+func init() {
+	registerSink("elasticsearch", newElasticSink)
+}
+
+var errSinkClosed = errors.New("sink is closed")
 
-func setupES() {
-	ES_CLIENT, _ = elasticsearch.NewDefaultClient()
+// ElasticSink queues documents and writes them to Elasticsearch in batches
+// using the _bulk API. A batch is sent once it reaches BatchSize documents or
+// FlushInterval has passed, whichever comes first. Failed requests and
+// retryable per-item failures are retried with exponential backoff; anything
+// still undelivered after MaxRetries is handed to OnFailure.
+type ElasticSink struct {
+	Client         *elasticsearch.Client
+	Index          string
+	Pipeline       string
+	BatchSize      int
+	FlushInterval  time.Duration
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Called with documents that could not be delivered. Defaults to logging
+	// and dropping them. Use SetFailureHandler to change it once the sink
+	// has started.
+	OnFailure func(docs []json.RawMessage, err error)
+
+	mu        sync.Mutex
+	queue     chan json.RawMessage
+	flushReqs chan chan error
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
 }
 
-func sendToESWithCtx(ctx ssh.Context, state *SessionState, doc SubDocument) {
-	splat := strings.Split(ctx.RemoteAddr().String(), ":")
-	toplevelDoc := SSHDoc{
-		Timestamp: time.Now(),
-		Action:    doc.action(),
-		Cwd:       state.Cwd.Path(),
-		Passwords: state.Passwords,
-		Keys:      state.Keys,
-		Fields:    doc,
-		SessionID: ctx.SessionID(),
-		Username:  ctx.User(),
-	}
-	if len(splat) == 2 {
-		toplevelDoc.SourceIP = splat[0]
-		toplevelDoc.SourcePort = splat[1]
-	}
-	if DEBUG {
-		toplevelDoc.SourceIP = randSourceIP()
-	}
-	docBytes, err := json.Marshal(toplevelDoc)
+func newElasticSink() (EventSink, error) {
+	_ = envOrFatal("ELASTICSEARCH_URL")
+	client, err := elasticsearch.NewDefaultClient()
 	if err != nil {
-		logrus.Errorln("there was an error marshalling an ES document to JSON")
-		return
+		return nil, err
+	}
+	es := &ElasticSink{
+		Client:         client,
+		Index:          envOrDefault("ES_INDEX", "honeystats_ssh_data"),
+		Pipeline:       envOrDefault("ES_PIPELINE", "geoip"),
+		BatchSize:      envPositiveIntOrDefault("ES_BATCH_SIZE", 500),
+		FlushInterval:  envPositiveDurationOrDefault("ES_FLUSH_INTERVAL", 5*time.Second),
+		MaxRetries:     envIntOrDefault("ES_MAX_RETRIES", 5),
+		InitialBackoff: envDurationOrDefault("ES_INITIAL_BACKOFF", 500*time.Millisecond),
+		MaxBackoff:     envDurationOrDefault("ES_MAX_BACKOFF", 30*time.Second),
 	}
-	req := esapi.IndexRequest{
-		Index:    "honeystats_ssh_data",
-		Body:     bytes.NewReader(docBytes),
-		Refresh:  "true",
-		Pipeline: "geoip",
+	es.start(envPositiveIntOrDefault("ES_QUEUE_SIZE", 10000))
+	return es, nil
+}
+
+func (es *ElasticSink) start(queueSize int) {
+	if es.OnFailure == nil {
+		es.OnFailure = func(docs []json.RawMessage, err error) {
+			logrus.WithFields(logrus.Fields{
+				"count": len(docs),
+				"err":   err,
+			}).Errorln("Dropping documents that could not be sent to ES")
+		}
 	}
-	res, err := req.Do(context.Background(), ES_CLIENT)
+	es.queue = make(chan json.RawMessage, queueSize)
+	es.flushReqs = make(chan chan error)
+	es.done = make(chan struct{})
+	es.wg.Add(1)
+	go es.run()
+}
+
+func (es *ElasticSink) Emit(doc SSHDoc) error {
+	docBytes, err := json.Marshal(doc)
 	if err != nil {
-		logrus.Fatalf("Error getting response: %s", err)
+		return fmt.Errorf("marshalling ES document to JSON: %w", err)
+	}
+	select {
+	case <-es.done:
+		return errSinkClosed
+	default:
+	}
+	select {
+	case es.queue <- docBytes:
+	default:
+		es.fail([]json.RawMessage{docBytes}, errors.New("ES queue is full"))
+	}
+	return nil
+}
+
+func (es *ElasticSink) Flush() error {
+	reply := make(chan error, 1)
+	select {
+	case es.flushReqs <- reply:
+		return <-reply
+	case <-es.done:
+		return errSinkClosed
+	}
+}
+
+func (es *ElasticSink) Close() error {
+	es.closeOnce.Do(func() {
+		close(es.done)
+	})
+	es.wg.Wait()
+	return nil
+}
+
+func (es *ElasticSink) run() {
+	defer es.wg.Done()
+	ticker := time.NewTicker(es.FlushInterval)
+	defer ticker.Stop()
+	batch := []json.RawMessage{}
+	send := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		failed, err := es.Deliver(batch)
+		batch = []json.RawMessage{}
+		if len(failed) > 0 {
+			es.fail(failed, err)
+		}
+		return err
+	}
+	drain := func() {
+		for {
+			select {
+			case doc := <-es.queue:
+				batch = append(batch, doc)
+				if len(batch) >= es.BatchSize {
+					send()
+				}
+			default:
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case doc := <-es.queue:
+			batch = append(batch, doc)
+			if len(batch) >= es.BatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case reply := <-es.flushReqs:
+			drain()
+			reply <- send()
+		case <-es.done:
+			drain()
+			send()
+			return
+		}
+	}
+}
+
+func (es *ElasticSink) backoff(attempt int) time.Duration {
+	wait := es.InitialBackoff << uint(attempt)
+	if wait <= 0 || wait > es.MaxBackoff {
+		wait = es.MaxBackoff
+	}
+	return wait
+}
+
+// Deliver synchronously sends docs with the _bulk API, retrying as needed,
+// and returns the documents that are still undelivered at the end. If ES
+// refuses the whole request for good, as it does for bad credentials or a
+// missing index, they are all returned straight away.
+func (es *ElasticSink) Deliver(docs []json.RawMessage) ([]json.RawMessage, error) {
+	pending := docs
+	var lastErr error
+	for attempt := 0; attempt <= es.MaxRetries; attempt++ {
+		if attempt > 0 {
+			wait := es.backoff(attempt - 1)
+			logrus.WithFields(logrus.Fields{
+				"attempt": attempt,
+				"pending": len(pending),
+				"wait":    wait,
+				"err":     lastErr,
+			}).Warnln("Retrying ES bulk request")
+			time.Sleep(wait)
+		}
+		var retry, rejected []json.RawMessage
+		retry, rejected, lastErr = es.bulk(pending)
+		if len(rejected) > 0 {
+			return rejected, fmt.Errorf("%d documents not delivered: %w", len(rejected), lastErr)
+		}
+		if len(retry) == 0 {
+			return nil, nil
+		}
+		pending = retry
+	}
+	return pending, fmt.Errorf("%d documents not delivered: %w", len(pending), lastErr)
+}
+
+func (es *ElasticSink) SetFailureHandler(fn func(docs []json.RawMessage, err error)) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.OnFailure = fn
+}
+
+// Hands undelivered documents to the failure handler.
+func (es *ElasticSink) fail(docs []json.RawMessage, err error) {
+	es.mu.Lock()
+	onFailure := es.OnFailure
+	es.mu.Unlock()
+	onFailure(docs, err)
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+func retryableStatus(status int) bool {
+	return status == 429 || status >= 500
+}
+
+// Sends one _bulk request and returns the documents worth retrying, and the
+// documents of a request ES refused as a whole for a non-retryable reason.
+// Documents rejected one by one for non-retryable reasons are logged and
+// dropped, as sending them again would only be rejected again.
+func (es *ElasticSink) bulk(docs []json.RawMessage) ([]json.RawMessage, []json.RawMessage, error) {
+	var body bytes.Buffer
+	for _, doc := range docs {
+		body.WriteString("{\"index\":{}}\n")
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+	req := esapi.BulkRequest{
+		Index:    es.Index,
+		Body:     &body,
+		Pipeline: es.Pipeline,
+	}
+	res, err := req.Do(context.Background(), es.Client)
+	if err != nil {
+		return docs, nil, fmt.Errorf("getting response: %w", err)
 	}
 	defer res.Body.Close()
 	if res.IsError() {
-		logrus.Errorf("[%s] Error indexing document\n", res.Status())
-	} else {
-		// Deserialize the response into a map.
-		var r map[string]interface{}
-		if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
-			logrus.Errorf("Error parsing the response body: %s\n", err)
-		} else {
-			// Print the response status and indexed document version.
-			logrus.Debugf("ES Document index status: [%s] %s; version=%d\n", res.Status(), r["result"], int(r["_version"].(float64)))
+		err := fmt.Errorf("[%s] error sending bulk request", res.Status())
+		if retryableStatus(res.StatusCode) {
+			return docs, nil, err
+		}
+		logrus.WithError(err).WithField("count", len(docs)).Errorln("ES rejected bulk request")
+		return nil, docs, err
+	}
+	var r bulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		// The request went through, so retrying would only duplicate documents.
+		logrus.Errorf("Error parsing the bulk response body: %s\n", err)
+		return nil, nil, nil
+	}
+	logrus.Debugf("ES bulk request status: [%s] items=%d errors=%t\n", res.Status(), len(r.Items), r.Errors)
+	if !r.Errors {
+		return nil, nil, nil
+	}
+	var retry []json.RawMessage
+	var lastErr error
+	for i, item := range r.Items {
+		if i >= len(docs) {
+			break
+		}
+		for _, result := range item {
+			if result.Status < 300 {
+				continue
+			}
+			lastErr = fmt.Errorf("[%d] %s: %s", result.Status, result.Error.Type, result.Error.Reason)
+			if retryableStatus(result.Status) {
+				retry = append(retry, docs[i])
+			} else {
+				logrus.WithError(lastErr).Errorln("ES rejected document")
+			}
 		}
 	}
+	return retry, nil, lastErr
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// fakeBulk answers _bulk requests with the replies it is given in turn,
+// repeating the last one. A reply is an HTTP status and the status of each
+// item, or no items for a whole-request error.
+type fakeBulk struct {
+	mu       sync.Mutex
+	replies  []bulkReply
+	requests int
+}
+
+type bulkReply struct {
+	status int
+	items  []int
+}
+
+func (f *fakeBulk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	reply := f.replies[len(f.replies)-1]
+	if f.requests < len(f.replies) {
+		reply = f.replies[f.requests]
+	}
+	f.requests++
+	f.mu.Unlock()
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(reply.status)
+	if reply.status >= 300 {
+		return
+	}
+	body := bulkResponse{}
+	for _, status := range reply.items {
+		item := map[string]struct {
+			Status int `json:"status"`
+			Error  struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		}{"index": {Status: status}}
+		body.Items = append(body.Items, item)
+		if status >= 300 {
+			body.Errors = true
+		}
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+func TestElasticDeliver(t *testing.T) {
+	tests := []struct {
+		name         string
+		replies      []bulkReply
+		wantFailed   int
+		wantRequests int
+	}{
+		{"accepted", []bulkReply{{200, []int{201, 201}}}, 0, 1},
+		{"request retried", []bulkReply{{503, nil}, {200, []int{201, 201}}}, 0, 2},
+		{"item retried", []bulkReply{{200, []int{201, 429}}, {200, []int{201}}}, 0, 2},
+		{"item rejected", []bulkReply{{200, []int{201, 400}}}, 0, 1},
+		{"request rejected", []bulkReply{{400, nil}}, 2, 1},
+		{"unauthorized", []bulkReply{{401, nil}}, 2, 1},
+		{"forbidden", []bulkReply{{403, nil}}, 2, 1},
+		{"no index", []bulkReply{{404, nil}}, 2, 1},
+		{"retries run out", []bulkReply{{503, nil}}, 2, 3},
+	}
+	for _, test := range tests {
+		fake := &fakeBulk{replies: test.replies}
+		server := httptest.NewServer(fake)
+		client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}, DisableRetry: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		es := &ElasticSink{
+			Client:         client,
+			Index:          "test",
+			MaxRetries:     2,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     time.Millisecond,
+		}
+		docs := []json.RawMessage{json.RawMessage(`{"a":1}`), json.RawMessage(`{"a":2}`)}
+		failed, _ := es.Deliver(docs)
+		server.Close()
+		if len(failed) != test.wantFailed {
+			t.Errorf("%s: %d documents failed, want %d", test.name, len(failed), test.wantFailed)
+		}
+		if fake.requests != test.wantRequests {
+			t.Errorf("%s: %d requests, want %d", test.name, fake.requests, test.wantRequests)
+		}
+	}
+}
+
+func TestElasticSinkBatches(t *testing.T) {
+	fake := &fakeBulk{replies: []bulkReply{{200, []int{201, 201, 201}}}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}, DisableRetry: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es := &ElasticSink{Client: client, BatchSize: 3, FlushInterval: time.Hour}
+	es.start(10)
+	for i := 0; i < 7; i++ {
+		es.Emit(SSHDoc{Action: "login"})
+	}
+	if err := es.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	es.Close()
+	if fake.requests != 3 {
+		t.Errorf("7 documents in batches of 3 took %d requests, want 3", fake.requests)
+	}
+}
+
+func TestNewElasticSinkSettings(t *testing.T) {
+	server := httptest.NewServer(&fakeBulk{replies: []bulkReply{{200, nil}}})
+	defer server.Close()
+	tests := []struct {
+		flushInterval, queueSize string
+		wantInterval             time.Duration
+		wantQueue                int
+	}{
+		{"", "", 5 * time.Second, 10000},
+		{"1s", "10", time.Second, 10},
+		{"0s", "0", 5 * time.Second, 10000},
+		{"-1s", "-5", 5 * time.Second, 10000},
+	}
+	for _, test := range tests {
+		t.Setenv("ELASTICSEARCH_URL", server.URL)
+		t.Setenv("ES_FLUSH_INTERVAL", test.flushInterval)
+		t.Setenv("ES_QUEUE_SIZE", test.queueSize)
+		sink, err := newElasticSink()
+		if err != nil {
+			t.Fatal(err)
+		}
+		es := sink.(*ElasticSink)
+		es.Close()
+		if es.FlushInterval != test.wantInterval || cap(es.queue) != test.wantQueue {
+			t.Errorf("ES_FLUSH_INTERVAL=%q ES_QUEUE_SIZE=%q gave %s and %d, want %s and %d",
+				test.flushInterval, test.queueSize, es.FlushInterval, cap(es.queue), test.wantInterval, test.wantQueue)
+		}
+	}
+}
+
+// A batch ES refuses outright is spooled rather than dropped.
+func TestElasticRejectedBatchIsSpooled(t *testing.T) {
+	fake := &fakeBulk{replies: []bulkReply{{403, nil}}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}, DisableRetry: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es := &ElasticSink{Client: client, BatchSize: 10, FlushInterval: time.Hour, MaxRetries: 2}
+	es.start(10)
+	spool := openTestSpool(t, 1<<20, 0)
+	ss := newSpoolingSink(es, spool, time.Hour, 10)
+	defer ss.Close()
+	for i := 0; i < 3; i++ {
+		ss.Emit(SSHDoc{Action: "login"})
+	}
+	if err := ss.Flush(); err == nil {
+		t.Error("flushing a refused batch gave no error")
+	}
+	_, docs, ok, err := spool.Oldest()
+	if err != nil || !ok || len(docs) != 3 {
+		t.Errorf("spool holds %d documents (%v, %v), want 3", len(docs), ok, err)
+	}
+	if fake.requests != 1 {
+		t.Errorf("a refused batch was sent %d times, want once", fake.requests)
+	}
+}
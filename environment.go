@@ -0,0 +1,238 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/honeystats/ssh/files"
+	"github.com/sirupsen/logrus"
+)
+
+// What an attacker leaves behind when they disconnect, so it's still there
+// when they reconnect.
+type Environment struct {
+	Root *files.FilesystemDir
+	// The physical working directory, and the one the shell showed.
+	Cwd     string
+	Pwd     string
+	History []string
+	// Roughly the memory it holds on to, see environmentSize.
+	Size    int64
+	savedAt time.Time
+}
+
+// Environments kept between sessions, keyed by client key fingerprint or
+// source IP. Entries expire TTL after they were last saved. An environment
+// that grows past MaxSize stops being saved, and the least recently saved
+// ones are dropped to stay within MaxEnvironments and MaxTotalSize.
+type EnvironmentStore struct {
+	TTL             time.Duration
+	MaxEnvironments int
+	MaxSize         int64
+	MaxTotalSize    int64
+
+	mu           sync.Mutex
+	environments map[string]*Environment
+	totalSize    int64
+}
+
+func newEnvironmentStore() *EnvironmentStore {
+	return &EnvironmentStore{
+		TTL:             7 * 24 * time.Hour,
+		MaxEnvironments: 10000,
+		MaxSize:         16 << 20,
+		MaxTotalSize:    512 << 20,
+		environments:    map[string]*Environment{},
+	}
+}
+
+// Sets up sticky environments when STICKY_ENVIRONMENTS is set.
+func setupEnvironments() {
+	if envOrDefault("STICKY_ENVIRONMENTS", "") == "" {
+		return
+	}
+	store := newEnvironmentStore()
+	store.TTL = envDurationOrDefault("STICKY_TTL", store.TTL)
+	store.MaxEnvironments = envIntOrDefault("STICKY_MAX_ENVIRONMENTS", store.MaxEnvironments)
+	store.MaxSize = int64(envIntOrDefault("STICKY_MAX_SIZE", int(store.MaxSize)))
+	store.MaxTotalSize = int64(envIntOrDefault("STICKY_MAX_TOTAL_SIZE", int(store.MaxTotalSize)))
+	sessionMap.Environments = store
+	go store.janitor(time.Minute)
+}
+
+// The key an attacker's environment is kept under: the first public key
+// they offered, or failing that their address.
+func environmentKey(ctx ssh.Context, state *SessionState) string {
+	_, _, keys := state.snapshot()
+	for _, key := range keys {
+		if key.Fingerprint != "" {
+			return "key:" + key.Fingerprint
+		}
+	}
+	ip, _ := sourceAddr(ctx)
+	return "ip:" + ip
+}
+
+func (store *EnvironmentStore) get(key string) *Environment {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	env, exists := store.environments[key]
+	if !exists {
+		return nil
+	}
+	if store.TTL > 0 && time.Since(env.savedAt) > store.TTL {
+		store.deleteLocked(key)
+		return nil
+	}
+	return env
+}
+
+func (store *EnvironmentStore) put(key string, env *Environment) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.MaxSize > 0 && env.Size > store.MaxSize {
+		logrus.WithFields(logrus.Fields{
+			"environment": key,
+			"size":        env.Size,
+		}).Warnln("Environment too large, not keeping its changes")
+		if old, exists := store.environments[key]; exists {
+			// Keep the files as they were last time.
+			env.Root = old.Root
+			env.Size = old.Size
+		} else {
+			env.Root = FILESYSTEM.Root
+			env.Size = 0
+		}
+	}
+	store.deleteLocked(key)
+	env.savedAt = time.Now()
+	store.environments[key] = env
+	store.totalSize += env.Size
+	store.evictLocked()
+}
+
+func (store *EnvironmentStore) deleteLocked(key string) {
+	if env, exists := store.environments[key]; exists {
+		store.totalSize -= env.Size
+		delete(store.environments, key)
+	}
+}
+
+// Drops expired environments, then the least recently saved ones until the
+// limits are met.
+func (store *EnvironmentStore) evictLocked() int {
+	evicted := 0
+	keys := make([]string, 0, len(store.environments))
+	for key, env := range store.environments {
+		if store.TTL > 0 && time.Since(env.savedAt) > store.TTL {
+			store.deleteLocked(key)
+			evicted++
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return store.environments[keys[i]].savedAt.Before(store.environments[keys[j]].savedAt)
+	})
+	for _, key := range keys {
+		overCount := store.MaxEnvironments > 0 && len(store.environments) > store.MaxEnvironments
+		overSize := store.MaxTotalSize > 0 && store.totalSize > store.MaxTotalSize
+		if !overCount && !overSize {
+			break
+		}
+		store.deleteLocked(key)
+		evicted++
+	}
+	return evicted
+}
+
+func (store *EnvironmentStore) Len() int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return len(store.environments)
+}
+
+func (store *EnvironmentStore) janitor(interval time.Duration) {
+	for range time.Tick(interval) {
+		store.mu.Lock()
+		evicted := store.evictLocked()
+		store.mu.Unlock()
+		if evicted > 0 {
+			logrus.WithField("count", evicted).Debugln("Evicted environments")
+		}
+	}
+}
+
+// Rough bytes taken by one file, directory or link in a copy of the tree:
+// the node itself and its share of the slices holding it.
+const environmentNodeSize = 256
+
+// Roughly the memory an environment with root holds on to. Any root other
+// than the shared one is a whole copy of the tree, so every node in it
+// counts, along with the file content that isn't shared.
+func environmentSize(root *files.FilesystemDir) int64 {
+	if root == FILESYSTEM.Root {
+		return 0
+	}
+	size := countNodes(root) * environmentNodeSize
+	for _, change := range files.Diff(FILESYSTEM.Root, root) {
+		if file, ok := change.Node.(*files.FilesystemFile); ok && change.Op != "removed" {
+			size += int64(len(file.Content))
+		}
+	}
+	return size
+}
+
+func countNodes(d *files.FilesystemDir) int64 {
+	count := int64(1 + len(d.Files) + len(d.Links))
+	for _, subdir := range d.Subdirs {
+		count += countNodes(subdir)
+	}
+	return count
+}
+
+// Picks up the environment kept under key, if there is one and the session
+// hasn't already been through this on another channel. Returns whether it
+// picked one up.
+func (state *SessionState) restoreEnvironment(key string, env *Environment) bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.envKey != "" {
+		return false
+	}
+	state.envKey = key
+	if env == nil {
+		return false
+	}
+	state.Root = env.Root
+	state.base = env.Root
+	state.ownRoot = false
+	state.Cwd = env.Root
+	state.Pwd = "/"
+	if err, res := env.Root.GetFileOrDir(env.Root, env.Cwd); err == nil {
+		if dir, ok := res.(*files.FilesystemDir); ok {
+			state.Cwd = dir
+			state.Pwd = env.Pwd
+		}
+	}
+	state.History = append([]string{}, env.History...)
+	return true
+}
+
+// Copies out the session's environment to be kept. The session gives up
+// its copy of the filesystem, so anything it changes from now on can't
+// reach the kept one.
+func (state *SessionState) saveEnvironment() (string, *Environment) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	env := &Environment{
+		Root:    state.Root,
+		Cwd:     state.Cwd.Path(),
+		Pwd:     state.Pwd,
+		History: append([]string{}, state.History...),
+	}
+	state.ownRoot = false
+	return state.envKey, env
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEnvironmentStore(t *testing.T) {
+	tests := []struct {
+		name                  string
+		maxEnvironments       int
+		maxSize, maxTotalSize int64
+		sizes                 []int64
+		wantKept              []bool
+		wantTotal             int64
+	}{
+		{"no limits", 0, 0, 0, []int64{10, 20, 30}, []bool{true, true, true}, 60},
+		{"count", 2, 0, 0, []int64{10, 20, 30}, []bool{false, true, true}, 50},
+		{"total size", 0, 0, 45, []int64{10, 20, 30}, []bool{false, false, true}, 30},
+		{"too large", 0, 25, 0, []int64{10, 20, 30}, []bool{true, true, true}, 30},
+	}
+	for _, test := range tests {
+		store := newEnvironmentStore()
+		store.MaxEnvironments = test.maxEnvironments
+		store.MaxSize = test.maxSize
+		store.MaxTotalSize = test.maxTotalSize
+		for i, size := range test.sizes {
+			store.put(string(rune('a'+i)), &Environment{Root: FILESYSTEM.Root, Size: size})
+			// Eviction goes by when each was saved.
+			time.Sleep(time.Millisecond)
+		}
+		for i, want := range test.wantKept {
+			if kept := store.get(string(rune('a'+i))) != nil; kept != want {
+				t.Errorf("%s: environment %d kept %v, want %v", test.name, i, kept, want)
+			}
+		}
+		if store.totalSize != test.wantTotal {
+			t.Errorf("%s: total size %d, want %d", test.name, store.totalSize, test.wantTotal)
+		}
+	}
+}
+
+func TestEnvironmentStoreTTL(t *testing.T) {
+	store := newEnvironmentStore()
+	store.TTL = time.Millisecond
+	store.put("a", &Environment{Root: FILESYSTEM.Root, Size: 10})
+	time.Sleep(5 * time.Millisecond)
+	if store.get("a") != nil {
+		t.Error("expired environment was handed out")
+	}
+	if store.Len() != 0 || store.totalSize != 0 {
+		t.Errorf("expired environment still counted: %d kept, %d bytes", store.Len(), store.totalSize)
+	}
+}
+
+func TestEnvironmentSize(t *testing.T) {
+	nodes := countNodes(FILESYSTEM.Root)
+	tests := []struct {
+		name    string
+		changes string
+		want    int64
+	}{
+		{"unchanged", "", 0},
+		{"cd only", "cd /a", 0},
+		{"new file", "echo hello > /a/new", (nodes+1)*environmentNodeSize + 6},
+		{"removed file", "rm /a/somefile", (nodes - 1) * environmentNodeSize},
+		{"new directory", "mkdir /a/dir", (nodes + 1) * environmentNodeSize},
+	}
+	for _, test := range tests {
+		state := newTestState()
+		runTestShell(state, test.changes)
+		if got := environmentSize(state.Root); got != test.want {
+			t.Errorf("%s: size %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func newTestEnvironmentMap() *SessionMap {
+	m := newTestSessionMap(0)
+	m.Environments = newEnvironmentStore()
+	return m
+}
+
+func TestStickyEnvironment(t *testing.T) {
+	m := newTestEnvironmentMap()
+	state, returning := m.getOrCreateWithEnvironment(newTestContext("root", "first"))
+	if returning {
+		t.Error("first session picked up an environment")
+	}
+	runTestShell(state, "mkdir /a/kept; cd /a/kept; echo x > file")
+	state.History = []string{"mkdir /a/kept"}
+	m.remove("first")
+	state, returning = m.getOrCreateWithEnvironment(newTestContext("root", "second"))
+	if !returning {
+		t.Fatal("second session didn't pick up the environment")
+	}
+	stdout, _, _ := runTestShell(state, "pwd; cat file")
+	if stdout != "/a/kept\nx\n" {
+		t.Errorf("returning session got %q", stdout)
+	}
+	if len(state.History) != 1 {
+		t.Errorf("history %q not kept", state.History)
+	}
+	if stdout, _, _ := runTestShell(newTestState(), "ls /a"); strings.Contains(stdout, "kept") {
+		t.Error("environment leaked into the shared filesystem")
+	}
+}
+
+// Every channel of a connection asks for the environment. Only the first
+// may restore it, or it would throw away what the others have done since.
+func TestStickyEnvironmentRestoredOnce(t *testing.T) {
+	m := newTestEnvironmentMap()
+	state, _ := m.getOrCreateWithEnvironment(newTestContext("root", "first"))
+	runTestShell(state, "cd /a")
+	m.remove("first")
+	ctx := newTestContext("root", "second")
+	var wg sync.WaitGroup
+	restores := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			state, returning := m.getOrCreateWithEnvironment(ctx)
+			restores <- returning
+			runTestShell(state, "touch /a/b/x; cd /a/b")
+		}()
+	}
+	wg.Wait()
+	close(restores)
+	count := 0
+	for returning := range restores {
+		if returning {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("environment restored %d times, want once", count)
+	}
+	state, returning := m.getOrCreateWithEnvironment(ctx)
+	if returning {
+		t.Error("environment restored again")
+	}
+	if stdout, _, _ := runTestShell(state, "pwd; ls x"); stdout != "/a/b\nx\n" {
+		t.Errorf("later channel sees %q, want the changes made since the restore", stdout)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/sirupsen/logrus"
+)
+
+// Handles `ssh host 'cmd'`: runs the command without a prompt, writing
+// stdout and stderr to their own streams, and exits with the status of the
+// last command, as sh -c would.
+func execHandler(s ssh.Session, state *SessionState) {
+	ctx := s.Context().(ssh.Context)
+	raw := s.RawCommand()
+	logrus.WithFields(logrus.Fields{
+		"user":    s.User(),
+		"id":      ctx.SessionID(),
+		"command": raw,
+	}).Infoln("SSH exec request")
+	if opts, ok := parseScpCommand(raw); ok {
+		exitCode := scpHandler(s, state, opts)
+		emitEvent(ctx, state, DocExec{
+			Command:  raw,
+			ExitCode: exitCode,
+		})
+		emitFilesystemDiff(ctx, state)
+		emitExecLogout(s, state)
+		s.Exit(exitCode)
+		return
+	}
+	var stdout io.Writer = s
+	var stderr io.Writer = s.Stderr()
+	if state.hasPty() {
+		stdout = &crlfWriter{w: stdout}
+		stderr = &crlfWriter{w: stderr}
+	}
+	sh := newShell(ctx, state, false)
+	exitCode := sh.Run(raw, stdout, stderr)
+	emitEvent(ctx, state, DocExec{
+		Command:  raw,
+		ExitCode: exitCode,
+		Payloads: append(capturePayloadsInCommand(ctx, raw), sh.payloads...),
+	})
+	emitFilesystemDiff(ctx, state)
+	emitExecLogout(s, state)
+	s.Exit(exitCode)
+}
+
+// An exec session ends with its command, so it logs out straight after.
+func emitExecLogout(s ssh.Session, state *SessionState) {
+	ctx := s.Context().(ssh.Context)
+	logrus.WithFields(logrus.Fields{
+		"user": s.User(),
+		"id":   ctx.SessionID(),
+	}).Infoln("SSH session closed")
+	emitEvent(ctx, state, DocLogout{
+		Username: s.User(),
+	})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExec(t *testing.T) {
+	addr, sink := startTestServer(t)
+	client := dialTestServer(t, addr)
+	tests := []struct {
+		command    string
+		wantStdout string
+		wantStderr string
+		wantStatus int
+	}{
+		{"echo hello", "hello\n", "", 0},
+		{"pwd; whoami", "/\nroot\n", "", 0},
+		{"nosuchcommand", "", "command not found: nosuchcommand\n", 127},
+		{"cat /nonexistent", "", "cat: /nonexistent: No such file or directory\n", 1},
+		{"exit 3", "", "", 3},
+		{"cd /nowhere || echo recovered", "recovered\n", "bash: cd: /nowhere: No such file or directory\n", 0},
+	}
+	for _, test := range tests {
+		before := len(sink.actions())
+		stdout, stderr, status := execTestCommand(t, client, test.command)
+		if stdout != test.wantStdout || stderr != test.wantStderr || status != test.wantStatus {
+			t.Errorf("%q gave %q, %q and status %d, want %q, %q and %d",
+				test.command, stdout, stderr, status, test.wantStdout, test.wantStderr, test.wantStatus)
+		}
+		actions := strings.Join(sink.actions()[before:], ",")
+		if !strings.HasSuffix(actions, "exec,logout") {
+			t.Errorf("%q emitted %s, want exec then logout", test.command, actions)
+		}
+	}
+}
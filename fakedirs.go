@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
+	"strconv"
 	"strings"
 
 	"github.com/honeystats/ssh/files"
@@ -13,7 +16,8 @@ import (
 var FILESYSTEM files.FilesystemConfig
 var CURRENT_DIR = "/"
 
-func init() {
+// Loads the fake filesystem from FILES_CONFIG.
+func setupFilesystem() {
 	filesConfig, configSet := os.LookupEnv("FILES_CONFIG")
 	if !configSet {
 		panic("FILES_CONFIG is not set.")
@@ -27,89 +31,190 @@ func init() {
 		}).Fatal("Error reading FILES_CONFIG")
 	}
 	FILESYSTEM = files.StrToFilesystem(bytes)
+	loadAccountNames(FILESYSTEM.Root)
 }
 
-func lsOne(root *files.FilesystemDir, cwd *files.FilesystemDir, path string) (error, string) {
-	err, f := cwd.GetFileOrDir(root, path)
-	if err != nil {
-		return err, ""
+// Names uids and gids after the fake /etc/passwd and /etc/group, so ls -l
+// agrees with them.
+func loadAccountNames(root *files.FilesystemDir) {
+	for filePath, names := range map[string]map[int]string{
+		"/etc/passwd": files.UserNames,
+		"/etc/group":  files.GroupNames,
+	} {
+		err, f := root.GetFileOrDir(root, filePath)
+		if err != nil {
+			continue
+		}
+		file, ok := f.(*files.FilesystemFile)
+		if !ok {
+			continue
+		}
+		for _, line := range strings.Split(file.Content, "\n") {
+			fields := strings.Split(line, ":")
+			if len(fields) < 3 {
+				continue
+			}
+			if id, err := strconv.Atoi(fields[2]); err == nil {
+				names[id] = fields[0]
+			}
+		}
 	}
-	return nil, f.Describe() + "\n"
 }
 
-func ls(root *files.FilesystemDir, cwd *files.FilesystemDir, path string) (error, string) {
-	trimmedPath := strings.Trim(path, " ")
-	if trimmedPath == "" {
-		return lsOne(root, cwd, "")
-	}
-	parts := strings.Split(trimmedPath, " ")
-	errs := []string{}
-	reses := []string{}
-	for _, part := range parts {
-		if part == "" {
-			continue
-		}
-		err, res := lsOne(root, cwd, part)
-		if err != nil {
-			errs = append(errs, err.Error())
+func init() {
+	registerCommand(cdCommand{commandSpec{
+		name:       "cd",
+		builtin:    true,
+		completion: completeDirs,
+		help:       "cd [-L|[-P [-e]] [-@]] [dir]\nChange the shell working directory.\n\nChange the current directory to DIR.  The default DIR is the value of the\nHOME shell variable.\n",
+	}})
+	registerCommand(catCommand{commandSpec{
+		name: "cat",
+		dir:  binDir,
+		help: "cat [OPTION]... [FILE]...\nConcatenate FILE(s) to standard output.\n\nWith no FILE, or when FILE is -, read standard input.\n",
+	}})
+}
+
+type cdCommand struct{ commandSpec }
+
+func (cdCommand) Run(inv *Invocation) int {
+	return inv.result(runCd(inv.State, inv.Args))
+}
+
+type catCommand struct{ commandSpec }
+
+func (catCommand) Run(inv *Invocation) int {
+	return inv.result(runCat(inv.State, inv.Args, inv.Stdin))
+}
+
+// Runs cd, which goes home without an argument and back to OLDPWD with "-",
+// and keeps PWD and OLDPWD up to date.
+func runCd(state *SessionState, args []string) CmdResult {
+	flags := []string{}
+	operands := []string{}
+	for _, arg := range args {
+		if arg == "-P" || arg == "-L" {
+			flags = append(flags, arg)
 		} else {
-			reses = append(reses, res)
+			operands = append(operands, arg)
 		}
 	}
-	resText := ""
-	for _, err := range errs {
-		resText += fmt.Sprintf("ls: %s\n", err)
+	stdout := ""
+	if len(operands) == 0 {
+		home, set := state.getVar("HOME")
+		if !set {
+			return CmdResult{Stderr: "bash: cd: HOME not set\n", ExitCode: 1}
+		}
+		operands = []string{home}
+	} else if operands[0] == "-" {
+		oldpwd, set := state.getVar("OLDPWD")
+		if !set {
+			return CmdResult{Stderr: "bash: cd: OLDPWD not set\n", ExitCode: 1}
+		}
+		operands[0] = oldpwd
+		stdout = oldpwd + "\n"
 	}
-	for _, res := range reses {
-		resText += res
+	oldPwd := state.pwd()
+	err := state.chdir(func(root *files.FilesystemDir, cwd *files.FilesystemDir) (error, *files.FilesystemDir, string) {
+		return cd(root, cwd, oldPwd, append(flags, operands...))
+	})
+	if err != nil {
+		message := err.Error()
+		if strings.HasSuffix(message, errNotDir.Error()) {
+			message = fmt.Sprintf("bash: cd: %s: %s", operands[0], errNotDir)
+		} else if !strings.HasPrefix(message, "bash: cd: ") {
+			message = fmt.Sprintf("bash: cd: %s: %s", operands[0], lookupError(err))
+		}
+		return CmdResult{Stderr: message + "\n", ExitCode: 1}
 	}
-	return nil, resText
+	state.setVar("OLDPWD", oldPwd)
+	state.setVar("PWD", state.pwd())
+	return CmdResult{Stdout: stdout}
 }
 
-func cd(root *files.FilesystemDir, cwd *files.FilesystemDir, path string) (error, *files.FilesystemDir) {
-	trimmedPath := strings.Trim(path, " ")
-	searchPath := trimmedPath
-	if trimmedPath == "" {
-		searchPath = "/"
+// Changes directory the way bash does. By default the new working directory
+// is worked out from the logical one, so `cd ..` after following a symlink
+// goes back where it came from. With -P symlinks are resolved first.
+// Returns the directory and the logical path to show as the working
+// directory.
+func cd(root *files.FilesystemDir, cwd *files.FilesystemDir, pwd string, args []string) (error, *files.FilesystemDir, string) {
+	physical := false
+	target := ""
+	for _, arg := range args {
+		switch {
+		case arg == "-P":
+			physical = true
+		case arg == "-L":
+			physical = false
+		case target == "":
+			target = arg
+		default:
+			return errors.New("bash: cd: too many arguments"), nil, ""
+		}
 	}
-	err, dir := cwd.GetFileOrDir(root, searchPath)
-	if err != nil {
-		return err, nil
+	if target == "" {
+		target = "/"
 	}
-	cdErr, cdRes := dir.TryCD()
-	return cdErr, cdRes
-}
-
-func catOne(root *files.FilesystemDir, cwd *files.FilesystemDir, path string) (error, string) {
-	err, f := cwd.GetFileOrDir(root, path)
+	if physical {
+		err, f := cwd.GetFileOrDir(root, target)
+		if err != nil {
+			return err, nil, ""
+		}
+		cdErr, dir := f.TryCD()
+		if cdErr != nil {
+			return cdErr, nil, ""
+		}
+		return nil, dir, dir.Path()
+	}
+	logical := target
+	if !strings.HasPrefix(target, "/") {
+		logical = pwd + "/" + target
+	}
+	logical = path.Clean(logical)
+	err, f := root.GetFileOrDir(root, logical)
 	if err != nil {
-		return err, ""
+		return err, nil, ""
+	}
+	cdErr, dir := f.TryCD()
+	if cdErr != nil {
+		return cdErr, nil, ""
 	}
-	return f.TryCat()
+	return nil, dir, logical
 }
 
-func cat(root *files.FilesystemDir, cwd *files.FilesystemDir, path string) (error, string) {
-	trimmedPath := strings.Trim(path, " ")
-	parts := strings.Split(trimmedPath, " ")
-	errs := []string{}
-	reses := []string{}
-	for _, part := range parts {
-		if part == "" {
+// Prints files in turn, or stdin when there are none or for "-".
+func runCat(state *SessionState, args []string, stdin string) CmdResult {
+	_, operands := splitFlags(args)
+	if len(operands) == 0 {
+		operands = []string{"-"}
+	}
+	res := CmdResult{}
+	for _, operand := range operands {
+		if operand == "-" {
+			res.Stdout += stdin
 			continue
 		}
-		err, res := catOne(root, cwd, part)
+		var err error
+		var content string
+		state.view(func(root *files.FilesystemDir, cwd *files.FilesystemDir) {
+			lookupErr, f := cwd.GetFileOrDir(root, operand)
+			if lookupErr != nil {
+				err = lookupError(lookupErr)
+				return
+			}
+			file, ok := f.(*files.FilesystemFile)
+			if !ok {
+				err = errIsDir
+				return
+			}
+			content = file.Read()
+		})
 		if err != nil {
-			errs = append(errs, err.Error())
-		} else {
-			reses = append(reses, res)
+			res.Stderr += fmt.Sprintf("cat: %s: %s\n", operand, err)
+			res.ExitCode = 1
+			continue
 		}
+		res.Stdout += content
 	}
-	resText := ""
-	for _, err := range errs {
-		resText += fmt.Sprintf("cat: %s\n", err)
-	}
-	for _, res := range reses {
-		resText += res
-	}
-	return nil, resText
+	return res
 }
@@ -0,0 +1,836 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/honeystats/ssh/files"
+)
+
+func init() {
+	registerCommand(touchCommand{commandSpec{
+		name: "touch",
+		dir:  binDir,
+		help: "touch [OPTION]... FILE...\nUpdate the access and modification times of each FILE to the current time.\n",
+	}})
+	registerCommand(mkdirCommand{commandSpec{
+		name:       "mkdir",
+		dir:        binDir,
+		completion: completeDirs,
+		help:       "mkdir [OPTION]... DIRECTORY...\nCreate the DIRECTORY(ies), if they do not already exist.\n",
+	}})
+	registerCommand(rmCommand{commandSpec{
+		name: "rm",
+		dir:  binDir,
+		help: "rm [OPTION]... [FILE]...\nRemove (unlink) the FILE(s).\n",
+	}})
+	registerCommand(mvCommand{commandSpec{
+		name: "mv",
+		dir:  binDir,
+		help: "mv [OPTION]... SOURCE... DIRECTORY\nRename SOURCE to DEST, or move SOURCE(s) to DIRECTORY.\n",
+	}})
+	registerCommand(cpCommand{commandSpec{
+		name: "cp",
+		dir:  binDir,
+		help: "cp [OPTION]... SOURCE... DIRECTORY\nCopy SOURCE to DEST, or multiple SOURCE(s) to DIRECTORY.\n",
+	}})
+	registerCommand(chmodCommand{commandSpec{
+		name: "chmod",
+		dir:  binDir,
+		help: "chmod [OPTION]... MODE[,MODE]... FILE...\nChange the mode of each FILE to MODE.\n",
+	}})
+	registerCommand(echoCommand{commandSpec{
+		name:    "echo",
+		dir:     binDir,
+		builtin: true,
+		help:    "echo [-neE] [arg ...]\nWrite arguments to the standard output.\n",
+	}})
+}
+
+type touchCommand struct{ commandSpec }
+
+func (touchCommand) Run(inv *Invocation) int {
+	return inv.result(runTouch(inv.State, inv.Args))
+}
+
+type mkdirCommand struct{ commandSpec }
+
+func (mkdirCommand) Run(inv *Invocation) int {
+	return inv.result(runMkdir(inv.State, inv.Args))
+}
+
+type rmCommand struct{ commandSpec }
+
+func (rmCommand) Run(inv *Invocation) int {
+	return inv.result(runRm(inv.State, inv.Args))
+}
+
+type mvCommand struct{ commandSpec }
+
+func (mvCommand) Run(inv *Invocation) int {
+	return inv.result(runMv(inv.State, inv.Args))
+}
+
+type cpCommand struct{ commandSpec }
+
+func (cpCommand) Run(inv *Invocation) int {
+	return inv.result(runCp(inv.State, inv.Args))
+}
+
+type chmodCommand struct{ commandSpec }
+
+func (chmodCommand) Run(inv *Invocation) int {
+	return inv.result(runChmod(inv.State, inv.Args))
+}
+
+type echoCommand struct{ commandSpec }
+
+func (echoCommand) Run(inv *Invocation) int {
+	return inv.result(runEcho(inv.Args))
+}
+
+var (
+	errNoEntry  = errors.New("No such file or directory")
+	errNotDir   = errors.New("Not a directory")
+	errIsDir    = errors.New("Is a directory")
+	errExists   = errors.New("File exists")
+	errNotEmpty = errors.New("Directory not empty")
+)
+
+// Most changes listed in one filesystem_diff event.
+const maxDiffChanges = 1000
+
+type DocFileChange struct {
+	Path   string   `json:"path"`
+	Op     string   `json:"op"`
+	Type   string   `json:"type"`
+	Fields []string `json:"fields,omitempty"`
+	Mode   string   `json:"mode"`
+	Size   int64    `json:"size"`
+	SHA256 string   `json:"sha256,omitempty"`
+	Target string   `json:"target,omitempty"`
+}
+
+type DocFilesystemDiff struct {
+	Changes   []DocFileChange `json:"changes"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+func (_ DocFilesystemDiff) action() string {
+	return "filesystem_diff"
+}
+
+// Logs what the session changed in the filesystem, if anything.
+func emitFilesystemDiff(ctx ssh.Context, state *SessionState) {
+	changes := state.filesystemDiff()
+	if len(changes) == 0 {
+		return
+	}
+	doc := DocFilesystemDiff{Changes: []DocFileChange{}}
+	if len(changes) > maxDiffChanges {
+		changes = changes[:maxDiffChanges]
+		doc.Truncated = true
+	}
+	for _, change := range changes {
+		info := change.Node.Info()
+		docChange := DocFileChange{
+			Path:   change.Path,
+			Op:     change.Op,
+			Type:   files.Kind(change.Node),
+			Fields: change.Fields,
+			Mode:   modeString(info.Mode),
+			Size:   info.Size,
+		}
+		switch node := change.Node.(type) {
+		case *files.FilesystemFile:
+			if change.Op != "removed" {
+				sum := sha256.Sum256([]byte(node.Content))
+				docChange.SHA256 = hex.EncodeToString(sum[:])
+			}
+		case *files.FilesystemLink:
+			docChange.Target = node.Target
+		}
+		doc.Changes = append(doc.Changes, docChange)
+	}
+	emitEvent(ctx, state, doc)
+}
+
+// Splits args into flags and operands. Flags are returned one letter at a
+// time, and long options whole.
+func splitFlags(args []string) ([]string, []string) {
+	flags := []string{}
+	operands := []string{}
+	for i, arg := range args {
+		if arg == "--" {
+			operands = append(operands, args[i+1:]...)
+			break
+		}
+		if strings.HasPrefix(arg, "--") {
+			flags = append(flags, arg)
+		} else if strings.HasPrefix(arg, "-") && arg != "-" {
+			for _, flag := range arg[1:] {
+				flags = append(flags, string(flag))
+			}
+		} else {
+			operands = append(operands, arg)
+		}
+	}
+	return flags, operands
+}
+
+func hasFlag(flags []string, names ...string) bool {
+	for _, flag := range flags {
+		for _, name := range names {
+			if flag == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Finds the directory that holds p and the name p has in it.
+func parentAndName(root *files.FilesystemDir, cwd *files.FilesystemDir, p string) (*files.FilesystemDir, string, error) {
+	trimmed := strings.TrimRight(p, "/")
+	if trimmed == "" {
+		return root.Parent, "/", nil
+	}
+	dirPath, name := path.Split(trimmed)
+	err, res := cwd.GetFileOrDir(root, dirPath)
+	if err == files.ErrLinkLoop {
+		return nil, "", err
+	}
+	if err != nil {
+		return nil, "", errNoEntry
+	}
+	dir, ok := res.(*files.FilesystemDir)
+	if !ok {
+		return nil, "", errNotDir
+	}
+	return dir, name, nil
+}
+
+func lookupError(err error) error {
+	if err == files.ErrLinkLoop {
+		return err
+	}
+	return errNoEntry
+}
+
+func runTouch(state *SessionState, args []string) CmdResult {
+	flags, operands := splitFlags(args)
+	if len(operands) == 0 {
+		return CmdResult{Stderr: "touch: missing file operand\nTry 'touch --help' for more information.\n", ExitCode: 1}
+	}
+	noCreate := hasFlag(flags, "c", "--no-create")
+	res := CmdResult{}
+	for _, operand := range operands {
+		err := state.mutate(func(root *files.FilesystemDir, cwd *files.FilesystemDir) error {
+			if err, existing := cwd.GetFileOrDir(root, operand); err == nil {
+				files.Touch(existing)
+				return nil
+			}
+			dir, name, err := parentAndName(root, cwd, operand)
+			if err != nil {
+				return err
+			}
+			if _, exists := dir.Entry(name); exists {
+				// A symlink that leads nowhere.
+				return errNoEntry
+			}
+			if noCreate {
+				return nil
+			}
+			err, _ = dir.WriteFile(name, "")
+			return err
+		})
+		if err != nil {
+			res.Stderr += fmt.Sprintf("touch: cannot touch '%s': %s\n", operand, err)
+			res.ExitCode = 1
+		}
+	}
+	return res
+}
+
+func runMkdir(state *SessionState, args []string) CmdResult {
+	parents := false
+	verbose := false
+	var mode *files.Permissions
+	operands := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-p" || arg == "--parents":
+			parents = true
+		case arg == "-v" || arg == "--verbose":
+			verbose = true
+		case arg == "-m" && i+1 < len(args):
+			i++
+			perm, err := parseMode(args[i], 0777)
+			if err != nil {
+				return CmdResult{Stderr: fmt.Sprintf("mkdir: invalid mode '%s'\n", args[i]), ExitCode: 1}
+			}
+			mode = &perm
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			for _, flag := range arg[1:] {
+				switch flag {
+				case 'p':
+					parents = true
+				case 'v':
+					verbose = true
+				default:
+					return CmdResult{Stderr: fmt.Sprintf("mkdir: invalid option -- '%c'\nTry 'mkdir --help' for more information.\n", flag), ExitCode: 1}
+				}
+			}
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	if len(operands) == 0 {
+		return CmdResult{Stderr: "mkdir: missing operand\nTry 'mkdir --help' for more information.\n", ExitCode: 1}
+	}
+	res := CmdResult{}
+	for _, operand := range operands {
+		created := []string{}
+		err := state.mutate(func(root *files.FilesystemDir, cwd *files.FilesystemDir) error {
+			if parents {
+				dir := cwd
+				if strings.HasPrefix(operand, "/") {
+					dir = root
+				}
+				soFar := strings.TrimSuffix(operand, strings.TrimLeft(operand, "/"))
+				for _, part := range strings.Split(operand, "/") {
+					if part == "" {
+						continue
+					}
+					soFar = path.Join(soFar, part)
+					if part == "." || part == ".." {
+						_, dir = dir.GetSubdir(part)
+						continue
+					}
+					if _, exists := dir.Entry(part); exists {
+						err, res := dir.GetFileOrDir(root, part)
+						next, ok := res.(*files.FilesystemDir)
+						if err != nil || !ok {
+							return errExists
+						}
+						dir = next
+						continue
+					}
+					err, next := dir.Mkdir(part)
+					if err != nil {
+						return err
+					}
+					if mode != nil {
+						files.Chmod(next, *mode)
+					}
+					created = append(created, soFar)
+					dir = next
+				}
+				return nil
+			}
+			dir, name, err := parentAndName(root, cwd, operand)
+			if err != nil {
+				return err
+			}
+			if _, exists := dir.Entry(name); exists {
+				return errExists
+			}
+			err, next := dir.Mkdir(name)
+			if err != nil {
+				return err
+			}
+			if mode != nil {
+				files.Chmod(next, *mode)
+			}
+			created = append(created, operand)
+			return nil
+		})
+		if err != nil {
+			res.Stderr += fmt.Sprintf("mkdir: cannot create directory '%s': %s\n", operand, err)
+			res.ExitCode = 1
+		}
+		if verbose {
+			for _, dir := range created {
+				res.Stdout += fmt.Sprintf("mkdir: created directory '%s'\n", dir)
+			}
+		}
+	}
+	return res
+}
+
+func runRm(state *SessionState, args []string) CmdResult {
+	flags, operands := splitFlags(args)
+	recursive := hasFlag(flags, "r", "R", "--recursive")
+	force := hasFlag(flags, "f", "--force")
+	emptyDirs := hasFlag(flags, "d", "--dir")
+	verbose := hasFlag(flags, "v", "--verbose")
+	if len(operands) == 0 {
+		if force {
+			return CmdResult{}
+		}
+		return CmdResult{Stderr: "rm: missing operand\nTry 'rm --help' for more information.\n", ExitCode: 1}
+	}
+	res := CmdResult{}
+	for _, operand := range operands {
+		base := path.Base(operand)
+		if base == "." || base == ".." {
+			res.Stderr += fmt.Sprintf("rm: refusing to remove '.' or '..' directory: skipping '%s'\n", operand)
+			res.ExitCode = 1
+			continue
+		}
+		err := state.mutate(func(root *files.FilesystemDir, cwd *files.FilesystemDir) error {
+			err, entry := cwd.GetFileOrDirNoFollow(root, operand)
+			if err != nil {
+				return lookupError(err)
+			}
+			if dir, isDir := entry.(*files.FilesystemDir); isDir {
+				if dir == root && recursive {
+					return errors.New("preserve-root")
+				}
+				empty := len(dir.Subdirs)+len(dir.Files)+len(dir.Links) == 0
+				if !recursive && !(emptyDirs && empty) {
+					return errIsDir
+				}
+			}
+			parent, name, err := parentAndName(root, cwd, operand)
+			if err != nil {
+				return err
+			}
+			_, err = parent.Detach(name)
+			return err
+		})
+		switch {
+		case err == nil:
+			if verbose {
+				res.Stdout += fmt.Sprintf("removed '%s'\n", operand)
+			}
+		case err.Error() == "preserve-root":
+			res.Stderr += "rm: it is dangerous to operate recursively on '/'\nrm: use --no-preserve-root to override this failsafe\n"
+			res.ExitCode = 1
+		case err == errNoEntry && force:
+		default:
+			res.Stderr += fmt.Sprintf("rm: cannot remove '%s': %s\n", operand, err)
+			res.ExitCode = 1
+		}
+	}
+	return res
+}
+
+// Where a mv or cp of src to dest puts it: inside dest if that's a
+// directory, else at dest itself.
+func destination(root *files.FilesystemDir, cwd *files.FilesystemDir, src string, dest string) (*files.FilesystemDir, string, error) {
+	if err, res := cwd.GetFileOrDir(root, dest); err == nil {
+		if dir, ok := res.(*files.FilesystemDir); ok {
+			return dir, path.Base(strings.TrimRight(src, "/")), nil
+		}
+	}
+	return parentAndName(root, cwd, dest)
+}
+
+// Makes room for a node called name in dir, replacing what's there the
+// way mv and cp do.
+func makeRoom(dir *files.FilesystemDir, name string, incoming files.FileDir) error {
+	existing, exists := dir.Entry(name)
+	if !exists {
+		return nil
+	}
+	if existing == incoming {
+		return errors.New("same file")
+	}
+	_, incomingIsDir := incoming.(*files.FilesystemDir)
+	existingDir, existingIsDir := existing.(*files.FilesystemDir)
+	switch {
+	case existingIsDir && !incomingIsDir:
+		return errIsDir
+	case !existingIsDir && incomingIsDir:
+		return errNotDir
+	case existingIsDir && len(existingDir.Subdirs)+len(existingDir.Files)+len(existingDir.Links) > 0:
+		return errNotEmpty
+	}
+	_, err := dir.Detach(name)
+	return err
+}
+
+func runMv(state *SessionState, args []string) CmdResult {
+	_, operands := splitFlags(args)
+	switch len(operands) {
+	case 0:
+		return CmdResult{Stderr: "mv: missing file operand\nTry 'mv --help' for more information.\n", ExitCode: 1}
+	case 1:
+		return CmdResult{Stderr: fmt.Sprintf("mv: missing destination file operand after '%s'\nTry 'mv --help' for more information.\n", operands[0]), ExitCode: 1}
+	}
+	dest := operands[len(operands)-1]
+	sources := operands[:len(operands)-1]
+	if len(sources) > 1 && !isDir(state, dest) {
+		return CmdResult{Stderr: fmt.Sprintf("mv: target '%s' is not a directory\n", dest), ExitCode: 1}
+	}
+	res := CmdResult{}
+	for _, src := range sources {
+		err := state.mutate(func(root *files.FilesystemDir, cwd *files.FilesystemDir) error {
+			err, entry := cwd.GetFileOrDirNoFollow(root, src)
+			if err != nil {
+				return fmt.Errorf("cannot stat '%s': %s", src, lookupError(err))
+			}
+			srcDir, srcName, err := parentAndName(root, cwd, src)
+			if err != nil {
+				return fmt.Errorf("cannot stat '%s': %s", src, err)
+			}
+			destDir, destName, err := destination(root, cwd, src, dest)
+			if err != nil {
+				return fmt.Errorf("cannot move '%s' to '%s': %s", src, dest, err)
+			}
+			if err := makeRoom(destDir, destName, entry); err != nil {
+				if err.Error() == "same file" {
+					return fmt.Errorf("'%s' and '%s' are the same file", src, dest)
+				}
+				return fmt.Errorf("cannot move '%s' to '%s': %s", src, dest, err)
+			}
+			node, err := srcDir.Detach(srcName)
+			if err != nil {
+				return fmt.Errorf("cannot stat '%s': %s", src, err)
+			}
+			if err := destDir.Attach(node, destName); err != nil {
+				// Put it back rather than lose it.
+				srcDir.Attach(node, srcName)
+				if err == files.ErrIntoItself {
+					return fmt.Errorf("cannot move '%s' to a subdirectory of itself, '%s'", src, dest)
+				}
+				return fmt.Errorf("cannot move '%s' to '%s': %s", src, dest, err)
+			}
+			return nil
+		})
+		if err != nil {
+			res.Stderr += fmt.Sprintf("mv: %s\n", err)
+			res.ExitCode = 1
+		}
+	}
+	return res
+}
+
+func runCp(state *SessionState, args []string) CmdResult {
+	flags, operands := splitFlags(args)
+	recursive := hasFlag(flags, "r", "R", "a", "--recursive", "--archive")
+	preserve := hasFlag(flags, "p", "a", "--archive", "--preserve")
+	switch len(operands) {
+	case 0:
+		return CmdResult{Stderr: "cp: missing file operand\nTry 'cp --help' for more information.\n", ExitCode: 1}
+	case 1:
+		return CmdResult{Stderr: fmt.Sprintf("cp: missing destination file operand after '%s'\nTry 'cp --help' for more information.\n", operands[0]), ExitCode: 1}
+	}
+	dest := operands[len(operands)-1]
+	sources := operands[:len(operands)-1]
+	if len(sources) > 1 && !isDir(state, dest) {
+		return CmdResult{Stderr: fmt.Sprintf("cp: target '%s' is not a directory\n", dest), ExitCode: 1}
+	}
+	res := CmdResult{}
+	for _, src := range sources {
+		err := state.mutate(func(root *files.FilesystemDir, cwd *files.FilesystemDir) error {
+			err, entry := cwd.GetFileOrDir(root, src)
+			if err != nil {
+				return fmt.Errorf("cannot stat '%s': %s", src, lookupError(err))
+			}
+			if _, isDir := entry.(*files.FilesystemDir); isDir && !recursive {
+				return fmt.Errorf("-r not specified; omitting directory '%s'", src)
+			}
+			destDir, destName, err := destination(root, cwd, src, dest)
+			if err != nil {
+				return fmt.Errorf("cannot create regular file '%s': %s", dest, err)
+			}
+			if existing, exists := destDir.Entry(destName); exists {
+				if existing == entry {
+					return fmt.Errorf("'%s' and '%s' are the same file", src, dest)
+				}
+				if file, ok := existing.(*files.FilesystemFile); ok {
+					if srcFile, ok := entry.(*files.FilesystemFile); ok {
+						// Overwriting keeps the file, hard links and all.
+						file.Content = srcFile.Read()
+						file.Generate = nil
+						files.Touch(file)
+						return nil
+					}
+				}
+			}
+			if err := makeRoom(destDir, destName, entry); err != nil {
+				return fmt.Errorf("cannot overwrite '%s': %s", dest, err)
+			}
+			node := files.Copy(entry)
+			if !preserve {
+				files.Touch(node)
+			}
+			return destDir.Attach(node, destName)
+		})
+		if err != nil {
+			res.Stderr += fmt.Sprintf("cp: %s\n", err)
+			res.ExitCode = 1
+		}
+	}
+	return res
+}
+
+func isDir(state *SessionState, p string) bool {
+	ok := false
+	state.view(func(root *files.FilesystemDir, cwd *files.FilesystemDir) {
+		if err, res := cwd.GetFileOrDir(root, p); err == nil {
+			_, ok = res.(*files.FilesystemDir)
+		}
+	})
+	return ok
+}
+
+// Parses a chmod mode, either octal or symbolic like u+x,go-w, applied to
+// the current permissions.
+func parseMode(mode string, current files.Permissions) (files.Permissions, error) {
+	if n, err := strconv.ParseUint(mode, 8, 32); err == nil {
+		if n > 07777 {
+			return 0, fmt.Errorf("invalid mode: '%s'", mode)
+		}
+		return files.Permissions(n), nil
+	}
+	perm := current
+	for _, clause := range strings.Split(mode, ",") {
+		who := 0
+		i := 0
+		for ; i < len(clause) && strings.ContainsRune("ugoa", rune(clause[i])); i++ {
+			switch clause[i] {
+			case 'u':
+				who |= 04700
+			case 'g':
+				who |= 02070
+			case 'o':
+				who |= 01007
+			case 'a':
+				who |= 07777
+			}
+		}
+		if who == 0 {
+			who = 07777
+		}
+		if i == len(clause) {
+			return 0, fmt.Errorf("invalid mode: '%s'", mode)
+		}
+		for i < len(clause) {
+			op := clause[i]
+			if op != '+' && op != '-' && op != '=' {
+				return 0, fmt.Errorf("invalid mode: '%s'", mode)
+			}
+			i++
+			bits := 0
+			for ; i < len(clause) && strings.ContainsRune("rwxXst", rune(clause[i])); i++ {
+				switch clause[i] {
+				case 'r':
+					bits |= 0444
+				case 'w':
+					bits |= 0222
+				case 'x':
+					bits |= 0111
+				case 'X':
+					if int(perm)&0111 != 0 {
+						bits |= 0111
+					}
+				case 's':
+					bits |= 06000
+				case 't':
+					bits |= 01000
+				}
+			}
+			bits &= who
+			switch op {
+			case '+':
+				perm |= files.Permissions(bits)
+			case '-':
+				perm &^= files.Permissions(bits)
+			case '=':
+				perm = perm&^files.Permissions(who) | files.Permissions(bits)
+			}
+		}
+	}
+	return perm, nil
+}
+
+func runChmod(state *SessionState, args []string) CmdResult {
+	flags := []string{}
+	operands := []string{}
+	for _, arg := range args {
+		// Modes like -x look like flags but aren't.
+		if strings.HasPrefix(arg, "-") && len(operands) == 0 && strings.Trim(arg[1:], "Rvcf") == "" {
+			flags = append(flags, arg[1:])
+			continue
+		}
+		operands = append(operands, arg)
+	}
+	recursive := false
+	for _, flag := range flags {
+		if strings.Contains(flag, "R") {
+			recursive = true
+		}
+	}
+	if len(operands) == 0 {
+		return CmdResult{Stderr: "chmod: missing operand\nTry 'chmod --help' for more information.\n", ExitCode: 1}
+	}
+	if len(operands) == 1 {
+		return CmdResult{Stderr: fmt.Sprintf("chmod: missing operand after '%s'\nTry 'chmod --help' for more information.\n", operands[0]), ExitCode: 1}
+	}
+	mode := operands[0]
+	if _, err := parseMode(mode, 0); err != nil {
+		return CmdResult{Stderr: fmt.Sprintf("chmod: %s\nTry 'chmod --help' for more information.\n", err), ExitCode: 1}
+	}
+	res := CmdResult{}
+	for _, operand := range operands[1:] {
+		err := state.mutate(func(root *files.FilesystemDir, cwd *files.FilesystemDir) error {
+			err, entry := cwd.GetFileOrDir(root, operand)
+			if err != nil {
+				return lookupError(err)
+			}
+			var apply func(entry files.FileDir)
+			apply = func(entry files.FileDir) {
+				perm, _ := parseMode(mode, files.PermissionsFromMode(entry.Info().Mode))
+				files.Chmod(entry, perm)
+				if dir, ok := entry.(*files.FilesystemDir); ok && recursive {
+					for _, subdir := range dir.Subdirs {
+						apply(subdir)
+					}
+					for _, file := range dir.Files {
+						apply(file)
+					}
+				}
+			}
+			apply(entry)
+			return nil
+		})
+		if err != nil {
+			res.Stderr += fmt.Sprintf("chmod: cannot access '%s': %s\n", operand, err)
+			res.ExitCode = 1
+		}
+	}
+	return res
+}
+
+func runEcho(args []string) CmdResult {
+	newline := true
+	escapes := false
+	for len(args) > 0 && len(args[0]) > 1 && strings.Trim(args[0], "-neE") == "" && strings.HasPrefix(args[0], "-") {
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'n':
+				newline = false
+			case 'e':
+				escapes = true
+			case 'E':
+				escapes = false
+			}
+		}
+		args = args[1:]
+	}
+	out := strings.Join(args, " ")
+	if escapes {
+		var stop bool
+		out, stop = expandEchoEscapes(out)
+		if stop {
+			newline = false
+		}
+	}
+	if newline {
+		out += "\n"
+	}
+	return CmdResult{Stdout: out}
+}
+
+// Handles the backslash escapes echo -e knows. \c stops output there.
+func expandEchoEscapes(s string) (string, bool) {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			out.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case 'r':
+			out.WriteByte('\r')
+		case 'a':
+			out.WriteByte('\a')
+		case 'b':
+			out.WriteByte('\b')
+		case 'e':
+			out.WriteByte('\x1b')
+		case 'f':
+			out.WriteByte('\f')
+		case 'v':
+			out.WriteByte('\v')
+		case '\\':
+			out.WriteByte('\\')
+		case 'c':
+			return out.String(), true
+		case '0':
+			n, j := 0, i+1
+			for ; j < len(s) && j < i+4 && s[j] >= '0' && s[j] <= '7'; j++ {
+				n = n*8 + int(s[j]-'0')
+			}
+			out.WriteByte(byte(n))
+			i = j - 1
+		case 'x':
+			j := i + 1
+			for ; j < len(s) && j < i+3 && strings.ContainsRune("0123456789abcdefABCDEF", rune(s[j])); j++ {
+			}
+			if j == i+1 {
+				out.WriteString("\\x")
+				continue
+			}
+			n, _ := strconv.ParseUint(s[i+1:j], 16, 8)
+			out.WriteByte(byte(n))
+			i = j - 1
+		default:
+			out.WriteByte('\\')
+			out.WriteByte(s[i])
+		}
+	}
+	return out.String(), false
+}
+
+// Writes a command's output to a file for > and >>. Symlinks are followed,
+// and one that leads nowhere creates its target.
+func redirectOutput(state *SessionState, target string, data string, appendTo bool) error {
+	return state.mutate(func(root *files.FilesystemDir, cwd *files.FilesystemDir) error {
+		for depth := 0; depth < files.MaxLinkDepth; depth++ {
+			err, entry := cwd.GetFileOrDirNoFollow(root, target)
+			if err != nil {
+				dir, name, err := parentAndName(root, cwd, target)
+				if err != nil {
+					return err
+				}
+				if err, _ := dir.WriteFile(name, data); err != nil {
+					return errNoEntry
+				}
+				return nil
+			}
+			switch node := entry.(type) {
+			case *files.FilesystemDir:
+				return errIsDir
+			case *files.FilesystemFile:
+				if appendTo {
+					node.Content = node.Read() + data
+				} else {
+					node.Content = data
+				}
+				node.Generate = nil
+				files.Touch(node)
+				return nil
+			case *files.FilesystemLink:
+				cwd = node.Parent
+				target = node.Target
+			}
+		}
+		return files.ErrLinkLoop
+	})
+}
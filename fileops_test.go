@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/honeystats/ssh/files"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		current files.Permissions
+		want    files.Permissions
+		wantErr bool
+	}{
+		{"000", 0644, 0, false},
+		{"0755", 0, 0755, false},
+		{"4755", 0644, 04755, false},
+		{"u+x", 0644, 0744, false},
+		{"go-w", 0666, 0644, false},
+		{"a=r", 0777, 0444, false},
+		{"u=,g=,o=", 0755, 0, false},
+		{"+x", 0644, 0755, false},
+		{"u+s", 0755, 04755, false},
+		{"+t", 0777, 01777, false},
+		{"u+rw,o-r", 0004, 0600, false},
+		{"g+X", 0700, 0710, false},
+		{"g+X", 0600, 0600, false},
+		{"10000", 0644, 0, true},
+		{"u", 0644, 0, true},
+		{"u*x", 0644, 0, true},
+	}
+	for _, test := range tests {
+		got, err := parseMode(test.mode, test.current)
+		if (err != nil) != test.wantErr || (err == nil && got != test.want) {
+			t.Errorf("parseMode(%q, %04o) = %04o, %v, want %04o, error %v",
+				test.mode, test.current, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestChmodModes(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"chmod 000 /a/somefile; ls -l /a/somefile", "----------"},
+		{"chmod 4755 /a/somefile; ls -l /a/somefile", "-rwsr-xr-x"},
+		{"chmod a= /a/b; ls -ld /a/b", "d---------"},
+		{"chmod 1777 /a/b; ls -ld /a/b", "drwxrwxrwt"},
+	}
+	for _, test := range tests {
+		stdout, stderr, _ := runTestShell(newTestState(), test.cmd)
+		if len(stdout) < 10 || stdout[:10] != test.want {
+			t.Errorf("%q gave %q, %q, want mode %s", test.cmd, stdout, stderr, test.want)
+		}
+	}
+}
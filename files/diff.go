@@ -0,0 +1,124 @@
+package files
+
+import (
+	"sort"
+)
+
+// Change is one difference between two trees.
+type Change struct {
+	Path string
+	// "added", "removed" or "modified".
+	Op string
+	// For modifications, what changed: "content", "target", "permissions",
+	// "owner" or "mtime".
+	Fields []string
+	// The node as it is now, or as it was for removals.
+	Node FileDir
+}
+
+// Diff lists what differs in changed compared to base, by path. Everything
+// in an added directory is listed too, while a removed directory is listed
+// once. Directories only count as modified when their permissions or owner
+// change, not whenever something inside them does.
+func Diff(base *FilesystemDir, changed *FilesystemDir) []Change {
+	changes := []Change{}
+	diffDir(base, changed, "", &changes)
+	return changes
+}
+
+func rawEntries(d *FilesystemDir) map[string]FileDir {
+	entries := map[string]FileDir{}
+	if d == nil {
+		return entries
+	}
+	for _, subdir := range d.Subdirs {
+		entries[subdir.Name] = subdir
+	}
+	for _, file := range d.Files {
+		entries[file.Name] = file
+	}
+	for _, link := range d.Links {
+		entries[link.Name] = link
+	}
+	return entries
+}
+
+func diffDir(base *FilesystemDir, changed *FilesystemDir, dirPath string, changes *[]Change) {
+	baseEntries := rawEntries(base)
+	changedEntries := rawEntries(changed)
+	names := []string{}
+	for name := range baseEntries {
+		names = append(names, name)
+	}
+	for name := range changedEntries {
+		if _, ok := baseEntries[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entryPath := dirPath + "/" + name
+		before, inBase := baseEntries[name]
+		after, inChanged := changedEntries[name]
+		if inBase && (!inChanged || Kind(before) != Kind(after)) {
+			*changes = append(*changes, Change{Path: entryPath, Op: "removed", Node: before})
+			before, inBase = nil, false
+		}
+		if !inChanged {
+			continue
+		}
+		if !inBase {
+			*changes = append(*changes, Change{Path: entryPath, Op: "added", Node: after})
+			if dir, ok := after.(*FilesystemDir); ok {
+				diffDir(nil, dir, entryPath, changes)
+			}
+			continue
+		}
+		if fields := changedFields(before, after); len(fields) > 0 {
+			*changes = append(*changes, Change{Path: entryPath, Op: "modified", Fields: fields, Node: after})
+		}
+		if dir, ok := after.(*FilesystemDir); ok {
+			diffDir(before.(*FilesystemDir), dir, entryPath, changes)
+		}
+	}
+}
+
+// Kind names the type of a node: "file", "dir", "symlink" or "hardlink".
+func Kind(entry FileDir) string {
+	switch node := entry.(type) {
+	case *FilesystemDir:
+		return "dir"
+	case *FilesystemLink:
+		if node.Hard {
+			return "hardlink"
+		}
+		return "symlink"
+	}
+	return "file"
+}
+
+func changedFields(before FileDir, after FileDir) []string {
+	fields := []string{}
+	switch b := before.(type) {
+	case *FilesystemFile:
+		if b.Content != after.(*FilesystemFile).Content {
+			fields = append(fields, "content")
+		}
+	case *FilesystemLink:
+		if b.Target != after.(*FilesystemLink).Target {
+			fields = append(fields, "target")
+		}
+	}
+	beforeInfo, afterInfo := before.Info(), after.Info()
+	if beforeInfo.Mode != afterInfo.Mode {
+		fields = append(fields, "permissions")
+	}
+	if beforeInfo.UID != afterInfo.UID || beforeInfo.GID != afterInfo.GID {
+		fields = append(fields, "owner")
+	}
+	// Only worth mentioning when nothing else explains it, as after touch.
+	if _, isDir := before.(*FilesystemDir); !isDir && !beforeInfo.ModTime.Equal(afterInfo.ModTime) && len(fields) == 0 {
+		fields = append(fields, "mtime")
+	}
+	return fields
+}
@@ -3,14 +3,15 @@ package files
 import (
 	"errors"
 	"fmt"
+	"os"
 	"sort"
-	"strings"
 
 	"github.com/fatih/color"
 	"gopkg.in/yaml.v2"
 )
 
 type FileDir interface {
+	Info() FileInfo
 	Describe() string
 	DescribeSelf() string
 	PlainName() string
@@ -24,12 +25,25 @@ type FilesystemFile struct {
 	Name    string         `yaml:"name"`
 	Content string         `yaml:"content"`
 	Parent  *FilesystemDir `yaml:"-"`
+	Attrs   `yaml:",inline"`
+	// Generate, if set, makes the content afresh each time the file is
+	// read, as for the files in /proc. Content stays empty, so such files
+	// list with size 0 as real ones do.
+	Generate func() string `yaml:"-"`
+	// Hard links to this file elsewhere in the tree.
+	hardLinks int
 }
 
 func (f FilesystemFile) PlainName() string {
 	return f.Name
 }
 
+func (f FilesystemFile) Info() FileInfo {
+	info := f.Attrs.info(0644, int64(len(f.Content)))
+	info.Links = 1 + f.hardLinks
+	return info
+}
+
 func (f FilesystemFile) TabcompleteName() string {
 	return f.Name + " "
 }
@@ -51,18 +65,27 @@ func (f FilesystemFile) TryCD() (error, *FilesystemDir) {
 }
 
 func (f FilesystemFile) TryCat() (error, string) {
-	return nil, f.Content
+	return nil, f.Read()
+}
+
+// Read returns what reading the file gives.
+func (f FilesystemFile) Read() string {
+	if f.Generate != nil {
+		return f.Generate()
+	}
+	return f.Content
 }
 
 type FilesystemDir struct {
-	Name        string            `yaml:"name"`
-	Permissions int               `yaml:"permissions"`
-	Subdirs     []*FilesystemDir  `yaml:"subdirs"`
-	Files       []*FilesystemFile `yaml:"files"`
-	Parent      *FilesystemDir    `yaml:"-"`
+	Name    string            `yaml:"name"`
+	Subdirs []*FilesystemDir  `yaml:"subdirs"`
+	Files   []*FilesystemFile `yaml:"files"`
+	Links   []*FilesystemLink `yaml:"links,omitempty"`
+	Parent  *FilesystemDir    `yaml:"-"`
+	Attrs   `yaml:",inline"`
 }
 
-func (d FilesystemDir) Path() string {
+func (d *FilesystemDir) Path() string {
 	return d.PathHelp(false)
 }
 
@@ -97,6 +120,15 @@ func (d *FilesystemDir) GetSubdir(name string) (error, *FilesystemDir) {
 	return errors.New("No subdir with name " + name), nil
 }
 
+func (d *FilesystemDir) GetLink(name string) (error, *FilesystemLink) {
+	for _, link := range d.Links {
+		if link.Name == name {
+			return nil, link
+		}
+	}
+	return errors.New("No link with name " + name), nil
+}
+
 func (d *FilesystemDir) GetFile(name string) (error, *FilesystemFile) {
 	for _, file := range d.Files {
 		if file.Name == name {
@@ -110,6 +142,14 @@ func (d FilesystemDir) PlainName() string {
 	return d.Name
 }
 
+func (d FilesystemDir) Info() FileInfo {
+	info := d.Attrs.info(0755, 4096)
+	info.Mode |= os.ModeDir
+	// Its entry in the parent, its own "." and each subdirectory's "..".
+	info.Links = 2 + len(d.Subdirs)
+	return info
+}
+
 func (d FilesystemDir) TabcompleteName() string {
 	return d.Name + "/"
 }
@@ -157,71 +197,115 @@ func fillInParents(root *FilesystemDir) {
 	for _, file := range root.Files {
 		file.Parent = root
 	}
+	for _, link := range root.Links {
+		link.Parent = root
+	}
 }
 
-func (cfg *FilesystemConfig) ToString() (string, error) {
-	data, err := yaml.Marshal(cfg)
-	return string(data), err
-}
-
-func StrToFilesystem(cfg []byte) FilesystemConfig {
-	ret := &FilesystemConfig{}
-	yaml.Unmarshal(cfg, ret)
-	ret.Root.Parent = ret.Root
-	fillInParents(ret.Root)
-	return *ret
+// Clone deep copies the tree rooted at d. File contents are shared, since
+// strings are immutable.
+func (d *FilesystemDir) Clone() *FilesystemDir {
+	clone := d.cloneHelp()
+	clone.Parent = clone
+	resolveHardLinks(clone)
+	return clone
 }
 
-func (cwd *FilesystemDir) GetFileOrDir(root *FilesystemDir, path string) (error, FileDir) {
-	if path == "" {
-		return nil, cwd
+func (d *FilesystemDir) cloneHelp() *FilesystemDir {
+	clone := &FilesystemDir{
+		Name:    d.Name,
+		Attrs:   d.Attrs,
+		Subdirs: make([]*FilesystemDir, 0, len(d.Subdirs)),
+		Files:   make([]*FilesystemFile, 0, len(d.Files)),
 	}
-
-	pathParts := strings.Split(path, "/")
-	lenParts := len(pathParts)
-	leadingSlash := pathParts[0] == ""
-	trailingSlash := pathParts[lenParts-1] == ""
-
-	if lenParts == 0 {
-		return nil, cwd
+	for _, subdir := range d.Subdirs {
+		subClone := subdir.cloneHelp()
+		subClone.Parent = clone
+		clone.Subdirs = append(clone.Subdirs, subClone)
 	}
-
-	var currentDir *FilesystemDir = cwd
-
-	if leadingSlash {
-		currentDir = root
+	for _, file := range d.Files {
+		fileClone := *file
+		fileClone.Parent = clone
+		clone.Files = append(clone.Files, &fileClone)
 	}
+	for _, link := range d.Links {
+		linkClone := *link
+		linkClone.Parent = clone
+		linkClone.file = nil
+		clone.Links = append(clone.Links, &linkClone)
+	}
+	return clone
+}
 
-	for i, part := range pathParts {
-		if part == "" {
-			continue
-		}
-
-		lastSegment := i == lenParts-1
-
-		dirErr, dirRes := currentDir.GetSubdir(part)
-		if dirErr == nil {
-			currentDir = dirRes
-			continue
+// WriteFile creates the file name in d, or replaces its content if it
+// already exists.
+func (d *FilesystemDir) WriteFile(name string, content string) (error, *FilesystemFile) {
+	if _, sub := d.GetSubdir(name); sub != nil {
+		return errors.New(fmt.Sprintf("%s: Is a directory", name)), nil
+	}
+	if _, file := d.GetFile(name); file != nil {
+		file.Content = content
+		file.Generate = nil
+		file.touch()
+		return nil, file
+	}
+	if _, link := d.GetLink(name); link != nil {
+		// Writing to a link writes to what it points at.
+		err, res := d.lookup(d.root(), name, true)
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s: No such file or directory", name)), nil
 		}
-
-		if (lastSegment && trailingSlash) || !lastSegment {
-			// must be a dir only
-			if dirErr != nil {
-				return dirErr, nil
-			}
-			currentDir = dirRes
-			continue
+		file, ok := res.(*FilesystemFile)
+		if !ok {
+			return errors.New(fmt.Sprintf("%s: Is a directory", name)), nil
 		}
+		file.Content = content
+		file.Generate = nil
+		file.touch()
+		return nil, file
+	}
+	file := &FilesystemFile{
+		Name:    name,
+		Content: content,
+		Parent:  d,
+	}
+	file.touch()
+	d.Files = append(d.Files, file)
+	d.touch()
+	return nil, file
+}
 
-		// could be a file...
+// Mkdir creates the directory name in d. An existing directory of that name
+// is returned as is.
+func (d *FilesystemDir) Mkdir(name string) (error, *FilesystemDir) {
+	if _, sub := d.GetSubdir(name); sub != nil {
+		return nil, sub
+	}
+	if entry, exists := d.Entry(name); exists && entry != nil {
+		return errors.New(fmt.Sprintf("cannot create directory '%s': File exists", name)), nil
+	}
+	dir := &FilesystemDir{
+		Name:    name,
+		Subdirs: []*FilesystemDir{},
+		Files:   []*FilesystemFile{},
+		Parent:  d,
+	}
+	dir.touch()
+	d.Subdirs = append(d.Subdirs, dir)
+	d.touch()
+	return nil, dir
+}
 
-		fileErr, fileRes := currentDir.GetFile(part)
-		if fileErr != nil {
-			return errors.New(fmt.Sprintf("cannot access '%s': No such file or directory", path)), nil
-		}
+func (cfg *FilesystemConfig) ToString() (string, error) {
+	data, err := yaml.Marshal(cfg)
+	return string(data), err
+}
 
-		return nil, fileRes
-	}
-	return nil, currentDir
+func StrToFilesystem(cfg []byte) FilesystemConfig {
+	ret := &FilesystemConfig{}
+	yaml.Unmarshal(cfg, ret)
+	ret.Root.Parent = ret.Root
+	fillInParents(ret.Root)
+	resolveHardLinks(ret.Root)
+	return *ret
 }
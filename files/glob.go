@@ -0,0 +1,452 @@
+package files
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Most words one brace expansion makes, so {1..1000000000} can't eat the
+// server's memory. Bigger expansions are left as they are.
+const MaxBraceWords = 10000
+
+// HasGlob reports whether pattern has an unescaped *, ? or [.
+func HasGlob(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// EscapeGlob escapes the characters Match treats specially, so that s
+// matches only itself.
+func EscapeGlob(s string) string {
+	var out strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			out.WriteRune('\\')
+		}
+		out.WriteRune(c)
+	}
+	return out.String()
+}
+
+func unescapeGlob(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
+
+// Match reports whether name matches the shell pattern, in which * matches
+// any string, ? any character, [...] any character in the set and a
+// backslash makes the next character literal.
+func Match(pattern string, name string) bool {
+	return match([]rune(pattern), []rune(name))
+}
+
+func match(pattern []rune, name []rune) bool {
+	// Rather than recursing at every *, remember the last one and, on a
+	// mismatch, let it swallow one more character of the name. Earlier
+	// stars never need revisiting, which keeps this O(len(pattern)*len(name)).
+	p, n := 0, 0
+	star, starName := -1, 0
+	for n < len(name) {
+		if p < len(pattern) {
+			switch c := pattern[p]; c {
+			case '*':
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+				star, starName = p, n
+				continue
+			case '?':
+				p, n = p+1, n+1
+				continue
+			case '[':
+				matched, rest, ok := matchBracket(pattern[p:], name[n])
+				if !ok {
+					// An unclosed [ is just a [.
+					if name[n] == '[' {
+						p, n = p+1, n+1
+						continue
+					}
+				} else if matched {
+					p, n = len(pattern)-len(rest), n+1
+					continue
+				}
+			default:
+				width := 1
+				if c == '\\' && p+1 < len(pattern) {
+					c, width = pattern[p+1], 2
+				}
+				if name[n] == c {
+					p, n = p+width, n+1
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		starName++
+		p, n = star, starName
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+var charClasses = map[string]func(rune) bool{
+	"alnum":  func(c rune) bool { return unicode.IsLetter(c) || unicode.IsDigit(c) },
+	"alpha":  unicode.IsLetter,
+	"blank":  func(c rune) bool { return c == ' ' || c == '\t' },
+	"cntrl":  unicode.IsControl,
+	"digit":  unicode.IsDigit,
+	"graph":  func(c rune) bool { return unicode.IsGraphic(c) && !unicode.IsSpace(c) },
+	"lower":  unicode.IsLower,
+	"print":  unicode.IsPrint,
+	"punct":  unicode.IsPunct,
+	"space":  unicode.IsSpace,
+	"upper":  unicode.IsUpper,
+	"xdigit": func(c rune) bool { return strings.ContainsRune("0123456789abcdefABCDEF", c) },
+}
+
+// Matches c against the bracket expression at the start of pattern.
+// Returns whether it matched, the pattern after the expression, and whether
+// there was a complete expression at all.
+func matchBracket(pattern []rune, c rune) (bool, []rune, bool) {
+	i := 1
+	negate := false
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		negate = true
+		i++
+	}
+	matched := false
+	first := true
+	for i < len(pattern) {
+		if pattern[i] == ']' && !first {
+			return matched != negate, pattern[i+1:], true
+		}
+		first = false
+		if end := classEnd(pattern, i); end > 0 {
+			if class, ok := charClasses[string(pattern[i+2:end])]; ok && class(c) {
+				matched = true
+			}
+			i = end + 2
+			continue
+		}
+		lo := pattern[i]
+		if lo == '\\' && i+1 < len(pattern) {
+			i++
+			lo = pattern[i]
+		}
+		hi := lo
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			hi = pattern[i+2]
+			if hi == '\\' && i+3 < len(pattern) {
+				i++
+				hi = pattern[i+2]
+			}
+			i += 2
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+		i++
+	}
+	return false, nil, false
+}
+
+// Finds the ":" that ends a [:class:] starting at pattern[i], or returns 0.
+func classEnd(pattern []rune, i int) int {
+	if i+1 >= len(pattern) || pattern[i] != '[' || pattern[i+1] != ':' {
+		return 0
+	}
+	for end := i + 2; end+1 < len(pattern); end++ {
+		if pattern[end] == ':' && pattern[end+1] == ']' {
+			return end
+		}
+	}
+	return 0
+}
+
+// Glob expands a pattern into the paths in the tree that match it, sorted.
+// Relative patterns start from cwd. As in bash, a name starting with "."
+// only matches a pattern component that starts with "." too. Nil is returned
+// when nothing matches.
+func (cwd *FilesystemDir) Glob(root *FilesystemDir, pattern string) []string {
+	type candidate struct {
+		path string
+		dir  *FilesystemDir
+	}
+	candidates := []candidate{{path: "", dir: cwd}}
+	if strings.HasPrefix(pattern, "/") {
+		candidates = []candidate{{path: "/", dir: root}}
+	}
+	components := []string{}
+	for _, component := range strings.Split(pattern, "/") {
+		if component != "" {
+			components = append(components, component)
+		}
+	}
+	dirsOnly := strings.HasSuffix(pattern, "/")
+	join := func(prefix string, name string) string {
+		if prefix == "" || strings.HasSuffix(prefix, "/") {
+			return prefix + name
+		}
+		return prefix + "/" + name
+	}
+	for i, component := range components {
+		last := i == len(components)-1
+		next := []candidate{}
+		for _, cand := range candidates {
+			names := []string{}
+			if HasGlob(component) {
+				for _, name := range cand.dir.entryNames() {
+					hidden := strings.HasPrefix(name, ".")
+					if hidden && !strings.HasPrefix(component, ".") && !strings.HasPrefix(component, `\.`) {
+						continue
+					}
+					if Match(component, name) {
+						names = append(names, name)
+					}
+				}
+			} else {
+				names = append(names, unescapeGlob(component))
+			}
+			for _, name := range names {
+				err, entry := cand.dir.GetFileOrDir(root, name)
+				dir, isDir := entry.(*FilesystemDir)
+				if err != nil {
+					// Only a dangling symlink at the end can still match.
+					if _, exists := cand.dir.Entry(name); exists && last && !dirsOnly {
+						next = append(next, candidate{path: join(cand.path, name)})
+					}
+					continue
+				}
+				if (!last || dirsOnly) && !isDir {
+					continue
+				}
+				next = append(next, candidate{path: join(cand.path, name), dir: dir})
+			}
+		}
+		candidates = next
+	}
+	if len(components) == 0 || len(candidates) == 0 {
+		return nil
+	}
+	matches := []string{}
+	for _, cand := range candidates {
+		if dirsOnly {
+			cand.path += "/"
+		}
+		matches = append(matches, cand.path)
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// Names in d, with "." and "..", which a pattern starting with "." can
+// match.
+func (d *FilesystemDir) entryNames() []string {
+	names := []string{".", ".."}
+	for _, subdir := range d.Subdirs {
+		names = append(names, subdir.Name)
+	}
+	for _, file := range d.Files {
+		names = append(names, file.Name)
+	}
+	for _, link := range d.Links {
+		names = append(names, link.Name)
+	}
+	return names
+}
+
+// ExpandBraces performs bash's brace expansion on a word, as in a{b,c}d and
+// {1..5}. A word without a valid brace expression, or one that would expand
+// to more than MaxBraceWords words, comes back as it was.
+func ExpandBraces(word string) []string {
+	budget := MaxBraceWords
+	words, ok := ExpandBracesWithin(word, &budget)
+	if !ok {
+		return []string{word}
+	}
+	return words
+}
+
+// ExpandBracesWithin is ExpandBraces taking the words it produces, at every
+// level of nesting, out of budget, so that callers expanding several parts
+// of one word can share a single limit. It reports false once the budget is
+// spent.
+func ExpandBracesWithin(word string, budget *int) ([]string, bool) {
+	for start := 0; start < len(word); start++ {
+		if word[start] == '\\' {
+			start++
+			continue
+		}
+		if word[start] != '{' || (start > 0 && word[start-1] == '$') {
+			continue
+		}
+		end, alternatives := braceAlternatives(word, start)
+		if alternatives == nil {
+			continue
+		}
+		prefix := word[:start]
+		suffixes, ok := ExpandBracesWithin(word[end+1:], budget)
+		if !ok {
+			return nil, false
+		}
+		words := []string{}
+		for _, alternative := range alternatives {
+			expanded, ok := ExpandBracesWithin(alternative, budget)
+			if !ok {
+				return nil, false
+			}
+			for _, middle := range expanded {
+				for _, suffix := range suffixes {
+					if *budget--; *budget < 0 {
+						return nil, false
+					}
+					words = append(words, prefix+middle+suffix)
+				}
+			}
+		}
+		return words, true
+	}
+	return []string{word}, true
+}
+
+// Finds the brace expression starting at word[start], returning the index
+// of its closing brace and what it expands to, or nil if it isn't one.
+func braceAlternatives(word string, start int) (int, []string) {
+	depth := 0
+	commas := []int{}
+	for i := start; i < len(word); i++ {
+		switch word[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if len(commas) == 0 {
+				return i, braceSequence(word[start+1 : i])
+			}
+			alternatives := []string{}
+			from := start + 1
+			for _, comma := range commas {
+				alternatives = append(alternatives, word[from:comma])
+				from = comma + 1
+			}
+			return i, append(alternatives, word[from:i])
+		}
+	}
+	return 0, nil
+}
+
+// Expands x..y or x..y..step, for numbers or single letters.
+func braceSequence(body string) []string {
+	parts := strings.Split(body, "..")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil
+	}
+	step := 1
+	if len(parts) == 3 {
+		n, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return nil
+		}
+		if n < 0 {
+			n = -n
+		}
+		if n < 0 {
+			// -n overflowed: no range is that long anyway.
+			return nil
+		}
+		if n != 0 {
+			step = n
+		}
+	}
+	from, errFrom := strconv.Atoi(parts[0])
+	to, errTo := strconv.Atoi(parts[1])
+	letters := false
+	if errFrom != nil || errTo != nil {
+		if len(parts[0]) != 1 || len(parts[1]) != 1 || !isLetter(parts[0][0]) || !isLetter(parts[1][0]) {
+			return nil
+		}
+		from, to, letters = int(parts[0][0]), int(parts[1][0]), true
+	}
+	// Measure the range unsigned so ends near the int limits can't overflow
+	// into a negative count.
+	span := uint64(to) - uint64(from)
+	if to < from {
+		span = uint64(from) - uint64(to)
+	}
+	if span/uint64(step) >= MaxBraceWords {
+		return nil
+	}
+	count := int(span/uint64(step)) + 1
+	width := 0
+	for _, part := range parts[:2] {
+		trimmed := strings.TrimPrefix(part, "-")
+		if !letters && len(trimmed) > 1 && trimmed[0] == '0' && len(part) > width {
+			width = len(part)
+		}
+	}
+	words := []string{}
+	for i, n := 0, from; i < count; i++ {
+		switch {
+		case letters:
+			words = append(words, string(rune(n)))
+		case width > 0:
+			words = append(words, padNumber(n, width))
+		default:
+			words = append(words, strconv.Itoa(n))
+		}
+		if to < from {
+			n -= step
+		} else {
+			n += step
+		}
+	}
+	return words
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func padNumber(n int, width int) string {
+	digits := strconv.Itoa(n)
+	negative := n < 0
+	if negative {
+		digits = digits[1:]
+		width--
+	}
+	for len(digits) < width {
+		digits = "0" + digits
+	}
+	if negative {
+		return "-" + digits
+	}
+	return digits
+}
@@ -0,0 +1,135 @@
+package files
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*", "passwd", true},
+		{".*", ".bashrc", true},
+		{"p?ss*", "passwd", true},
+		{"p?ss*", "pass", true},
+		{"p?ss*", "pss", false},
+		{"[a-c]at", "bat", true},
+		{"[a-c]at", "rat", false},
+		{"[!a-c]at", "rat", true},
+		{"[^a-c]at", "cat", false},
+		{"[[:digit:]]*", "1abc", true},
+		{"[[:digit:]]*", "abc", false},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{"[]]", "]", true},
+		{"*.sh", "x.sh", true},
+		{"*.sh", "x.shx", false},
+		{"*a*b", "xaab", true},
+		{"a*b*c", "abcbc", true},
+		{"a*b*c", "abcbd", false},
+		{"*[", "x[", true},
+		{"*[a", "x[", false},
+	}
+	for _, test := range tests {
+		if got := Match(test.pattern, test.name); got != test.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", test.pattern, test.name, got, test.want)
+		}
+	}
+}
+
+// Patterns with many stars that nearly match used to backtrack
+// exponentially.
+func TestMatchManyStars(t *testing.T) {
+	pattern := strings.Repeat("*a", 20) + "*b"
+	name := strings.Repeat("a", 1000)
+	start := time.Now()
+	if Match(pattern, name) {
+		t.Errorf("Match(%q, %d a's) = true", pattern, len(name))
+	}
+	if !Match(pattern, name+"b") {
+		t.Errorf("Match(%q, %d a's and b) = false", pattern, len(name))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("matching took %v", elapsed)
+	}
+}
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		word string
+		want []string
+	}{
+		{"plain", []string{"plain"}},
+		{"a{b,c}d", []string{"abd", "acd"}},
+		{"{a,b}{1,2}", []string{"a1", "a2", "b1", "b2"}},
+		{"{a,{b,c}}x", []string{"ax", "bx", "cx"}},
+		{"{1..3}", []string{"1", "2", "3"}},
+		{"{3..1}", []string{"3", "2", "1"}},
+		{"{01..3}", []string{"01", "02", "03"}},
+		{"{1..7..3}", []string{"1", "4", "7"}},
+		{"{a..c}", []string{"a", "b", "c"}},
+		{"{a}", []string{"{a}"}},
+		{"${a,b}", []string{"${a,b}"}},
+		{`\{a,b}`, []string{`\{a,b}`}},
+		{"{1..100000}", []string{"{1..100000}"}},
+		{"{{1..9999},x}{1..9999}", []string{"{{1..9999},x}{1..9999}"}},
+		{"{1..9999}{1..9999}", []string{"{1..9999}{1..9999}"}},
+		{"{1..9999999999999999999}", []string{"{1..9999999999999999999}"}},
+		{"{0..9223372036854775807}", []string{"{0..9223372036854775807}"}},
+		{"{-9223372036854775808..9223372036854775807}", []string{"{-9223372036854775808..9223372036854775807}"}},
+		{"{9223372036854775807..-9223372036854775808}", []string{"{9223372036854775807..-9223372036854775808}"}},
+		{"{1..2..-9223372036854775808}", []string{"{1..2..-9223372036854775808}"}},
+		{"{9223372036854775806..9223372036854775807}", []string{"9223372036854775806", "9223372036854775807"}},
+	}
+	for _, test := range tests {
+		if got := ExpandBraces(test.word); !reflect.DeepEqual(got, test.want) {
+			if len(got) > 5 {
+				t.Errorf("ExpandBraces(%q) gave %d words, want %v", test.word, len(got), test.want)
+				continue
+			}
+			t.Errorf("ExpandBraces(%q) = %q, want %q", test.word, got, test.want)
+		}
+	}
+}
+
+func TestExpandBracesWithinSharesBudget(t *testing.T) {
+	budget := MaxBraceWords
+	if _, ok := ExpandBracesWithin("{1..9999}", &budget); !ok {
+		t.Fatal("first expansion ran out of budget")
+	}
+	if _, ok := ExpandBracesWithin("{1..9999}", &budget); ok {
+		t.Error("second expansion fit in a budget the first had spent")
+	}
+}
+
+func TestGlob(t *testing.T) {
+	root := &FilesystemDir{Name: "/"}
+	etc := &FilesystemDir{Name: "etc", Parent: root}
+	root.Subdirs = []*FilesystemDir{etc}
+	etc.Files = []*FilesystemFile{{Name: "passwd"}, {Name: "group"}, {Name: ".hidden"}}
+	tests := []struct {
+		cwd     *FilesystemDir
+		pattern string
+		want    []string
+	}{
+		{root, "/etc/*", []string{"/etc/group", "/etc/passwd"}},
+		{root, "etc/p*", []string{"etc/passwd"}},
+		{etc, "*", []string{"group", "passwd"}},
+		{etc, ".h*", []string{".hidden"}},
+		{etc, "nothing*", nil},
+		{root, "/*/group", []string{"/etc/group"}},
+	}
+	for _, test := range tests {
+		got := test.cwd.Glob(root, test.pattern)
+		if len(got) == 0 && len(test.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Glob(%q) = %q, want %q", test.pattern, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Most symlinks followed in one lookup, as with Linux's MAXSYMLINKS. This
+// is also what stops a lookup going round a loop of links forever.
+const MaxLinkDepth = 40
+
+var ErrLinkLoop = errors.New("Too many levels of symbolic links")
+
+// FilesystemLink is a symbolic link, or with Hard set a hard link to a file
+// elsewhere in the tree. Targets are paths as ln would take them, relative
+// to the directory holding the link unless they start with a slash.
+type FilesystemLink struct {
+	Name   string         `yaml:"name"`
+	Target string         `yaml:"target"`
+	Hard   bool           `yaml:"hard,omitempty"`
+	Parent *FilesystemDir `yaml:"-"`
+	Attrs  `yaml:",inline"`
+	// What a hard link points at, found when the tree is loaded.
+	file *FilesystemFile
+}
+
+func (l FilesystemLink) PlainName() string {
+	return l.Name
+}
+
+func (l FilesystemLink) Info() FileInfo {
+	info := l.Attrs.info(0777, int64(len(l.Target)))
+	info.Mode |= os.ModeSymlink
+	info.Links = 1
+	return info
+}
+
+func (l *FilesystemLink) resolved() (FileDir, bool) {
+	err, res := l.Parent.lookup(l.Parent.root(), l.Name, true)
+	return res, err == nil
+}
+
+func (l *FilesystemLink) TabcompleteName() string {
+	if res, ok := l.resolved(); ok {
+		if _, isDir := res.(*FilesystemDir); isDir {
+			return l.Name + "/"
+		}
+	}
+	return l.Name + " "
+}
+
+func (l *FilesystemLink) Describe() string {
+	return l.DescribeSelf()
+}
+
+func (l *FilesystemLink) DescribeSelf() string {
+	return l.Name + " -> " + l.Target
+}
+
+func (l *FilesystemLink) Path() string {
+	return l.Parent.PathHelp(true) + "/" + l.Name
+}
+
+func (l *FilesystemLink) TryCD() (error, *FilesystemDir) {
+	res, ok := l.resolved()
+	if !ok {
+		return errors.New(fmt.Sprintf("bash: cd: %s: No such file or directory", l.Name)), nil
+	}
+	return res.TryCD()
+}
+
+func (l *FilesystemLink) TryCat() (error, string) {
+	res, ok := l.resolved()
+	if !ok {
+		return errors.New(fmt.Sprintf("%s: No such file or directory", l.Name)), ""
+	}
+	return res.TryCat()
+}
+
+// The top of the tree d is in.
+func (d *FilesystemDir) root() *FilesystemDir {
+	for d.Parent != d && d.Parent != nil {
+		d = d.Parent
+	}
+	return d
+}
+
+// Points each hard link at its file and counts the links to every file.
+// Hard links that don't lead to a file are dropped, as ln would refuse to
+// make them.
+func resolveHardLinks(root *FilesystemDir) {
+	walkDirs(root, func(d *FilesystemDir) {
+		for _, file := range d.Files {
+			file.hardLinks = 0
+		}
+	})
+	walkDirs(root, func(d *FilesystemDir) {
+		kept := d.Links[:0]
+		for _, link := range d.Links {
+			if !link.Hard {
+				kept = append(kept, link)
+				continue
+			}
+			err, res := d.lookup(root, link.Target, true)
+			file, ok := res.(*FilesystemFile)
+			if err != nil || !ok {
+				continue
+			}
+			link.file = file
+			file.hardLinks++
+			kept = append(kept, link)
+		}
+		d.Links = kept
+	})
+}
+
+func walkDirs(d *FilesystemDir, visit func(d *FilesystemDir)) {
+	visit(d)
+	for _, subdir := range d.Subdirs {
+		walkDirs(subdir, visit)
+	}
+}
+
+// Entry returns the file, directory or symlink called name in d, without
+// following symlinks. Hard links come back as the file they link to.
+func (d *FilesystemDir) Entry(name string) (FileDir, bool) {
+	if _, sub := d.GetSubdir(name); sub != nil {
+		return sub, true
+	}
+	if _, file := d.GetFile(name); file != nil {
+		return file, true
+	}
+	if _, link := d.GetLink(name); link != nil {
+		if link.Hard {
+			return link.file, link.file != nil
+		}
+		return link, true
+	}
+	return nil, false
+}
+
+// GetFileOrDir looks path up from cwd, following symlinks all the way.
+func (cwd *FilesystemDir) GetFileOrDir(root *FilesystemDir, path string) (error, FileDir) {
+	return cwd.lookup(root, path, true)
+}
+
+// GetFileOrDirNoFollow is GetFileOrDir, except that a symlink at the end of
+// path is returned itself rather than what it points to, as lstat does.
+func (cwd *FilesystemDir) GetFileOrDirNoFollow(root *FilesystemDir, path string) (error, FileDir) {
+	return cwd.lookup(root, path, false)
+}
+
+func (cwd *FilesystemDir) lookup(root *FilesystemDir, path string, followLast bool) (error, FileDir) {
+	depth := 0
+	return cwd.walk(root, path, followLast, &depth)
+}
+
+func (cwd *FilesystemDir) walk(root *FilesystemDir, path string, followLast bool, depth *int) (error, FileDir) {
+	if path == "" {
+		return nil, cwd
+	}
+	currentDir := cwd
+	if strings.HasPrefix(path, "/") {
+		currentDir = root
+	}
+	// A trailing slash means the path has to be a directory, and that a
+	// symlink at the end is followed regardless.
+	mustBeDir := strings.HasSuffix(path, "/")
+	parts := []string{}
+	for _, part := range strings.Split(path, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	var current FileDir = currentDir
+	for i, part := range parts {
+		lastSegment := i == len(parts)-1
+		dir, ok := current.(*FilesystemDir)
+		if !ok {
+			return errors.New(fmt.Sprintf("cannot access '%s': Not a directory", path)), nil
+		}
+		if part == "." || part == ".." {
+			_, current = dir.GetSubdir(part)
+			continue
+		}
+		entry, ok := dir.Entry(part)
+		if !ok {
+			if lastSegment && !mustBeDir {
+				return errors.New(fmt.Sprintf("cannot access '%s': No such file or directory", path)), nil
+			}
+			return errors.New("No subdir with name " + part), nil
+		}
+		if link, isLink := entry.(*FilesystemLink); isLink && (!lastSegment || followLast || mustBeDir) {
+			*depth++
+			if *depth > MaxLinkDepth {
+				return ErrLinkLoop, nil
+			}
+			err, target := dir.walk(root, link.Target, true, depth)
+			if err != nil {
+				if err == ErrLinkLoop {
+					return err, nil
+				}
+				return errors.New(fmt.Sprintf("cannot access '%s': No such file or directory", path)), nil
+			}
+			entry = target
+		}
+		current = entry
+	}
+	if _, isDir := current.(*FilesystemDir); mustBeDir && !isDir {
+		return errors.New(fmt.Sprintf("cannot access '%s': Not a directory", path)), nil
+	}
+	return nil, current
+}
@@ -0,0 +1,136 @@
+package files
+
+import (
+	"testing"
+)
+
+const linkTestConfig = `
+root:
+  name: ""
+  subdirs:
+  - name: etc
+    files:
+    - name: passwd
+      content: "root:x:0:0\n"
+    links:
+    - name: rel
+      target: passwd
+    - name: abs
+      target: /etc/passwd
+    - name: up
+      target: ../usr/bin
+    - name: chain
+      target: rel
+    - name: dangling
+      target: nowhere
+    - name: loop
+      target: loop
+    - name: hard
+      target: passwd
+      hard: true
+    - name: badhard
+      target: nowhere
+      hard: true
+  - name: usr
+    subdirs:
+    - name: bin
+      files:
+      - name: ls
+        content: ELF
+  links:
+  - name: bin
+    target: usr/bin
+`
+
+func loadLinkTestTree() *FilesystemDir {
+	return StrToFilesystem([]byte(linkTestConfig)).Root
+}
+
+func TestLinkLookup(t *testing.T) {
+	tests := []struct {
+		path     string
+		noFollow bool
+		wantPath string
+		wantErr  error
+		wantFail bool
+	}{
+		{"/etc/rel", false, "/etc/passwd", nil, false},
+		{"/etc/abs", false, "/etc/passwd", nil, false},
+		{"/etc/chain", false, "/etc/passwd", nil, false},
+		{"/etc/hard", false, "/etc/passwd", nil, false},
+		{"/etc/up/ls", false, "/usr/bin/ls", nil, false},
+		{"/bin/ls", false, "/usr/bin/ls", nil, false},
+		{"/bin", false, "/usr/bin", nil, false},
+		{"/bin", true, "/bin", nil, false},
+		{"/bin/", true, "/usr/bin", nil, false},
+		{"/etc/rel", true, "/etc/rel", nil, false},
+		{"/etc/rel/", false, "", nil, true},
+		{"/etc/dangling", false, "", nil, true},
+		{"/etc/dangling", true, "/etc/dangling", nil, false},
+		{"/etc/loop", false, "", ErrLinkLoop, true},
+		{"/etc/loop/x", true, "", ErrLinkLoop, true},
+		{"/etc/badhard", true, "", nil, true},
+	}
+	root := loadLinkTestTree()
+	for _, test := range tests {
+		lookup := root.GetFileOrDir
+		if test.noFollow {
+			lookup = root.GetFileOrDirNoFollow
+		}
+		err, res := lookup(root, test.path)
+		if (err != nil) != test.wantFail || (test.wantErr != nil && err != test.wantErr) {
+			t.Errorf("%s (no follow %v): error %v, want failure %v, %v", test.path, test.noFollow, err, test.wantFail, test.wantErr)
+			continue
+		}
+		if err == nil && res.Path() != test.wantPath {
+			t.Errorf("%s (no follow %v) found %s, want %s", test.path, test.noFollow, res.Path(), test.wantPath)
+		}
+	}
+}
+
+func TestHardLinkCounts(t *testing.T) {
+	root := loadLinkTestTree()
+	_, passwd := root.GetFileOrDir(root, "/etc/passwd")
+	if links := passwd.Info().Links; links != 2 {
+		t.Errorf("passwd has %d links, want 2", links)
+	}
+	_, etc := root.GetFileOrDir(root, "/etc")
+	if _, ok := etc.(*FilesystemDir).Entry("badhard"); ok {
+		t.Error("hard link to a missing file was kept")
+	}
+	clone := root.Clone()
+	_, clonedHard := clone.GetFileOrDir(clone, "/etc/hard")
+	_, clonedPasswd := clone.GetFileOrDir(clone, "/etc/passwd")
+	if clonedHard != clonedPasswd {
+		t.Error("hard link in a clone points outside it")
+	}
+	if clonedPasswd == passwd {
+		t.Error("clone shares files with the original")
+	}
+}
+
+func TestLinkInfo(t *testing.T) {
+	root := loadLinkTestTree()
+	tests := []struct {
+		path     string
+		wantName string
+		wantSize int64
+	}{
+		{"/etc/rel", "rel ", 6},
+		{"/bin", "bin/", 7},
+		{"/etc/dangling", "dangling ", 7},
+	}
+	for _, test := range tests {
+		err, res := root.GetFileOrDirNoFollow(root, test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		link := res.(*FilesystemLink)
+		if link.TabcompleteName() != test.wantName {
+			t.Errorf("%s completes as %q, want %q", test.path, link.TabcompleteName(), test.wantName)
+		}
+		if info := link.Info(); info.Size != test.wantSize || info.Mode.String() != "Lrwxrwxrwx" {
+			t.Errorf("%s: size %d, mode %s", test.path, info.Size, info.Mode)
+		}
+	}
+}
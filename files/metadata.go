@@ -0,0 +1,174 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// When the filesystem was loaded, which is the default for every timestamp
+// in it.
+var LoadedAt = time.Now()
+
+// Names shown for uids and gids that have no name of their own in the YAML.
+// Anything not in here shows as the bare number, as ls does.
+var UserNames = map[int]string{0: "root"}
+var GroupNames = map[int]string{0: "root"}
+
+// FileInfo is what ls -l and stat show about a file or directory.
+type FileInfo struct {
+	Mode       os.FileMode
+	Links      int
+	UID        int
+	GID        int
+	Owner      string
+	Group      string
+	Size       int64
+	ModTime    time.Time
+	AccessTime time.Time
+	ChangeTime time.Time
+}
+
+// Attrs is the metadata files and directories can set in the YAML. Anything
+// left out gets a default.
+type Attrs struct {
+	// Permission bits as chmod takes them, including setuid (04000), setgid
+	// (02000) and sticky (01000). Defaults to 0644 for files and 0755 for
+	// directories, as does a bare 0, which older generators wrote for
+	// everything; mode 0000 is written "0000".
+	Permissions *Permissions `yaml:"permissions,omitempty"`
+	UID         int          `yaml:"uid,omitempty"`
+	GID         int          `yaml:"gid,omitempty"`
+	// Names for UID and GID, else looked up in UserNames and GroupNames.
+	User  string `yaml:"user,omitempty"`
+	Group string `yaml:"group,omitempty"`
+	// Defaults to the length of the content for files, 4096 for
+	// directories.
+	Size  *int64     `yaml:"size,omitempty"`
+	Mtime *time.Time `yaml:"mtime,omitempty"`
+	// Both default to mtime.
+	Atime *time.Time `yaml:"atime,omitempty"`
+	Ctime *time.Time `yaml:"ctime,omitempty"`
+}
+
+// Permissions are chmod style mode bits. They're written to YAML as an
+// octal string, and read back from either that or a YAML integer such as
+// 0755.
+type Permissions int
+
+// What a YAML integer 0 reads as: the default permissions rather than none.
+const unsetPermissions Permissions = -1
+
+func (p Permissions) MarshalYAML() (interface{}, error) {
+	if p == unsetPermissions {
+		return 0, nil
+	}
+	return fmt.Sprintf("%04o", int(p)), nil
+}
+
+func (p *Permissions) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var number int
+	if err := unmarshal(&number); err == nil {
+		*p = Permissions(number)
+		if number == 0 {
+			*p = unsetPermissions
+		}
+		return nil
+	}
+	var text string
+	if err := unmarshal(&text); err != nil {
+		return err
+	}
+	number64, err := strconv.ParseInt(text, 8, 32)
+	if err != nil {
+		return fmt.Errorf("permissions %q are not octal", text)
+	}
+	*p = Permissions(number64)
+	return nil
+}
+
+// Converts chmod style permission bits to an os.FileMode.
+func ModeFromPermissions(perm Permissions) os.FileMode {
+	mode := os.FileMode(perm & 0777)
+	if perm&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if perm&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if perm&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// The reverse of ModeFromPermissions.
+func PermissionsFromMode(mode os.FileMode) Permissions {
+	perm := Permissions(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		perm |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		perm |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		perm |= 01000
+	}
+	return perm
+}
+
+func (a Attrs) info(defaultPerm Permissions, defaultSize int64) FileInfo {
+	perm := defaultPerm
+	if a.Permissions != nil && *a.Permissions != unsetPermissions {
+		perm = *a.Permissions
+	}
+	info := FileInfo{
+		Mode:       ModeFromPermissions(perm),
+		UID:        a.UID,
+		GID:        a.GID,
+		Owner:      a.User,
+		Group:      a.Group,
+		Size:       defaultSize,
+		ModTime:    LoadedAt,
+		AccessTime: LoadedAt,
+		ChangeTime: LoadedAt,
+	}
+	if info.Owner == "" {
+		info.Owner = nameOrNumber(UserNames, a.UID)
+	}
+	if info.Group == "" {
+		info.Group = nameOrNumber(GroupNames, a.GID)
+	}
+	if a.Size != nil {
+		info.Size = *a.Size
+	}
+	if a.Mtime != nil {
+		info.ModTime = *a.Mtime
+		info.AccessTime = *a.Mtime
+		info.ChangeTime = *a.Mtime
+	}
+	if a.Atime != nil {
+		info.AccessTime = *a.Atime
+	}
+	if a.Ctime != nil {
+		info.ChangeTime = *a.Ctime
+	}
+	return info
+}
+
+func nameOrNumber(names map[int]string, id int) string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+	return strconv.Itoa(id)
+}
+
+// Marks the attributes as changed now, as writing a file does. Sizes set in
+// the YAML no longer apply once there's real content.
+func (a *Attrs) touch() {
+	now := time.Now()
+	a.Size = nil
+	a.Mtime = &now
+	a.Ctime = &now
+}
@@ -0,0 +1,103 @@
+package files
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestPermissionsYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		yaml     string
+		wantMode os.FileMode
+		wantErr  bool
+	}{
+		{"default", "name: f\n", 0644, false},
+		{"old generator's zero", "name: f\npermissions: 0\n", 0644, false},
+		{"zero in octal", "name: f\npermissions: 0000\n", 0644, false},
+		{"octal integer", "name: f\npermissions: 0755\n", 0755, false},
+		{"octal string", "name: f\npermissions: \"0600\"\n", 0600, false},
+		{"zero string", "name: f\npermissions: \"0000\"\n", 0, false},
+		{"short zero string", "name: f\npermissions: \"0\"\n", 0, false},
+		{"setuid", "name: f\npermissions: \"4755\"\n", os.ModeSetuid | 0755, false},
+		{"sticky", "name: f\npermissions: \"1777\"\n", os.ModeSticky | 0777, false},
+		{"not octal", "name: f\npermissions: \"rwx\"\n", 0, true},
+	}
+	for _, test := range tests {
+		file := FilesystemFile{}
+		err := yaml.Unmarshal([]byte(test.yaml), &file)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if mode := file.Info().Mode; mode != test.wantMode {
+			t.Errorf("%s: mode %s, want %s", test.name, mode, test.wantMode)
+		}
+		out, err := yaml.Marshal(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again := FilesystemFile{}
+		if err := yaml.Unmarshal(out, &again); err != nil {
+			t.Fatal(err)
+		}
+		if mode := again.Info().Mode; mode != test.wantMode {
+			t.Errorf("%s: mode %s after a round trip through\n%s", test.name, mode, out)
+		}
+		if set := strings.Contains(string(out), "permissions"); set != (file.Permissions != nil) {
+			t.Errorf("%s: written as\n%s", test.name, out)
+		}
+	}
+}
+
+func TestDefaultPermissions(t *testing.T) {
+	zero := Permissions(0)
+	tests := []struct {
+		name string
+		f    FileDir
+		want os.FileMode
+	}{
+		{"file", &FilesystemFile{}, 0644},
+		{"directory", &FilesystemDir{}, os.ModeDir | 0755},
+		{"symlink", &FilesystemLink{}, os.ModeSymlink | 0777},
+		{"file with none", &FilesystemFile{Attrs: Attrs{Permissions: &zero}}, 0},
+		{"directory with none", &FilesystemDir{Attrs: Attrs{Permissions: &zero}}, os.ModeDir},
+	}
+	for _, test := range tests {
+		if got := test.f.Info().Mode; got != test.want {
+			t.Errorf("%s: mode %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestChmod(t *testing.T) {
+	tests := []struct {
+		perm Permissions
+		want os.FileMode
+	}{
+		{0, 0},
+		{0700, 0700},
+		{04711, os.ModeSetuid | 0711},
+	}
+	for _, test := range tests {
+		file := &FilesystemFile{}
+		Chmod(file, test.perm)
+		if got := file.Info().Mode; got != test.want {
+			t.Errorf("Chmod(%04o) gave mode %s, want %s", test.perm, got, test.want)
+		}
+	}
+}
+
+func TestModePermissionsRoundTrip(t *testing.T) {
+	for _, perm := range []Permissions{0, 0644, 0755, 04755, 02750, 01777, 07777} {
+		if got := PermissionsFromMode(ModeFromPermissions(perm)); got != perm {
+			t.Errorf("%04o became %04o", perm, got)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AttrsOf gives access to the metadata of any node in the tree.
+func AttrsOf(entry FileDir) *Attrs {
+	switch node := entry.(type) {
+	case *FilesystemFile:
+		return &node.Attrs
+	case *FilesystemDir:
+		return &node.Attrs
+	case *FilesystemLink:
+		return &node.Attrs
+	}
+	return nil
+}
+
+// Touch sets a node's access and modification times to now, as touch does.
+func Touch(entry FileDir) {
+	attrs := AttrsOf(entry)
+	if attrs == nil {
+		return
+	}
+	now := time.Now()
+	attrs.Atime = &now
+	attrs.Mtime = &now
+	attrs.Ctime = &now
+}
+
+// Chmod sets a node's permission bits.
+func Chmod(entry FileDir, perm Permissions) {
+	attrs := AttrsOf(entry)
+	if attrs == nil {
+		return
+	}
+	now := time.Now()
+	attrs.Permissions = &perm
+	attrs.Ctime = &now
+}
+
+// Detach removes the entry called name from d and returns it. Links come
+// back as themselves, hard links included, so they can be attached
+// elsewhere.
+func (d *FilesystemDir) Detach(name string) (FileDir, error) {
+	for i, subdir := range d.Subdirs {
+		if subdir.Name == name {
+			d.Subdirs = append(d.Subdirs[:i:i], d.Subdirs[i+1:]...)
+			d.touch()
+			return subdir, nil
+		}
+	}
+	for i, file := range d.Files {
+		if file.Name == name {
+			d.Files = append(d.Files[:i:i], d.Files[i+1:]...)
+			d.touch()
+			return file, nil
+		}
+	}
+	for i, link := range d.Links {
+		if link.Name == name {
+			d.Links = append(d.Links[:i:i], d.Links[i+1:]...)
+			if link.Hard && link.file != nil {
+				link.file.hardLinks--
+			}
+			d.touch()
+			return link, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("%s: No such file or directory", name))
+}
+
+// ErrIntoItself is returned when a directory would be attached below itself.
+var ErrIntoItself = errors.New("cannot move a directory into itself")
+
+// Attach puts a detached node into d under name, which must be free.
+func (d *FilesystemDir) Attach(entry FileDir, name string) error {
+	if _, exists := d.Entry(name); exists {
+		return errors.New(fmt.Sprintf("%s: File exists", name))
+	}
+	switch node := entry.(type) {
+	case *FilesystemDir:
+		for ancestor := d; ; ancestor = ancestor.Parent {
+			if ancestor == node {
+				return ErrIntoItself
+			}
+			if ancestor.Parent == ancestor || ancestor.Parent == nil {
+				break
+			}
+		}
+		node.Name = name
+		node.Parent = d
+		d.Subdirs = append(d.Subdirs, node)
+	case *FilesystemFile:
+		node.Name = name
+		node.Parent = d
+		d.Files = append(d.Files, node)
+	case *FilesystemLink:
+		node.Name = name
+		node.Parent = d
+		if node.Hard && node.file != nil {
+			node.file.hardLinks++
+		}
+		d.Links = append(d.Links, node)
+	default:
+		return errors.New("unknown node type")
+	}
+	d.touch()
+	return nil
+}
+
+// Copy makes a detached deep copy of a node, which can then be attached
+// somewhere. A hard link is copied as a new file, as cp does.
+func Copy(entry FileDir) FileDir {
+	switch node := entry.(type) {
+	case *FilesystemDir:
+		return copyDir(node)
+	case *FilesystemFile:
+		clone := *node
+		clone.hardLinks = 0
+		clone.Parent = nil
+		// A copy of a generated file holds what it read.
+		clone.Content = node.Read()
+		clone.Generate = nil
+		return &clone
+	case *FilesystemLink:
+		if node.Hard && node.file != nil {
+			return Copy(node.file)
+		}
+		clone := *node
+		clone.Parent = nil
+		return &clone
+	}
+	return nil
+}
+
+// Hard links inside a copied directory become files of their own, as with
+// cp -r.
+func copyDir(d *FilesystemDir) *FilesystemDir {
+	clone := &FilesystemDir{
+		Name:    d.Name,
+		Attrs:   d.Attrs,
+		Subdirs: make([]*FilesystemDir, 0, len(d.Subdirs)),
+		Files:   make([]*FilesystemFile, 0, len(d.Files)),
+	}
+	for _, subdir := range d.Subdirs {
+		subClone := copyDir(subdir)
+		subClone.Parent = clone
+		clone.Subdirs = append(clone.Subdirs, subClone)
+	}
+	for _, file := range d.Files {
+		fileClone := Copy(file).(*FilesystemFile)
+		fileClone.Parent = clone
+		clone.Files = append(clone.Files, fileClone)
+	}
+	for _, link := range d.Links {
+		switch linkClone := Copy(link).(type) {
+		case *FilesystemFile:
+			linkClone.Name = link.Name
+			linkClone.Parent = clone
+			clone.Files = append(clone.Files, linkClone)
+		case *FilesystemLink:
+			linkClone.Parent = clone
+			clone.Links = append(clone.Links, linkClone)
+		}
+	}
+	return clone
+}
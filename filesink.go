@@ -0,0 +1,263 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerSink("file", newFileSink)
+}
+
+// How long to wait before trying again after a rotated file couldn't be
+// moved aside.
+const rotateRetryDelay = time.Minute
+
+// The timestamp rotated files are named with; it sorts lexically.
+const rotateTimeFormat = "20060102T150405.000000000"
+
+// FileSink writes each SSHDoc as one line of JSON to Path. The file is
+// rotated once it reaches MaxSize bytes or has been open for MaxAge; rotated
+// files are renamed with a timestamp, optionally gzipped, and only the newest
+// MaxBackups of them are kept. Zero disables the corresponding limit.
+type FileSink struct {
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	Compress   bool
+
+	mu       sync.Mutex
+	file     *os.File
+	closed   bool
+	size     int64
+	openedAt time.Time
+	// After a failed rotation, no rotation is tried before this time.
+	retryRotateAt time.Time
+	// Serialises compression and pruning of rotated files.
+	archiveMu sync.Mutex
+	wg        sync.WaitGroup
+}
+
+func newFileSink() (EventSink, error) {
+	fs := &FileSink{
+		Path:       envOrDefault("FILE_SINK_PATH", "honeystats_ssh.jsonl"),
+		MaxSize:    int64(envIntOrDefault("FILE_SINK_MAX_SIZE", 100*1024*1024)),
+		MaxAge:     envDurationOrDefault("FILE_SINK_MAX_AGE", 24*time.Hour),
+		MaxBackups: envIntOrDefault("FILE_SINK_MAX_BACKUPS", 7),
+		Compress:   envOrDefault("FILE_SINK_COMPRESS", "") != "",
+	}
+	if err := fs.open(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(fs.Path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fs.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fs.file = f
+	fs.size = info.Size()
+	fs.openedAt = time.Now()
+	return nil
+}
+
+func (fs *FileSink) Emit(doc SSHDoc) error {
+	docBytes, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("marshalling document to JSON: %w", err)
+	}
+	docBytes = append(docBytes, '\n')
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.closed {
+		return errSinkClosed
+	}
+	// A failed rotation may have left no file open.
+	if fs.file == nil {
+		if err := fs.open(); err != nil {
+			return fmt.Errorf("reopening %s: %w", fs.Path, err)
+		}
+	}
+	if fs.shouldRotate(int64(len(docBytes))) {
+		if err := fs.rotate(); err != nil {
+			return fmt.Errorf("rotating %s: %w", fs.Path, err)
+		}
+	}
+	n, err := fs.file.Write(docBytes)
+	fs.size += int64(n)
+	return err
+}
+
+func (fs *FileSink) shouldRotate(nextWrite int64) bool {
+	if fs.size == 0 || time.Now().Before(fs.retryRotateAt) {
+		return false
+	}
+	if fs.MaxSize > 0 && fs.size+nextWrite > fs.MaxSize {
+		return true
+	}
+	return fs.MaxAge > 0 && time.Since(fs.openedAt) > fs.MaxAge
+}
+
+// Moves the current file aside and opens a fresh one. Compression and
+// retention happen in the background so Emit isn't held up. If the file
+// can't be moved, the error is logged and writing carries on at Path
+// until rotateRetryDelay has passed.
+func (fs *FileSink) rotate() error {
+	if err := fs.file.Close(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"file": fs.Path,
+			"err":  err,
+		}).Errorln("Error closing file before rotating it")
+	}
+	fs.file = nil
+	ext := filepath.Ext(fs.Path)
+	base := strings.TrimSuffix(fs.Path, ext)
+	rotatedPath := fmt.Sprintf("%s-%s%s", base, time.Now().UTC().Format(rotateTimeFormat), ext)
+	for i := 1; fileExists(rotatedPath) || fileExists(rotatedPath+".gz"); i++ {
+		rotatedPath = fmt.Sprintf("%s-%s.%d%s", base, time.Now().UTC().Format(rotateTimeFormat), i, ext)
+	}
+	renameErr := os.Rename(fs.Path, rotatedPath)
+	if err := fs.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		logrus.WithFields(logrus.Fields{
+			"file": fs.Path,
+			"err":  renameErr,
+		}).Errorln("Error rotating file, carrying on with the current one")
+		fs.retryRotateAt = time.Now().Add(rotateRetryDelay)
+		return nil
+	}
+	fs.wg.Add(1)
+	go func() {
+		defer fs.wg.Done()
+		fs.archiveMu.Lock()
+		defer fs.archiveMu.Unlock()
+		if fs.Compress {
+			if err := gzipFile(rotatedPath); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"file": rotatedPath,
+					"err":  err,
+				}).Errorln("Error compressing rotated file")
+			}
+		}
+		fs.prune()
+	}()
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		gz.Close()
+		out.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Deletes all but the newest MaxBackups rotated files. Only files named the
+// way rotate names them count, so others sharing the prefix are left alone.
+func (fs *FileSink) prune() {
+	if fs.MaxBackups <= 0 {
+		return
+	}
+	dir := filepath.Dir(fs.Path)
+	ext := filepath.Ext(fs.Path)
+	base := strings.TrimSuffix(filepath.Base(fs.Path), ext)
+	rotated := regexp.MustCompile("^" + regexp.QuoteMeta(base) + `-\d{8}T\d{6}\.\d{9}(\.\d+)?` + regexp.QuoteMeta(ext) + `(\.gz)?$`)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		logrus.WithError(err).Errorln("Error listing rotated files")
+		return
+	}
+	backups := []string{}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !rotated.MatchString(name) {
+			continue
+		}
+		backups = append(backups, name)
+	}
+	// The timestamp format sorts lexically.
+	sort.Strings(backups)
+	for len(backups) > fs.MaxBackups {
+		oldest := filepath.Join(dir, backups[0])
+		if err := os.Remove(oldest); err != nil && !os.IsNotExist(err) {
+			logrus.WithFields(logrus.Fields{
+				"file": oldest,
+				"err":  err,
+			}).Errorln("Error removing old rotated file")
+		}
+		backups = backups[1:]
+	}
+}
+
+func (fs *FileSink) Flush() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.file == nil {
+		return nil
+	}
+	return fs.file.Sync()
+}
+
+func (fs *FileSink) Close() error {
+	fs.mu.Lock()
+	fs.closed = true
+	var err error
+	if fs.file != nil {
+		err = fs.file.Sync()
+		if closeErr := fs.file.Close(); err == nil {
+			err = closeErr
+		}
+		fs.file = nil
+	}
+	fs.mu.Unlock()
+	fs.wg.Wait()
+	return err
+}
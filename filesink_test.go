@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTestFileSink(t *testing.T, fs *FileSink) *FileSink {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "filesink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	fs.Path = filepath.Join(dir, "events.jsonl")
+	if err := fs.open(); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines++
+	}
+	return lines
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxSize     int64
+		maxBackups  int
+		compress    bool
+		emits       int
+		wantBackups int
+	}{
+		{"no limit", 0, 0, false, 5, 0},
+		{"rotated", 1, 0, false, 5, 4},
+		{"pruned", 1, 2, false, 5, 2},
+		{"compressed", 1, 0, true, 3, 2},
+	}
+	for _, test := range tests {
+		fs := openTestFileSink(t, &FileSink{MaxSize: test.maxSize, MaxBackups: test.maxBackups, Compress: test.compress})
+		for i := 0; i < test.emits; i++ {
+			if err := fs.Emit(SSHDoc{Action: "login"}); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		if err := fs.Close(); err != nil {
+			t.Fatal(err)
+		}
+		backups, _ := filepath.Glob(strings.TrimSuffix(fs.Path, ".jsonl") + "-*")
+		if len(backups) != test.wantBackups {
+			t.Errorf("%s: %d rotated files, want %d", test.name, len(backups), test.wantBackups)
+		}
+		for _, backup := range backups {
+			if strings.HasSuffix(backup, ".gz") != test.compress {
+				t.Errorf("%s: rotated file %s, compressed %v", test.name, backup, test.compress)
+			}
+		}
+		if test.wantBackups == 0 {
+			if lines := countLines(t, fs.Path); lines != test.emits {
+				t.Errorf("%s: %d lines written, want %d", test.name, lines, test.emits)
+			}
+		}
+	}
+}
+
+func TestFileSinkCarriesOnWhenRotationFails(t *testing.T) {
+	fs := openTestFileSink(t, &FileSink{MaxSize: 1})
+	defer fs.Close()
+	if err := fs.Emit(SSHDoc{Action: "login"}); err != nil {
+		t.Fatal(err)
+	}
+	// With the file gone there is nothing to rename.
+	os.Remove(fs.Path)
+	for i := 0; i < 2; i++ {
+		if err := fs.Emit(SSHDoc{Action: "logout"}); err != nil {
+			t.Fatalf("Emit after a failed rotation: %v", err)
+		}
+	}
+	if lines := countLines(t, fs.Path); lines != 2 {
+		t.Errorf("%d lines written after a failed rotation, want 2", lines)
+	}
+	// Rotation waits a while before being tried again.
+	if backups, _ := filepath.Glob(strings.TrimSuffix(fs.Path, ".jsonl") + "-*"); len(backups) != 0 {
+		t.Errorf("rotated again straight after a failure: %v", backups)
+	}
+}
+
+func TestFileSinkPruneKeepsOtherFiles(t *testing.T) {
+	fs := openTestFileSink(t, &FileSink{MaxSize: 1, MaxBackups: 1})
+	dir := filepath.Dir(fs.Path)
+	others := []string{"events-old.jsonl", "events-20200101.jsonl", "events-notes.jsonl.gz"}
+	for _, name := range others {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if err := fs.Emit(SSHDoc{Action: "login"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range others {
+		if !fileExists(filepath.Join(dir, name)) {
+			t.Errorf("prune removed %s, which the sink didn't create", name)
+		}
+	}
+	backups, _ := filepath.Glob(filepath.Join(dir, "events-*T*.jsonl"))
+	if len(backups) != 1 {
+		t.Errorf("%d rotated files kept, want 1: %v", len(backups), backups)
+	}
+}
+
+func TestFileSinkClosed(t *testing.T) {
+	fs := openTestFileSink(t, &FileSink{})
+	fs.Close()
+	if err := fs.Emit(SSHDoc{Action: "login"}); err != errSinkClosed {
+		t.Errorf("Emit after Close = %v, want %v", err, errSinkClosed)
+	}
+}
@@ -7,6 +7,7 @@ require (
 	github.com/fatih/color v1.13.0
 	github.com/gliderlabs/ssh v0.3.3
 	github.com/mattn/go-colorable v0.1.12 // indirect
+	github.com/pkg/sftp v1.13.4
 	github.com/sirupsen/logrus v1.8.1
 	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
 	golang.org/x/sys v0.0.0-20220224003255-dbe011f71a99 // indirect
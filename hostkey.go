@@ -1,33 +1,255 @@
 package main
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
-	"math/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/hkdf"
+	gossh "golang.org/x/crypto/ssh"
 )
 
-type SeededRandReader struct {
-	Random *rand.Rand
+var hostKeyTypes = []string{"ed25519", "ecdsa", "rsa"}
+
+// Loads or creates the server's host keys.
+//
+// If HOST_KEY_FILES is set, exactly those private keys are used. Otherwise
+// there is one key per type in HOST_KEY_TYPES. With HOST_KEY_SEED set, those
+// keys are derived from the seed and the hostname, so they're the same every
+// time without being stored. Without it, they're read from HOST_KEY_DIR, and
+// generated and saved there the first time.
+func loadHostSigners(hostname string) ([]ssh.Signer, error) {
+	if keyFiles := envOrDefault("HOST_KEY_FILES", ""); keyFiles != "" {
+		signers := []ssh.Signer{}
+		for _, path := range strings.Split(keyFiles, ",") {
+			path = strings.TrimSpace(path)
+			if path == "" {
+				continue
+			}
+			signer, err := readHostKey(path)
+			if err != nil {
+				return nil, fmt.Errorf("reading host key %s: %w", path, err)
+			}
+			signers = append(signers, signer)
+		}
+		return signers, nil
+	}
+
+	types := []string{}
+	for _, keyType := range strings.Split(envOrDefault("HOST_KEY_TYPES", ""), ",") {
+		if keyType = strings.TrimSpace(keyType); keyType != "" {
+			types = append(types, keyType)
+		}
+	}
+	if len(types) == 0 {
+		types = hostKeyTypes
+	}
+	rsaBits := envIntOrDefault("HOST_KEY_RSA_BITS", 3072)
+	seed := envOrDefault("HOST_KEY_SEED", "")
+	dir := envOrDefault("HOST_KEY_DIR", "host_keys")
+
+	signers := []ssh.Signer{}
+	for _, keyType := range types {
+		var key crypto.Signer
+		var err error
+		if seed != "" {
+			key, err = deriveHostKey(keyType, rsaBits, kdfReader(seed, hostname, keyType))
+		} else {
+			key, err = loadOrCreateHostKey(dir, keyType, rsaBits)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s host key: %w", keyType, err)
+		}
+		signer, err := gossh.NewSignerFromKey(key)
+		if err != nil {
+			return nil, err
+		}
+		logrus.WithFields(logrus.Fields{
+			"type":        signer.PublicKey().Type(),
+			"fingerprint": gossh.FingerprintSHA256(signer.PublicKey()),
+		}).Infoln("Loaded host key")
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+func readHostKey(path string) (ssh.Signer, error) {
+	keyBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return gossh.ParsePrivateKey(keyBytes)
+}
+
+func loadOrCreateHostKey(dir string, keyType string, rsaBits int) (crypto.Signer, error) {
+	path := filepath.Join(dir, "ssh_host_"+keyType+"_key")
+	keyBytes, err := ioutil.ReadFile(path)
+	if err == nil {
+		raw, err := gossh.ParseRawPrivateKey(keyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		key, ok := signerFromRaw(raw)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key in %s", path)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := deriveHostKey(keyType, rsaBits, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(path, pemBytes, 0600); err != nil {
+		return nil, err
+	}
+	logrus.WithField("path", path).Infoln("Generated new host key")
+	return key, nil
+}
+
+// ParseRawPrivateKey returns ed25519 keys by pointer.
+func signerFromRaw(raw interface{}) (crypto.Signer, bool) {
+	if key, ok := raw.(*ed25519.PrivateKey); ok {
+		return *key, true
+	}
+	key, ok := raw.(crypto.Signer)
+	return key, ok
 }
 
-func (srr SeededRandReader) Read(buf []byte) (n int, err error) {
-	return srr.Random.Read(buf)
+// Stream of key material for one key type, derived from the seed with
+// HKDF-SHA256 and salted with the hostname.
+func kdfReader(seed string, hostname string, keyType string) io.Reader {
+	return hkdf.New(sha256.New, []byte(seed), []byte(hostname), []byte("honeystats ssh host key "+keyType))
 }
 
-func strDigest(str string) int64 {
-	var ret int64
-	for _, ch := range str {
-		ret += int64(ch)
+// Generates a key of keyType from the bytes in r. The standard library's
+// generators are free to mix in extra randomness, so keys are built by hand
+// here to stay deterministic when r is.
+func deriveHostKey(keyType string, rsaBits int, r io.Reader) (crypto.Signer, error) {
+	switch keyType {
+	case "ed25519":
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := io.ReadFull(r, seed); err != nil {
+			return nil, err
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	case "ecdsa":
+		return deriveECDSAKey(elliptic.P256(), r)
+	case "rsa":
+		return deriveRSAKey(rsaBits, r)
+	default:
+		return nil, fmt.Errorf("unknown host key type %q", keyType)
 	}
-	return ret
 }
 
-func genHostKey(seedStr string) (*rsa.PrivateKey, error) {
-	var r SeededRandReader
-	r = SeededRandReader{
-		Random: rand.New(
-			rand.NewSource(strDigest(seedStr)),
-		),
+func deriveECDSAKey(curve elliptic.Curve, r io.Reader) (*ecdsa.PrivateKey, error) {
+	params := curve.Params()
+	buf := make([]byte, params.BitSize/8+8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
 	}
+	// d is uniform enough in [1, N-1] with the extra 64 bits of input.
+	nMinusOne := new(big.Int).Sub(params.N, big.NewInt(1))
+	d := new(big.Int).SetBytes(buf)
+	d.Mod(d, nMinusOne)
+	d.Add(d, big.NewInt(1))
+	key := &ecdsa.PrivateKey{D: d}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+	return key, nil
+}
 
-	return rsa.GenerateKey(r, 2048)
+func deriveRSAKey(bits int, r io.Reader) (*rsa.PrivateKey, error) {
+	if bits < 2048 || bits%16 != 0 {
+		return nil, fmt.Errorf("unsupported RSA key size %d", bits)
+	}
+	e := big.NewInt(65537)
+	one := big.NewInt(1)
+	for {
+		p, err := derivePrime(bits/2, e, r)
+		if err != nil {
+			return nil, err
+		}
+		q, err := derivePrime(bits/2, e, r)
+		if err != nil {
+			return nil, err
+		}
+		if p.Cmp(q) == 0 {
+			continue
+		}
+		n := new(big.Int).Mul(p, q)
+		if n.BitLen() != bits {
+			continue
+		}
+		pMinusOne := new(big.Int).Sub(p, one)
+		qMinusOne := new(big.Int).Sub(q, one)
+		totient := new(big.Int).Mul(pMinusOne, qMinusOne)
+		d := new(big.Int).ModInverse(e, totient)
+		if d == nil {
+			continue
+		}
+		key := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: n, E: int(e.Int64())},
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		if err := key.Validate(); err != nil {
+			return nil, err
+		}
+		key.Precompute()
+		return key, nil
+	}
+}
+
+// Finds the first prime p of the given size with p-1 coprime to e, at or
+// after a candidate read from r.
+func derivePrime(bits int, e *big.Int, r io.Reader) (*big.Int, error) {
+	buf := make([]byte, (bits+7)/8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	// Setting the top two bits makes the product of two primes exactly
+	// twice as long.
+	extra := uint(len(buf)*8 - bits)
+	buf[0] &= byte(0xff >> extra)
+	buf[0] |= byte(0xc0 >> extra)
+	buf[len(buf)-1] |= 1
+	candidate := new(big.Int).SetBytes(buf)
+	two := big.NewInt(2)
+	one := big.NewInt(1)
+	rem := new(big.Int)
+	for candidate.BitLen() == bits {
+		if rem.Mod(candidate, e).Cmp(one) != 0 && candidate.ProbablyPrime(20) {
+			return candidate, nil
+		}
+		candidate.Add(candidate, two)
+	}
+	return nil, errors.New("ran out of prime candidates")
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func publicKeyBytes(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer.PublicKey().Marshal()
+}
+
+func TestDeriveHostKey(t *testing.T) {
+	tests := []struct {
+		keyType string
+		wantSSH string
+	}{
+		{"ed25519", "ssh-ed25519"},
+		{"ecdsa", "ecdsa-sha2-nistp256"},
+		{"rsa", "ssh-rsa"},
+	}
+	for _, test := range tests {
+		key, err := deriveHostKey(test.keyType, 2048, kdfReader("seed", "web01", test.keyType))
+		if err != nil {
+			t.Fatalf("%s: %v", test.keyType, err)
+		}
+		again, _ := deriveHostKey(test.keyType, 2048, kdfReader("seed", "web01", test.keyType))
+		if !bytes.Equal(publicKeyBytes(t, key), publicKeyBytes(t, again)) {
+			t.Errorf("%s: same seed and hostname gave different keys", test.keyType)
+		}
+		for _, other := range [][2]string{{"other seed", "web01"}, {"seed", "web02"}} {
+			otherKey, _ := deriveHostKey(test.keyType, 2048, kdfReader(other[0], other[1], test.keyType))
+			if bytes.Equal(publicKeyBytes(t, key), publicKeyBytes(t, otherKey)) {
+				t.Errorf("%s: seed %q and hostname %q gave the same key", test.keyType, other[0], other[1])
+			}
+		}
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			if err := key.Validate(); err != nil || key.N.BitLen() != 2048 {
+				t.Errorf("rsa: invalid %d bit key: %v", key.N.BitLen(), err)
+			}
+		case *ecdsa.PrivateKey:
+			if !key.Curve.IsOnCurve(key.X, key.Y) {
+				t.Error("ecdsa: public key is not on the curve")
+			}
+		}
+		signer, err := gossh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signer.PublicKey().Type() != test.wantSSH {
+			t.Errorf("%s: SSH key type %s, want %s", test.keyType, signer.PublicKey().Type(), test.wantSSH)
+		}
+		data := []byte("session hash")
+		sig, err := signer.Sign(nil, data)
+		if err != nil {
+			t.Fatalf("%s: signing: %v", test.keyType, err)
+		}
+		if err := signer.PublicKey().Verify(data, sig); err != nil {
+			t.Errorf("%s: signature doesn't verify: %v", test.keyType, err)
+		}
+	}
+}
+
+func TestDeriveRSAKeySizes(t *testing.T) {
+	tests := []struct {
+		bits    int
+		wantErr bool
+	}{
+		{1024, true},
+		{2050, true},
+		{2048, false},
+	}
+	for _, test := range tests {
+		_, err := deriveRSAKey(test.bits, kdfReader("seed", "web01", "rsa"))
+		if (err != nil) != test.wantErr {
+			t.Errorf("deriveRSAKey(%d) error = %v, want error %v", test.bits, err, test.wantErr)
+		}
+	}
+}
+
+func TestLoadHostSigners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		types     string
+		seed      string
+		wantTypes []string
+		wantErr   bool
+	}{
+		{"ed25519", "", []string{"ssh-ed25519"}, false},
+		{"ed25519,,ecdsa,", "", []string{"ssh-ed25519", "ecdsa-sha2-nistp256"}, false},
+		{" ecdsa , ed25519 ", "seed", []string{"ecdsa-sha2-nistp256", "ssh-ed25519"}, false},
+		{",", "seed", []string{"ssh-ed25519", "ecdsa-sha2-nistp256", "ssh-rsa"}, false},
+		{"dsa", "", nil, true},
+	}
+	for _, test := range tests {
+		t.Setenv("HOST_KEY_DIR", dir)
+		t.Setenv("HOST_KEY_TYPES", test.types)
+		t.Setenv("HOST_KEY_SEED", test.seed)
+		t.Setenv("HOST_KEY_RSA_BITS", "2048")
+		signers, err := loadHostSigners("web01")
+		if (err != nil) != test.wantErr {
+			t.Errorf("HOST_KEY_TYPES=%q: error = %v, want error %v", test.types, err, test.wantErr)
+			continue
+		}
+		if len(signers) != len(test.wantTypes) {
+			t.Errorf("HOST_KEY_TYPES=%q: %d keys, want %d", test.types, len(signers), len(test.wantTypes))
+			continue
+		}
+		for i, signer := range signers {
+			if signer.PublicKey().Type() != test.wantTypes[i] {
+				t.Errorf("HOST_KEY_TYPES=%q: key %d is %s, want %s", test.types, i, signer.PublicKey().Type(), test.wantTypes[i])
+			}
+		}
+	}
+}
+
+func TestHostKeysPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first, err := loadOrCreateHostKey(dir, "ed25519", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := loadOrCreateHostKey(dir, "ed25519", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(publicKeyBytes(t, first), publicKeyBytes(t, second)) {
+		t.Error("saved host key was not loaded back")
+	}
+}
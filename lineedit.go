@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+type keyCode int
+
+const (
+	keyRune keyCode = iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+	keyUnknown
+)
+
+// Reads the rest of an escape sequence after the initial ESC and works out
+// which key it was. Sequences we don't understand are consumed whole, so
+// their bytes never end up in the command line. Terminals send a sequence
+// all at once, so an ESC with nothing after it yet, or followed by anything
+// but "[" or "O", is the Escape key on its own and the next keystroke is
+// left to be read normally.
+func readEscape(reader *bufio.Reader) (keyCode, error) {
+	if reader.Buffered() == 0 {
+		return keyUnknown, nil
+	}
+	next, err := reader.ReadByte()
+	if err != nil {
+		return keyUnknown, err
+	}
+	switch next {
+	case '[': // CSI
+		params := []byte{}
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return keyUnknown, err
+			}
+			if b >= 0x40 && b <= 0x7e {
+				return csiKey(string(params), b), nil
+			}
+			params = append(params, b)
+			if len(params) > 16 {
+				return keyUnknown, nil
+			}
+		}
+	case 'O': // SS3, sent for arrows and Home/End in application mode
+		b, err := reader.ReadByte()
+		if err != nil {
+			return keyUnknown, err
+		}
+		return csiKey("", b), nil
+	default:
+		reader.UnreadByte()
+		return keyUnknown, nil
+	}
+}
+
+func csiKey(params string, final byte) keyCode {
+	switch final {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		return keyRight
+	case 'D':
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	case '~':
+		switch params {
+		case "1", "7":
+			return keyHome
+		case "4", "8":
+			return keyEnd
+		case "3":
+			return keyDelete
+		}
+	}
+	return keyUnknown
+}
+
+// LineEditor keeps the line being typed and the cursor position, echoing
+// edits back to the terminal roughly the way readline does.
+type LineEditor struct {
+	out     io.Writer
+	line    []rune
+	pos     int
+	state   *SessionState
+	histPos int
+	// The line being typed before browsing history started.
+	draft []rune
+}
+
+func newLineEditor(out io.Writer, state *SessionState) *LineEditor {
+	return &LineEditor{
+		out:     out,
+		line:    []rune{},
+		state:   state,
+		histPos: -1,
+	}
+}
+
+func (e *LineEditor) String() string {
+	return string(e.line)
+}
+
+// Text before the cursor, which is what tab completion works on.
+func (e *LineEditor) BeforeCursor() string {
+	return string(e.line[:e.pos])
+}
+
+func (e *LineEditor) AtEnd() bool {
+	return e.pos == len(e.line)
+}
+
+func (e *LineEditor) moveBack(n int) {
+	if n > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", n)
+	}
+}
+
+// Rewrites everything from the cursor onwards, blanking `erase` extra
+// columns left over from a longer line, and puts the cursor back.
+func (e *LineEditor) redrawTail(erase int) {
+	tail := string(e.line[e.pos:])
+	io.WriteString(e.out, tail+strings.Repeat(" ", erase))
+	e.moveBack(len(e.line[e.pos:]) + erase)
+}
+
+func (e *LineEditor) Insert(text string) {
+	runes := []rune(text)
+	newLine := make([]rune, 0, len(e.line)+len(runes))
+	newLine = append(newLine, e.line[:e.pos]...)
+	newLine = append(newLine, runes...)
+	newLine = append(newLine, e.line[e.pos:]...)
+	e.line = newLine
+	io.WriteString(e.out, text)
+	e.pos += len(runes)
+	if !e.AtEnd() {
+		e.redrawTail(0)
+	}
+}
+
+// Deletes n runes before the cursor.
+func (e *LineEditor) deleteBack(n int) {
+	if n > e.pos {
+		n = e.pos
+	}
+	if n == 0 {
+		return
+	}
+	e.line = append(e.line[:e.pos-n], e.line[e.pos:]...)
+	e.moveBack(n)
+	e.pos -= n
+	e.redrawTail(n)
+}
+
+// Deletes n runes from the cursor onwards.
+func (e *LineEditor) deleteForward(n int) {
+	if e.pos+n > len(e.line) {
+		n = len(e.line) - e.pos
+	}
+	if n == 0 {
+		return
+	}
+	e.line = append(e.line[:e.pos], e.line[e.pos+n:]...)
+	e.redrawTail(n)
+}
+
+func (e *LineEditor) Backspace() {
+	e.deleteBack(1)
+}
+
+func (e *LineEditor) Delete() {
+	e.deleteForward(1)
+}
+
+func (e *LineEditor) Left() {
+	if e.pos > 0 {
+		e.pos--
+		io.WriteString(e.out, "\x08")
+	}
+}
+
+func (e *LineEditor) Right() {
+	if e.pos < len(e.line) {
+		io.WriteString(e.out, string(e.line[e.pos]))
+		e.pos++
+	}
+}
+
+func (e *LineEditor) Home() {
+	e.moveBack(e.pos)
+	e.pos = 0
+}
+
+func (e *LineEditor) End() {
+	io.WriteString(e.out, string(e.line[e.pos:]))
+	e.pos = len(e.line)
+}
+
+// Ctrl+U
+func (e *LineEditor) KillToStart() {
+	e.deleteBack(e.pos)
+}
+
+// Ctrl+K
+func (e *LineEditor) KillToEnd() {
+	e.deleteForward(len(e.line) - e.pos)
+}
+
+// Ctrl+W, which like bash's unix-word-rubout treats anything but whitespace
+// as part of a word.
+func (e *LineEditor) KillWordBack() {
+	start := e.pos
+	for start > 0 && unicode.IsSpace(e.line[start-1]) {
+		start--
+	}
+	for start > 0 && !unicode.IsSpace(e.line[start-1]) {
+		start--
+	}
+	e.deleteBack(e.pos - start)
+}
+
+// Swaps the whole line for another one, e.g. from history.
+func (e *LineEditor) replace(line []rune) {
+	oldLen := len(e.line)
+	e.moveBack(e.pos)
+	e.line = append([]rune{}, line...)
+	e.pos = len(e.line)
+	io.WriteString(e.out, string(e.line))
+	if oldLen > len(e.line) {
+		erase := oldLen - len(e.line)
+		io.WriteString(e.out, strings.Repeat(" ", erase))
+		e.moveBack(erase)
+	}
+}
+
+func (e *LineEditor) HistoryPrev() {
+	history := e.state.history()
+	if len(history) == 0 || e.histPos == 0 {
+		return
+	}
+	if e.histPos == -1 {
+		e.draft = append([]rune{}, e.line...)
+		e.histPos = len(history)
+	}
+	e.histPos--
+	e.replace([]rune(history[e.histPos]))
+}
+
+func (e *LineEditor) HistoryNext() {
+	if e.histPos == -1 {
+		return
+	}
+	history := e.state.history()
+	e.histPos++
+	if e.histPos >= len(history) {
+		e.histPos = -1
+		e.replace(e.draft)
+		return
+	}
+	e.replace([]rune(history[e.histPos]))
+}
+
+// Reprints the prompt and line, e.g. after the screen was cleared.
+func (e *LineEditor) Redraw(prompt string) {
+	io.WriteString(e.out, prompt+string(e.line))
+	e.moveBack(len(e.line) - e.pos)
+}
+
+// Returns the finished line, records it in the session history and starts
+// a new one.
+func (e *LineEditor) Submit() string {
+	line := string(e.line)
+	e.state.touch()
+	e.state.addHistory(line)
+	e.Reset()
+	return line
+}
+
+func (e *LineEditor) Reset() {
+	e.line = []rune{}
+	e.pos = 0
+	e.histPos = -1
+	e.draft = nil
+}
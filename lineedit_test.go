@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadEscape(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantKey  keyCode
+		wantRest string
+	}{
+		{"up", "\x1b[A", keyUp, ""},
+		{"down", "\x1b[B", keyDown, ""},
+		{"right", "\x1b[C", keyRight, ""},
+		{"left", "\x1b[Dls", keyLeft, "ls"},
+		{"home", "\x1b[H", keyHome, ""},
+		{"end", "\x1b[F", keyEnd, ""},
+		{"home tilde", "\x1b[1~", keyHome, ""},
+		{"end tilde", "\x1b[4~", keyEnd, ""},
+		{"delete", "\x1b[3~", keyDelete, ""},
+		{"application up", "\x1bOA", keyUp, ""},
+		{"ctrl right", "\x1b[1;5C", keyRight, ""},
+		{"unknown CSI", "\x1b[15~x", keyUnknown, "x"},
+		{"lone escape", "\x1b", keyUnknown, ""},
+		{"escape then key", "\x1bls", keyUnknown, "ls"},
+	}
+	for _, test := range tests {
+		reader := bufio.NewReader(strings.NewReader(test.input))
+		reader.ReadByte()
+		key, err := readEscape(reader)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		rest, _ := ioutil.ReadAll(reader)
+		if key != test.wantKey || string(rest) != test.wantRest {
+			t.Errorf("%s: key %d leaving %q, want %d leaving %q", test.name, key, rest, test.wantKey, test.wantRest)
+		}
+	}
+}
+
+func TestReadEscapeDoesNotWait(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	reader := bufio.NewReader(pr)
+	go pw.Write([]byte("\x1b"))
+	reader.ReadByte()
+	done := make(chan keyCode, 1)
+	go func() {
+		key, _ := readEscape(reader)
+		done <- key
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("readEscape waited for a key after a lone ESC")
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(e *LineEditor)
+		want string
+	}{
+		{"insert", func(e *LineEditor) { e.Insert("ls -la") }, "ls -la"},
+		{"backspace", func(e *LineEditor) { e.Insert("lss"); e.Backspace() }, "ls"},
+		{"insert in the middle", func(e *LineEditor) { e.Insert("l"); e.Left(); e.Insert("c") }, "cl"},
+		{"delete", func(e *LineEditor) { e.Insert("cat"); e.Home(); e.Delete() }, "at"},
+		{"backspace at start", func(e *LineEditor) { e.Insert("ab"); e.Home(); e.Backspace() }, "ab"},
+		{"right past end", func(e *LineEditor) { e.Insert("ab"); e.Right(); e.Insert("c") }, "abc"},
+	}
+	for _, test := range tests {
+		e := newLineEditor(ioutil.Discard, &SessionState{})
+		test.edit(e)
+		if got := e.String(); got != test.want {
+			t.Errorf("%s: line %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLineEditorHistory(t *testing.T) {
+	state := &SessionState{}
+	var out bytes.Buffer
+	e := newLineEditor(&out, state)
+	for _, line := range []string{"ls", "ls", "", "pwd"} {
+		e.Insert(line)
+		e.Submit()
+	}
+	if history := state.history(); strings.Join(history, ",") != "ls,pwd" {
+		t.Errorf("history %q, want blank lines and repeats left out", history)
+	}
+	e.Insert("draft")
+	steps := []struct {
+		move func()
+		want string
+	}{
+		{e.HistoryPrev, "pwd"},
+		{e.HistoryPrev, "ls"},
+		{e.HistoryPrev, "ls"},
+		{e.HistoryNext, "pwd"},
+		{e.HistoryNext, "draft"},
+	}
+	for i, step := range steps {
+		step.move()
+		if got := e.String(); got != step.want {
+			t.Errorf("step %d: line %q, want %q", i, got, step.want)
+		}
+	}
+}
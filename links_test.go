@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/honeystats/ssh/files"
+)
+
+const linksTestConfig = `
+root:
+  name: ""
+  subdirs:
+  - name: etc
+    files:
+    - name: passwd
+      content: "root:x:0:0:root:/root:/bin/bash\n"
+    links:
+    - name: rel
+      target: passwd
+    - name: loop
+      target: loop
+  - name: usr
+    subdirs:
+    - name: bin
+      files:
+      - name: ls
+        content: ELF
+  links:
+  - name: bin
+    target: usr/bin
+`
+
+// A session on a tree with links in it.
+func newLinksTestState() *SessionState {
+	root := files.StrToFilesystem([]byte(linksTestConfig)).Root
+	state := newTestState()
+	state.Root, state.Cwd, state.base = root, root, root
+	return state
+}
+
+func TestLinks(t *testing.T) {
+	tests := []struct {
+		cmd        string
+		wantStdout string
+		wantStderr string
+	}{
+		{"cd /bin; pwd; pwd -P", "/bin\n/usr/bin\n", ""},
+		{"cd /bin; cd ..; pwd", "/\n", ""},
+		{"cd -P /bin; pwd", "/usr/bin\n", ""},
+		{"cat /etc/rel", "root:x:0:0:root:/root:/bin/bash\n", ""},
+		{"ls /bin", "ls\n", ""},
+		{"ls -d /bin /bin/", "/bin\n/bin/\n", ""},
+		{"ls /etc", "loop\npasswd\nrel\n", ""},
+		{"ls /etc/loop", "", "ls: cannot access '/etc/loop': Too many levels of symbolic links\n"},
+		{"cd /etc/loop", "", "bash: cd: /etc/loop: Too many levels of symbolic links\n"},
+	}
+	for _, test := range tests {
+		stdout, stderr, _ := runTestShell(newLinksTestState(), test.cmd)
+		if stdout != test.wantStdout || stderr != test.wantStderr {
+			t.Errorf("%q gave %q, %q, want %q, %q", test.cmd, stdout, stderr, test.wantStdout, test.wantStderr)
+		}
+	}
+}
+
+func TestLsLongShowsLinkTargets(t *testing.T) {
+	stdout, _, _ := runTestShell(newLinksTestState(), "ls -l /bin /etc/rel")
+	stdout = lsTimestamp.ReplaceAllString(stdout, "TIME")
+	want := "lrwxrwxrwx 1 root root 7 TIME /bin -> usr/bin\nlrwxrwxrwx 1 root root 6 TIME /etc/rel -> passwd\n"
+	if stdout != want {
+		t.Errorf("ls -l gave %q, want %q", stdout, want)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"github.com/honeystats/ssh/files"
+)
+
+func init() {
+	registerCommand(lsCommand{commandSpec{
+		name:    "ls",
+		aliases: []string{"dir"},
+		dir:     binDir,
+		help:    "ls [OPTION]... [FILE]...\nList information about the FILEs (the current directory by default).\nSort entries alphabetically if none of -cftuvSUX nor --sort is specified.\n",
+	}})
+}
+
+type lsCommand struct{ commandSpec }
+
+func (lsCommand) Run(inv *Invocation) int {
+	return inv.result(runLs(inv.State, inv.Args))
+}
+
+type lsOptions struct {
+	long      bool
+	all       bool
+	human     bool
+	recursive bool
+	// 0 for by name, else 't' or 'S', whichever flag came last.
+	sortBy     rune
+	onePerLine bool
+	directory  bool
+}
+
+const lsTryHelp = "Try 'ls --help' for more information.\n"
+
+func parseLsArgs(args []string) (lsOptions, []string, error) {
+	opts := lsOptions{}
+	operands := []string{}
+	for i, arg := range args {
+		if arg == "--" {
+			operands = append(operands, args[i+1:]...)
+			break
+		}
+		if strings.HasPrefix(arg, "--") {
+			switch {
+			case arg == "--all":
+				opts.all = true
+			case arg == "--human-readable":
+				opts.human = true
+			case arg == "--recursive":
+				opts.recursive = true
+			case arg == "--directory":
+				opts.directory = true
+			case arg == "--color" || strings.HasPrefix(arg, "--color="):
+			default:
+				return opts, nil, fmt.Errorf("ls: unrecognized option '%s'\n%s", arg, lsTryHelp)
+			}
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			operands = append(operands, arg)
+			continue
+		}
+		for _, flag := range arg[1:] {
+			switch flag {
+			case 'l':
+				opts.long = true
+			case 'a':
+				opts.all = true
+			case 'h':
+				opts.human = true
+			case 'R':
+				opts.recursive = true
+			case 't', 'S':
+				opts.sortBy = flag
+			case '1':
+				opts.onePerLine = true
+			case 'd':
+				opts.directory = true
+			default:
+				return opts, nil, fmt.Errorf("ls: invalid option -- '%c'\n%s", flag, lsTryHelp)
+			}
+		}
+	}
+	return opts, operands, nil
+}
+
+type lsEntry struct {
+	// As shown in the listing.
+	name string
+	// As shown in the header when the entry is listed as a directory.
+	path string
+	f    files.FileDir
+}
+
+func (e lsEntry) dir() (*files.FilesystemDir, bool) {
+	dir, ok := e.f.(*files.FilesystemDir)
+	return dir, ok
+}
+
+func runLs(state *SessionState, args []string) CmdResult {
+	opts, operands, err := parseLsArgs(args)
+	if err != nil {
+		return CmdResult{Stderr: err.Error(), ExitCode: 2}
+	}
+	style := state.outputStyle()
+	showHeaders := len(operands) > 1 || opts.recursive
+	if len(operands) == 0 {
+		operands = []string{"."}
+	}
+	var stdout, stderr strings.Builder
+	exitCode := 0
+	state.view(func(root *files.FilesystemDir, cwd *files.FilesystemDir) {
+		fileEntries := []lsEntry{}
+		dirEntries := []lsEntry{}
+		for _, operand := range operands {
+			lookup := cwd.GetFileOrDir
+			if opts.long || opts.directory {
+				// Symlinks named on the command line are shown as links.
+				lookup = cwd.GetFileOrDirNoFollow
+			}
+			err, f := lookup(root, operand)
+			if err == files.ErrLinkLoop {
+				fmt.Fprintf(&stderr, "ls: cannot access '%s': %s\n", operand, err)
+				exitCode = 2
+				continue
+			}
+			if err != nil {
+				fmt.Fprintf(&stderr, "ls: cannot access '%s': No such file or directory\n", operand)
+				exitCode = 2
+				continue
+			}
+			entry := lsEntry{name: operand, path: operand, f: f}
+			if _, isDir := entry.dir(); isDir && !opts.directory {
+				dirEntries = append(dirEntries, entry)
+			} else {
+				fileEntries = append(fileEntries, entry)
+			}
+		}
+		opts.sort(fileEntries)
+		opts.sort(dirEntries)
+		stdout.WriteString(opts.format(fileEntries, style, false))
+		for _, entry := range dirEntries {
+			if stdout.Len() > 0 {
+				stdout.WriteString("\n")
+			}
+			opts.listDir(&stdout, entry, style, showHeaders)
+		}
+	})
+	return CmdResult{Stdout: stdout.String(), Stderr: stderr.String(), ExitCode: exitCode}
+}
+
+func (opts lsOptions) listDir(out *strings.Builder, entry lsEntry, style outputStyle, header bool) {
+	dir, _ := entry.dir()
+	if header {
+		fmt.Fprintf(out, "%s:\n", entry.path)
+	}
+	children := []lsEntry{}
+	childPath := func(name string) string {
+		if strings.HasSuffix(entry.path, "/") {
+			return entry.path + name
+		}
+		return entry.path + "/" + name
+	}
+	if opts.all {
+		children = append(children,
+			lsEntry{name: ".", path: childPath("."), f: dir},
+			lsEntry{name: "..", path: childPath(".."), f: dir.Parent})
+	}
+	for _, file := range dir.Files {
+		children = append(children, lsEntry{name: file.Name, path: childPath(file.Name), f: file})
+	}
+	for _, subdir := range dir.Subdirs {
+		children = append(children, lsEntry{name: subdir.Name, path: childPath(subdir.Name), f: subdir})
+	}
+	for _, link := range dir.Links {
+		if f, ok := dir.Entry(link.Name); ok {
+			children = append(children, lsEntry{name: link.Name, path: childPath(link.Name), f: f})
+		}
+	}
+	if !opts.all {
+		visible := children[:0]
+		for _, child := range children {
+			if !strings.HasPrefix(child.name, ".") {
+				visible = append(visible, child)
+			}
+		}
+		children = visible
+	}
+	opts.sort(children)
+	out.WriteString(opts.format(children, style, true))
+	if !opts.recursive {
+		return
+	}
+	for _, child := range children {
+		if _, isDir := child.dir(); isDir && child.name != "." && child.name != ".." {
+			out.WriteString("\n")
+			opts.listDir(out, child, style, true)
+		}
+	}
+}
+
+// Orders names roughly the way en_US.UTF-8 collation does: case and
+// leading dots don't matter unless everything else is equal.
+func lsNameLess(a string, b string) bool {
+	foldA := strings.ToLower(strings.TrimLeft(a, "."))
+	foldB := strings.ToLower(strings.TrimLeft(b, "."))
+	if foldA != foldB {
+		return foldA < foldB
+	}
+	return a < b
+}
+
+func (opts lsOptions) sort(entries []lsEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].f.Info(), entries[j].f.Info()
+		switch {
+		case opts.sortBy == 't' && !a.ModTime.Equal(b.ModTime):
+			return a.ModTime.After(b.ModTime)
+		case opts.sortBy == 'S' && a.Size != b.Size:
+			return a.Size > b.Size
+		}
+		return lsNameLess(entries[i].name, entries[j].name)
+	})
+}
+
+func (opts lsOptions) paint(entry lsEntry, style outputStyle) string {
+	info := entry.f.Info()
+	switch {
+	case info.Mode&os.ModeSymlink != 0:
+		return style.paint(entry.name, color.Bold, color.FgCyan)
+	case info.Mode.IsDir():
+		return style.paint(entry.name, color.Bold, color.FgBlue)
+	case info.Mode&0111 != 0:
+		return style.paint(entry.name, color.Bold, color.FgGreen)
+	}
+	return entry.name
+}
+
+// Lists entries in columns, one per line, or in long format. A directory's
+// own listing in long format starts with its total size in blocks.
+func (opts lsOptions) format(entries []lsEntry, style outputStyle, isDirListing bool) string {
+	if opts.long {
+		return opts.formatLong(entries, style, isDirListing)
+	}
+	if len(entries) == 0 {
+		return ""
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.name
+	}
+	width := style.Width
+	if opts.onePerLine {
+		width = 0
+	}
+	return formatColumns(names, width, func(i int, _ string) string {
+		return opts.paint(entries[i], style)
+	})
+}
+
+func (opts lsOptions) formatLong(entries []lsEntry, style outputStyle, isDirListing bool) string {
+	var out strings.Builder
+	if isDirListing {
+		total := int64(0)
+		for _, entry := range entries {
+			total += diskUsageKiB(entry.f.Info())
+		}
+		if opts.human {
+			fmt.Fprintf(&out, "total %s\n", humanSize(total*1024))
+		} else {
+			fmt.Fprintf(&out, "total %d\n", total)
+		}
+	}
+	sizes := make([]string, len(entries))
+	linksWidth, ownerWidth, groupWidth, sizeWidth := 0, 0, 0, 0
+	for i, entry := range entries {
+		info := entry.f.Info()
+		sizes[i] = fmt.Sprint(info.Size)
+		if opts.human {
+			sizes[i] = humanSize(info.Size)
+		}
+		linksWidth = maxInt(linksWidth, len(fmt.Sprint(info.Links)))
+		ownerWidth = maxInt(ownerWidth, utf8.RuneCountInString(info.Owner))
+		groupWidth = maxInt(groupWidth, utf8.RuneCountInString(info.Group))
+		sizeWidth = maxInt(sizeWidth, len(sizes[i]))
+	}
+	now := time.Now()
+	for i, entry := range entries {
+		info := entry.f.Info()
+		name := opts.paint(entry, style)
+		if link, ok := entry.f.(*files.FilesystemLink); ok {
+			name += " -> " + link.Target
+		}
+		fmt.Fprintf(&out, "%s %*d %-*s %-*s %*s %s %s\n",
+			modeString(info.Mode), linksWidth, info.Links, ownerWidth, info.Owner,
+			groupWidth, info.Group, sizeWidth, sizes[i], lsTime(info.ModTime, now),
+			name)
+	}
+	return out.String()
+}
+
+// Disk usage in KiB, assuming 4K blocks. Short symlinks live in the inode
+// and take none.
+func diskUsageKiB(info files.FileInfo) int64 {
+	if info.Mode&os.ModeSymlink != 0 {
+		return 0
+	}
+	return (info.Size + 4095) / 4096 * 4
+}
+
+// Formats a size the way ls -h does, rounding up: 512, 4.0K, 15K, 1.2M.
+func humanSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprint(size)
+	}
+	value := float64(size)
+	units := "KMGTPE"
+	unit := 0
+	for value /= 1024; value >= 1024 && unit < len(units)-1; value /= 1024 {
+		unit++
+	}
+	if value < 10 {
+		value = math.Ceil(value*10) / 10
+		if value < 10 {
+			return fmt.Sprintf("%.1f%c", value, units[unit])
+		}
+	}
+	return fmt.Sprintf("%.0f%c", math.Ceil(value), units[unit])
+}
+
+// Recent times get the time of day, older or future ones the year, as
+// with GNU ls.
+func lsTime(t time.Time, now time.Time) string {
+	sixMonthsAgo := now.AddDate(0, -6, 0)
+	if t.After(sixMonthsAgo) && !t.After(now.Add(time.Minute)) {
+		return t.Format("Jan _2 15:04")
+	}
+	return t.Format("Jan _2  2006")
+}
+
+// Formats a mode as ls -l does, e.g. drwxr-xr-x or -rwsr-xr-t.
+func modeString(mode os.FileMode) string {
+	buf := []byte("----------")
+	switch {
+	case mode.IsDir():
+		buf[0] = 'd'
+	case mode&os.ModeSymlink != 0:
+		buf[0] = 'l'
+	}
+	const rwx = "rwxrwxrwx"
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) != 0 {
+			buf[i+1] = rwx[i]
+		}
+	}
+	special := func(pos int, set bool, lower byte, upper byte) {
+		if !set {
+			return
+		}
+		if buf[pos] == 'x' {
+			buf[pos] = lower
+		} else {
+			buf[pos] = upper
+		}
+	}
+	special(3, mode&os.ModeSetuid != 0, 's', 'S')
+	special(6, mode&os.ModeSetgid != 0, 's', 'S')
+	special(9, mode&os.ModeSticky != 0, 't', 'T')
+	return string(buf)
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Lays names out in columns filled top to bottom, like GNU ls -C: as many
+// columns as fit in width, each as wide as its longest name plus two spaces.
+// With no width, names go one per line. paint colours a name once its width
+// has been accounted for.
+func formatColumns(names []string, width int, paint func(i int, name string) string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	widths := make([]int, len(names))
+	for i, name := range names {
+		widths[i] = utf8.RuneCountInString(name)
+	}
+	cols, colWidths := 1, []int{0}
+	if width > 0 {
+		for tryCols := len(names); tryCols > 1; tryCols-- {
+			rows := (len(names) + tryCols - 1) / tryCols
+			// Skip counts that would leave trailing columns empty.
+			if (len(names)+rows-1)/rows != tryCols {
+				continue
+			}
+			tryWidths := make([]int, tryCols)
+			total := 0
+			for i, w := range widths {
+				col := i / rows
+				if w+2 > tryWidths[col] {
+					tryWidths[col] = w + 2
+				}
+			}
+			for _, w := range tryWidths {
+				total += w
+			}
+			if total-2 <= width {
+				cols, colWidths = tryCols, tryWidths
+				break
+			}
+		}
+	}
+	rows := (len(names) + cols - 1) / cols
+	var out strings.Builder
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			i := col*rows + row
+			if i >= len(names) {
+				break
+			}
+			out.WriteString(paint(i, names[i]))
+			last := col == cols-1 || (col+1)*rows+row >= len(names)
+			if !last {
+				out.WriteString(strings.Repeat(" ", colWidths[col]-widths[i]))
+			}
+		}
+		out.WriteString("\n")
+	}
+	return out.String()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestParseLsArgs(t *testing.T) {
+	tests := []struct {
+		args         []string
+		want         lsOptions
+		wantOperands []string
+		wantErr      bool
+	}{
+		{nil, lsOptions{}, []string{}, false},
+		{[]string{"-la", "/etc"}, lsOptions{long: true, all: true}, []string{"/etc"}, false},
+		{[]string{"-lhR1d"}, lsOptions{long: true, human: true, recursive: true, onePerLine: true, directory: true}, []string{}, false},
+		{[]string{"-tS"}, lsOptions{sortBy: 'S'}, []string{}, false},
+		{[]string{"-St"}, lsOptions{sortBy: 't'}, []string{}, false},
+		{[]string{"--all", "--human-readable", "--color=auto", "-"}, lsOptions{all: true, human: true}, []string{"-"}, false},
+		{[]string{"--", "-l"}, lsOptions{}, []string{"-l"}, false},
+		{[]string{"-x"}, lsOptions{}, nil, true},
+		{[]string{"--bogus"}, lsOptions{}, nil, true},
+	}
+	for _, test := range tests {
+		opts, operands, err := parseLsArgs(test.args)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: error = %v, want error %v", test.args, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if opts != test.want || len(operands) != len(test.wantOperands) {
+			t.Errorf("%q: %+v %q, want %+v %q", test.args, opts, operands, test.want, test.wantOperands)
+			continue
+		}
+		for i := range operands {
+			if operands[i] != test.wantOperands[i] {
+				t.Errorf("%q: operands %q, want %q", test.args, operands, test.wantOperands)
+			}
+		}
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "0"},
+		{1023, "1023"},
+		{1024, "1.0K"},
+		{1025, "1.1K"},
+		{4096, "4.0K"},
+		{10 * 1024, "10K"},
+		{15*1024 + 1, "16K"},
+		{1024 * 1024, "1.0M"},
+		{5 << 30, "5.0G"},
+	}
+	for _, test := range tests {
+		if got := humanSize(test.size); got != test.want {
+			t.Errorf("humanSize(%d) = %s, want %s", test.size, got, test.want)
+		}
+	}
+}
+
+func TestModeString(t *testing.T) {
+	tests := []struct {
+		mode os.FileMode
+		want string
+	}{
+		{0644, "-rw-r--r--"},
+		{os.ModeDir | 0755, "drwxr-xr-x"},
+		{os.ModeSymlink | 0777, "lrwxrwxrwx"},
+		{0, "----------"},
+		{os.ModeSetuid | 0755, "-rwsr-xr-x"},
+		{os.ModeSetuid | 0644, "-rwSr--r--"},
+		{os.ModeSetgid | 0750, "-rwxr-s---"},
+		{os.ModeDir | os.ModeSticky | 0777, "drwxrwxrwt"},
+		{os.ModeDir | os.ModeSticky | 0776, "drwxrwxrwT"},
+	}
+	for _, test := range tests {
+		if got := modeString(test.mode); got != test.want {
+			t.Errorf("modeString(%v) = %s, want %s", test.mode, got, test.want)
+		}
+	}
+}
+
+func TestLsTime(t *testing.T) {
+	now := time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		t    time.Time
+		want string
+	}{
+		{now.Add(-time.Hour), "Jun 15 11:00"},
+		{time.Date(2021, 1, 2, 3, 4, 0, 0, time.UTC), "Jan  2 03:04"},
+		{time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC), "Dec  1  2020"},
+		{now.Add(time.Hour), "Jun 15  2021"},
+	}
+	for _, test := range tests {
+		if got := lsTime(test.t, now); got != test.want {
+			t.Errorf("lsTime(%s) = %q, want %q", test.t, got, test.want)
+		}
+	}
+}
+
+func TestLsNameLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"a", "b", true},
+		{"B", "a", false},
+		{".bashrc", "bin", true},
+		{"main.go", "Makefile", true},
+		{"a", "A", false},
+	}
+	for _, test := range tests {
+		if got := lsNameLess(test.a, test.b); got != test.want {
+			t.Errorf("lsNameLess(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+var lsTimestamp = regexp.MustCompile(`[A-Z][a-z]{2} [ 0-9]\d ( \d{4}|\d\d:\d\d)`)
+
+func TestLs(t *testing.T) {
+	tests := []struct {
+		cmd        string
+		wantStdout string
+		wantStderr string
+		wantStatus int
+	}{
+		{"ls /a", "b\nsomefile\n", "", 0},
+		{"ls -a /a", ".\n..\nb\n.hidden\nsomefile\n", "", 0},
+		{"ls -R /a", "/a:\nb\nsomefile\n\n/a/b:\nsomeotherfile\n", "", 0},
+		{"ls /a/somefile /a", "/a/somefile\n\n/a:\nb\nsomefile\n", "", 0},
+		{"cd /a; ls -d b", "b\n", "", 0},
+		{"ls -S /a/b/someotherfile /a/somefile", "/a/somefile\n/a/b/someotherfile\n", "", 0},
+		{"ls -l /a", "total 8\ndrwxr-xr-x 2 root root 4096 TIME b\n-rw-r--r-- 1 root root   24 TIME somefile\n", "", 0},
+		{"ls -lh /a", "total 8.0K\ndrwxr-xr-x 2 root root 4.0K TIME b\n-rw-r--r-- 1 root root   24 TIME somefile\n", "", 0},
+		{"ls -ld /a", "drwxr-xr-x 3 root root 4096 TIME /a\n", "", 0},
+		{"ls /nope /a/b", "/a/b:\nsomeotherfile\n", "ls: cannot access '/nope': No such file or directory\n", 2},
+		{"ls -x", "", "ls: invalid option -- 'x'\n" + lsTryHelp, 2},
+	}
+	state := newTestState()
+	runTestShell(state, "touch /a/.hidden")
+	for _, test := range tests {
+		stdout, stderr, status := runTestShell(state, test.cmd)
+		stdout = lsTimestamp.ReplaceAllString(stdout, "TIME")
+		if stdout != test.wantStdout || stderr != test.wantStderr || status != test.wantStatus {
+			t.Errorf("%q gave %q, %q and status %d, want %q, %q and %d",
+				test.cmd, stdout, stderr, status, test.wantStdout, test.wantStderr, test.wantStatus)
+		}
+	}
+}
+
+func TestLsColumns(t *testing.T) {
+	state := newTestState()
+	state.setTerminal("xterm", 80, 24)
+	stdout, _, _ := runTestShell(state, "ls /a; ls -1 /a")
+	want := "\x1b[1;34mb\x1b[0m  somefile\n\x1b[1;34mb\x1b[0m\nsomefile\n"
+	if stdout != want {
+		t.Errorf("with a terminal ls printed %q, want %q", stdout, want)
+	}
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"bufio"
-	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"path"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	gossh "golang.org/x/crypto/ssh"
@@ -26,6 +29,60 @@ func envOrFatal(envName string) string {
 	return val
 }
 
+func envOrDefault(envName string, def string) string {
+	val, wasSet := os.LookupEnv(envName)
+	if !wasSet || val == "" {
+		return def
+	}
+	return val
+}
+
+func envIntOrDefault(envName string, def int) int {
+	val, wasSet := os.LookupEnv(envName)
+	if !wasSet || val == "" {
+		return def
+	}
+	num, err := strconv.Atoi(val)
+	if err != nil {
+		logrus.Fatalf("Environment variable $%s is not an integer: %s", envName, val)
+	}
+	return num
+}
+
+func envDurationOrDefault(envName string, def time.Duration) time.Duration {
+	val, wasSet := os.LookupEnv(envName)
+	if !wasSet || val == "" {
+		return def
+	}
+	dur, err := time.ParseDuration(val)
+	if err != nil {
+		logrus.Fatalf("Environment variable $%s is not a duration: %s", envName, val)
+	}
+	return dur
+}
+
+// Like envIntOrDefault, for settings that must be above zero. Anything else
+// is logged and replaced by def.
+func envPositiveIntOrDefault(envName string, def int) int {
+	num := envIntOrDefault(envName, def)
+	if num <= 0 {
+		logrus.Warnf("Environment variable $%s must be above zero, using %d", envName, def)
+		return def
+	}
+	return num
+}
+
+// Like envDurationOrDefault, for settings that must be above zero. Anything
+// else is logged and replaced by def.
+func envPositiveDurationOrDefault(envName string, def time.Duration) time.Duration {
+	dur := envDurationOrDefault(envName, def)
+	if dur <= 0 {
+		logrus.Warnf("Environment variable $%s must be above zero, using %s", envName, def)
+		return def
+	}
+	return dur
+}
+
 var DEBUG = false
 
 func init() {
@@ -33,8 +90,6 @@ func init() {
 		FullTimestamp: true,
 		PadLevelText:  true,
 	})
-	_ = envOrFatal("ELASTICSEARCH_URL")
-	PORT_NUM = envOrFatal("PORT")
 
 	_, debugSet := os.LookupEnv("DEBUG")
 	if debugSet {
@@ -51,6 +106,7 @@ type SSHDoc struct {
 	Passwords  []string    `json:"passwords"`
 	Keys       []SSHKey    `json:"keys"`
 	Fields     SubDocument `json:"fields"`
+	Payloads   []string    `json:"payloads,omitempty"`
 	SessionID  string      `json:"sessionId"`
 	Username   string      `json:"username"`
 	Timestamp  time.Time   `json:"@timestamp"`
@@ -61,15 +117,36 @@ type SubDocument interface {
 }
 
 type DocCommandRun struct {
-	Command string `json:"command"`
+	Command  string   `json:"command"`
+	Payloads []string `json:"payloads,omitempty"`
 }
 
 func (_ DocCommandRun) action() string {
 	return "command_run"
 }
 
+func (d DocCommandRun) payloadRefs() []string {
+	return d.Payloads
+}
+
+type DocExec struct {
+	Command  string   `json:"command"`
+	ExitCode int      `json:"exitCode"`
+	Payloads []string `json:"payloads,omitempty"`
+}
+
+func (_ DocExec) action() string {
+	return "command_exec"
+}
+
+func (d DocExec) payloadRefs() []string {
+	return d.Payloads
+}
+
 type DocLogin struct {
 	Username string `json:"username"`
+	// Whether the attacker's environment from an earlier session was kept.
+	Returning bool `json:"returning,omitempty"`
 }
 
 func (_ DocLogin) action() string {
@@ -78,6 +155,11 @@ func (_ DocLogin) action() string {
 
 type DocLogout struct {
 	Username string `json:"username"`
+	// Path of the session's asciicast recording, if there is one.
+	Recording string `json:"recording,omitempty"`
+	// Set when the recording stopped before the session did, having hit
+	// RECORDING_MAX_SIZE or RECORDING_MAX_TOTAL.
+	RecordingTruncated bool `json:"recordingTruncated,omitempty"`
 }
 
 func (_ DocLogout) action() string {
@@ -109,243 +191,399 @@ func hostnameOrDefault() string {
 }
 
 func makePrompt(s ssh.Session, state *SessionState) string {
-	hostname := hostnameOrDefault()
-	userAtHost := color.HiGreenString(s.User() + "@" + hostname)
-	path := color.HiBlueString(state.Cwd.Path())
-	promptStr := color.WhiteString("$ ")
+	hostname := state.hostname()
+	style := state.outputStyle()
+	if ps1, set := state.getVar("PS1"); set {
+		home, _ := state.getVar("HOME")
+		return expandPrompt(ps1, s.User(), hostname, state.pwd(), home, style.Colour)
+	}
+	userAtHost := style.paint(s.User()+"@"+hostname, color.FgHiGreen)
+	path := style.paint(state.pwd(), color.FgHiBlue)
+	promptStr := style.paint("$ ", color.FgWhite)
 	return userAtHost + ":" + path + promptStr
 }
 
-func runCmd(ctx ssh.Context, state *SessionState, cmd string) string {
-	splat := strings.Split(cmd, " ")
-	if len(splat) < 1 {
-		return ""
+// Expands the backslash escapes bash knows in PS1. Without colour, the
+// non-printing parts between \[ and \] are left out.
+func expandPrompt(ps1 string, user string, hostname string, pwd string, home string, colour bool) string {
+	var out strings.Builder
+	nonPrinting := false
+	dir := pwd
+	if home != "" && (pwd == home || strings.HasPrefix(pwd, home+"/")) {
+		dir = "~" + pwd[len(home):]
 	}
-	cmdName := splat[0]
-	args := cmd[len(cmdName):]
-	if len(args) > 0 && args[0] == ' ' {
-		args = args[1:]
-	}
-	if strings.HasPrefix(cmdName, "#") {
-		return ""
-	}
-	switch cmdName {
-	case "":
-		return ""
-	case "clear":
-		return "\033c"
-	case "ls":
-		err, res := ls(state.Root, state.Cwd, args)
-		if err != nil {
-			return fmt.Sprintf("Error: %s\n", err)
+	for i := 0; i < len(ps1); i++ {
+		c := ps1[i]
+		if c != '\\' || i+1 == len(ps1) {
+			if colour || !nonPrinting {
+				out.WriteByte(c)
+			}
+			continue
 		}
-		return res
-	case "cd":
-		err, res := cd(state.Root, state.Cwd, args)
-		if err != nil {
-			return fmt.Sprintf("Error: %s\n", err)
+		i++
+		text := ""
+		switch ps1[i] {
+		case 'u':
+			text = user
+		case 'h':
+			text = strings.SplitN(hostname, ".", 2)[0]
+		case 'H':
+			text = hostname
+		case 'w':
+			text = dir
+		case 'W':
+			text = path.Base(dir)
+		case '$':
+			text = "$"
+			if user == "root" {
+				text = "#"
+			}
+		case 'n':
+			text = "\n"
+		case 'r':
+			text = "\r"
+		case 'a':
+			text = "\a"
+		case 'e':
+			text = "\033"
+		case 's':
+			text = "bash"
+		case 'v':
+			text = "5.1"
+		case 'd':
+			text = time.Now().Format("Mon Jan 02")
+		case 't':
+			text = time.Now().Format("15:04:05")
+		case 'T':
+			text = time.Now().Format("03:04:05")
+		case 'A':
+			text = time.Now().Format("15:04")
+		case '@':
+			text = time.Now().Format("03:04 PM")
+		case '\\':
+			text = "\\"
+		case '[':
+			nonPrinting = true
+		case ']':
+			nonPrinting = false
+		case '0', '1', '2', '3':
+			end := i
+			for end < len(ps1) && end < i+3 && ps1[end] >= '0' && ps1[end] <= '7' {
+				end++
+			}
+			n, _ := strconv.ParseUint(ps1[i:end], 8, 8)
+			text = string([]byte{byte(n)})
+			i = end - 1
+		default:
+			text = "\\" + string(ps1[i])
 		}
-		state.Cwd = res
-		return ""
-	case "cat":
-		err, res := cat(state.Root, state.Cwd, args)
-		if err != nil {
-			return fmt.Sprintf("%s\n", err)
+		if colour || !nonPrinting {
+			out.WriteString(text)
 		}
-		return res
-	case "pwd":
-		path := state.Cwd.Path()
-		return fmt.Sprintf("%s\n", path)
-	case "whoami":
-		return fmt.Sprintf("%s\n", ctx.User())
-	default:
-		return fmt.Sprintf("command not found: %s\n", cmd)
 	}
+	return out.String()
 }
 
-var commandList = []string{
-	"cat",
-	"cd",
-	"clear",
-	"exit",
-	"ls",
-	"pwd",
-	"whoami",
+// Output of a command, split the way it would be on a real system.
+type CmdResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
 }
 
 // Returns string to print and whether to repopulate (if there were conflicts)
-func tabCompleteFile(state *SessionState, partialFile string) (string, bool) {
-	startDir := state.Cwd
+func tabCompleteFile(state *SessionState, partialFile string, dirsOnly bool) (string, bool) {
+	// The tab-completed names in the directory, with whether each is one.
+	type candidate struct {
+		name  string
+		isDir bool
+	}
+	candidates := []candidate{}
 	searchFile := partialFile
-	if strings.HasPrefix(partialFile, "/") {
-		startDir = FILESYSTEM.Root
-		searchFile = strings.TrimPrefix(partialFile, "/")
-	}
-	lastSlash := strings.LastIndex(partialFile, "/")
-	if lastSlash > 0 {
-		dirPath := partialFile[0 : lastSlash+1]
-		err, res := startDir.GetFileOrDir(state.Root, dirPath)
-		if err != nil {
-			return "", false
+	state.view(func(root *files.FilesystemDir, cwd *files.FilesystemDir) {
+		startDir := cwd
+		if strings.HasPrefix(partialFile, "/") {
+			startDir = root
+			searchFile = strings.TrimPrefix(partialFile, "/")
 		}
-		startDir = res.(*files.FilesystemDir)
-		searchFile = partialFile[lastSlash+1:]
-	}
-	validFileDir := []files.FileDir{}
-	for _, file := range startDir.Files {
-		validFileDir = append(validFileDir, file)
-	}
-	for _, dir := range startDir.Subdirs {
-		validFileDir = append(validFileDir, dir)
-	}
+		lastSlash := strings.LastIndex(partialFile, "/")
+		if lastSlash > 0 {
+			dirPath := partialFile[0 : lastSlash+1]
+			err, res := startDir.GetFileOrDir(root, dirPath)
+			if err != nil {
+				return
+			}
+			dir, ok := res.(*files.FilesystemDir)
+			if !ok {
+				return
+			}
+			startDir = dir
+			searchFile = partialFile[lastSlash+1:]
+		}
+		for _, file := range startDir.Files {
+			candidates = append(candidates, candidate{file.TabcompleteName(), false})
+		}
+		for _, dir := range startDir.Subdirs {
+			candidates = append(candidates, candidate{dir.TabcompleteName(), true})
+		}
+		for _, link := range startDir.Links {
+			candidates = append(candidates, candidate{link.TabcompleteName(), false})
+		}
+	})
 	one := false
 	multiple := false
 	last := ""
-	allValid := []string{}
-	for _, validFileDir := range validFileDir {
-		if strings.HasPrefix(validFileDir.TabcompleteName(), searchFile) {
+	allValid := []candidate{}
+	for _, validFileDir := range candidates {
+		if dirsOnly && !strings.HasSuffix(validFileDir.name, "/") {
+			continue
+		}
+		if strings.HasPrefix(validFileDir.name, searchFile) {
 			if one == true {
 				multiple = true
 			}
 			one = true
-			last = strings.TrimPrefix(validFileDir.TabcompleteName(), searchFile)
-			allValid = append(allValid, validFileDir.DescribeSelf())
+			last = strings.TrimPrefix(validFileDir.name, searchFile)
+			allValid = append(allValid, validFileDir)
 		}
 	}
 	if one && !multiple {
 		return last, false
 	}
 	if multiple {
-		return strings.Join(allValid, " "), true
+		style := state.outputStyle()
+		names := []string{}
+		for _, valid := range allValid {
+			names = append(names, strings.TrimSuffix(valid.name, " "))
+		}
+		listing := formatColumns(names, style.Width, func(i int, name string) string {
+			if allValid[i].isDir {
+				return style.paint(name, color.FgBlue, color.Bold)
+			}
+			return name
+		})
+		return strings.TrimSuffix(listing, "\n"), true
 	}
 	return "", false
 }
 
-func tabCompleteCmd(state *SessionState, partialCmd string) (string, bool) {
+// Completes partial to one of candidates, the way tabCompleteFile does.
+func tabCompleteWord(state *SessionState, partial string, candidates []string) (string, bool) {
 	one := false
 	multiple := false
 	last := ""
 	allValid := []string{}
-	for _, validCommand := range commandList {
-		if strings.HasPrefix(validCommand, partialCmd) {
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, partial) {
 			if one == true {
 				multiple = true
 			}
 			one = true
-			last = strings.TrimPrefix(validCommand, partialCmd)
-			allValid = append(allValid, validCommand)
+			last = strings.TrimPrefix(candidate, partial)
+			allValid = append(allValid, candidate)
 		}
 	}
 	if one && !multiple {
 		return last + " ", false
 	}
 	if multiple {
-		return strings.Join(allValid, " "), true
+		listing := formatColumns(allValid, state.outputStyle().Width, func(_ int, name string) string {
+			return name
+		})
+		return strings.TrimSuffix(listing, "\n"), true
 	}
 	return "", false
 }
 
+// Completes the command name, or else an argument as the command asks.
 func tabComplete(state *SessionState, cmd string) (string, bool) {
 	parts := strings.Split(cmd, " ")
 	numParts := len(parts)
 	if numParts == 1 {
-		return tabCompleteCmd(state, parts[0])
-	} else {
-		return tabCompleteFile(state, parts[numParts-1])
+		return tabCompleteWord(state, parts[0], commandNames())
+	}
+	partial := parts[numParts-1]
+	completion := completeFiles
+	if command, ok := lookupCommand(parts[0]); ok {
+		completion = command.Completion()
+	}
+	switch completion {
+	case completeDirs:
+		return tabCompleteFile(state, partial, true)
+	case completeCommands:
+		return tabCompleteWord(state, partial, commandNames())
+	case completeVariables:
+		return tabCompleteWord(state, partial, state.varNames(func(v *shellVar) bool { return true }))
+	case completeNothing:
+		return "", false
 	}
+	return tabCompleteFile(state, partial, false)
 }
 
 func sshHandler(s ssh.Session) {
 	ctx := s.Context().(ssh.Context)
 	sessionId := ctx.SessionID()
-	state := sessionMap.getOrCreateById(sessionId)
+	state, returning := sessionMap.getOrCreateWithEnvironment(ctx)
 	logrus.WithFields(logrus.Fields{
-		"user": s.User(),
-		"id":   sessionId,
+		"user":      s.User(),
+		"id":        sessionId,
+		"returning": returning,
 	}).Infoln("SSH session opened")
-	sendToES := func(doc SubDocument) {
-		go sendToESWithCtx(ctx, state, doc)
+	emit := func(doc SubDocument) {
+		emitEvent(ctx, state, doc)
 	}
-	reader := bufio.NewReader(s)
-	io.WriteString(s, makePrompt(s, state))
-	sendToES(DocLogin{
-		Username: s.User(),
+	emit(DocLogin{
+		Username:  s.User(),
+		Returning: returning,
 	})
-	var cmd []byte = []byte{}
-	for {
-		oneByte, err := reader.ReadByte()
-		logrus.Debugf("%#v\n", oneByte)
+	if s.RawCommand() != "" {
+		setupTerminal(s, state, nil)
+		execHandler(s, state)
+		return
+	}
+	var term io.ReadWriter = s
+	var rec *Recording
+	recording := ""
+	if RECORDING_DIR != "" {
+		var err error
+		rec, err = startRecording(s)
 		if err != nil {
-			s.Close()
-			return
+			logrus.WithError(err).Errorln("Error starting session recording")
+		} else {
+			defer rec.Close()
+			term = rec
+			recording = rec.Path
 		}
-		doLogout := func() {
-			logrus.WithFields(logrus.Fields{
-				"user": s.User(),
-				"id":   sessionId,
-			}).Infoln("SSH session closed")
-			sendToES(DocLogout{
-				Username: s.User(),
-			})
+	}
+	// However the session ends, it is logged out exactly once, with the
+	// status of the last command as its exit status.
+	exitCode := 0
+	defer func() {
+		logrus.WithFields(logrus.Fields{
+			"user": s.User(),
+			"id":   sessionId,
+		}).Infoln("SSH session closed")
+		emitFilesystemDiff(ctx, state)
+		logout := DocLogout{
+			Username:  s.User(),
+			Recording: recording,
+		}
+		if recording != "" {
+			logout.RecordingTruncated = rec.Truncated()
+		}
+		emit(logout)
+		if s.Exit(exitCode) != nil {
 			s.Close()
 		}
-		switch oneByte {
+	}()
+	var out io.Writer = term
+	if setupTerminal(s, state, rec) {
+		out = &crlfWriter{w: term}
+	}
+	reader := bufio.NewReader(term)
+	sh := newShell(ctx, state, true)
+	io.WriteString(out, makePrompt(s, state))
+	editor := newLineEditor(out, state)
+	for {
+		char, _, err := reader.ReadRune()
+		logrus.Debugf("%#v\n", char)
+		if err != nil {
+			return
+		}
+		switch char {
 		case '\x04': // Ctrl+D / EOF
-			if len(cmd) != 0 {
+			if editor.String() != "" {
+				editor.Delete()
 				continue
 			}
-			io.WriteString(s, "logout\n")
-			doLogout()
+			io.WriteString(out, "logout\n")
+			exitCode = sh.status
 			return
 		case '\x0c': // Ctrl+L
-			res := runCmd(ctx, state, "clear")
-			io.WriteString(s, res)
-			io.WriteString(s, makePrompt(s, state))
+			runCmd(ctx, state, []string{"clear"}, streams{stdout: out, stderr: out}, nil)
+			editor.Redraw(makePrompt(s, state))
 		case '\x0d': // Return
-			sendToES(DocCommandRun{
-				Command: string(cmd),
-			})
-			io.WriteString(s, "\n")
-			res := runCmd(ctx, state, string(cmd))
-			if string(cmd) == "exit" || strings.HasPrefix(string(cmd), "exit ") {
-				doLogout()
-				return
+			cmd := editor.Submit()
+			// The event is stamped with when and where the command was
+			// entered, but lists what it wrote into files too.
+			run := DocCommandRun{
+				Command:  cmd,
+				Payloads: capturePayloadsInCommand(ctx, cmd),
 			}
-			cmd = []byte{}
-			io.WriteString(s, res)
-			io.WriteString(s, makePrompt(s, state))
-		case '\x7f': // Backspace
-			if len(cmd) < 1 {
-				cmd = []byte{}
-				continue
+			doc := newSSHDoc(ctx, state, run)
+			io.WriteString(out, "\n")
+			sh.Run(cmd, out, out)
+			run.Payloads = append(run.Payloads, sh.payloads...)
+			doc.Fields, doc.Payloads = run, run.Payloads
+			emitDoc(doc)
+			if sh.exited {
+				exitCode = sh.status
+				return
 			}
-			cmd = cmd[0 : len(cmd)-1]
-			io.WriteString(s, "\x08 \x08")
+			io.WriteString(out, makePrompt(s, state))
+		case '\x7f', '\x08': // Backspace
+			editor.Backspace()
 		case '\t':
-			res, repop := tabComplete(state, string(cmd))
+			res, repop := tabComplete(state, editor.BeforeCursor())
 			if repop {
-				io.WriteString(s, "\n"+res+"\n"+makePrompt(s, state)+string(cmd))
+				io.WriteString(out, "\n"+res+"\n")
+				editor.Redraw(makePrompt(s, state))
 			} else {
-				cmd = append(cmd, res...)
-				io.WriteString(s, res)
+				editor.Insert(res)
+			}
+		case '\x01': // Ctrl+A
+			editor.Home()
+		case '\x05': // Ctrl+E
+			editor.End()
+		case '\x02': // Ctrl+B
+			editor.Left()
+		case '\x06': // Ctrl+F
+			editor.Right()
+		case '\x0b': // Ctrl+K
+			editor.KillToEnd()
+		case '\x15': // Ctrl+U
+			editor.KillToStart()
+		case '\x17': // Ctrl+W
+			editor.KillWordBack()
+		case '\x10': // Ctrl+P
+			editor.HistoryPrev()
+		case '\x0e': // Ctrl+N
+			editor.HistoryNext()
+		case '\x1b': // Escape sequence, e.g. arrow keys
+			key, err := readEscape(reader)
+			if err != nil {
+				s.Close()
+				return
+			}
+			switch key {
+			case keyUp:
+				editor.HistoryPrev()
+			case keyDown:
+				editor.HistoryNext()
+			case keyLeft:
+				editor.Left()
+			case keyRight:
+				editor.Right()
+			case keyHome:
+				editor.Home()
+			case keyEnd:
+				editor.End()
+			case keyDelete:
+				editor.Delete()
 			}
-		case '\x1b': // Arrow Key Escape 1
-		case '\x5b': // Arrow Key Escape 2
-		case '\x41': // Arrow Key
-		case '\x42': // Arrow Key
-		case '\x43': // Arrow Key
-		case '\x44': // Arrow Key
 		case '\x03': // Ctrl+C
-			cmd = append(cmd, '^', 'C')
-			sendToES(DocCommandRun{
-				Command: string(cmd),
+			emit(DocCommandRun{
+				Command: editor.String() + "^C",
 			})
-			io.WriteString(s, "^C\n")
-			io.WriteString(s, makePrompt(s, state))
-			cmd = []byte{}
+			editor.End()
+			editor.Reset()
+			io.WriteString(out, "^C\n")
+			io.WriteString(out, makePrompt(s, state))
 		default:
-			cmd = append(cmd, oneByte)
-			io.WriteString(s, string(oneByte))
+			if char < ' ' {
+				continue
+			}
+			editor.Insert(string(char))
 		}
 	}
 }
@@ -353,83 +591,68 @@ func sshHandler(s ssh.Session) {
 func pubKeyHandler(ctx ssh.Context, key ssh.PublicKey) bool {
 	strKey := string(gossh.MarshalAuthorizedKey(key))
 
-	sessionId := ctx.SessionID()
-	curState := sessionMap.getOrCreateById(sessionId)
-	curState.Keys = append(curState.Keys, SSHKey{
-		Key:  strKey,
-		Type: key.Type(),
+	curState := sessionMap.getOrCreate(ctx)
+	curState.addKey(SSHKey{
+		Key:         strKey,
+		Type:        key.Type(),
+		Fingerprint: gossh.FingerprintSHA256(key),
 	})
-	sendToESWithCtx(ctx, curState, DocPubkey{
+	emitEvent(ctx, curState, DocPubkey{
 		Key: strKey,
 	})
 	return false
 }
 
 func passwordHandler(ctx ssh.Context, password string) bool {
-	sessionId := ctx.SessionID()
-	curState := sessionMap.getOrCreateById(sessionId)
-	curState.Passwords = append(curState.Passwords, password)
-	sendToESWithCtx(ctx, curState, DocPassword{
+	curState := sessionMap.getOrCreate(ctx)
+	curState.addPassword(password)
+	emitEvent(ctx, curState, DocPassword{
 		Password: password,
 	})
 	// return password == "ubuntu"
 	return true
 }
 
-type SSHKey struct {
-	Key  string `json:"key"`
-	Type string `json:"type"`
-}
-
-type SessionState struct {
-	Root      *files.FilesystemDir `json:"-"`
-	Cwd       *files.FilesystemDir `json:"cwd"`
-	Passwords []string             `json:"passwords"`
-	Keys      []SSHKey             `json:"keys"`
-}
-
-// Map from session ID to session state
-type SessionMap map[string]*SessionState
-
-var sessionMap SessionMap = SessionMap{}
-
-func (m SessionMap) getOrCreateById(id string) *SessionState {
-	state, exists := m[id]
-	if exists {
-		return state
-	}
-	var newState SessionState = SessionState{
-		Root:      FILESYSTEM.Root,
-		Cwd:       FILESYSTEM.Root,
-		Passwords: []string{},
-		Keys:      []SSHKey{},
-	}
-	m[id] = &newState
-	return &newState
-}
-
 func main() {
-	setupES()
-	hostname := hostnameOrDefault()
-	key, err := genHostKey(hostname)
-	if err != nil {
-		logrus.WithError(err).Fatal("Error generating private key")
-	}
-	hostKeySigner, err := gossh.NewSignerFromKey(key)
-
+	PORT_NUM = envOrFatal("PORT")
+	setupFilesystem()
+	setupSinks()
+	defer closeSinks()
+	setupSessionMap()
+	setupEnvironments()
+	setupQuarantine()
+	setupPersona()
+	hostSigners, err := loadHostSigners(PERSONA.Hostname)
 	if err != nil {
-		logrus.WithError(err).Fatal("Error generating host key signer")
+		logrus.WithError(err).Fatal("Error loading host keys")
 	}
 	srv := &ssh.Server{
 		Addr:             ":" + PORT_NUM,
 		Handler:          sshHandler,
 		PublicKeyHandler: pubKeyHandler,
 		PasswordHandler:  passwordHandler,
-		HostSigners: []ssh.Signer{
-			hostKeySigner,
+		ConnCallback:     sessionMap.connCallback,
+		SubsystemHandlers: map[string]ssh.SubsystemHandler{
+			"sftp": sftpHandler,
 		},
-		Version: "OpenSSH_8.4p1 Ubuntu-6ubuntu2.1",
+		HostSigners: hostSigners,
+		Version:     "OpenSSH_8.4p1 Ubuntu-6ubuntu2.1",
+	}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		logrus.WithField("signal", sig).Infoln("Shutting down")
+		srv.Close()
+	}()
+	ln, err := listen(srv.Addr)
+	if err != nil {
+		logrus.WithError(err).Fatal("Error listening")
 	}
 	logrus.Infoln("Waiting for SSH connections...")
-	logrus.Fatalln(srv.ListenAndServe())
+	err = srv.Serve(ln)
+	if err != nil && err != ssh.ErrServerClosed {
+		closeSinks()
+		logrus.Fatalln(err)
+	}
 }
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/honeystats/ssh/asciicast"
+	"github.com/honeystats/ssh/files"
+	"github.com/honeystats/ssh/quarantine"
+	"github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	configBytes, err := ioutil.ReadFile("files.yaml")
+	if err != nil {
+		panic(err)
+	}
+	FILESYSTEM = files.StrToFilesystem(configBytes)
+	loadAccountNames(FILESYSTEM.Root)
+	setupPersona()
+	SINK = testSink
+	recordings, err := ioutil.TempDir("", "recordings")
+	if err != nil {
+		panic(err)
+	}
+	RECORDING_DIR = recordings
+	samples, err := ioutil.TempDir("", "samples")
+	if err != nil {
+		panic(err)
+	}
+	QUARANTINE, err = quarantine.NewStore(samples)
+	if err != nil {
+		panic(err)
+	}
+	status := m.Run()
+	os.RemoveAll(samples)
+	os.RemoveAll(recordings)
+	os.Exit(status)
+}
+
+// Where events go in every test. It is never swapped out, as a server's
+// goroutines may still be emitting after it has been closed.
+var testSink = &memorySink{}
+
+// Starts the honeypot on a local port, sending its events to the sink it
+// returns.
+func startTestServer(t *testing.T) (string, *memorySink) {
+	t.Helper()
+	key, err := deriveHostKey("ed25519", 0, kdfReader("test", "test", "ed25519"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &ssh.Server{
+		Handler:          sshHandler,
+		PublicKeyHandler: pubKeyHandler,
+		PasswordHandler:  passwordHandler,
+		ConnCallback:     sessionMap.connCallback,
+		SubsystemHandlers: map[string]ssh.SubsystemHandler{
+			"sftp": sftpHandler,
+		},
+		HostSigners: []ssh.Signer{signer},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String(), testSink
+}
+
+func dialTestServer(t *testing.T, addr string) *gossh.Client {
+	t.Helper()
+	client, err := gossh.Dial("tcp", addr, &gossh.ClientConfig{
+		User:            "root",
+		Auth:            []gossh.AuthMethod{gossh.Password("hunter2")},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// Runs command as an exec request, returning its output and exit status.
+func execTestCommand(t *testing.T, client *gossh.Client, command string) (string, string, int) {
+	t.Helper()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	status := 0
+	if err := session.Run(command); err != nil {
+		exitErr, ok := err.(*gossh.ExitError)
+		if !ok {
+			t.Fatalf("running %q: %v", command, err)
+		}
+		status = exitErr.ExitStatus()
+	}
+	return stdout.String(), stderr.String(), status
+}
+
+// The actions of the events a sink has been sent, in order.
+func (m *memorySink) actions() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	actions := []string{}
+	for _, doc := range m.docs {
+		actions = append(actions, doc.Action)
+	}
+	return actions
+}
+
+// testContext stands in for the ssh.Context of one connection.
+type testContext struct {
+	context.Context
+	sync.Mutex
+	cancel context.CancelFunc
+	user   string
+	id     string
+	remote net.Addr
+}
+
+func newTestContext(user string, id string) *testContext {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &testContext{
+		Context: ctx,
+		cancel:  cancel,
+		user:    user,
+		id:      id,
+		remote:  &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 40000},
+	}
+}
+
+func (c *testContext) User() string          { return c.user }
+func (c *testContext) SessionID() string     { return c.id }
+func (c *testContext) ClientVersion() string { return "SSH-2.0-OpenSSH_8.9" }
+func (c *testContext) ServerVersion() string { return "SSH-2.0-OpenSSH_8.4p1 Ubuntu-6ubuntu2.1" }
+func (c *testContext) RemoteAddr() net.Addr  { return c.remote }
+func (c *testContext) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+}
+func (c *testContext) Permissions() *ssh.Permissions { return &ssh.Permissions{} }
+
+func (c *testContext) SetValue(key, value interface{}) {
+	c.Context = context.WithValue(c.Context, key, value)
+}
+
+func TestInteractiveLogout(t *testing.T) {
+	addr, sink := startTestServer(t)
+	client := dialTestServer(t, addr)
+	tests := []struct {
+		name       string
+		input      string
+		wantStatus int
+	}{
+		{"exit", "echo hi\rexit\r", 0},
+		{"exit with a status", "echo hi\rexit 5\r", 5},
+		{"Ctrl+D", "echo hi\r\x04", 0},
+		{"Ctrl+D after a failure", "echo hi; (exit 3)\r\x04", 3},
+		{"connection closed", "echo hi\r", -1},
+		{"exit in a subshell", "(exit); echo hi\r\x04", 0},
+	}
+	for _, test := range tests {
+		sink.mu.Lock()
+		before := len(sink.docs)
+		sink.mu.Unlock()
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := session.RequestPty("xterm", 24, 80, gossh.TerminalModes{}); err != nil {
+			t.Fatal(err)
+		}
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := session.Shell(); err != nil {
+			t.Fatal(err)
+		}
+		stdin.Write([]byte(test.input))
+		stdin.Close()
+		err = session.Wait()
+		session.Close()
+		status := 0
+		if exitErr, ok := err.(*gossh.ExitError); ok {
+			status = exitErr.ExitStatus()
+		} else if err != nil {
+			status = -1
+		}
+		if test.wantStatus >= 0 && status != test.wantStatus {
+			t.Errorf("%s: exit status %d (%v), want %d", test.name, status, err, test.wantStatus)
+		}
+		var logouts []DocLogout
+		waitFor(t, "the logout of "+test.name, func() bool {
+			sink.mu.Lock()
+			defer sink.mu.Unlock()
+			logouts = nil
+			for _, doc := range sink.docs[before:] {
+				if logout, ok := doc.Fields.(DocLogout); ok {
+					logouts = append(logouts, logout)
+				}
+			}
+			return len(logouts) > 0
+		})
+		// Give a second logout time to turn up.
+		time.Sleep(20 * time.Millisecond)
+		sink.mu.Lock()
+		count := 0
+		for _, doc := range sink.docs[before:] {
+			if doc.Action == "logout" {
+				count++
+			}
+		}
+		sink.mu.Unlock()
+		if count != 1 {
+			t.Errorf("%s: %d logouts, want 1", test.name, count)
+		}
+		f, err := os.Open(logouts[0].Recording)
+		if err != nil {
+			t.Errorf("%s: no recording: %v", test.name, err)
+			continue
+		}
+		rec, err := asciicast.Read(f)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: reading the recording: %v", test.name, err)
+			continue
+		}
+		output := ""
+		for _, event := range rec.Events {
+			if event.Type == asciicast.Output {
+				output += event.Data
+			}
+		}
+		if !strings.Contains(output, "hi\r\n") {
+			t.Errorf("%s: recording holds %q, want the command's output", test.name, output)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/honeystats/ssh/files"
+)
+
+// A session starts out looking at the shared FILESYSTEM.Root and gets its
+// own copy the first time it changes anything. Both Root and Cwd can be
+// swapped for that copy at any time by another channel of the same
+// connection, and the copy is changed in place, so the tree is only ever
+// looked at or changed with state.mu held, through view, chdir and mutate.
+
+// Runs fn against the session's view of the filesystem. state.mu is held
+// throughout, so fn must copy out what it needs and not call anything else
+// that takes it.
+func (state *SessionState) view(fn func(root *files.FilesystemDir, cwd *files.FilesystemDir)) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	fn(state.Root, state.Cwd)
+}
+
+// Moves the session to the directory fn picks, which is looked up and moved
+// to in one go so that the tree can't be copied in between. fn returns the
+// directory and the path to show for it.
+func (state *SessionState) chdir(fn func(root *files.FilesystemDir, cwd *files.FilesystemDir) (error, *files.FilesystemDir, string)) error {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	err, dir, pwd := fn(state.Root, state.Cwd)
+	if err != nil {
+		return err
+	}
+	state.Cwd = dir
+	state.Pwd = pwd
+	state.lastSeen = time.Now()
+	return nil
+}
+
+// Gives the session its own copy of the filesystem the first time it writes
+// to it, so changes never reach the shared tree or other sessions. Must be
+// called with state.mu held.
+func (state *SessionState) writableRootLocked() *files.FilesystemDir {
+	if state.ownRoot {
+		return state.Root
+	}
+	cwdPath := state.Cwd.Path()
+	state.Root = state.Root.Clone()
+	state.ownRoot = true
+	if err, cwd := state.Root.GetFileOrDir(state.Root, cwdPath); err == nil {
+		if dir, ok := cwd.(*files.FilesystemDir); ok {
+			state.Cwd = dir
+			return state.Root
+		}
+	}
+	state.Cwd = state.Root
+	return state.Root
+}
+
+// Resolves path in the session's own copy of the filesystem. Relative paths
+// start from the working directory.
+func (state *SessionState) writableDirLocked(path string) (*files.FilesystemDir, error) {
+	root := state.writableRootLocked()
+	err, res := state.Cwd.GetFileOrDir(root, path)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := res.(*files.FilesystemDir)
+	if !ok {
+		return nil, fmt.Errorf("%s: Not a directory", path)
+	}
+	return dir, nil
+}
+
+// Writes a file into the session's view of the filesystem.
+func (state *SessionState) writeFile(filePath string, content string) error {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	dirPath, name := path.Split(filePath)
+	dir, err := state.writableDirLocked(dirPath)
+	if err != nil {
+		return err
+	}
+	err, _ = dir.WriteFile(name, content)
+	return err
+}
+
+// Creates a directory in the session's view of the filesystem.
+func (state *SessionState) mkdir(dirPath string) error {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	parentPath, name := path.Split(strings.TrimSuffix(dirPath, "/"))
+	parent, err := state.writableDirLocked(parentPath)
+	if err != nil {
+		return err
+	}
+	err, _ = parent.Mkdir(name)
+	return err
+}
+
+// Runs fn against the session's own copy of the filesystem, for commands
+// that change it.
+func (state *SessionState) mutate(fn func(root *files.FilesystemDir, cwd *files.FilesystemDir) error) error {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	root := state.writableRootLocked()
+	state.lastSeen = time.Now()
+	return fn(root, state.Cwd)
+}
+
+// What the session has changed in the filesystem it started with.
+func (state *SessionState) filesystemDiff() []files.Change {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if !state.ownRoot {
+		return nil
+	}
+	return files.Diff(state.base, state.Root)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestState() *SessionState {
+	return newTestSessionMap(0).getOrCreate(newTestContext("root", "session"))
+}
+
+// Runs line in a non-interactive shell on state.
+func runTestShell(state *SessionState, line string) (string, string, int) {
+	var stdout, stderr strings.Builder
+	sh := newShell(newTestContext("root", "session"), state, false)
+	status := sh.Run(line, &stdout, &stderr)
+	return stdout.String(), stderr.String(), status
+}
+
+func TestOverlayIsolation(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  string
+		check   string
+		wantOwn string
+	}{
+		{"new file", "echo hi > /a/overlay", "cat /a/overlay", "hi\n"},
+		{"new directory", "mkdir /a/overlaydir", "ls -d /a/overlaydir", "/a/overlaydir\n"},
+		{"removed file", "rm /etc/hostname", "ls /etc/hostname", ""},
+	}
+	for _, test := range tests {
+		other := newTestState()
+		_, _, otherBefore := runTestShell(other, test.check)
+		state := newTestState()
+		if _, stderr, status := runTestShell(state, test.change); status != 0 {
+			t.Fatalf("%s: %q failed: %s", test.name, test.change, stderr)
+		}
+		if stdout, _, _ := runTestShell(state, test.check); stdout != test.wantOwn {
+			t.Errorf("%s: the session sees %q, want %q", test.name, stdout, test.wantOwn)
+		}
+		if _, _, status := runTestShell(other, test.check); status != otherBefore {
+			t.Errorf("%s: another session's %q went from status %d to %d", test.name, test.check, otherBefore, status)
+		}
+		if _, _, status := runTestShell(newTestState(), test.check); status != otherBefore {
+			t.Errorf("%s: the shared filesystem changed", test.name)
+		}
+	}
+}
+
+func TestOverlayKeepsWorkingDirectory(t *testing.T) {
+	state := newTestState()
+	runTestShell(state, "cd /etc")
+	// The first change copies the tree; the session must stay in /etc.
+	runTestShell(state, "touch overlay")
+	stdout, _, _ := runTestShell(state, "pwd -P; ls overlay")
+	if stdout != "/etc\noverlay\n" {
+		t.Errorf("after the first change got %q, want to still be in /etc", stdout)
+	}
+}
+
+// Channels of one connection share a session. Run with -race.
+func TestOverlayConcurrentChannels(t *testing.T) {
+	state := newTestState()
+	var wg sync.WaitGroup
+	lines := []string{
+		"touch /a/a /a/b /a/c; mkdir /a/d",
+		"cd /etc; cat hostname; ls; pwd -P",
+		"cat < /etc/passwd; ls /a",
+		"cd /a; ls -la b; cd /",
+		"id; df /",
+	}
+	for _, line := range lines {
+		wg.Add(1)
+		go func(line string) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				runTestShell(state, line)
+			}
+		}(line)
+	}
+	for i := 0; i < 20; i++ {
+		tabCompleteFile(state, "/et", false)
+		isDir(state, "/a")
+	}
+	wg.Wait()
+	if !isDir(state, "/a/d") {
+		t.Error("a change made alongside other channels was lost")
+	}
+}
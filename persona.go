@@ -0,0 +1,622 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeystats/ssh/files"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Persona describes the machine the honeypot pretends to be. The recon
+// commands and the generated /proc and /etc files all answer from it, so
+// they agree with each other.
+type Persona struct {
+	Hostname string `yaml:"hostname"`
+	// What hostname -I prints.
+	Address string        `yaml:"address"`
+	Kernel  PersonaKernel `yaml:"kernel"`
+	Distro  PersonaDistro `yaml:"distro"`
+	CPU     PersonaCPU    `yaml:"cpu"`
+	Memory  PersonaMemory `yaml:"memory"`
+	// Real filesystems, the root one first. tmpfs mounts are added to them
+	// based on Memory.
+	Disks []PersonaDisk `yaml:"disks"`
+	// How long the machine had been up when the honeypot started. Ignored
+	// if BootTime is set.
+	Uptime   time.Duration `yaml:"uptime"`
+	BootTime time.Time     `yaml:"boot_time"`
+	Load     [3]float64    `yaml:"load"`
+	// Other people logged in, as w shows them.
+	Users []PersonaUser `yaml:"users"`
+	// What runs on the machine, as /proc shows it.
+	Processes []PersonaProcess `yaml:"processes"`
+	// The shell sessions log in to, which $$ and /proc/self point at. A PID
+	// of 0 gives each shell a random one with no entry in /proc.
+	Shell PersonaProcess `yaml:"shell"`
+
+	// When the honeypot started, which Uptime and the users' times count
+	// back from.
+	started time.Time
+}
+
+type PersonaKernel struct {
+	Release string `yaml:"release"`
+	Version string `yaml:"version"`
+	Machine string `yaml:"machine"`
+	// Who built the kernel and with what, as /proc/version shows it.
+	Builder string `yaml:"builder"`
+}
+
+type PersonaDistro struct {
+	ID       string `yaml:"id"`
+	Name     string `yaml:"name"`
+	Version  string `yaml:"version"`
+	Codename string `yaml:"codename"`
+	Nickname string `yaml:"nickname"`
+}
+
+type PersonaCPU struct {
+	Model          string  `yaml:"model"`
+	Vendor         string  `yaml:"vendor"`
+	Family         int     `yaml:"family"`
+	ModelNumber    int     `yaml:"model_number"`
+	Stepping       int     `yaml:"stepping"`
+	Microcode      string  `yaml:"microcode"`
+	MHz            float64 `yaml:"mhz"`
+	CacheKB        int     `yaml:"cache_kb"`
+	Count          int     `yaml:"count"`
+	ThreadsPerCore int     `yaml:"threads_per_core"`
+	Hypervisor     string  `yaml:"hypervisor"`
+	Flags          string  `yaml:"flags"`
+	Bugs           string  `yaml:"bugs"`
+}
+
+// Sizes in MiB. Free memory is what's left of the total.
+type PersonaMemory struct {
+	TotalMB     int `yaml:"total_mb"`
+	UsedMB      int `yaml:"used_mb"`
+	BuffCacheMB int `yaml:"buff_cache_mb"`
+	SharedMB    int `yaml:"shared_mb"`
+	SwapMB      int `yaml:"swap_mb"`
+	SwapUsedMB  int `yaml:"swap_used_mb"`
+}
+
+type PersonaDisk struct {
+	Device string `yaml:"device"`
+	Mount  string `yaml:"mount"`
+	Type   string `yaml:"type"`
+	SizeMB int    `yaml:"size_mb"`
+	UsedMB int    `yaml:"used_mb"`
+}
+
+type PersonaUser struct {
+	Name string `yaml:"name"`
+	TTY  string `yaml:"tty"`
+	From string `yaml:"from"`
+	// How long before the honeypot started they logged in, and had last
+	// typed something.
+	Login time.Duration `yaml:"login"`
+	Idle  time.Duration `yaml:"idle"`
+	What  string        `yaml:"what"`
+}
+
+// A process, as /proc/<pid> describes it. Kernel threads have no Args.
+type PersonaProcess struct {
+	PID  int      `yaml:"pid"`
+	PPID int      `yaml:"ppid"`
+	Name string   `yaml:"name"`
+	UID  int      `yaml:"uid"`
+	Args []string `yaml:"args"`
+}
+
+var PERSONA = defaultPersona()
+
+func defaultPersona() Persona {
+	return Persona{
+		Hostname: hostnameOrDefault(),
+		Address:  "10.0.2.15",
+		Kernel: PersonaKernel{
+			Release: "5.15.0-91-generic",
+			Version: "#101-Ubuntu SMP Tue Nov 14 13:30:08 UTC 2023",
+			Machine: "x86_64",
+			Builder: "(buildd@lcy02-amd64-045) (gcc (Ubuntu 11.4.0-1ubuntu1~22.04) 11.4.0, GNU ld (GNU Binutils for Ubuntu) 2.38)",
+		},
+		Distro: PersonaDistro{
+			ID:       "ubuntu",
+			Name:     "Ubuntu",
+			Version:  "22.04.3 LTS",
+			Codename: "jammy",
+			Nickname: "Jammy Jellyfish",
+		},
+		CPU: PersonaCPU{
+			Model:          "Intel(R) Xeon(R) CPU E5-2686 v4 @ 2.30GHz",
+			Vendor:         "GenuineIntel",
+			Family:         6,
+			ModelNumber:    79,
+			Stepping:       1,
+			Microcode:      "0xb000040",
+			MHz:            2299.998,
+			CacheKB:        46080,
+			Count:          4,
+			ThreadsPerCore: 2,
+			Hypervisor:     "KVM",
+			Flags:          "fpu vme de pse tsc msr pae mce cx8 apic sep mtrr pge mca cmov pat pse36 clflush mmx fxsr sse sse2 ht syscall nx pdpe1gb rdtscp lm constant_tsc rep_good nopl xtopology cpuid tsc_known_freq pni pclmulqdq ssse3 fma cx16 pcid sse4_1 sse4_2 x2apic movbe popcnt tsc_deadline_timer aes xsave avx f16c rdrand hypervisor lahf_lm abm 3dnowprefetch cpuid_fault invpcid_single pti ssbd ibrs ibpb stibp fsgsbase tsc_adjust bmi1 hle avx2 smep bmi2 erms invpcid rtm rdseed adx smap xsaveopt arat md_clear flush_l1d arch_capabilities",
+			Bugs:           "cpu_meltdown spectre_v1 spectre_v2 spec_store_bypass l1tf mds swapgs taa itlb_multihit mmio_stale_data retbleed",
+		},
+		Memory: PersonaMemory{
+			TotalMB:     7951,
+			UsedMB:      1184,
+			BuffCacheMB: 2213,
+			SharedMB:    3,
+		},
+		Disks: []PersonaDisk{
+			{Device: "/dev/vda1", Mount: "/", Type: "ext4", SizeMB: 78745, UsedMB: 9317},
+			{Device: "/dev/vda15", Mount: "/boot/efi", Type: "vfat", SizeMB: 105, UsedMB: 6},
+		},
+		Uptime: 41*24*time.Hour + 3*time.Hour + 27*time.Minute,
+		Load:   [3]float64{0.08, 0.03, 0.01},
+		Users: []PersonaUser{
+			{Name: "ubuntu", TTY: "pts/0", From: "10.0.2.2", Login: 50 * time.Hour, Idle: 49 * time.Hour, What: "-bash"},
+		},
+		Processes: []PersonaProcess{
+			{PID: 1, Name: "systemd", Args: []string{"/sbin/init"}},
+			{PID: 2, Name: "kthreadd"},
+			{PID: 3, PPID: 2, Name: "rcu_gp"},
+			{PID: 13, PPID: 2, Name: "ksoftirqd/0"},
+			{PID: 398, PPID: 1, Name: "systemd-journal", Args: []string{"/lib/systemd/systemd-journald"}},
+			{PID: 441, PPID: 1, Name: "systemd-udevd", Args: []string{"/lib/systemd/systemd-udevd"}},
+			{PID: 598, PPID: 1, Name: "systemd-network", UID: 100, Args: []string{"/lib/systemd/systemd-networkd"}},
+			{PID: 601, PPID: 1, Name: "systemd-resolve", UID: 101, Args: []string{"/lib/systemd/systemd-resolved"}},
+			{PID: 687, PPID: 1, Name: "cron", Args: []string{"/usr/sbin/cron", "-f", "-P"}},
+			{PID: 688, PPID: 1, Name: "dbus-daemon", UID: 102, Args: []string{"@dbus-daemon", "--system", "--address=systemd:", "--nofork", "--nopidfile", "--systemd-activation", "--syslog-only"}},
+			{PID: 702, PPID: 1, Name: "rsyslogd", UID: 104, Args: []string{"/usr/sbin/rsyslogd", "-n", "-iNONE"}},
+			{PID: 705, PPID: 1, Name: "systemd-logind", Args: []string{"/lib/systemd/systemd-logind"}},
+			{PID: 741, PPID: 1, Name: "agetty", Args: []string{"/sbin/agetty", "-o", "-p -- \\u", "--noclear", "tty1", "linux"}},
+			{PID: 803, PPID: 1, Name: "sshd", Args: []string{"sshd: /usr/sbin/sshd -D [listener] 0 of 10-100 startups"}},
+			{PID: 21874, PPID: 803, Name: "sshd", Args: []string{"sshd: ubuntu [priv]"}},
+			{PID: 21950, PPID: 21874, Name: "sshd", UID: 1000, Args: []string{"sshd: ubuntu@pts/0"}},
+			{PID: 21951, PPID: 21950, Name: "bash", UID: 1000, Args: []string{"-bash"}},
+			{PID: 22416, PPID: 803, Name: "sshd", Args: []string{"sshd: root@pts/1"}},
+		},
+		Shell: PersonaProcess{PID: 22417, PPID: 22416, Name: "bash", Args: []string{"-bash"}},
+	}
+}
+
+// Reads PERSONA_CONFIG over the default persona, if it is set, and writes
+// the persona's /proc and /etc files into the fake filesystem.
+func setupPersona() {
+	if personaConfig := envOrDefault("PERSONA_CONFIG", ""); personaConfig != "" {
+		data, err := ioutil.ReadFile(personaConfig)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"personaConfig": personaConfig,
+				"err":           err,
+			}).Fatal("Error reading PERSONA_CONFIG")
+		}
+		if err := yaml.Unmarshal(data, &PERSONA); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"personaConfig": personaConfig,
+				"err":           err,
+			}).Fatal("Error parsing PERSONA_CONFIG")
+		}
+	}
+	PERSONA.started = time.Now()
+	if PERSONA.BootTime.IsZero() {
+		PERSONA.BootTime = PERSONA.started.Add(-PERSONA.Uptime).Truncate(time.Second)
+	}
+	if PERSONA.CPU.Count < 1 {
+		PERSONA.CPU.Count = 1
+	}
+	if PERSONA.CPU.ThreadsPerCore < 1 || PERSONA.CPU.Count%PERSONA.CPU.ThreadsPerCore != 0 {
+		PERSONA.CPU.ThreadsPerCore = 1
+	}
+	PERSONA.install(FILESYSTEM.Root)
+}
+
+// Writes the files that describe the machine into root. Files in /proc are
+// generated as they're read, so uptime keeps counting.
+func (p *Persona) install(root *files.FilesystemDir) {
+	static := map[string]string{
+		"/etc/hostname":    p.Hostname + "\n",
+		"/etc/os-release":  p.osRelease(),
+		"/etc/lsb-release": p.lsbRelease(),
+		"/etc/issue":       p.Distro.Name + " " + p.Distro.Version + ` \n \l` + "\n\n",
+		"/etc/issue.net":   p.Distro.Name + " " + p.Distro.Version + "\n",
+	}
+	for filePath, content := range static {
+		installFile(root, filePath, 0644, content, nil)
+	}
+	generated := map[string]func() string{
+		"/proc/cpuinfo":              p.cpuinfo,
+		"/proc/meminfo":              p.meminfo,
+		"/proc/uptime":               p.procUptime,
+		"/proc/loadavg":              p.loadavg,
+		"/proc/version":              p.procVersion,
+		"/proc/mounts":               p.procMounts,
+		"/proc/sys/kernel/hostname":  func() string { return p.Hostname + "\n" },
+		"/proc/sys/kernel/osrelease": func() string { return p.Kernel.Release + "\n" },
+	}
+	procs := p.Processes
+	if p.Shell.PID > 0 {
+		procs = append(procs[:len(procs):len(procs)], p.Shell)
+	}
+	for _, proc := range procs {
+		dir := fmt.Sprintf("/proc/%d/", proc.PID)
+		generated[dir+"cmdline"] = proc.cmdline
+		generated[dir+"comm"] = proc.comm
+		generated[dir+"status"] = proc.status
+	}
+	for filePath, generate := range generated {
+		installFile(root, filePath, 0444, "", generate)
+	}
+	if p.Shell.PID > 0 {
+		installLink(root, "/proc/self", strconv.Itoa(p.Shell.PID))
+	}
+	// So that df can be asked about each mount point.
+	for _, m := range p.mounts() {
+		installDir(root, m.Target)
+	}
+}
+
+func installFile(root *files.FilesystemDir, filePath string, perms files.Permissions, content string, generate func() string) {
+	dir := installDir(root, path.Dir(filePath))
+	if dir == nil {
+		return
+	}
+	err, file := dir.WriteFile(path.Base(filePath), content)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"path": filePath,
+			"err":  err,
+		}).Warnln("Can't install persona file")
+		return
+	}
+	file.Generate = generate
+	file.Permissions = &perms
+}
+
+func installLink(root *files.FilesystemDir, linkPath string, target string) {
+	dir := installDir(root, path.Dir(linkPath))
+	if dir == nil {
+		return
+	}
+	dir.Detach(path.Base(linkPath))
+	if err := dir.Attach(&files.FilesystemLink{Target: target}, path.Base(linkPath)); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"path": linkPath,
+			"err":  err,
+		}).Warnln("Can't install persona link")
+	}
+}
+
+// Makes the directory at dirPath and any above it that are missing.
+func installDir(root *files.FilesystemDir, dirPath string) *files.FilesystemDir {
+	dir := root
+	for _, name := range strings.Split(strings.Trim(dirPath, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		err, sub := dir.Mkdir(name)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"path": dirPath,
+				"err":  err,
+			}).Warnln("Can't install persona directory")
+			return nil
+		}
+		dir = sub
+	}
+	return dir
+}
+
+func (p *Persona) uptime(now time.Time) time.Duration {
+	return now.Sub(p.BootTime)
+}
+
+// A mount as df and /proc/mounts show it. Sizes are in KiB.
+type mount struct {
+	Device  string
+	Target  string
+	Type    string
+	Options string
+	Size    int64
+	Used    int64
+	// Whether df leaves it out, as it does sysfs and proc.
+	Pseudo bool
+}
+
+func (m mount) avail() int64 {
+	if m.Type == "ext4" {
+		// ext4 keeps 5% for root.
+		return m.Size - m.Used - m.Size/20
+	}
+	return m.Size - m.Used
+}
+
+// Every mount, in the order a freshly booted Ubuntu lists them.
+func (p *Persona) mounts() []mount {
+	ramKB := int64(p.Memory.TotalMB) * 1024
+	runKB := ramKB / 10
+	tmpfsOptions := func(sizeKB int64, extra string) string {
+		return fmt.Sprintf("rw,nosuid,nodev%s,relatime,size=%dk,inode64", extra, sizeKB)
+	}
+	mounts := []mount{
+		{Device: "sysfs", Target: "/sys", Type: "sysfs", Options: "rw,nosuid,nodev,noexec,relatime", Pseudo: true},
+		{Device: "proc", Target: "/proc", Type: "proc", Options: "rw,nosuid,nodev,noexec,relatime", Pseudo: true},
+		{Device: "udev", Target: "/dev", Type: "devtmpfs", Options: fmt.Sprintf("rw,nosuid,relatime,size=%dk,nr_inodes=%d,mode=755,inode64", ramKB/2, ramKB/8), Size: ramKB / 2},
+		{Device: "devpts", Target: "/dev/pts", Type: "devpts", Options: "rw,nosuid,noexec,relatime,gid=5,mode=620,ptmxmode=000", Pseudo: true},
+		{Device: "tmpfs", Target: "/run", Type: "tmpfs", Options: tmpfsOptions(runKB, ",noexec") + ",mode=755", Size: runKB, Used: 1084},
+	}
+	for i, disk := range p.Disks {
+		options := "rw,relatime"
+		switch disk.Type {
+		case "ext4":
+			options = "rw,relatime,discard,errors=remount-ro"
+		case "vfat":
+			options = "rw,relatime,fmask=0077,dmask=0077,codepage=437,iocharset=iso8859-1,shortname=mixed,errors=remount-ro"
+		}
+		mounts = append(mounts, mount{
+			Device:  disk.Device,
+			Target:  disk.Mount,
+			Type:    disk.Type,
+			Options: options,
+			Size:    int64(disk.SizeMB) * 1024,
+			Used:    int64(disk.UsedMB) * 1024,
+		})
+		if i == 0 {
+			mounts = append(mounts,
+				mount{Device: "tmpfs", Target: "/dev/shm", Type: "tmpfs", Options: tmpfsOptions(ramKB/2, ""), Size: ramKB / 2},
+				mount{Device: "tmpfs", Target: "/run/lock", Type: "tmpfs", Options: tmpfsOptions(5120, ",noexec"), Size: 5120},
+			)
+		}
+	}
+	return mounts
+}
+
+// Memory sizes in KiB, as /proc/meminfo and free show them.
+type memoryStats struct {
+	Total, Free, Used, Shared, Buffers, Cached, Reclaimable, Available int64
+	SwapTotal, SwapFree                                                int64
+}
+
+func (p *Persona) memory() memoryStats {
+	m := p.Memory
+	stats := memoryStats{
+		Total:     int64(m.TotalMB) * 1024,
+		Used:      int64(m.UsedMB) * 1024,
+		Shared:    int64(m.SharedMB) * 1024,
+		SwapTotal: int64(m.SwapMB) * 1024,
+		SwapFree:  int64(m.SwapMB-m.SwapUsedMB) * 1024,
+	}
+	buffCache := int64(m.BuffCacheMB) * 1024
+	stats.Buffers = buffCache / 16
+	stats.Reclaimable = buffCache / 10
+	stats.Cached = buffCache - stats.Buffers - stats.Reclaimable
+	stats.Free = stats.Total - stats.Used - buffCache
+	if stats.Free < 0 {
+		stats.Free = 0
+	}
+	stats.Available = stats.Free + buffCache*9/10
+	if stats.Available > stats.Total {
+		stats.Available = stats.Total
+	}
+	return stats
+}
+
+// The number of users logged in now, counting the session asking if it has
+// a terminal, as only those are recorded in utmp.
+func (p *Persona) userCount(state *SessionState) int {
+	if state.hasPty() {
+		return len(p.Users) + 1
+	}
+	return len(p.Users)
+}
+
+// Where the kernel keeps the host name. hostname changes it there, and
+// only there, as on a real machine: /etc/hostname is only read at boot.
+const hostnamePath = "/proc/sys/kernel/hostname"
+
+// The session's host name, as hostname, uname -n and the prompt show it.
+func (state *SessionState) hostname() string {
+	name := ""
+	state.view(func(root *files.FilesystemDir, cwd *files.FilesystemDir) {
+		if err, res := root.GetFileOrDir(root, hostnamePath); err == nil {
+			if file, ok := res.(*files.FilesystemFile); ok {
+				name = strings.TrimSpace(file.Read())
+			}
+		}
+	})
+	if name == "" {
+		return PERSONA.Hostname
+	}
+	return name
+}
+
+// Changes the host name for this session only.
+func (state *SessionState) setHostname(name string) {
+	state.mutate(func(root *files.FilesystemDir, cwd *files.FilesystemDir) error {
+		installFile(root, hostnamePath, 0444, name+"\n", nil)
+		return nil
+	})
+}
+
+// The release without its point version or suffix, as in 22.04.
+func (d PersonaDistro) versionID() string {
+	versionID := d.Version
+	if fields := strings.Fields(versionID); len(fields) > 0 {
+		versionID = fields[0]
+	}
+	if parts := strings.Split(versionID, "."); len(parts) > 2 {
+		versionID = strings.Join(parts[:2], ".")
+	}
+	return versionID
+}
+
+func (p *Persona) osRelease() string {
+	d := p.Distro
+	version := d.Version
+	if d.Nickname != "" {
+		version += " (" + d.Nickname + ")"
+	}
+	out := fmt.Sprintf("PRETTY_NAME=\"%s %s\"\nNAME=\"%s\"\nVERSION_ID=\"%s\"\nVERSION=\"%s\"\nVERSION_CODENAME=%s\nID=%s\n",
+		d.Name, d.Version, d.Name, d.versionID(), version, d.Codename, d.ID)
+	if d.ID == "ubuntu" {
+		out += "ID_LIKE=debian\n" +
+			"HOME_URL=\"https://www.ubuntu.com/\"\n" +
+			"SUPPORT_URL=\"https://help.ubuntu.com/\"\n" +
+			"BUG_REPORT_URL=\"https://bugs.launchpad.net/ubuntu/\"\n" +
+			"PRIVACY_POLICY_URL=\"https://www.ubuntu.com/legal/terms-and-policies/privacy-policy\"\n" +
+			"UBUNTU_CODENAME=" + d.Codename + "\n"
+	}
+	return out
+}
+
+func (p *Persona) lsbRelease() string {
+	d := p.Distro
+	return fmt.Sprintf("DISTRIB_ID=%s\nDISTRIB_RELEASE=%s\nDISTRIB_CODENAME=%s\nDISTRIB_DESCRIPTION=\"%s %s\"\n",
+		d.Name, d.versionID(), d.Codename, d.Name, d.Version)
+}
+
+func (p *Persona) cpuinfo() string {
+	c := p.CPU
+	cores := c.Count / c.ThreadsPerCore
+	var out strings.Builder
+	for i := 0; i < c.Count; i++ {
+		fmt.Fprintf(&out, "processor\t: %d\n", i)
+		fmt.Fprintf(&out, "vendor_id\t: %s\n", c.Vendor)
+		fmt.Fprintf(&out, "cpu family\t: %d\n", c.Family)
+		fmt.Fprintf(&out, "model\t\t: %d\n", c.ModelNumber)
+		fmt.Fprintf(&out, "model name\t: %s\n", c.Model)
+		fmt.Fprintf(&out, "stepping\t: %d\n", c.Stepping)
+		fmt.Fprintf(&out, "microcode\t: %s\n", c.Microcode)
+		fmt.Fprintf(&out, "cpu MHz\t\t: %.3f\n", c.MHz)
+		fmt.Fprintf(&out, "cache size\t: %d KB\n", c.CacheKB)
+		fmt.Fprintf(&out, "physical id\t: 0\n")
+		fmt.Fprintf(&out, "siblings\t: %d\n", c.Count)
+		fmt.Fprintf(&out, "core id\t\t: %d\n", i/c.ThreadsPerCore)
+		fmt.Fprintf(&out, "cpu cores\t: %d\n", cores)
+		fmt.Fprintf(&out, "apicid\t\t: %d\n", i)
+		fmt.Fprintf(&out, "initial apicid\t: %d\n", i)
+		fmt.Fprintf(&out, "fpu\t\t: yes\nfpu_exception\t: yes\ncpuid level\t: 13\nwp\t\t: yes\n")
+		fmt.Fprintf(&out, "flags\t\t: %s\n", c.Flags)
+		fmt.Fprintf(&out, "bugs\t\t: %s\n", c.Bugs)
+		fmt.Fprintf(&out, "bogomips\t: %.2f\n", p.bogomips())
+		fmt.Fprintf(&out, "clflush size\t: 64\ncache_alignment\t: 64\n")
+		fmt.Fprintf(&out, "address sizes\t: 46 bits physical, 48 bits virtual\npower management:\n\n")
+	}
+	return out.String()
+}
+
+// The CPUs that are online, as in 0-3.
+func (c PersonaCPU) onlineList() string {
+	if c.Count > 1 {
+		return fmt.Sprintf("0-%d", c.Count-1)
+	}
+	return "0"
+}
+
+func (p *Persona) bogomips() float64 {
+	return math.Floor(p.CPU.MHz*2*100) / 100
+}
+
+func (p *Persona) meminfo() string {
+	m := p.memory()
+	lines := []struct {
+		name string
+		kb   int64
+	}{
+		{"MemTotal", m.Total},
+		{"MemFree", m.Free},
+		{"MemAvailable", m.Available},
+		{"Buffers", m.Buffers},
+		{"Cached", m.Cached},
+		{"SwapCached", 0},
+		{"Active", m.Used/2 + m.Cached/2},
+		{"Inactive", m.Used/3 + m.Cached/2},
+		{"SwapTotal", m.SwapTotal},
+		{"SwapFree", m.SwapFree},
+		{"Dirty", 132},
+		{"Writeback", 0},
+		{"AnonPages", m.Used * 3 / 4},
+		{"Mapped", m.Used / 6},
+		{"Shmem", m.Shared},
+		{"KReclaimable", m.Reclaimable},
+		{"Slab", m.Reclaimable * 3 / 2},
+		{"SReclaimable", m.Reclaimable},
+		{"SUnreclaim", m.Reclaimable / 2},
+		{"KernelStack", 2896},
+		{"PageTables", 6120},
+		{"CommitLimit", m.Total/2 + m.SwapTotal},
+		{"Committed_AS", m.Used * 2},
+		{"VmallocTotal", 34359738367},
+		{"HugePages_Total", -1},
+	}
+	var out strings.Builder
+	for _, line := range lines {
+		if line.kb < 0 {
+			out.WriteString("HugePages_Total:       0\nHugePages_Free:        0\nHugepagesize:       2048 kB\n")
+			continue
+		}
+		fmt.Fprintf(&out, "%-15s %8d kB\n", line.name+":", line.kb)
+	}
+	return out.String()
+}
+
+func (p *Persona) procUptime() string {
+	up := p.uptime(time.Now()).Seconds()
+	return fmt.Sprintf("%.2f %.2f\n", up, up*float64(p.CPU.Count)*0.97)
+}
+
+func (p *Persona) loadavg() string {
+	minutes := int(p.uptime(time.Now()).Minutes())
+	return fmt.Sprintf("%.2f %.2f %.2f 1/%d %d\n", p.Load[0], p.Load[1], p.Load[2], 120+minutes%17, 1000+minutes%30000)
+}
+
+func (p *Persona) procVersion() string {
+	return fmt.Sprintf("Linux version %s %s %s\n", p.Kernel.Release, p.Kernel.Builder, p.Kernel.Version)
+}
+
+// The process's arguments as /proc/<pid>/cmdline holds them, each ended by
+// a NUL. Kernel threads have none.
+func (proc PersonaProcess) cmdline() string {
+	out := ""
+	for _, arg := range proc.Args {
+		out += arg + "\x00"
+	}
+	return out
+}
+
+func (proc PersonaProcess) comm() string {
+	return proc.Name + "\n"
+}
+
+func (proc PersonaProcess) status() string {
+	var out strings.Builder
+	umask := "0022"
+	if len(proc.Args) == 0 {
+		umask = "0000"
+	}
+	fmt.Fprintf(&out, "Name:\t%s\nUmask:\t%s\nState:\tS (sleeping)\n", proc.Name, umask)
+	fmt.Fprintf(&out, "Tgid:\t%d\nNgid:\t0\nPid:\t%d\nPPid:\t%d\nTracerPid:\t0\n", proc.PID, proc.PID, proc.PPID)
+	fmt.Fprintf(&out, "Uid:\t%d\t%d\t%d\t%d\nGid:\t%d\t%d\t%d\t%d\n", proc.UID, proc.UID, proc.UID, proc.UID, proc.UID, proc.UID, proc.UID, proc.UID)
+	fmt.Fprintf(&out, "FDSize:\t64\nGroups:\t\nNStgid:\t%d\nNSpid:\t%d\nNSpgid:\t%d\nNSsid:\t%d\n", proc.PID, proc.PID, proc.PID, proc.PID)
+	fmt.Fprintf(&out, "Threads:\t1\nSigQ:\t0/31721\n")
+	fmt.Fprintf(&out, "Cpus_allowed_list:\t%s\n", PERSONA.CPU.onlineList())
+	fmt.Fprintf(&out, "voluntary_ctxt_switches:\t%d\nnonvoluntary_ctxt_switches:\t%d\n", 1000+proc.PID%977, proc.PID%53)
+	return out.String()
+}
+
+func (p *Persona) procMounts() string {
+	var out strings.Builder
+	for _, m := range p.mounts() {
+		fmt.Fprintf(&out, "%s %s %s %s 0 0\n", m.Device, m.Target, m.Type, m.Options)
+	}
+	return out.String()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestPersonaFiles(t *testing.T) {
+	p := PERSONA
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/etc/hostname", []string{p.Hostname + "\n"}},
+		{"/proc/sys/kernel/hostname", []string{p.Hostname + "\n"}},
+		{"/proc/sys/kernel/osrelease", []string{p.Kernel.Release + "\n"}},
+		{"/proc/version", []string{"Linux version " + p.Kernel.Release + " ", p.Kernel.Version + "\n"}},
+		{"/etc/os-release", []string{`PRETTY_NAME="Ubuntu 22.04.3 LTS"`, `VERSION_ID="22.04"`, "VERSION_CODENAME=jammy\n", "ID_LIKE=debian\n"}},
+		{"/etc/lsb-release", []string{"DISTRIB_RELEASE=22.04\n", `DISTRIB_DESCRIPTION="Ubuntu 22.04.3 LTS"`}},
+		{"/proc/cpuinfo", []string{"processor\t: 3\n", "model name\t: " + p.CPU.Model + "\n", "cpu cores\t: 2\n"}},
+		{"/proc/meminfo", []string{"MemTotal:        8141824 kB\n", "SwapTotal:             0 kB\n"}},
+		{"/proc/mounts", []string{"/dev/vda1 / ext4 rw,relatime,discard,errors=remount-ro 0 0\n", "proc /proc proc "}},
+		{"/proc/1/cmdline", []string{"/sbin/init\x00"}},
+		{"/proc/1/comm", []string{"systemd\n"}},
+		{"/proc/1/status", []string{"Name:\tsystemd\n", "Pid:\t1\nPPid:\t0\n", "Uid:\t0\t0\t0\t0\n", "Cpus_allowed_list:\t0-3\n"}},
+		{"/proc/598/status", []string{"Uid:\t100\t100\t100\t100\n"}},
+		{"/proc/741/cmdline", []string{"/sbin/agetty\x00-o\x00-p -- \\u\x00--noclear\x00tty1\x00linux\x00"}},
+	}
+	state := newTestState()
+	for _, test := range tests {
+		stdout, stderr, _ := runTestShell(state, "cat "+test.path)
+		for _, want := range test.want {
+			if !strings.Contains(stdout, want) {
+				t.Errorf("%s: %q doesn't hold %q%s", test.path, stdout, want, stderr)
+			}
+		}
+	}
+}
+
+func TestPersonaProcesses(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		// Kernel threads have an empty command line.
+		{"cat /proc/2/cmdline", ""},
+		{"cat /proc/2/comm", "kthreadd\n"},
+		{"cat /proc/*/cmdline", "/sbin/init\x00"},
+		{"ls -d /proc/1 /proc/803", "/proc/1\n/proc/803\n"},
+		{"cat /proc/803/cmdline", "sshd: /usr/sbin/sshd -D [listener] 0 of 10-100 startups\x00"},
+		// /proc/self is the shell, as is $$.
+		{"cat /proc/self/cmdline", "-bash\x00"},
+		{"cat /proc/$$/comm", "bash\n"},
+		{"cd /proc/self; pwd -P", "/proc/22417\n"},
+		{"echo $$", "22417\n"},
+	}
+	for _, test := range tests {
+		stdout, stderr, status := runTestShell(newTestState(), test.line)
+		if status != 0 || !strings.HasPrefix(stdout, test.want) || (test.want == "" && stdout != "") {
+			t.Errorf("%q: got %q, %q, status %d, want %q", test.line, stdout, stderr, status, test.want)
+		}
+	}
+	// Every process's command line is listed by the glob.
+	stdout, _, _ := runTestShell(newTestState(), "cat /proc/*/cmdline")
+	for _, proc := range PERSONA.Processes {
+		if !strings.Contains(stdout, proc.cmdline()) {
+			t.Errorf("cat /proc/*/cmdline leaves out %d", proc.PID)
+		}
+	}
+}
+
+func TestPersonaProcessOutput(t *testing.T) {
+	tests := []struct {
+		proc        PersonaProcess
+		wantCmdline string
+		wantStatus  []string
+	}{
+		{
+			PersonaProcess{PID: 7, PPID: 1, Name: "nginx", UID: 33, Args: []string{"nginx: master process", "-g", "daemon on;"}},
+			"nginx: master process\x00-g\x00daemon on;\x00",
+			[]string{"Name:\tnginx\nUmask:\t0022\n", "Tgid:\t7\n", "Pid:\t7\nPPid:\t1\n", "Uid:\t33\t33\t33\t33\nGid:\t33\t33\t33\t33\n"},
+		},
+		{
+			PersonaProcess{PID: 9, PPID: 2, Name: "kworker/0:1"},
+			"",
+			[]string{"Name:\tkworker/0:1\nUmask:\t0000\n", "PPid:\t2\n"},
+		},
+	}
+	for _, test := range tests {
+		if got := test.proc.cmdline(); got != test.wantCmdline {
+			t.Errorf("%s: cmdline %q, want %q", test.proc.Name, got, test.wantCmdline)
+		}
+		status := test.proc.status()
+		for _, want := range test.wantStatus {
+			if !strings.Contains(status, want) {
+				t.Errorf("%s: status %q doesn't hold %q", test.proc.Name, status, want)
+			}
+		}
+	}
+}
+
+func TestPersonaConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		yaml  string
+		check func(p Persona) bool
+	}{
+		{"defaults kept", "hostname: db-prod-3\n", func(p Persona) bool {
+			return p.Hostname == "db-prod-3" && p.Distro.Codename == "jammy" && len(p.Processes) == len(defaultPersona().Processes)
+		}},
+		{"processes replaced", "processes:\n- {pid: 1, name: init, args: [/sbin/init, splash]}\n", func(p Persona) bool {
+			return len(p.Processes) == 1 && p.Processes[0].cmdline() == "/sbin/init\x00splash\x00"
+		}},
+	}
+	for _, test := range tests {
+		p := defaultPersona()
+		if err := yaml.Unmarshal([]byte(test.yaml), &p); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !test.check(p) {
+			t.Errorf("%s: got %+v", test.name, p)
+		}
+	}
+}
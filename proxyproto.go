@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Signature that starts every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const proxyV1MaxLength = 107
+
+var errNoProxyHeader = errors.New("connection from trusted proxy has no PROXY header")
+
+// ProxyListener accepts connections from the trusted proxies in Trusted and
+// reads a PROXY protocol (v1 or v2) header from each of them, so that
+// RemoteAddr reports the real client rather than the load balancer.
+// Connections from anywhere else are passed through untouched.
+type ProxyListener struct {
+	net.Listener
+	Trusted       []*net.IPNet
+	HeaderTimeout time.Duration
+}
+
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	// The header is read on first use rather than here, so that a slow
+	// client can't hold up the accept loop.
+	return &proxyConn{
+		Conn:          conn,
+		reader:        bufio.NewReaderSize(conn, 256),
+		headerTimeout: l.HeaderTimeout,
+	}, nil
+}
+
+func (l *ProxyListener) trusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range l.Trusted {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+type proxyConn struct {
+	net.Conn
+	reader        *bufio.Reader
+	headerTimeout time.Duration
+
+	once       sync.Once
+	headerErr  error
+	remoteAddr net.Addr
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		if c.headerTimeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		addr, err := readProxyHeader(c.reader)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"proxy": c.Conn.RemoteAddr().String(),
+				"err":   err,
+			}).Warnln("Bad PROXY protocol header, closing connection")
+			c.headerErr = err
+			c.Conn.Close()
+			return
+		}
+		c.remoteAddr = addr
+	})
+}
+
+func (c *proxyConn) Read(buf []byte) (int, error) {
+	c.readHeader()
+	if c.headerErr != nil {
+		return 0, c.headerErr
+	}
+	return c.reader.Read(buf)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// Reads a v1 or v2 header. The returned address is nil when the header is
+// valid but carries no client address (v1 UNKNOWN, v2 LOCAL or non-TCP).
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	peek, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(peek, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if bytes.HasPrefix(peek, []byte("PROXY ")) {
+		return readProxyV1(r)
+	}
+	return nil, errNoProxyHeader
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	line := []byte{}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, errors.New("PROXY v1 header too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY v1 header not terminated by CRLF")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("PROXY v1 header has %d fields", len(fields))
+	}
+	if fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, fmt.Errorf("unknown PROXY v1 protocol %q", fields[1])
+	}
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return nil, fmt.Errorf("bad PROXY v1 source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad PROXY v1 source port %q", fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	verCmd := header[12]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unknown PROXY v2 version %d", verCmd>>4)
+	}
+	family := header[13]
+	length := binary.BigEndian.Uint16(header[14:16])
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	switch verCmd & 0xf {
+	case 0x0: // LOCAL, e.g. a health check from the proxy itself
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unknown PROXY v2 command %d", verCmd&0xf)
+	}
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("PROXY v2 IPv4 address block too short")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(body[0:4]),
+			Port: int(binary.BigEndian.Uint16(body[8:10])),
+		}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("PROXY v2 IPv6 address block too short")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(body[0:16]),
+			Port: int(binary.BigEndian.Uint16(body[32:34])),
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// Parses a comma separated list of CIDRs or bare IPs.
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Listens on addr, wrapping the listener in a ProxyListener when
+// TRUSTED_PROXIES is set.
+func listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	trusted, err := parseTrustedProxies(envOrDefault("TRUSTED_PROXIES", ""))
+	if err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("parsing TRUSTED_PROXIES: %w", err)
+	}
+	if len(trusted) == 0 {
+		return ln, nil
+	}
+	logrus.WithField("proxies", len(trusted)).Infoln("PROXY protocol enabled for trusted proxies")
+	return &ProxyListener{
+		Listener:      ln,
+		Trusted:       trusted,
+		HeaderTimeout: envDurationOrDefault("PROXY_HEADER_TIMEOUT", 5*time.Second),
+	}, nil
+}
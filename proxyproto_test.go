@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Builds a PROXY v2 header for the given command, family and address block.
+func proxyV2Header(cmd byte, family byte, body []byte) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:16], uint16(len(body)))
+	return append(header, body...)
+}
+
+func proxyV2IPv4(src string, srcPort uint16) []byte {
+	body := make([]byte, 12)
+	copy(body[0:4], net.ParseIP(src).To4())
+	copy(body[4:8], net.ParseIP("192.0.2.1").To4())
+	binary.BigEndian.PutUint16(body[8:10], srcPort)
+	binary.BigEndian.PutUint16(body[10:12], 22)
+	return body
+}
+
+func proxyV2IPv6(src string, srcPort uint16) []byte {
+	body := make([]byte, 36)
+	copy(body[0:16], net.ParseIP(src))
+	copy(body[16:32], net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(body[32:34], srcPort)
+	binary.BigEndian.PutUint16(body[34:36], 22)
+	return body
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   []byte
+		wantAddr string
+		wantErr  bool
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 198.51.100.2 192.0.2.1 51234 22\r\n"), "198.51.100.2:51234", false},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::2 2001:db8::1 51234 22\r\n"), "[2001:db8::2]:51234", false},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 missing CR", []byte("PROXY TCP4 198.51.100.2 192.0.2.1 51234 22\n"), "", true},
+		{"v1 too few fields", []byte("PROXY TCP4 198.51.100.2 192.0.2.1\r\n"), "", true},
+		{"v1 bad protocol", []byte("PROXY UDP4 198.51.100.2 192.0.2.1 51234 22\r\n"), "", true},
+		{"v1 bad address", []byte("PROXY TCP4 nowhere 192.0.2.1 51234 22\r\n"), "", true},
+		{"v1 bad port", []byte("PROXY TCP4 198.51.100.2 192.0.2.1 99999 22\r\n"), "", true},
+		{"v1 too long", []byte("PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n"), "", true},
+		{"v2 IPv4", proxyV2Header(0x1, 0x11, proxyV2IPv4("198.51.100.2", 51234)), "198.51.100.2:51234", false},
+		{"v2 IPv6", proxyV2Header(0x1, 0x21, proxyV2IPv6("2001:db8::2", 51234)), "[2001:db8::2]:51234", false},
+		{"v2 LOCAL", proxyV2Header(0x0, 0x00, nil), "", false},
+		{"v2 UDP", proxyV2Header(0x1, 0x12, proxyV2IPv4("198.51.100.2", 51234)), "", false},
+		{"v2 short IPv4 block", proxyV2Header(0x1, 0x11, make([]byte, 4)), "", true},
+		{"v2 short IPv6 block", proxyV2Header(0x1, 0x21, make([]byte, 12)), "", true},
+		{"v2 bad command", proxyV2Header(0x5, 0x11, proxyV2IPv4("198.51.100.2", 51234)), "", true},
+		{"no header", []byte("SSH-2.0-OpenSSH_8.9\r\n"), "", true},
+	}
+	for _, test := range tests {
+		r := bufio.NewReader(bytes.NewReader(append(test.header, "SSH-2.0-client\r\n"...)))
+		addr, err := readProxyHeader(r)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		gotAddr := ""
+		if addr != nil {
+			gotAddr = addr.String()
+		}
+		if gotAddr != test.wantAddr {
+			t.Errorf("%s: address = %q, want %q", test.name, gotAddr, test.wantAddr)
+		}
+		if err != nil {
+			continue
+		}
+		if rest, _ := ioutil.ReadAll(r); string(rest) != "SSH-2.0-client\r\n" {
+			t.Errorf("%s: left %q after the header", test.name, rest)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		list    string
+		trusted []string
+		not     []string
+		wantErr bool
+	}{
+		{"", nil, []string{"10.0.0.1"}, false},
+		{"10.0.0.0/8", []string{"10.1.2.3"}, []string{"11.0.0.1"}, false},
+		{"192.0.2.7, 2001:db8::/32,", []string{"192.0.2.7", "2001:db8::5"}, []string{"192.0.2.8", "2001:db9::1"}, false},
+		{"not-an-ip", nil, nil, true},
+		{"10.0.0.0/33", nil, nil, true},
+	}
+	for _, test := range tests {
+		networks, err := parseTrustedProxies(test.list)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseTrustedProxies(%q) error = %v, want error %v", test.list, err, test.wantErr)
+			continue
+		}
+		l := &ProxyListener{Trusted: networks}
+		for _, ip := range test.trusted {
+			if !l.trusted(&net.TCPAddr{IP: net.ParseIP(ip)}) {
+				t.Errorf("%q: %s not trusted", test.list, ip)
+			}
+		}
+		for _, ip := range test.not {
+			if l.trusted(&net.TCPAddr{IP: net.ParseIP(ip)}) {
+				t.Errorf("%q: %s trusted", test.list, ip)
+			}
+		}
+	}
+}
+
+func TestProxyConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := &proxyConn{Conn: server, reader: bufio.NewReader(server)}
+	go client.Write([]byte("PROXY TCP4 198.51.100.2 192.0.2.1 51234 22\r\nhello"))
+	if got := conn.RemoteAddr().String(); got != "198.51.100.2:51234" {
+		t.Errorf("RemoteAddr = %s, want the client behind the proxy", got)
+	}
+	buf := make([]byte, 5)
+	if _, err := conn.Read(buf); err != nil || string(buf) != "hello" {
+		t.Errorf("Read = %q, %v, want what followed the header", buf, err)
+	}
+}
+
+func TestSourceAddr(t *testing.T) {
+	tests := []struct {
+		remote   net.Addr
+		wantIP   string
+		wantPort string
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 40000}, "203.0.113.7", "40000"},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 40000}, "2001:db8::7", "40000"},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:203.0.113.7"), Port: 1}, "203.0.113.7", "1"},
+	}
+	for _, test := range tests {
+		ctx := newTestContext("root", "id")
+		ctx.remote = test.remote
+		ip, port := sourceAddr(ctx)
+		if ip != test.wantIP || port != test.wantPort {
+			t.Errorf("sourceAddr(%s) = %s %s, want %s %s", test.remote, ip, port, test.wantIP, test.wantPort)
+		}
+	}
+}
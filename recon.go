@@ -0,0 +1,695 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeystats/ssh/files"
+)
+
+// The commands bots run first to size up a machine. They all answer from
+// PERSONA.
+func init() {
+	registerCommand(unameCommand{commandSpec{
+		name:       "uname",
+		dir:        binDir,
+		completion: completeNothing,
+		help:       "uname [OPTION]...\nPrint certain system information.  With no OPTION, same as -s.\n",
+	}})
+	registerCommand(hostnameCommand{commandSpec{
+		name:       "hostname",
+		dir:        binDir,
+		completion: completeNothing,
+		help:       "hostname [-a|-A|-d|-f|-i|-I|-s] [hostname]\nShow or set the system's host name.\n",
+	}})
+	registerCommand(nprocCommand{commandSpec{
+		name:       "nproc",
+		dir:        binDir,
+		completion: completeNothing,
+		help:       "nproc [OPTION]...\nPrint the number of processing units available to the current process,\nwhich may be less than the number of online processors\n",
+	}})
+	registerCommand(freeCommand{commandSpec{
+		name:       "free",
+		dir:        binDir,
+		completion: completeNothing,
+		help:       "free [options]\nDisplay amount of free and used memory in the system.\n",
+	}})
+	registerCommand(dfCommand{commandSpec{
+		name: "df",
+		dir:  binDir,
+		help: "df [OPTION]... [FILE]...\nShow information about the file system on which each FILE resides,\nor all file systems by default.\n",
+	}})
+	registerCommand(uptimeCommand{commandSpec{
+		name:       "uptime",
+		dir:        binDir,
+		completion: completeNothing,
+		help:       "uptime [options]\nTell how long the system has been running.\n",
+	}})
+	registerCommand(wCommand{commandSpec{
+		name:       "w",
+		dir:        binDir,
+		completion: completeNothing,
+		help:       "w [options] [user]\nShow who is logged on and what they are doing.\n",
+	}})
+	registerCommand(lscpuCommand{commandSpec{
+		name:       "lscpu",
+		dir:        binDir,
+		completion: completeNothing,
+		help:       "lscpu [options]\nDisplay information about the CPU architecture.\n",
+	}})
+	registerCommand(idCommand{commandSpec{
+		name:       "id",
+		dir:        binDir,
+		completion: completeNothing,
+		help:       "id [OPTION]... [USER]...\nPrint user and group information for each specified USER,\nor (when USER omitted) for the current user.\n",
+	}})
+}
+
+type unameCommand struct{ commandSpec }
+
+func (unameCommand) Run(inv *Invocation) int {
+	long := map[string]string{
+		"--all":               "a",
+		"--kernel-name":       "s",
+		"--nodename":          "n",
+		"--kernel-release":    "r",
+		"--kernel-version":    "v",
+		"--machine":           "m",
+		"--processor":         "p",
+		"--hardware-platform": "i",
+		"--operating-system":  "o",
+	}
+	flags, operands := splitFlags(inv.Args)
+	if len(operands) > 0 {
+		fmt.Fprintf(inv.Stderr, "uname: extra operand '%s'\nTry 'uname --help' for more information.\n", operands[0])
+		return 1
+	}
+	selected := map[string]bool{}
+	for _, flag := range flags {
+		if short, ok := long[flag]; ok {
+			flag = short
+		}
+		if !strings.Contains("asnrvmpio", flag) || len(flag) != 1 {
+			if strings.HasPrefix(flag, "--") {
+				fmt.Fprintf(inv.Stderr, "uname: unrecognized option '%s'\n", flag)
+			} else {
+				fmt.Fprintf(inv.Stderr, "uname: invalid option -- '%s'\n", flag)
+			}
+			fmt.Fprint(inv.Stderr, "Try 'uname --help' for more information.\n")
+			return 1
+		}
+		selected[flag] = true
+	}
+	if len(selected) == 0 {
+		selected["s"] = true
+	}
+	k := PERSONA.Kernel
+	fields := []struct {
+		flag  string
+		value string
+	}{
+		{"s", "Linux"},
+		{"n", inv.State.hostname()},
+		{"r", k.Release},
+		{"v", k.Version},
+		{"m", k.Machine},
+		{"p", k.Machine},
+		{"i", k.Machine},
+		{"o", "GNU/Linux"},
+	}
+	values := []string{}
+	for _, field := range fields {
+		if selected[field.flag] || selected["a"] {
+			values = append(values, field.value)
+		}
+	}
+	fmt.Fprintf(inv.Stdout, "%s\n", strings.Join(values, " "))
+	return 0
+}
+
+type hostnameCommand struct{ commandSpec }
+
+var validHostname = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.-]*$`)
+
+func (hostnameCommand) Run(inv *Invocation) int {
+	flags, operands := splitFlags(inv.Args)
+	if len(operands) > 0 {
+		if inv.Ctx.User() != "root" {
+			fmt.Fprint(inv.Stderr, "hostname: you must be root to change the host name\n")
+			return 1
+		}
+		if len(operands[0]) > 64 {
+			fmt.Fprint(inv.Stderr, "hostname: name too long\n")
+			return 1
+		}
+		if !validHostname.MatchString(operands[0]) {
+			fmt.Fprint(inv.Stderr, "hostname: the specified hostname is invalid\n")
+			return 1
+		}
+		inv.State.setHostname(operands[0])
+		return 0
+	}
+	name := inv.State.hostname()
+	switch {
+	case hasFlag(flags, "i", "I", "--ip-address", "--all-ip-addresses"):
+		name = PERSONA.Address
+		if hasFlag(flags, "I", "--all-ip-addresses") {
+			name += " "
+		}
+	case hasFlag(flags, "s", "--short"):
+		name = strings.SplitN(name, ".", 2)[0]
+	case hasFlag(flags, "d", "--domain"):
+		domain := ""
+		if dot := strings.IndexByte(name, '.'); dot >= 0 {
+			domain = name[dot+1:]
+		}
+		name = domain
+	}
+	fmt.Fprintf(inv.Stdout, "%s\n", name)
+	return 0
+}
+
+type nprocCommand struct{ commandSpec }
+
+func (nprocCommand) Run(inv *Invocation) int {
+	count := PERSONA.CPU.Count
+	for _, arg := range inv.Args {
+		if strings.HasPrefix(arg, "--ignore=") {
+			ignore, err := strconv.Atoi(strings.TrimPrefix(arg, "--ignore="))
+			if err != nil || ignore < 0 {
+				fmt.Fprintf(inv.Stderr, "nproc: invalid number: '%s'\n", strings.TrimPrefix(arg, "--ignore="))
+				return 1
+			}
+			count -= ignore
+		}
+	}
+	if count < 1 {
+		count = 1
+	}
+	fmt.Fprintf(inv.Stdout, "%d\n", count)
+	return 0
+}
+
+type freeCommand struct{ commandSpec }
+
+func (freeCommand) Run(inv *Invocation) int {
+	flags, _ := splitFlags(inv.Args)
+	// Turns a size in KiB into what is printed.
+	format := func(kb int64) string { return strconv.FormatInt(kb, 10) }
+	for _, flag := range flags {
+		switch flag {
+		case "b", "--bytes":
+			format = func(kb int64) string { return strconv.FormatInt(kb*1024, 10) }
+		case "k", "--kibi":
+			format = func(kb int64) string { return strconv.FormatInt(kb, 10) }
+		case "m", "--mebi":
+			format = func(kb int64) string { return strconv.FormatInt(kb/1024, 10) }
+		case "g", "--gibi":
+			format = func(kb int64) string { return strconv.FormatInt(kb/1024/1024, 10) }
+		case "h", "--human":
+			format = freeHuman
+		case "t", "--total", "w", "--wide":
+		default:
+			if strings.HasPrefix(flag, "--") {
+				fmt.Fprintf(inv.Stderr, "free: unrecognized option '%s'\n", flag)
+			} else {
+				fmt.Fprintf(inv.Stderr, "free: invalid option -- '%s'\n", flag)
+			}
+			fmt.Fprint(inv.Stderr, "\nUsage:\n free [options]\n")
+			return 1
+		}
+	}
+	m := PERSONA.memory()
+	buffCache := m.Buffers + m.Cached + m.Reclaimable
+	fmt.Fprint(inv.Stdout, "               total        used        free      shared  buff/cache   available\n")
+	fmt.Fprintf(inv.Stdout, "%-8s%12s%12s%12s%12s%12s%12s\n", "Mem:",
+		format(m.Total), format(m.Total-m.Free-buffCache), format(m.Free), format(m.Shared), format(buffCache), format(m.Available))
+	fmt.Fprintf(inv.Stdout, "%-8s%12s%12s%12s\n", "Swap:",
+		format(m.SwapTotal), format(m.SwapTotal-m.SwapFree), format(m.SwapFree))
+	if hasFlag(flags, "t", "--total") {
+		fmt.Fprintf(inv.Stdout, "%-8s%12s%12s%12s\n", "Total:",
+			format(m.Total+m.SwapTotal), format(m.Total-m.Free-buffCache+m.SwapTotal-m.SwapFree), format(m.Free+m.SwapFree))
+	}
+	return 0
+}
+
+// Sizes as free -h shows them, as in 7.8Gi.
+func freeHuman(kb int64) string {
+	if kb == 0 {
+		return "0B"
+	}
+	value := float64(kb)
+	units := "KMGTPE"
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if value < 10 {
+		return fmt.Sprintf("%.1f%ci", value, units[unit])
+	}
+	return fmt.Sprintf("%.0f%ci", value, units[unit])
+}
+
+type dfCommand struct{ commandSpec }
+
+func (dfCommand) Run(inv *Invocation) int {
+	flags, operands := splitFlags(inv.Args)
+	human := hasFlag(flags, "h", "--human-readable")
+	showType := hasFlag(flags, "T", "--print-type")
+	mounts := []mount{}
+	for _, m := range PERSONA.mounts() {
+		if !m.Pseudo || hasFlag(flags, "a", "--all") {
+			mounts = append(mounts, m)
+		}
+	}
+	status := 0
+	if len(operands) > 0 {
+		selected := []mount{}
+		for _, operand := range operands {
+			var err error
+			var cwdPath string
+			inv.State.view(func(root *files.FilesystemDir, cwd *files.FilesystemDir) {
+				err, _ = cwd.GetFileOrDir(root, operand)
+				cwdPath = cwd.Path()
+			})
+			if err != nil {
+				fmt.Fprintf(inv.Stderr, "df: %s: No such file or directory\n", operand)
+				status = 1
+				continue
+			}
+			absPath := path.Join(cwdPath, operand)
+			if strings.HasPrefix(operand, "/") {
+				absPath = path.Clean(operand)
+			}
+			// Pseudo filesystems are shown when asked about.
+			selected = append(selected, mountOf(PERSONA.mounts(), absPath))
+		}
+		mounts = selected
+	}
+	if len(mounts) == 0 {
+		return status
+	}
+	header := []string{"Filesystem", "1K-blocks", "Used", "Available", "Use%", "Mounted on"}
+	if human {
+		header = []string{"Filesystem", "Size", "Used", "Avail", "Use%", "Mounted on"}
+	}
+	size := func(kb int64) string {
+		if human {
+			return humanSize(kb * 1024)
+		}
+		return strconv.FormatInt(kb, 10)
+	}
+	rows := [][]string{header}
+	for _, m := range mounts {
+		pcent := "-"
+		if m.Size > 0 {
+			pcent = fmt.Sprintf("%d%%", int(math.Ceil(float64(m.Used)*100/float64(m.Used+m.avail()))))
+		}
+		rows = append(rows, []string{m.Device, size(m.Size), size(m.Used), size(m.avail()), pcent, m.Target})
+	}
+	if showType {
+		for i, row := range rows {
+			kind := "Type"
+			if i > 0 {
+				kind = mounts[i-1].Type
+			}
+			rows[i] = append([]string{row[0], kind}, row[1:]...)
+		}
+	}
+	// GNU df's minimum widths, widened to fit.
+	widths := make([]int, len(rows[0]))
+	for i := range widths {
+		widths[i] = 5
+	}
+	widths[0] = 14
+	widths[len(widths)-2] = 4
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	for _, row := range rows {
+		cells := []string{}
+		for i, cell := range row {
+			switch {
+			case i == len(row)-1:
+				cells = append(cells, cell)
+			case i == 0 || (showType && i == 1):
+				cells = append(cells, fmt.Sprintf("%-*s", widths[i], cell))
+			default:
+				cells = append(cells, fmt.Sprintf("%*s", widths[i], cell))
+			}
+		}
+		fmt.Fprintf(inv.Stdout, "%s\n", strings.Join(cells, " "))
+	}
+	return status
+}
+
+// The mount a path is on, by the longest mount point above it.
+func mountOf(mounts []mount, absPath string) mount {
+	best := -1
+	for i, m := range mounts {
+		above := m.Target == "/" || absPath == m.Target || strings.HasPrefix(absPath, m.Target+"/")
+		if above && (best < 0 || len(m.Target) > len(mounts[best].Target)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return mounts[0]
+	}
+	return mounts[best]
+}
+
+type uptimeCommand struct{ commandSpec }
+
+func (uptimeCommand) Run(inv *Invocation) int {
+	flags, _ := splitFlags(inv.Args)
+	now := time.Now()
+	switch {
+	case hasFlag(flags, "s", "--since"):
+		fmt.Fprintf(inv.Stdout, "%s\n", PERSONA.BootTime.Local().Format("2006-01-02 15:04:05"))
+	case hasFlag(flags, "p", "--pretty"):
+		fmt.Fprintf(inv.Stdout, "%s\n", prettyUptime(PERSONA.uptime(now)))
+	default:
+		fmt.Fprintf(inv.Stdout, "%s\n", uptimeLine(inv.State, now))
+	}
+	return 0
+}
+
+// The line uptime prints, which w starts with too.
+func uptimeLine(state *SessionState, now time.Time) string {
+	up := PERSONA.uptime(now)
+	days := int(up.Hours()) / 24
+	hours := int(up.Hours()) % 24
+	minutes := int(up.Minutes()) % 60
+	line := fmt.Sprintf(" %s up ", now.Format("15:04:05"))
+	if days > 0 {
+		line += fmt.Sprintf("%d day%s, ", days, plural(days))
+	}
+	if hours > 0 {
+		line += fmt.Sprintf("%2d:%02d, ", hours, minutes)
+	} else {
+		line += fmt.Sprintf("%d min, ", minutes)
+	}
+	users := PERSONA.userCount(state)
+	line += fmt.Sprintf("%2d user%s, ", users, plural(users))
+	load := PERSONA.Load
+	return line + fmt.Sprintf(" load average: %.2f, %.2f, %.2f", load[0], load[1], load[2])
+}
+
+func prettyUptime(up time.Duration) string {
+	minutes := int(up.Minutes())
+	parts := []string{}
+	for _, unit := range []struct {
+		name    string
+		minutes int
+	}{
+		{"year", 365 * 24 * 60},
+		{"week", 7 * 24 * 60},
+		{"day", 24 * 60},
+		{"hour", 60},
+		{"minute", 1},
+	} {
+		if n := minutes / unit.minutes; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s%s", n, unit.name, plural(n)))
+			minutes %= unit.minutes
+		}
+	}
+	if len(parts) == 0 {
+		return "up 0 minutes"
+	}
+	return "up " + strings.Join(parts, ", ")
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+type wCommand struct{ commandSpec }
+
+func (wCommand) Run(inv *Invocation) int {
+	flags, operands := splitFlags(inv.Args)
+	now := time.Now()
+	if !hasFlag(flags, "h", "--no-header") {
+		fmt.Fprintf(inv.Stdout, "%s\n", uptimeLine(inv.State, now))
+		fmt.Fprint(inv.Stdout, "USER     TTY      FROM             LOGIN@   IDLE   JCPU   PCPU WHAT\n")
+	}
+	type row struct {
+		user, tty, from string
+		login, active   time.Time
+		jcpu, pcpu      string
+		what            string
+	}
+	rows := []row{}
+	for _, u := range PERSONA.Users {
+		started := PERSONA.started
+		rows = append(rows, row{u.Name, u.TTY, u.From, started.Add(-u.Login), started.Add(-u.Idle), "0.04s", "0.04s", u.What})
+	}
+	if inv.State.hasPty() {
+		from, _, err := net.SplitHostPort(inv.Ctx.RemoteAddr().String())
+		if err != nil {
+			from = inv.Ctx.RemoteAddr().String()
+		}
+		inv.State.mu.Lock()
+		started := inv.State.started
+		inv.State.mu.Unlock()
+		tty := fmt.Sprintf("pts/%d", len(PERSONA.Users))
+		rows = append(rows, row{inv.Ctx.User(), tty, from, started, now, "0.01s", "0.00s", "w"})
+	}
+	for _, r := range rows {
+		if len(operands) > 0 && operands[0] != r.user {
+			continue
+		}
+		fmt.Fprintf(inv.Stdout, "%-8s %-8s %-16s %-8s %-6s %-6s %-5s %s\n",
+			r.user, r.tty, r.from, loginTime(r.login, now), idleTime(now.Sub(r.active)), r.jcpu, r.pcpu, r.what)
+	}
+	return 0
+}
+
+// When a user logged in, as w shows it.
+func loginTime(t time.Time, now time.Time) string {
+	switch {
+	case now.Sub(t) < 12*time.Hour && t.Day() == now.Day():
+		return t.Format("15:04")
+	case now.Sub(t) < 6*24*time.Hour:
+		return t.Format("Mon15")
+	}
+	return t.Format("02Jan06")
+}
+
+// How long a user has been idle, as w shows it.
+func idleTime(idle time.Duration) string {
+	switch {
+	case idle < time.Minute:
+		return fmt.Sprintf("%d.%02ds", int(idle.Seconds()), int(idle.Milliseconds()/10)%100)
+	case idle < time.Hour:
+		return fmt.Sprintf("%d:%02d", int(idle.Minutes()), int(idle.Seconds())%60)
+	case idle < 24*time.Hour:
+		return fmt.Sprintf("%d:%02dm", int(idle.Hours()), int(idle.Minutes())%60)
+	}
+	return fmt.Sprintf("%ddays", int(idle.Hours())/24)
+}
+
+type lscpuCommand struct{ commandSpec }
+
+func (lscpuCommand) Run(inv *Invocation) int {
+	c := PERSONA.CPU
+	cores := c.Count / c.ThreadsPerCore
+	online := c.onlineList()
+	instances := func(n int) string {
+		if n == 1 {
+			return "1 instance"
+		}
+		return fmt.Sprintf("%d instances", n)
+	}
+	l3 := fmt.Sprintf("%d KiB", c.CacheKB)
+	if c.CacheKB%1024 == 0 {
+		l3 = fmt.Sprintf("%d MiB", c.CacheKB/1024)
+	}
+	lines := [][2]string{
+		{"Architecture:", PERSONA.Kernel.Machine},
+		{"  CPU op-mode(s):", "32-bit, 64-bit"},
+		{"  Address sizes:", "46 bits physical, 48 bits virtual"},
+		{"  Byte Order:", "Little Endian"},
+		{"CPU(s):", strconv.Itoa(c.Count)},
+		{"  On-line CPU(s) list:", online},
+		{"Vendor ID:", c.Vendor},
+		{"  Model name:", c.Model},
+		{"    CPU family:", strconv.Itoa(c.Family)},
+		{"    Model:", strconv.Itoa(c.ModelNumber)},
+		{"    Thread(s) per core:", strconv.Itoa(c.ThreadsPerCore)},
+		{"    Core(s) per socket:", strconv.Itoa(cores)},
+		{"    Socket(s):", "1"},
+		{"    Stepping:", strconv.Itoa(c.Stepping)},
+		{"    BogoMIPS:", fmt.Sprintf("%.2f", PERSONA.bogomips())},
+		{"    Flags:", c.Flags},
+	}
+	if c.Hypervisor != "" {
+		lines = append(lines,
+			[2]string{"Virtualization features:", ""},
+			[2]string{"  Hypervisor vendor:", c.Hypervisor},
+			[2]string{"  Virtualization type:", "full"},
+		)
+	}
+	lines = append(lines,
+		[2]string{"Caches (sum of all):", ""},
+		[2]string{"  L1d:", fmt.Sprintf("%d KiB (%s)", 32*cores, instances(cores))},
+		[2]string{"  L1i:", fmt.Sprintf("%d KiB (%s)", 32*cores, instances(cores))},
+		[2]string{"  L2:", fmt.Sprintf("%d KiB (%s)", 256*cores, instances(cores))},
+		[2]string{"  L3:", fmt.Sprintf("%s (1 instance)", l3)},
+		[2]string{"NUMA:", ""},
+		[2]string{"  NUMA node(s):", "1"},
+		[2]string{"  NUMA node0 CPU(s):", online},
+	)
+	for _, line := range lines {
+		fmt.Fprintf(inv.Stdout, "%-25s%s\n", line[0], line[1])
+	}
+	return 0
+}
+
+type idCommand struct{ commandSpec }
+
+func (idCommand) Run(inv *Invocation) int {
+	flags, operands := splitFlags(inv.Args)
+	user := inv.Ctx.User()
+	if len(operands) > 0 {
+		user = operands[0]
+	}
+	var account account
+	var ok bool
+	inv.State.view(func(root *files.FilesystemDir, cwd *files.FilesystemDir) {
+		account, ok = lookupAccount(root, user)
+	})
+	if !ok {
+		if len(operands) > 0 {
+			fmt.Fprintf(inv.Stderr, "id: '%s': no such user\n", user)
+			return 1
+		}
+		// Whoever logged in exists, whatever /etc/passwd says.
+		account = accountFor(user)
+	}
+	names := hasFlag(flags, "n", "--name")
+	id := func(n int, name string) string {
+		if names {
+			return name
+		}
+		return strconv.Itoa(n)
+	}
+	switch {
+	case hasFlag(flags, "u", "--user"):
+		fmt.Fprintf(inv.Stdout, "%s\n", id(account.uid, account.name))
+	case hasFlag(flags, "g", "--group"):
+		fmt.Fprintf(inv.Stdout, "%s\n", id(account.groups[0].gid, account.groups[0].name))
+	case hasFlag(flags, "G", "--groups"):
+		ids := []string{}
+		for _, group := range account.groups {
+			ids = append(ids, id(group.gid, group.name))
+		}
+		fmt.Fprintf(inv.Stdout, "%s\n", strings.Join(ids, " "))
+	default:
+		groups := []string{}
+		for _, group := range account.groups {
+			groups = append(groups, fmt.Sprintf("%d(%s)", group.gid, group.name))
+		}
+		fmt.Fprintf(inv.Stdout, "uid=%d(%s) gid=%d(%s) groups=%s\n",
+			account.uid, account.name, account.groups[0].gid, account.groups[0].name, strings.Join(groups, ","))
+	}
+	return 0
+}
+
+type accountGroup struct {
+	gid  int
+	name string
+}
+
+// A user and their groups, the primary group first.
+type account struct {
+	uid    int
+	name   string
+	groups []accountGroup
+}
+
+// What id says about a user with no entry in /etc/passwd: root, or the
+// first user Ubuntu's installer makes.
+func accountFor(user string) account {
+	if user == "root" {
+		return account{uid: 0, name: "root", groups: []accountGroup{{0, "root"}}}
+	}
+	return account{uid: 1000, name: user, groups: []accountGroup{{1000, user}}}
+}
+
+// Looks a user up in the fake /etc/passwd and /etc/group.
+func lookupAccount(root *files.FilesystemDir, user string) (account, bool) {
+	passwd, ok := readFile(root, "/etc/passwd")
+	if !ok {
+		return account{}, false
+	}
+	found := account{}
+	gid := -1
+	for _, line := range strings.Split(passwd, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 || fields[0] != user {
+			continue
+		}
+		uid, errUID := strconv.Atoi(fields[2])
+		primary, errGID := strconv.Atoi(fields[3])
+		if errUID != nil || errGID != nil {
+			continue
+		}
+		found = account{uid: uid, name: user}
+		gid = primary
+		break
+	}
+	if gid < 0 {
+		return account{}, false
+	}
+	group, _ := readFile(root, "/etc/group")
+	primaryName := strconv.Itoa(gid)
+	others := []accountGroup{}
+	for _, line := range strings.Split(group, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 {
+			continue
+		}
+		n, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		if n == gid {
+			primaryName = fields[0]
+			continue
+		}
+		for _, member := range strings.Split(fields[3], ",") {
+			if member == user {
+				others = append(others, accountGroup{n, fields[0]})
+			}
+		}
+	}
+	found.groups = append([]accountGroup{{gid, primaryName}}, others...)
+	return found, true
+}
+
+// The content of a regular file in the tree, if there is one at filePath.
+func readFile(root *files.FilesystemDir, filePath string) (string, bool) {
+	err, res := root.GetFileOrDir(root, filePath)
+	if err != nil {
+		return "", false
+	}
+	file, ok := res.(*files.FilesystemFile)
+	if !ok {
+		return "", false
+	}
+	return file.Read(), true
+}
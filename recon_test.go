@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Runs line in a non-interactive shell on state as user.
+func runTestShellAs(state *SessionState, user string, line string) (string, string, int) {
+	var stdout, stderr strings.Builder
+	status := newShell(newTestContext(user, "session"), state, false).Run(line, &stdout, &stderr)
+	return stdout.String(), stderr.String(), status
+}
+
+func TestHostname(t *testing.T) {
+	host := PERSONA.Hostname
+	tests := []struct {
+		user       string
+		line       string
+		wantStdout string
+		wantStderr string
+		wantStatus int
+	}{
+		{"root", "hostname", host + "\n", "", 0},
+		{"root", "hostname -I", PERSONA.Address + " \n", "", 0},
+		{"root", "hostname -i", PERSONA.Address + "\n", "", 0},
+		{"root", "hostname db.example.com; hostname; hostname -s; hostname -d", "db.example.com\ndb\nexample.com\n", "", 0},
+		{"root", "hostname db; uname -n; cat /proc/sys/kernel/hostname", "db\ndb\n", "", 0},
+		// As on a real machine, /etc/hostname is left for the next boot.
+		{"root", "hostname db; cat /etc/hostname", host + "\n", "", 0},
+		{"root", "hostname 'bad name'", "", "hostname: the specified hostname is invalid\n", 1},
+		{"root", "hostname " + strings.Repeat("a", 65), "", "hostname: name too long\n", 1},
+		{"ubuntu", "hostname db", "", "hostname: you must be root to change the host name\n", 1},
+		{"ubuntu", "hostname db; hostname", host + "\n", "hostname: you must be root to change the host name\n", 0},
+	}
+	for _, test := range tests {
+		stdout, stderr, status := runTestShellAs(newTestState(), test.user, test.line)
+		if stdout != test.wantStdout || stderr != test.wantStderr || status != test.wantStatus {
+			t.Errorf("%s: %q: got %q, %q, status %d, want %q, %q, status %d",
+				test.user, test.line, stdout, stderr, status, test.wantStdout, test.wantStderr, test.wantStatus)
+		}
+	}
+}
+
+func TestHostnameIsPerSession(t *testing.T) {
+	state := newTestState()
+	runTestShell(state, "hostname changed")
+	if stdout, _, _ := runTestShell(state, "hostname"); stdout != "changed\n" {
+		t.Errorf("the session's host name is %q, want changed", stdout)
+	}
+	if got := state.hostname(); got != "changed" {
+		t.Errorf("the prompt would show %q, want changed", got)
+	}
+	if stdout, _, _ := runTestShell(newTestState(), "hostname; uname -n"); stdout != PERSONA.Hostname+"\n"+PERSONA.Hostname+"\n" {
+		t.Errorf("another session's host name is %q", stdout)
+	}
+}
+
+func TestUname(t *testing.T) {
+	k := PERSONA.Kernel
+	tests := []struct {
+		line       string
+		wantStdout string
+		wantStatus int
+	}{
+		{"uname", "Linux\n", 0},
+		{"uname -r", k.Release + "\n", 0},
+		{"uname -m", k.Machine + "\n", 0},
+		{"uname -sn", "Linux " + PERSONA.Hostname + "\n", 0},
+		{"uname --kernel-release --machine", k.Release + " " + k.Machine + "\n", 0},
+		{"uname -a", strings.Join([]string{"Linux", PERSONA.Hostname, k.Release, k.Version, k.Machine, k.Machine, k.Machine, "GNU/Linux"}, " ") + "\n", 0},
+		{"uname -z", "", 1},
+		{"uname extra", "", 1},
+	}
+	for _, test := range tests {
+		stdout, _, status := runTestShell(newTestState(), test.line)
+		if stdout != test.wantStdout || status != test.wantStatus {
+			t.Errorf("%q: got %q, status %d, want %q, status %d", test.line, stdout, status, test.wantStdout, test.wantStatus)
+		}
+	}
+}
+
+func TestReconCommands(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"nproc", []string{"4\n"}},
+		{"nproc --ignore=1", []string{"3\n"}},
+		{"free -m", []string{"Mem:            7951        1184        4554           3        2213        6545\n", "Swap:              0           0           0\n"}},
+		{"free -h", []string{"Mem:           7.8Gi"}},
+		{"df -h /", []string{"/dev/vda1", " /\n"}},
+		{"lscpu", []string{"CPU(s):                  4\n", "On-line CPU(s) list:   0-3\n", "Hypervisor vendor:     KVM\n"}},
+		{"id", []string{"uid=0(root) gid=0(root) groups=0(root)\n"}},
+		{"uptime", []string{" up 41 days, "}},
+	}
+	for _, test := range tests {
+		stdout, stderr, _ := runTestShell(newTestState(), test.line)
+		for _, want := range test.want {
+			if !strings.Contains(stdout, want) {
+				t.Errorf("%q: %q doesn't hold %q%s", test.line, stdout, want, stderr)
+			}
+		}
+	}
+}
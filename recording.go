@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/honeystats/ssh/asciicast"
+	"github.com/sirupsen/logrus"
+)
+
+// Where interactive sessions are recorded. Empty turns recording off.
+var RECORDING_DIR = envOrDefault("RECORDING_DIR", "recordings")
+
+// Most bytes kept of one recording, and of all the recordings in
+// RECORDING_DIR together. Past either, a session stops being recorded.
+// Zero means no limit.
+var RECORDING_MAX_SIZE = int64(envIntOrDefault("RECORDING_MAX_SIZE", 10*1024*1024))
+var RECORDING_MAX_TOTAL = int64(envIntOrDefault("RECORDING_MAX_TOTAL", 1024*1024*1024))
+
+var errRecordingFull = errors.New("recording size limit reached")
+
+// Bytes used in RECORDING_DIR, counted from the files already there the
+// first time a recording starts.
+var recordingUsage struct {
+	sync.Mutex
+	bytes   int64
+	counted bool
+}
+
+// Reserves n bytes of RECORDING_MAX_TOTAL, failing if they don't fit.
+func reserveRecordingSpace(n int64) error {
+	recordingUsage.Lock()
+	defer recordingUsage.Unlock()
+	if !recordingUsage.counted {
+		infos, err := ioutil.ReadDir(RECORDING_DIR)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if !info.IsDir() {
+				recordingUsage.bytes += info.Size()
+			}
+		}
+		recordingUsage.counted = true
+	}
+	if RECORDING_MAX_TOTAL > 0 && recordingUsage.bytes+n > RECORDING_MAX_TOTAL {
+		return errRecordingFull
+	}
+	recordingUsage.bytes += n
+	return nil
+}
+
+// Recording sits between the shell and the ssh.Session, copying everything
+// the attacker types and sees into an asciicast v2 file.
+type Recording struct {
+	Path string
+
+	session ssh.Session
+	file    *os.File
+	cast    *asciicast.Writer
+
+	mu     sync.Mutex
+	failed bool
+	size   int64
+	// Set when the recording stopped early for want of space.
+	truncated bool
+}
+
+// Starts recording s, sized from its PTY request or 80x24 without one.
+func startRecording(s ssh.Session) (*Recording, error) {
+	if err := os.MkdirAll(RECORDING_DIR, 0700); err != nil {
+		return nil, err
+	}
+	ctx := s.Context().(ssh.Context)
+	header := asciicast.Header{
+		Width:  80,
+		Height: 24,
+		Title:  s.User() + "@" + PERSONA.Hostname,
+		Env:    map[string]string{"SHELL": "/bin/bash"},
+	}
+	if pty, _, ok := s.Pty(); ok {
+		if pty.Window.Width > 0 && pty.Window.Height > 0 {
+			header.Width = pty.Window.Width
+			header.Height = pty.Window.Height
+		}
+		header.Env["TERM"] = pty.Term
+	}
+	r, err := openRecording(ctx.SessionID(), header)
+	if err != nil {
+		return nil, err
+	}
+	r.session = s
+	return r, nil
+}
+
+// Creates the recording file and writes its header.
+func openRecording(sessionID string, header asciicast.Header) (*Recording, error) {
+	file, err := createRecordingFile(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recording{
+		Path: file.Name(),
+		file: file,
+	}
+	r.cast, err = asciicast.NewWriter(recordingFile{r}, header)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return r, nil
+}
+
+// One connection can open more than one shell, so later recordings for the
+// same session get a numeric suffix.
+func createRecordingFile(sessionID string) (*os.File, error) {
+	name := filepath.Join(RECORDING_DIR, sessionID+".cast")
+	for i := 1; ; i++ {
+		file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if !os.IsExist(err) {
+			return file, err
+		}
+		name = filepath.Join(RECORDING_DIR, fmt.Sprintf("%s-%d.cast", sessionID, i))
+	}
+}
+
+// The recording's file, as the asciicast writer sees it.
+type recordingFile struct {
+	r *Recording
+}
+
+// Writes one whole line of the recording, or nothing if it would take the
+// recording past RECORDING_MAX_SIZE or RECORDING_DIR past RECORDING_MAX_TOTAL.
+func (f recordingFile) Write(p []byte) (int, error) {
+	r := f.r
+	if RECORDING_MAX_SIZE > 0 && r.size+int64(len(p)) > RECORDING_MAX_SIZE {
+		return 0, errRecordingFull
+	}
+	if err := reserveRecordingSpace(int64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *Recording) record(eventType string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failed {
+		return
+	}
+	err := r.cast.WriteEvent(eventType, string(data))
+	if err == errRecordingFull {
+		r.failed = true
+		r.truncated = true
+		logrus.WithField("path", r.Path).Warnln("Session recording reached its size limit, stopping it")
+	} else if err != nil {
+		// Losing the recording mustn't end the session.
+		r.failed = true
+		logrus.WithFields(logrus.Fields{
+			"path": r.Path,
+			"err":  err,
+		}).Errorln("Error writing session recording, giving up on it")
+	}
+}
+
+func (r *Recording) Read(p []byte) (int, error) {
+	n, err := r.session.Read(p)
+	r.record(asciicast.Input, p[:n])
+	return n, err
+}
+
+func (r *Recording) Write(p []byte) (int, error) {
+	n, err := r.session.Write(p)
+	r.record(asciicast.Output, p[:n])
+	return n, err
+}
+
+// Whether the recording was cut short by the size limits.
+func (r *Recording) Truncated() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.truncated
+}
+
+func (r *Recording) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failed = true
+	return r.file.Close()
+}
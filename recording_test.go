@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/honeystats/ssh/asciicast"
+)
+
+func TestRecordingLimits(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxSize       int64
+		maxTotal      int64
+		existing      int
+		writes        int
+		wantEvents    int
+		wantTruncated bool
+	}{
+		{"no limits", 0, 0, 0, 10, 10, false},
+		{"under the limits", 1 << 20, 1 << 20, 0, 10, 10, false},
+		{"per recording", 1000, 0, 0, 10, 4, true},
+		{"total", 0, 1000, 0, 10, 4, true},
+		{"total counts other recordings", 0, 1500, 500, 10, 4, true},
+	}
+	defer func(dir string, maxSize, maxTotal int64) {
+		RECORDING_DIR, RECORDING_MAX_SIZE, RECORDING_MAX_TOTAL = dir, maxSize, maxTotal
+		recordingUsage.bytes, recordingUsage.counted = 0, false
+	}(RECORDING_DIR, RECORDING_MAX_SIZE, RECORDING_MAX_TOTAL)
+	for _, test := range tests {
+		RECORDING_DIR = t.TempDir()
+		RECORDING_MAX_SIZE, RECORDING_MAX_TOTAL = test.maxSize, test.maxTotal
+		recordingUsage.bytes, recordingUsage.counted = 0, false
+		if test.existing > 0 {
+			other := filepath.Join(RECORDING_DIR, "other.cast")
+			if err := ioutil.WriteFile(other, make([]byte, test.existing), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rec, err := openRecording("session", asciicast.Header{Width: 80, Height: 24})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		// Each event is a line of about 200 bytes.
+		for i := 0; i < test.writes; i++ {
+			rec.record(asciicast.Output, []byte(strings.Repeat("x", 180)))
+		}
+		if rec.Truncated() != test.wantTruncated {
+			t.Errorf("%s: truncated %v, want %v", test.name, rec.Truncated(), test.wantTruncated)
+		}
+		rec.Close()
+		f, err := os.Open(rec.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cast, err := asciicast.Read(f)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: reading the recording: %v", test.name, err)
+			continue
+		}
+		if len(cast.Events) != test.wantEvents {
+			t.Errorf("%s: %d events recorded, want %d", test.name, len(cast.Events), test.wantEvents)
+		}
+	}
+}
+
+// With RECORDING_DIR already full, sessions aren't recorded at all.
+func TestRecordingDirFull(t *testing.T) {
+	defer func(dir string, maxTotal int64) {
+		RECORDING_DIR, RECORDING_MAX_TOTAL = dir, maxTotal
+		recordingUsage.bytes, recordingUsage.counted = 0, false
+	}(RECORDING_DIR, RECORDING_MAX_TOTAL)
+	RECORDING_DIR = t.TempDir()
+	RECORDING_MAX_TOTAL = 100
+	recordingUsage.bytes, recordingUsage.counted = 0, false
+	if err := ioutil.WriteFile(filepath.Join(RECORDING_DIR, "other.cast"), make([]byte, 100), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openRecording("session", asciicast.Header{Width: 80, Height: 24}); err != errRecordingFull {
+		t.Errorf("opening a recording in a full directory: %v, want %v", err, errRecordingFull)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/honeystats/ssh/files"
+	"github.com/sirupsen/logrus"
+)
+
+type DocScp struct {
+	Direction string `json:"direction"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (_ DocScp) action() string {
+	return "scp"
+}
+
+func (d DocScp) payloadRefs() []string {
+	if d.SHA256 == "" {
+		return nil
+	}
+	return []string{d.SHA256}
+}
+
+type scpOptions struct {
+	sink      bool // -t, we receive files
+	source    bool // -f, we send files
+	recursive bool
+	target    string
+}
+
+// Recognises the remote half of scp, e.g. `scp -t /tmp/x` or `scp -r -f /etc`.
+func parseScpCommand(cmd string) (scpOptions, bool) {
+	fields := strings.Fields(cmd)
+	opts := scpOptions{}
+	if len(fields) < 2 || fields[0] != "scp" {
+		return opts, false
+	}
+	for _, field := range fields[1:] {
+		if strings.HasPrefix(field, "-") && opts.target == "" {
+			for _, flag := range field[1:] {
+				switch flag {
+				case 't':
+					opts.sink = true
+				case 'f':
+					opts.source = true
+				case 'r':
+					opts.recursive = true
+				}
+			}
+			continue
+		}
+		opts.target = field
+	}
+	if opts.sink == opts.source || opts.target == "" {
+		return opts, false
+	}
+	return opts, true
+}
+
+// Speaks the legacy SCP protocol over an exec channel and returns the exit
+// status to report.
+func scpHandler(s ssh.Session, state *SessionState, opts scpOptions) int {
+	ctx := s.Context().(ssh.Context)
+	logrus.WithFields(logrus.Fields{
+		"user":   s.User(),
+		"id":     ctx.SessionID(),
+		"sink":   opts.sink,
+		"target": opts.target,
+	}).Infoln("SCP transfer started")
+	transfer := &scpTransfer{
+		ctx:    ctx,
+		state:  state,
+		reader: bufio.NewReader(s),
+		writer: s,
+	}
+	var err error
+	if opts.sink {
+		err = transfer.receive(opts)
+	} else {
+		err = transfer.send(opts)
+	}
+	if err != nil {
+		logrus.WithError(err).Debugln("SCP transfer failed")
+		return 1
+	}
+	return 0
+}
+
+type scpTransfer struct {
+	ctx    ssh.Context
+	state  *SessionState
+	reader *bufio.Reader
+	writer io.Writer
+}
+
+func (t *scpTransfer) ack() {
+	t.writer.Write([]byte{0})
+}
+
+// Sends an error the client prints as "scp: ...". Fatal errors end the
+// transfer.
+func (t *scpTransfer) fail(fatal bool, msg string) error {
+	code := byte(1)
+	if fatal {
+		code = 2
+	}
+	t.writer.Write(append([]byte{code}, []byte("scp: "+msg+"\n")...))
+	return errors.New(msg)
+}
+
+func (t *scpTransfer) readAck() error {
+	b, err := t.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b == 0 {
+		return nil
+	}
+	msg, _ := t.reader.ReadString('\n')
+	return errors.New(strings.TrimSpace(msg))
+}
+
+// Parses "C0644 12 name" or "D0755 0 name" into the size and name.
+func parseScpHeader(line string) (int64, string, error) {
+	parts := strings.SplitN(line[1:], " ", 3)
+	if len(parts) != 3 {
+		return 0, "", fmt.Errorf("bad header %q", line)
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, "", fmt.Errorf("bad size in %q", line)
+	}
+	name := parts[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return 0, "", fmt.Errorf("bad name %q", name)
+	}
+	return size, name, nil
+}
+
+// Sink mode (scp -t): the client pushes files to us.
+func (t *scpTransfer) receive(opts scpOptions) error {
+	targetIsDir := isDir(t.state, opts.target)
+	// Where a file or directory called name goes: into the directory we were
+	// last sent with D, else into the target directory, else the target
+	// itself.
+	dirs := []string{}
+	destFor := func(name string) string {
+		if len(dirs) > 0 {
+			return path.Join(dirs[len(dirs)-1], name)
+		}
+		if targetIsDir {
+			return path.Join(opts.target, name)
+		}
+		return opts.target
+	}
+	t.ack()
+	for {
+		line, err := t.reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return t.fail(true, "protocol error: empty line")
+		}
+		switch line[0] {
+		case 'C':
+			size, name, err := parseScpHeader(line)
+			if err != nil {
+				return t.fail(true, "protocol error: "+err.Error())
+			}
+			t.ack()
+			if err := t.receiveFile(destFor(name), size); err != nil {
+				return err
+			}
+		case 'D':
+			if !opts.recursive {
+				return t.fail(true, "received directory without -r")
+			}
+			_, name, err := parseScpHeader(line)
+			if err != nil {
+				return t.fail(true, "protocol error: "+err.Error())
+			}
+			dirPath := destFor(name)
+			if err := t.state.mkdir(dirPath); err != nil {
+				return t.fail(true, err.Error())
+			}
+			dirs = append(dirs, dirPath)
+			t.ack()
+		case 'E':
+			if len(dirs) > 0 {
+				dirs = dirs[:len(dirs)-1]
+			}
+			t.ack()
+		case 'T':
+			t.ack()
+		case '\x01', '\x02':
+			logrus.WithField("msg", line[1:]).Debugln("SCP client reported an error")
+			if line[0] == '\x02' {
+				return errors.New(line[1:])
+			}
+		default:
+			return t.fail(true, "protocol error: unexpected "+strconv.Quote(line))
+		}
+	}
+}
+
+func (t *scpTransfer) receiveFile(dest string, size int64) error {
+	doc := DocScp{
+		Direction: "upload",
+		Path:      dest,
+		Size:      size,
+	}
+	if size > UPLOAD_MAX_SIZE {
+		// Still read the file, or the client gets out of step with us.
+		io.CopyN(ioutil.Discard, t.reader, size+1)
+		doc.Error = errUploadTooLarge.Error()
+		emitEvent(t.ctx, t.state, doc)
+		return t.fail(false, dest+": File too large")
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(t.reader, data); err != nil {
+		return err
+	}
+	if err := t.readAck(); err != nil {
+		return err
+	}
+	hash, err := captureUpload(t.ctx, t.state, dest, data, "scp")
+	doc.SHA256 = hash
+	if err != nil {
+		doc.Error = err.Error()
+		emitEvent(t.ctx, t.state, doc)
+		return t.fail(false, dest+": "+err.Error())
+	}
+	emitEvent(t.ctx, t.state, doc)
+	t.ack()
+	return nil
+}
+
+// Source mode (scp -f): the client pulls files from us.
+func (t *scpTransfer) send(opts scpOptions) error {
+	if err := t.readAck(); err != nil {
+		return err
+	}
+	var entry *scpEntry
+	t.state.view(func(root *files.FilesystemDir, cwd *files.FilesystemDir) {
+		if err, res := cwd.GetFileOrDir(root, opts.target); err == nil {
+			entry = newScpEntry(res, opts.recursive)
+		}
+	})
+	if entry == nil {
+		emitEvent(t.ctx, t.state, DocScp{Direction: "download", Path: opts.target, Error: "not found"})
+		return t.fail(false, opts.target+": No such file or directory")
+	}
+	return t.sendEntry(entry, opts)
+}
+
+// What is sent for a file or directory, copied out of the filesystem with the
+// session locked so that the transfer itself can run without it.
+type scpEntry struct {
+	name    string
+	path    string
+	content string
+	isDir   bool
+	// Files first, then subdirectories, as they are sent.
+	children []*scpEntry
+}
+
+// Copies out res, and everything under it when recursive is set.
+func newScpEntry(res files.FileDir, recursive bool) *scpEntry {
+	switch f := res.(type) {
+	case *files.FilesystemFile:
+		return &scpEntry{name: f.Name, path: f.Path(), content: f.Read()}
+	case *files.FilesystemDir:
+		entry := &scpEntry{name: f.Name, path: f.Path(), isDir: true}
+		if !recursive {
+			return entry
+		}
+		for _, file := range f.Files {
+			entry.children = append(entry.children, newScpEntry(file, recursive))
+		}
+		for _, subdir := range f.Subdirs {
+			entry.children = append(entry.children, newScpEntry(subdir, recursive))
+		}
+		return entry
+	}
+	return nil
+}
+
+func (t *scpTransfer) sendEntry(entry *scpEntry, opts scpOptions) error {
+	if !entry.isDir {
+		emitEvent(t.ctx, t.state, DocScp{Direction: "download", Path: entry.path, Size: int64(len(entry.content))})
+		fmt.Fprintf(t.writer, "C0644 %d %s\n", len(entry.content), entry.name)
+		if err := t.readAck(); err != nil {
+			return err
+		}
+		io.WriteString(t.writer, entry.content)
+		t.ack()
+		return t.readAck()
+	}
+	if !opts.recursive {
+		return t.fail(false, entry.name+": not a regular file")
+	}
+	fmt.Fprintf(t.writer, "D0755 0 %s\n", entry.name)
+	if err := t.readAck(); err != nil {
+		return err
+	}
+	for _, child := range entry.children {
+		if child == nil {
+			continue
+		}
+		if err := t.sendEntry(child, opts); err != nil {
+			return err
+		}
+	}
+	io.WriteString(t.writer, "E\n")
+	return t.readAck()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseScpCommand(t *testing.T) {
+	tests := []struct {
+		cmd    string
+		want   scpOptions
+		wantOk bool
+	}{
+		{"scp -t /a", scpOptions{sink: true, target: "/a"}, true},
+		{"scp -f /etc/passwd", scpOptions{source: true, target: "/etc/passwd"}, true},
+		{"scp -r -f /etc", scpOptions{source: true, recursive: true, target: "/etc"}, true},
+		{"scp -rt /a", scpOptions{sink: true, recursive: true, target: "/a"}, true},
+		{"scp -v -d -t -- /a", scpOptions{sink: true, target: "/a"}, true},
+		{"scp -t -f /a", scpOptions{}, false},
+		{"scp -t", scpOptions{}, false},
+		{"scp /a", scpOptions{}, false},
+		{"ls -t /a", scpOptions{}, false},
+	}
+	for _, test := range tests {
+		got, ok := parseScpCommand(test.cmd)
+		if ok != test.wantOk || (ok && got != test.want) {
+			t.Errorf("parseScpCommand(%q) = %+v, %v, want %+v, %v", test.cmd, got, ok, test.want, test.wantOk)
+		}
+	}
+}
+
+func TestParseScpHeader(t *testing.T) {
+	tests := []struct {
+		line     string
+		wantSize int64
+		wantName string
+		wantErr  bool
+	}{
+		{"C0644 12 name", 12, "name", false},
+		{"D0755 0 dir", 0, "dir", false},
+		{"C0644 5 with space", 5, "with space", false},
+		{"C0644 12", 0, "", true},
+		{"C0644 -1 name", 0, "", true},
+		{"C0644 x name", 0, "", true},
+		{"C0644 1 ..", 0, "", true},
+		{"C0644 1 ../../etc/passwd", 0, "", true},
+	}
+	for _, test := range tests {
+		size, name, err := parseScpHeader(test.line)
+		if (err != nil) != test.wantErr || size != test.wantSize || name != test.wantName {
+			t.Errorf("parseScpHeader(%q) = %d, %q, %v, want %d, %q, error %v",
+				test.line, size, name, err, test.wantSize, test.wantName, test.wantErr)
+		}
+	}
+}
+
+// Runs one end of an SCP transfer against what the client sends, returning
+// what we sent back.
+func runScpTransfer(state *SessionState, opts scpOptions, client string) (string, error) {
+	var out bytes.Buffer
+	transfer := &scpTransfer{
+		ctx:    newTestContext("root", "session"),
+		state:  state,
+		reader: bufio.NewReader(strings.NewReader(client)),
+		writer: &out,
+	}
+	var err error
+	if opts.sink {
+		err = transfer.receive(opts)
+	} else {
+		err = transfer.send(opts)
+	}
+	return out.String(), err
+}
+
+func TestScpReceive(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      scpOptions
+		client    string
+		wantFiles map[string]string
+		wantErr   bool
+	}{
+		{
+			"file into a directory",
+			scpOptions{sink: true, target: "/a"},
+			"C0644 5 up\nhello\x00",
+			map[string]string{"/a/up": "hello"},
+			false,
+		},
+		{
+			"file to a new name",
+			scpOptions{sink: true, target: "/a/renamed"},
+			"C0644 2 up\nhi\x00",
+			map[string]string{"/a/renamed": "hi"},
+			false,
+		},
+		{
+			"directory tree",
+			scpOptions{sink: true, recursive: true, target: "/a"},
+			"D0755 0 tree\nC0644 1 one\n1\x00D0755 0 sub\nC0644 1 two\n2\x00E\nE\n",
+			map[string]string{"/a/tree/one": "1", "/a/tree/sub/two": "2"},
+			false,
+		},
+		{
+			"directory without -r",
+			scpOptions{sink: true, target: "/a"},
+			"D0755 0 tree\n",
+			nil,
+			true,
+		},
+		{
+			"bad header",
+			scpOptions{sink: true, target: "/a"},
+			"C0644 1 ../escape\nx\x00",
+			nil,
+			true,
+		},
+	}
+	for _, test := range tests {
+		state := newTestState()
+		_, err := runScpTransfer(state, test.opts, test.client)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, want error %v", test.name, err, test.wantErr)
+		}
+		for p, want := range test.wantFiles {
+			if got, _, _ := runTestShell(state, "cat "+p); got != want {
+				t.Errorf("%s: %s holds %q, want %q", test.name, p, got, want)
+			}
+		}
+	}
+}
+
+func TestScpSend(t *testing.T) {
+	acks := strings.Repeat("\x00", 10)
+	tests := []struct {
+		name    string
+		opts    scpOptions
+		want    string
+		wantErr bool
+	}{
+		{
+			"file",
+			scpOptions{source: true, target: "/a/somefile"},
+			"C0644 24 somefile\nthis is file a/somefile\n\x00",
+			false,
+		},
+		{
+			"directory",
+			scpOptions{source: true, recursive: true, target: "/a/b"},
+			"D0755 0 b\nC0644 23 someotherfile\nthis\nfile\nhas\nnewlines\n\x00E\n",
+			false,
+		},
+		{
+			"directory without -r",
+			scpOptions{source: true, target: "/a/b"},
+			"\x01scp: b: not a regular file\n",
+			true,
+		},
+		{
+			"missing",
+			scpOptions{source: true, target: "/nowhere"},
+			"\x01scp: /nowhere: No such file or directory\n",
+			true,
+		},
+	}
+	for _, test := range tests {
+		got, err := runScpTransfer(newTestState(), test.opts, acks)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("%s: sent %q, error %v, want %q, error %v", test.name, got, err, test.want, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/honeystats/ssh/files"
+	"github.com/sirupsen/logrus"
+)
+
+type SSHKey struct {
+	Key         string `json:"key"`
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type SessionState struct {
+	Root *files.FilesystemDir `json:"-"`
+	Cwd  *files.FilesystemDir `json:"cwd"`
+	// The working directory as the shell shows it, through any symlinks
+	// that were followed to get there.
+	Pwd       string   `json:"pwd"`
+	Passwords []string `json:"passwords"`
+	Keys      []SSHKey `json:"keys"`
+	History   []string `json:"history"`
+	// Nil unless the client asked for a PTY.
+	Terminal *Terminal `json:"terminal,omitempty"`
+
+	// Guards the fields above against the auth callbacks, shell handlers and
+	// event emitters of one connection, which run on different goroutines.
+	mu       sync.Mutex
+	lastSeen time.Time
+	// When the connection was made, which w shows as its login time.
+	started time.Time
+	// Whether Root is this session's own copy of the filesystem, rather than
+	// the shared FILESYSTEM.Root.
+	ownRoot bool
+	// The tree the session started from, which its changes are measured
+	// against.
+	base *files.FilesystemDir
+	// Where the session's environment is kept between connections, once it
+	// has been looked up. Empty unless sticky environments are on.
+	envKey string
+	// Shell variables, seeded when the first shell starts.
+	vars map[string]*shellVar
+}
+
+func (state *SessionState) touch() {
+	state.mu.Lock()
+	state.lastSeen = time.Now()
+	state.mu.Unlock()
+}
+
+func (state *SessionState) idleSince() time.Time {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.lastSeen
+}
+
+func (state *SessionState) addPassword(password string) {
+	state.mu.Lock()
+	state.Passwords = append(state.Passwords, password)
+	state.lastSeen = time.Now()
+	state.mu.Unlock()
+}
+
+func (state *SessionState) addKey(key SSHKey) {
+	state.mu.Lock()
+	state.Keys = append(state.Keys, key)
+	state.lastSeen = time.Now()
+	state.mu.Unlock()
+}
+
+// Same as bash's default HISTSIZE.
+const maxHistory = 1000
+
+// Records a command line in the shell history, skipping blank lines and
+// immediate repeats.
+func (state *SessionState) addHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if len(state.History) > 0 && state.History[len(state.History)-1] == line {
+		return
+	}
+	state.History = append(state.History, line)
+	if len(state.History) > maxHistory {
+		state.History = state.History[len(state.History)-maxHistory:]
+	}
+}
+
+func (state *SessionState) history() []string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return append([]string{}, state.History...)
+}
+
+func (state *SessionState) setTerminal(term string, width int, height int) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.Terminal = &Terminal{
+		Term:    term,
+		Width:   width,
+		Height:  height,
+		Resizes: []WindowSize{},
+	}
+}
+
+func (state *SessionState) resize(width int, height int) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.Terminal == nil {
+		return
+	}
+	state.Terminal.Width = width
+	state.Terminal.Height = height
+	if len(state.Terminal.Resizes) < maxResizes {
+		state.Terminal.Resizes = append(state.Terminal.Resizes, WindowSize{
+			Width:  width,
+			Height: height,
+			Time:   time.Now(),
+		})
+	}
+}
+
+func (state *SessionState) hasPty() bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.Terminal != nil
+}
+
+// Sessions without a PTY get plain output, one entry per line, as if
+// stdout were a pipe.
+func (state *SessionState) outputStyle() outputStyle {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.Terminal == nil {
+		return outputStyle{}
+	}
+	width := state.Terminal.Width
+	if width <= 0 {
+		width = 80
+	}
+	return outputStyle{Width: width, Colour: true}
+}
+
+func (state *SessionState) pwd() string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.Pwd
+}
+
+// Copies out what an SSHDoc needs, so the document can't change after it has
+// been built.
+func (state *SessionState) snapshot() (string, []string, []SSHKey) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	passwords := append([]string{}, state.Passwords...)
+	keys := append([]SSHKey{}, state.Keys...)
+	return state.Pwd, passwords, keys
+}
+
+// Map from session ID to session state. Entries are removed when their
+// connection closes, or once they have been idle for IdleTTL. Every
+// connection let in holds a place in the map until it closes, and while
+// MaxSessions places are taken, new connections are refused. When
+// Environments is set, what a session leaves behind is kept there for the
+// attacker's next one.
+type SessionMap struct {
+	MaxSessions  int
+	IdleTTL      time.Duration
+	Environments *EnvironmentStore
+
+	mu       sync.Mutex
+	sessions map[string]*SessionState
+	// Connections let in whose session hasn't been created yet.
+	reserved int
+}
+
+// The place in the map held for one connection. The connection's session
+// takes it over when it is created.
+type sessionSlot struct {
+	reserved bool
+	id       string
+}
+
+type contextKey string
+
+const contextKeySessionSlot = contextKey("sessionSlot")
+
+var sessionMap = &SessionMap{
+	MaxSessions: 10000,
+	IdleTTL:     time.Hour,
+	sessions:    map[string]*SessionState{},
+}
+
+func setupSessionMap() {
+	sessionMap.MaxSessions = envIntOrDefault("MAX_SESSIONS", sessionMap.MaxSessions)
+	sessionMap.IdleTTL = envDurationOrDefault("SESSION_IDLE_TTL", sessionMap.IdleTTL)
+	go sessionMap.janitor(time.Minute)
+}
+
+// Returns the connection's session, creating it the first time.
+func (m *SessionMap) getOrCreate(ctx ssh.Context) *SessionState {
+	id := ctx.SessionID()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, exists := m.sessions[id]
+	if exists {
+		state.touch()
+		return state
+	}
+	if slot, ok := ctx.Value(contextKeySessionSlot).(*sessionSlot); ok {
+		if slot.reserved {
+			slot.reserved = false
+			m.reserved--
+		}
+		slot.id = id
+	}
+	newState := &SessionState{
+		Root:      FILESYSTEM.Root,
+		Cwd:       FILESYSTEM.Root,
+		Pwd:       "/",
+		base:      FILESYSTEM.Root,
+		Passwords: []string{},
+		Keys:      []SSHKey{},
+		History:   []string{},
+		lastSeen:  time.Now(),
+		started:   time.Now(),
+	}
+	m.sessions[id] = newState
+	return newState
+}
+
+// Like getOrCreate, but the first time a shell or SFTP session asks, it
+// also picks up the attacker's kept environment. Returns whether there was
+// one to pick up.
+func (m *SessionMap) getOrCreateWithEnvironment(ctx ssh.Context) (*SessionState, bool) {
+	state := m.getOrCreate(ctx)
+	if m.Environments == nil {
+		return state, false
+	}
+	key := environmentKey(ctx, state)
+	return state, state.restoreEnvironment(key, m.Environments.get(key))
+}
+
+// Keeps what the session leaves behind, if it got as far as a shell.
+func (m *SessionMap) keepEnvironment(state *SessionState) {
+	if m.Environments == nil {
+		return
+	}
+	key, env := state.saveEnvironment()
+	if key == "" {
+		return
+	}
+	env.Size = environmentSize(env.Root)
+	m.Environments.put(key, env)
+}
+
+func (m *SessionMap) remove(id string) {
+	m.mu.Lock()
+	state, exists := m.sessions[id]
+	delete(m.sessions, id)
+	m.mu.Unlock()
+	if exists {
+		m.keepEnvironment(state)
+	}
+}
+
+func (m *SessionMap) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sessions)
+}
+
+func (m *SessionMap) evictIdle() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.evictIdleLocked()
+}
+
+func (m *SessionMap) evictIdleLocked() int {
+	if m.IdleTTL <= 0 {
+		return 0
+	}
+	evicted := 0
+	for id, state := range m.sessions {
+		if time.Since(state.idleSince()) > m.IdleTTL {
+			delete(m.sessions, id)
+			go m.keepEnvironment(state)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+func (m *SessionMap) janitor(interval time.Duration) {
+	for range time.Tick(interval) {
+		if evicted := m.evictIdle(); evicted > 0 {
+			logrus.WithField("count", evicted).Debugln("Evicted idle sessions")
+		}
+	}
+}
+
+// Used as the server's ConnCallback. Refuses the connection when the map is
+// full, and otherwise holds a place for its session and arranges for the
+// session to be dropped from the map once the connection closes.
+func (m *SessionMap) connCallback(ctx ssh.Context, conn net.Conn) net.Conn {
+	m.mu.Lock()
+	if m.fullLocked() {
+		m.evictIdleLocked()
+	}
+	if m.fullLocked() {
+		m.mu.Unlock()
+		logrus.WithField("remote", conn.RemoteAddr().String()).Warnln("Session limit reached, refusing connection")
+		return nil
+	}
+	slot := &sessionSlot{reserved: true}
+	m.reserved++
+	m.mu.Unlock()
+	ctx.SetValue(contextKeySessionSlot, slot)
+	done := ctx.Done()
+	go func() {
+		<-done
+		m.mu.Lock()
+		if slot.reserved {
+			slot.reserved = false
+			m.reserved--
+		}
+		// The session only exists once the client has tried to log in.
+		id := slot.id
+		m.mu.Unlock()
+		if id != "" {
+			m.remove(id)
+		}
+	}()
+	return conn
+}
+
+func (m *SessionMap) fullLocked() bool {
+	return m.MaxSessions > 0 && len(m.sessions)+m.reserved >= m.MaxSessions
+}
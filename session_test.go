@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestSessionMap(max int) *SessionMap {
+	return &SessionMap{
+		MaxSessions: max,
+		IdleTTL:     time.Hour,
+		sessions:    map[string]*SessionState{},
+	}
+}
+
+// Lets a connection in through connCallback, reporting whether it was.
+func connect(m *SessionMap, ctx *testContext) bool {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	return m.connCallback(ctx, server) != nil
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSessionMapLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int
+		conns    int
+		loggedIn int
+		want     int
+	}{
+		{"unlimited", 0, 5, 5, 5},
+		{"under the limit", 5, 3, 3, 3},
+		{"at the limit", 3, 5, 3, 3},
+		{"places held before login", 3, 5, 0, 3},
+	}
+	for _, test := range tests {
+		m := newTestSessionMap(test.max)
+		accepted := 0
+		for i := 0; i < test.conns; i++ {
+			ctx := newTestContext("root", fmt.Sprint(i))
+			defer ctx.cancel()
+			if !connect(m, ctx) {
+				continue
+			}
+			accepted++
+			if i < test.loggedIn {
+				m.getOrCreate(ctx)
+			}
+		}
+		if accepted != test.want {
+			t.Errorf("%s: %d connections let in, want %d", test.name, accepted, test.want)
+		}
+	}
+}
+
+func TestSessionMapLimitIsAtomic(t *testing.T) {
+	m := newTestSessionMap(10)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < 100; i++ {
+		ctx := newTestContext("root", fmt.Sprint(i))
+		defer ctx.cancel()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if connect(m, ctx) {
+				m.getOrCreate(ctx)
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 10 || m.Len() != 10 {
+		t.Errorf("%d connections let in and %d sessions, want 10 of each", accepted, m.Len())
+	}
+}
+
+func TestSessionMapFreesPlaceOnClose(t *testing.T) {
+	tests := []struct {
+		name     string
+		loggedIn bool
+	}{
+		{"before login", false},
+		{"after login", true},
+	}
+	for _, test := range tests {
+		m := newTestSessionMap(1)
+		first := newTestContext("root", "first")
+		if !connect(m, first) {
+			t.Fatalf("%s: first connection refused", test.name)
+		}
+		if test.loggedIn {
+			m.getOrCreate(first)
+		}
+		second := newTestContext("root", "second")
+		defer second.cancel()
+		if connect(m, second) {
+			t.Fatalf("%s: second connection let in over the limit", test.name)
+		}
+		first.cancel()
+		waitFor(t, "the first connection's place to be freed", func() bool {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			return len(m.sessions)+m.reserved == 0
+		})
+		if !connect(m, second) {
+			t.Errorf("%s: connection refused after the first one closed", test.name)
+		}
+	}
+}
+
+func TestSessionMapEvictIdle(t *testing.T) {
+	m := newTestSessionMap(0)
+	idle := m.getOrCreate(newTestContext("root", "idle"))
+	busy := m.getOrCreate(newTestContext("root", "busy"))
+	idle.lastSeen = time.Now().Add(-2 * time.Hour)
+	busy.lastSeen = time.Now().Add(-2 * time.Hour)
+	editor := newLineEditor(ioutil.Discard, busy)
+	editor.Submit()
+	if evicted := m.evictIdle(); evicted != 1 {
+		t.Errorf("evicted %d sessions, want 1", evicted)
+	}
+	if _, ok := m.sessions["busy"]; !ok {
+		t.Error("session that just submitted a line was evicted")
+	}
+}
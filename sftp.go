@@ -0,0 +1,411 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/honeystats/ssh/files"
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+)
+
+// Largest upload we keep in memory before refusing the rest of it.
+var UPLOAD_MAX_SIZE = int64(envIntOrDefault("UPLOAD_MAX_SIZE", 64*1024*1024))
+
+type DocSftp struct {
+	Operation string `json:"operation"`
+	Path      string `json:"path"`
+	Target    string `json:"target,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (_ DocSftp) action() string {
+	return "sftp"
+}
+
+func (d DocSftp) payloadRefs() []string {
+	if d.SHA256 == "" {
+		return nil
+	}
+	return []string{d.SHA256}
+}
+
+func sftpHandler(s ssh.Session) {
+	ctx := s.Context().(ssh.Context)
+	state, returning := sessionMap.getOrCreateWithEnvironment(ctx)
+	logrus.WithFields(logrus.Fields{
+		"user":      s.User(),
+		"id":        ctx.SessionID(),
+		"returning": returning,
+	}).Infoln("SFTP session opened")
+	fs := &sftpFS{ctx: ctx, state: state}
+	server := sftp.NewRequestServer(s, sftp.Handlers{
+		FileGet:  fs,
+		FilePut:  fs,
+		FileCmd:  fs,
+		FileList: fs,
+	})
+	if err := server.Serve(); err != nil && err != io.EOF {
+		logrus.WithError(err).Debugln("SFTP session ended with error")
+	}
+	server.Close()
+	emitFilesystemDiff(ctx, state)
+}
+
+// sftpFS serves SFTP requests from a session's fake filesystem.
+type sftpFS struct {
+	ctx   ssh.Context
+	state *SessionState
+}
+
+func (fs *sftpFS) log(doc DocSftp, err error) {
+	if err != nil {
+		doc.Error = err.Error()
+	}
+	emitEvent(fs.ctx, fs.state, doc)
+}
+
+// Looks p up in the session's filesystem and runs fn on what it finds, with
+// the session locked.
+func (fs *sftpFS) lookup(p string, fn func(res files.FileDir)) error {
+	err := os.ErrNotExist
+	fs.state.view(func(root *files.FilesystemDir, cwd *files.FilesystemDir) {
+		lookupErr, res := root.GetFileOrDir(root, p)
+		if lookupErr == nil {
+			err = nil
+			fn(res)
+		}
+	})
+	return err
+}
+
+func (fs *sftpFS) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	var content string
+	isFile := false
+	err := fs.lookup(r.Filepath, func(res files.FileDir) {
+		if file, ok := res.(*files.FilesystemFile); ok {
+			content, isFile = file.Read(), true
+		}
+	})
+	if err == nil {
+		if isFile {
+			fs.log(DocSftp{Operation: "get", Path: r.Filepath, Size: int64(len(content))}, nil)
+			return strings.NewReader(content), nil
+		}
+		err = sftp.ErrSSHFxFailure
+	}
+	fs.log(DocSftp{Operation: "get", Path: r.Filepath}, err)
+	return nil, err
+}
+
+func (fs *sftpFS) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	isDir := false
+	err := fs.lookup(path.Dir(r.Filepath), func(res files.FileDir) {
+		_, isDir = res.(*files.FilesystemDir)
+	})
+	if err == nil && !isDir {
+		err = sftp.ErrSSHFxNoSuchFile
+	}
+	if err != nil {
+		fs.log(DocSftp{Operation: "put", Path: r.Filepath}, err)
+		return nil, err
+	}
+	return &uploadBuffer{
+		onClose: func(data []byte) {
+			hash, err := captureUpload(fs.ctx, fs.state, r.Filepath, data, "sftp")
+			fs.log(DocSftp{
+				Operation: "put",
+				Path:      r.Filepath,
+				Size:      int64(len(data)),
+				SHA256:    hash,
+			}, err)
+		},
+	}, nil
+}
+
+// Runs fn on the directory holding p and the name p has in it, in the
+// session's own copy of the filesystem.
+func (fs *sftpFS) mutate(p string, fn func(root *files.FilesystemDir, dir *files.FilesystemDir, name string) error) error {
+	err := fs.state.mutate(func(root *files.FilesystemDir, cwd *files.FilesystemDir) error {
+		dir, name, err := parentAndName(root, root, p)
+		if err != nil {
+			return err
+		}
+		return fn(root, dir, name)
+	})
+	if err == errNoEntry {
+		return os.ErrNotExist
+	}
+	return err
+}
+
+func (fs *sftpFS) Filecmd(r *sftp.Request) error {
+	doc := DocSftp{Operation: strings.ToLower(r.Method), Path: r.Filepath, Target: r.Target}
+	var err error
+	switch r.Method {
+	case "Setstat":
+		err = fs.lookup(r.Filepath, func(files.FileDir) {})
+	case "Mkdir":
+		err = fs.mutate(r.Filepath, func(root *files.FilesystemDir, dir *files.FilesystemDir, name string) error {
+			if _, exists := dir.Entry(name); exists {
+				return errExists
+			}
+			err, _ := dir.Mkdir(name)
+			return err
+		})
+	case "Remove", "Rmdir":
+		err = fs.mutate(r.Filepath, func(root *files.FilesystemDir, dir *files.FilesystemDir, name string) error {
+			entry, exists := dir.Entry(name)
+			if !exists {
+				return errNoEntry
+			}
+			sub, isDir := entry.(*files.FilesystemDir)
+			switch {
+			case isDir && r.Method == "Remove":
+				return errIsDir
+			case !isDir && r.Method == "Rmdir":
+				return errNotDir
+			case isDir && len(sub.Subdirs)+len(sub.Files)+len(sub.Links) > 0:
+				return errNotEmpty
+			}
+			_, err := dir.Detach(name)
+			return err
+		})
+	case "Rename":
+		err = fs.rename(r.Filepath, r.Target, false)
+	default:
+		err = sftp.ErrSSHFxPermissionDenied
+	}
+	fs.log(doc, err)
+	return err
+}
+
+// Handles posix-rename@openssh.com, which unlike Rename replaces the target.
+func (fs *sftpFS) PosixRename(r *sftp.Request) error {
+	err := fs.rename(r.Filepath, r.Target, true)
+	fs.log(DocSftp{Operation: "posixrename", Path: r.Filepath, Target: r.Target}, err)
+	return err
+}
+
+// Moves from to to, replacing what's at to the way mv would if replace is
+// set, and failing if there's anything there otherwise.
+func (fs *sftpFS) rename(from string, to string, replace bool) error {
+	return fs.mutate(from, func(root *files.FilesystemDir, srcDir *files.FilesystemDir, srcName string) error {
+		entry, exists := srcDir.Entry(srcName)
+		if !exists {
+			return errNoEntry
+		}
+		destDir, destName, err := parentAndName(root, root, to)
+		if err != nil {
+			return err
+		}
+		if replace {
+			if err := makeRoom(destDir, destName, entry); err != nil {
+				if err.Error() == "same file" {
+					return nil
+				}
+				return err
+			}
+		} else if _, exists := destDir.Entry(destName); exists {
+			return errExists
+		}
+		node, err := srcDir.Detach(srcName)
+		if err != nil {
+			return err
+		}
+		if err := destDir.Attach(node, destName); err != nil {
+			// Put it back rather than lose it.
+			srcDir.Attach(node, srcName)
+			return err
+		}
+		return nil
+	})
+}
+
+func (fs *sftpFS) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	doc := DocSftp{Operation: strings.ToLower(r.Method), Path: r.Filepath}
+	var infos listerAt
+	var err error
+	switch r.Method {
+	case "List":
+		err = fs.lookup(r.Filepath, func(res files.FileDir) {
+			dir, ok := res.(*files.FilesystemDir)
+			if !ok {
+				return
+			}
+			infos = listerAt{}
+			for _, subdir := range dir.Subdirs {
+				infos = append(infos, newFileDirInfo(subdir, ""))
+			}
+			for _, file := range dir.Files {
+				infos = append(infos, newFileDirInfo(file, ""))
+			}
+			for _, link := range dir.Links {
+				if f, ok := dir.Entry(link.Name); ok {
+					infos = append(infos, newFileDirInfo(f, link.Name))
+				}
+			}
+		})
+		if err == nil && infos == nil {
+			err = sftp.ErrSSHFxFailure
+		}
+	case "Stat":
+		err = fs.lookup(r.Filepath, func(res files.FileDir) {
+			infos = listerAt{newFileDirInfo(res, "")}
+		})
+	case "Readlink":
+		fs.state.view(func(root *files.FilesystemDir, cwd *files.FilesystemDir) {
+			lookupErr, res := root.GetFileOrDirNoFollow(root, r.Filepath)
+			if link, ok := res.(*files.FilesystemLink); ok && lookupErr == nil {
+				infos = listerAt{linkTargetInfo{link.Target}}
+			}
+		})
+		if infos == nil {
+			err = sftp.ErrSSHFxFailure
+		}
+	default:
+		err = sftp.ErrSSHFxOpUnsupported
+	}
+	fs.log(doc, err)
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// Like Stat, but a symlink is described rather than followed.
+func (fs *sftpFS) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	doc := DocSftp{Operation: "lstat", Path: r.Filepath}
+	var infos listerAt
+	fs.state.view(func(root *files.FilesystemDir, cwd *files.FilesystemDir) {
+		if err, res := root.GetFileOrDirNoFollow(root, r.Filepath); err == nil {
+			infos = listerAt{newFileDirInfo(res, "")}
+		}
+	})
+	if infos == nil {
+		fs.log(doc, os.ErrNotExist)
+		return nil, os.ErrNotExist
+	}
+	fs.log(doc, nil)
+	return infos, nil
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(dst []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(dst, l[offset:])
+	if n+int(offset) >= len(l) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Presents a FileDir as an os.FileInfo. It is a copy, taken with the
+// session locked, so it stays valid once the lock is released.
+type fileDirInfo struct {
+	name string
+	info files.FileInfo
+}
+
+// name is set for hard links, whose file has a name of its own.
+func newFileDirInfo(f files.FileDir, name string) fileDirInfo {
+	if name == "" {
+		name = f.PlainName()
+	}
+	if name == "" {
+		name = "/"
+	}
+	return fileDirInfo{name: name, info: f.Info()}
+}
+
+func (i fileDirInfo) Name() string {
+	return i.name
+}
+
+func (i fileDirInfo) Size() int64 {
+	return i.info.Size
+}
+
+func (i fileDirInfo) Mode() os.FileMode {
+	return i.info.Mode
+}
+
+func (i fileDirInfo) ModTime() time.Time {
+	return i.info.ModTime
+}
+
+func (i fileDirInfo) IsDir() bool {
+	return i.info.Mode.IsDir()
+}
+
+func (i fileDirInfo) Sys() interface{} {
+	return nil
+}
+
+// Readlink replies carry the target as the name of a file.
+type linkTargetInfo struct {
+	target string
+}
+
+func (i linkTargetInfo) Name() string       { return i.target }
+func (i linkTargetInfo) Size() int64        { return 0 }
+func (i linkTargetInfo) Mode() os.FileMode  { return os.ModeSymlink | 0777 }
+func (i linkTargetInfo) ModTime() time.Time { return time.Time{} }
+func (i linkTargetInfo) IsDir() bool        { return false }
+func (i linkTargetInfo) Sys() interface{}   { return nil }
+
+// Collects an upload in memory and hands it to onClose once the client is
+// done with it.
+type uploadBuffer struct {
+	mu      sync.Mutex
+	data    []byte
+	closed  bool
+	onClose func(data []byte)
+}
+
+var errUploadTooLarge = errors.New("upload too large")
+
+func (b *uploadBuffer) WriteAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	end := off + int64(len(p))
+	if end > UPLOAD_MAX_SIZE {
+		return 0, errUploadTooLarge
+	}
+	if end > int64(len(b.data)) {
+		if end > int64(cap(b.data)) {
+			newCap := end * 2
+			if newCap > UPLOAD_MAX_SIZE {
+				newCap = UPLOAD_MAX_SIZE
+			}
+			grown := make([]byte, end, newCap)
+			copy(grown, b.data)
+			b.data = grown
+		} else {
+			b.data = b.data[:end]
+		}
+	}
+	copy(b.data[off:], p)
+	return len(p), nil
+}
+
+func (b *uploadBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		b.onClose(b.data)
+	}
+	return nil
+}
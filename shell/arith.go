@@ -0,0 +1,486 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ArithError is an arithmetic expression bash refuses, worded as bash
+// words it.
+type ArithError struct {
+	Expr  string
+	Msg   string
+	Token string
+}
+
+func (e *ArithError) Error() string {
+	return fmt.Sprintf("%s: %s (error token is \"%s\")", e.Expr, e.Msg, e.Token)
+}
+
+// Deepest a variable's value is evaluated in turn as an expression, and
+// deepest operators nest.
+const maxArithDepth = 1024
+
+// Arith evaluates an expression the way $((...)) does, once its parameters
+// and commands have been expanded. Numbers are 64-bit and wrap around.
+// Variables are read with get and their values evaluated in turn, so unset
+// or empty ones are 0; set is called for assignments such as x=1 or x+=2.
+func Arith(expr string, get func(name string) string, set func(name string, value string)) (int64, error) {
+	return arith(expr, get, set, 0)
+}
+
+func arith(expr string, get func(name string) string, set func(name string, value string), depth int) (int64, error) {
+	a := &arithParser{src: expr, get: get, set: set, depth: depth}
+	a.skipSpace()
+	if a.pos == len(a.src) {
+		return 0, nil
+	}
+	n, err := a.assignment()
+	if err != nil {
+		return 0, err
+	}
+	if a.pos < len(a.src) {
+		rest := a.rest()
+		if isDigit(a.src[a.pos]) || isNameStart(rune(a.src[a.pos])) || a.src[a.pos] == '(' {
+			return 0, a.fail("syntax error in expression", rest)
+		}
+		return 0, a.fail("syntax error: invalid arithmetic operator", rest)
+	}
+	return n, nil
+}
+
+type arithParser struct {
+	src   string
+	pos   int
+	get   func(name string) string
+	set   func(name string, value string)
+	depth int
+	// How deep the operators around pos nest.
+	nesting int
+	// Where the last operator started, which bash names when an operand
+	// is missing at the end.
+	lastOp int
+	// Set while parsing the side of &&, || or ?: that isn't taken, where
+	// nothing is assigned and dividing by zero is no error.
+	noEval int
+}
+
+func (a *arithParser) fail(msg string, token string) error {
+	return &ArithError{Expr: strings.TrimSpace(a.src), Msg: msg, Token: token}
+}
+
+func (a *arithParser) rest() string {
+	return strings.TrimSpace(a.src[a.pos:])
+}
+
+func (a *arithParser) skipSpace() {
+	for a.pos < len(a.src) && strings.IndexByte(" \t\n", a.src[a.pos]) >= 0 {
+		a.pos++
+	}
+}
+
+// Consumes op if it comes next and isn't the start of a longer operator.
+func (a *arithParser) accept(op string, unless ...string) bool {
+	if !strings.HasPrefix(a.src[a.pos:], op) {
+		return false
+	}
+	for _, longer := range unless {
+		if strings.HasPrefix(a.src[a.pos:], longer) {
+			return false
+		}
+	}
+	a.lastOp = a.pos
+	a.pos += len(op)
+	a.skipSpace()
+	return true
+}
+
+// Binary operators by precedence, loosest first. Each is listed with the
+// longer operators it mustn't be mistaken for the start of.
+var arithLevels = [][][]string{
+	{{"|", "||", "|="}},
+	{{"^", "^="}},
+	{{"&", "&&", "&="}},
+	{{"==", "==="}, {"!="}},
+	{{"<=", "<<"}, {">=", ">>"}, {"<", "<<", "<="}, {">", ">>", ">="}},
+	{{"<<", "<<="}, {">>", ">>="}},
+	{{"+", "+="}, {"-", "-="}},
+	{{"*", "**", "*="}, {"/", "/="}, {"%", "%="}},
+}
+
+// Operators that assign, each with the binary operator it applies.
+var arithAssignOps = []string{"<<=", ">>=", "+=", "-=", "*=", "/=", "%=", "&=", "^=", "|=", "="}
+
+// Goes a level deeper into the expression, failing past maxArithDepth.
+func (a *arithParser) enter() error {
+	if a.nesting >= maxArithDepth {
+		return a.fail("expression recursion level exceeded", a.rest())
+	}
+	a.nesting++
+	return nil
+}
+
+func (a *arithParser) leave() {
+	a.nesting--
+}
+
+func (a *arithParser) assignment() (int64, error) {
+	if err := a.enter(); err != nil {
+		return 0, err
+	}
+	defer a.leave()
+	start := a.pos
+	name := a.name()
+	if name != "" {
+		a.skipSpace()
+		for _, op := range arithAssignOps {
+			if !a.accept(op, "==") {
+				continue
+			}
+			value, err := a.assignment()
+			if err != nil {
+				return 0, err
+			}
+			if op != "=" {
+				current, err := a.variable(name)
+				if err != nil {
+					return 0, err
+				}
+				value, err = a.binary(strings.TrimSuffix(op, "="), current, value, "")
+				if err != nil {
+					return 0, err
+				}
+			}
+			if a.noEval == 0 {
+				a.set(name, strconv.FormatInt(value, 10))
+			}
+			return value, nil
+		}
+	}
+	a.pos = start
+	return a.ternary()
+}
+
+func (a *arithParser) ternary() (int64, error) {
+	if err := a.enter(); err != nil {
+		return 0, err
+	}
+	defer a.leave()
+	cond, err := a.logical("||")
+	if err != nil {
+		return 0, err
+	}
+	if !a.accept("?") {
+		return cond, nil
+	}
+	if cond == 0 {
+		a.noEval++
+	}
+	then, err := a.assignment()
+	if cond == 0 {
+		a.noEval--
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !a.accept(":") {
+		return 0, a.fail("expected `:' for conditional expression", a.rest())
+	}
+	if cond != 0 {
+		a.noEval++
+	}
+	otherwise, err := a.ternary()
+	if cond != 0 {
+		a.noEval--
+	}
+	if err != nil {
+		return 0, err
+	}
+	if cond != 0 {
+		return then, nil
+	}
+	return otherwise, nil
+}
+
+// Parses || or && and what's tighter, skipping the right side's effects
+// when the left side decides.
+func (a *arithParser) logical(op string) (int64, error) {
+	next := func() (int64, error) {
+		if op == "||" {
+			return a.logical("&&")
+		}
+		return a.level(0)
+	}
+	left, err := next()
+	if err != nil {
+		return 0, err
+	}
+	for a.accept(op) {
+		decided := (op == "||") == (left != 0)
+		if decided {
+			a.noEval++
+		}
+		right, err := next()
+		if decided {
+			a.noEval--
+		}
+		if err != nil {
+			return 0, err
+		}
+		left = boolInt(left != 0 && right != 0)
+		if op == "||" {
+			left = boolInt(decided || right != 0)
+		}
+	}
+	return left, nil
+}
+
+func (a *arithParser) level(i int) (int64, error) {
+	if i == len(arithLevels) {
+		return a.power()
+	}
+	left, err := a.level(i + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := ""
+		for _, candidate := range arithLevels[i] {
+			if a.accept(candidate[0], candidate[1:]...) {
+				op = candidate[0]
+				break
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		rightStart := a.pos
+		right, err := a.level(i + 1)
+		if err != nil {
+			return 0, err
+		}
+		left, err = a.binary(op, left, right, strings.TrimSpace(a.src[rightStart:]))
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Exponentiation binds tighter than the other binary operators, and to
+// the right.
+func (a *arithParser) power() (int64, error) {
+	if err := a.enter(); err != nil {
+		return 0, err
+	}
+	defer a.leave()
+	base, err := a.unary()
+	if err != nil {
+		return 0, err
+	}
+	if !a.accept("**", "**=") {
+		return base, nil
+	}
+	rightStart := a.pos
+	exp, err := a.power()
+	if err != nil {
+		return 0, err
+	}
+	return a.binary("**", base, exp, strings.TrimSpace(a.src[rightStart:]))
+}
+
+func (a *arithParser) binary(op string, x int64, y int64, token string) (int64, error) {
+	switch op {
+	case "|":
+		return x | y, nil
+	case "^":
+		return x ^ y, nil
+	case "&":
+		return x & y, nil
+	case "==":
+		return boolInt(x == y), nil
+	case "!=":
+		return boolInt(x != y), nil
+	case "<":
+		return boolInt(x < y), nil
+	case "<=":
+		return boolInt(x <= y), nil
+	case ">":
+		return boolInt(x > y), nil
+	case ">=":
+		return boolInt(x >= y), nil
+	case "<<":
+		return x << (uint64(y) & 63), nil
+	case ">>":
+		return x >> (uint64(y) & 63), nil
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/", "%":
+		if y == 0 {
+			if a.noEval > 0 {
+				return 0, nil
+			}
+			return 0, a.fail("division by 0", token)
+		}
+		if op == "/" {
+			return x / y, nil
+		}
+		return x % y, nil
+	case "**":
+		if y < 0 {
+			return 0, a.fail("exponent less than 0", token)
+		}
+		result := int64(1)
+		for ; y > 0; y >>= 1 {
+			if y&1 != 0 {
+				result *= x
+			}
+			x *= x
+		}
+		return result, nil
+	}
+	return 0, a.fail("syntax error: invalid arithmetic operator", op)
+}
+
+func (a *arithParser) unary() (int64, error) {
+	if err := a.enter(); err != nil {
+		return 0, err
+	}
+	defer a.leave()
+	for _, op := range []string{"-", "+", "!", "~"} {
+		if !a.accept(op, op+op, op+"=", "!=") {
+			continue
+		}
+		n, err := a.unary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "-":
+			return -n, nil
+		case "!":
+			return boolInt(n == 0), nil
+		case "~":
+			return ^n, nil
+		}
+		return n, nil
+	}
+	return a.operand()
+}
+
+func (a *arithParser) operand() (int64, error) {
+	if a.pos == len(a.src) {
+		return 0, a.fail("syntax error: operand expected", strings.TrimSpace(a.src[a.lastOp:]))
+	}
+	c := a.src[a.pos]
+	switch {
+	case c == '(':
+		a.accept("(")
+		n, err := a.assignment()
+		if err != nil {
+			return 0, err
+		}
+		if !a.accept(")") {
+			return 0, a.fail("missing `)'", a.rest())
+		}
+		return n, nil
+	case isDigit(c):
+		start := a.pos
+		for a.pos < len(a.src) && (isNameChar(rune(a.src[a.pos])) || strings.IndexByte("#@", a.src[a.pos]) >= 0) {
+			a.pos++
+		}
+		text := a.src[start:a.pos]
+		a.skipSpace()
+		return a.number(text)
+	case isNameStart(rune(c)):
+		name := a.name()
+		a.skipSpace()
+		return a.variable(name)
+	}
+	return 0, a.fail("syntax error: operand expected", a.rest())
+}
+
+func (a *arithParser) name() string {
+	start := a.pos
+	if a.pos < len(a.src) && isNameStart(rune(a.src[a.pos])) {
+		for a.pos < len(a.src) && isNameChar(rune(a.src[a.pos])) {
+			a.pos++
+		}
+	}
+	return a.src[start:a.pos]
+}
+
+func (a *arithParser) variable(name string) (int64, error) {
+	value := a.get(name)
+	if strings.TrimSpace(value) == "" {
+		return 0, nil
+	}
+	if a.depth >= maxArithDepth {
+		return 0, a.fail("expression recursion level exceeded", name)
+	}
+	return arith(value, a.get, a.set, a.depth+1)
+}
+
+// Reads a number as bash writes them: decimal, 0x hex, 0 octal, or
+// base#digits for bases from 2 to 64.
+func (a *arithParser) number(text string) (int64, error) {
+	base := int64(10)
+	digits := text
+	switch {
+	case strings.Contains(text, "#"):
+		i := strings.Index(text, "#")
+		b, err := strconv.Atoi(text[:i])
+		if err != nil || b < 2 || b > 64 {
+			return 0, a.fail("invalid arithmetic base", text)
+		}
+		base, digits = int64(b), text[i+1:]
+	case strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X"):
+		base, digits = 16, text[2:]
+	case len(text) > 1 && text[0] == '0':
+		base, digits = 8, text[1:]
+	}
+	if digits == "" {
+		return 0, a.fail("invalid number", text)
+	}
+	var n int64
+	for _, c := range digits {
+		var d int64
+		switch {
+		case isDigit(byte(c)):
+			d = int64(c - '0')
+		case c >= 'a' && c <= 'z':
+			d = int64(c-'a') + 10
+		case c >= 'A' && c <= 'Z':
+			d = int64(c-'A') + 10
+			if base > 36 {
+				d += 26
+			}
+		case c == '@':
+			d = 62
+		case c == '_':
+			d = 63
+		default:
+			return 0, a.fail("invalid number", text)
+		}
+		if d >= base {
+			return 0, a.fail("value too great for base", text)
+		}
+		n = n*base + d
+	}
+	return n, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,105 @@
+package shell
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestArith(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    int64
+		wantErr string
+	}{
+		{"", 0, ""},
+		{" 1 + 2 * 3 ", 7, ""},
+		{"(1+2)*3", 9, ""},
+		{"7/2 7%3", 0, `7/2 7%3: syntax error in expression (error token is "7%3")`},
+		{"-3/2", -1, ""},
+		{"-2**2", 4, ""},
+		{"2**3**2", 512, ""},
+		{"2**63", -9223372036854775808, ""},
+		{"9223372036854775807+1", -9223372036854775808, ""},
+		{"1<<65", 2, ""},
+		{"~5", -6, ""},
+		{"!0 + !7", 1, ""},
+		{"5>3==1", 1, ""},
+		{"1 < 2 && 3 >= 4", 0, ""},
+		{"0 || 2", 1, ""},
+		{"6 & 3 | 8 ^ 1", 11, ""},
+		{"1?2:3?4:5", 2, ""},
+		{"0?2:0?4:5", 5, ""},
+		{"0x1F + 010 + 2#101 + 36#z + 64#_@", 31 + 8 + 5 + 35 + 63*64 + 62, ""},
+		{"x * 2", 10, ""},
+		{"expr + 1", 8, ""},
+		{"unset", 0, ""},
+		{"x = 4", 4, ""},
+		{"x += 2", 7, ""},
+		{"x <<= 1", 10, ""},
+		{"0 && (x = 9)", 0, ""},
+		{"0 && 1/0", 0, ""},
+		{"1 ? 1 : 1/0", 1, ""},
+		{"1/0", 0, `1/0: division by 0 (error token is "0")`},
+		{"5 % (2-2)", 0, `5 % (2-2): division by 0 (error token is "(2-2)")`},
+		{"2 ** -1", 0, `2 ** -1: exponent less than 0 (error token is "-1")`},
+		{"1 +", 0, `1 +: syntax error: operand expected (error token is "+")`},
+		{"@", 0, `@: syntax error: operand expected (error token is "@")`},
+		{"1 @ 2", 0, `1 @ 2: syntax error: invalid arithmetic operator (error token is "@ 2")`},
+		{"(1", 0, "(1: missing `)' (error token is \"\")"},
+		{"1 ? 2", 0, "1 ? 2: expected `:' for conditional expression (error token is \"\")"},
+		{"08", 0, `08: value too great for base (error token is "08")`},
+		{"37#Z", 0, `37#Z: value too great for base (error token is "37#Z")`},
+		{"65#1", 0, `65#1: invalid arithmetic base (error token is "65#1")`},
+		{"0x", 0, `0x: invalid number (error token is "0x")`},
+		{"bad", 0, `1 2: syntax error in expression (error token is "2")`},
+		{"loop", 0, `loop: expression recursion level exceeded (error token is "loop")`},
+	}
+	for _, test := range tests {
+		vars := map[string]string{"x": "5", "expr": "x+2", "bad": "1 2", "loop": "loop"}
+		get := func(name string) string { return vars[name] }
+		set := func(name string, value string) { vars[name] = value }
+		got, err := Arith(test.expr, get, set)
+		if test.wantErr != "" {
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("Arith(%q) error = %v, want %s", test.expr, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("Arith(%q) = %d, %v, want %d", test.expr, got, err, test.want)
+		}
+	}
+}
+
+func TestArithAssigns(t *testing.T) {
+	vars := map[string]string{}
+	get := func(name string) string { return vars[name] }
+	set := func(name string, value string) { vars[name] = value }
+	for _, expr := range []string{"x = 4", "x += 2", "y = x * 2", "x <<= 1", "z = (x == 12) ? 1 : 0"} {
+		if _, err := Arith(expr, get, set); err != nil {
+			t.Fatalf("Arith(%q): %v", expr, err)
+		}
+	}
+	if vars["x"] != "12" || vars["y"] != "12" || vars["z"] != "1" {
+		t.Errorf("variables after assigning: %v", vars)
+	}
+}
+
+// Deep nesting is refused rather than exhausting the stack.
+func TestArithDeepNesting(t *testing.T) {
+	get := func(string) string { return "" }
+	set := func(string, string) {}
+	tests := []string{
+		strings.Repeat("(", 100000) + "1",
+		strings.Repeat("- ", 100000) + "1",
+		strings.Repeat("2**", 100000) + "1",
+		strings.Repeat("x=", 100000) + "1",
+		strings.Repeat("1?1:", 100000) + "1",
+	}
+	for _, expr := range tests {
+		_, err := Arith(expr, get, set)
+		if err == nil || !strings.Contains(err.Error(), "recursion level exceeded") {
+			t.Errorf("Arith of %.10s...: %.100v", expr, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Package shell parses command lines the way a POSIX shell does, into lists
+// of pipelines of commands whose words are expanded when they run.
+package shell
+
+// List is commands separated by ";", "&" or newlines, run in turn.
+type List struct {
+	Items []*AndOr
+}
+
+// AndOr is pipelines joined by "&&" and "||". Ops[i] joins Pipelines[i] and
+// Pipelines[i+1].
+type AndOr struct {
+	Pipelines []*Pipeline
+	Ops       []string
+}
+
+// Pipeline is commands joined by "|", each reading what the one before it
+// wrote. Negated pipelines start with "!".
+type Pipeline struct {
+	Negated  bool
+	Commands []Command
+}
+
+// Command is a SimpleCommand or a Subshell.
+type Command interface {
+	command()
+}
+
+// SimpleCommand is a command name and its arguments, after any variable
+// assignments for it.
+type SimpleCommand struct {
+	Assigns   []*Assign
+	Args      []Word
+	Redirects []*Redirect
+}
+
+// Assign is NAME=value.
+type Assign struct {
+	Name  string
+	Value Word
+}
+
+// Subshell is a list run in parentheses, so that changes to the working
+// directory don't outlast it.
+type Subshell struct {
+	List      *List
+	Redirects []*Redirect
+}
+
+func (*SimpleCommand) command() {}
+func (*Subshell) command()      {}
+
+// Redirect is one of "<", ">", ">>", "<&", ">&", "&>", "&>>", "<<" or "<<<"
+// applied to file descriptor Fd. For "<&" and ">&" the target may be another
+// descriptor, as in 2>&1. For "<<" the target is the here-document's body,
+// with any tabs <<- strips already gone.
+type Redirect struct {
+	Fd     int
+	Op     string
+	Target Word
+}
+
+// Word is one shell word, made of parts that are expanded and joined.
+type Word []WordPart
+
+// WordPart is a Lit, a ParamExp, a CmdSubst or an ArithExp.
+type WordPart interface {
+	wordPart()
+}
+
+// Lit is literal text. Quoted text isn't split into fields.
+type Lit struct {
+	Value  string
+	Quoted bool
+}
+
+// CmdSubst is $(...) or `...`, replaced by what the list writes.
+type CmdSubst struct {
+	List   *List
+	Quoted bool
+}
+
+// ArithExp is $((...)), replaced by the value of Expr, which is expanded
+// and then evaluated with Arith.
+type ArithExp struct {
+	Expr   Word
+	Quoted bool
+}
+
+// ParamExp is $NAME or ${NAME...}. Op is empty or one of:
+//
+//	"-", "=", "+" or "?", optionally after ":", applied with Word
+//	"#", "##", "%" or "%%", removing the pattern in Word
+//	"/", "//", "/#" or "/%", replacing the pattern in Word with Arg
+//	":", the substring at offset Word of length Arg
+//
+// Arg is nil when it was left out. Length is ${#NAME}. Invalid is set to
+// the text of a ${...} bash can't make sense of, which fails as a bad
+// substitution when it is expanded rather than when it is parsed.
+type ParamExp struct {
+	Name    string
+	Op      string
+	Word    Word
+	Arg     Word
+	Length  bool
+	Quoted  bool
+	Invalid string
+}
+
+func (*Lit) wordPart()      {}
+func (*ParamExp) wordPart() {}
+func (*CmdSubst) wordPart() {}
+func (*ArithExp) wordPart() {}
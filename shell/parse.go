@@ -0,0 +1,995 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// SyntaxError is a command line bash would refuse to run. Its message is
+// worded the way bash's is.
+type SyntaxError struct {
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Msg
+}
+
+func unexpectedToken(token string) error {
+	return &SyntaxError{Msg: fmt.Sprintf("syntax error near unexpected token `%s'", token)}
+}
+
+func unexpectedEOF(matching string) error {
+	return &SyntaxError{Msg: fmt.Sprintf("unexpected EOF while looking for matching `%s'", matching)}
+}
+
+var errUnexpectedEnd = &SyntaxError{Msg: "syntax error: unexpected end of file"}
+
+// Parse parses a command line, which may span several lines.
+func Parse(src string) (*List, error) {
+	p := &parser{src: []rune(src)}
+	list, err := p.list(false)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlanks()
+	if !p.eof() {
+		return nil, unexpectedToken(p.operator())
+	}
+	return list, nil
+}
+
+type parser struct {
+	src []rune
+	pos int
+	// Here-documents whose bodies start after the next newline.
+	heredocs []*heredoc
+	// What was parsed at each "$((", so that trying arithmetic first and
+	// falling back to a command substitution doesn't take exponential time
+	// when they nest.
+	substs map[substKey]substResult
+}
+
+type substKey struct {
+	pos    int
+	quoted bool
+}
+
+type substResult struct {
+	part WordPart
+	end  int
+	err  error
+}
+
+// A here-document waiting for its body.
+type heredoc struct {
+	redirect *Redirect
+	delim    string
+	// A quoted delimiter turns off expansion in the body.
+	quoted bool
+	// <<- strips leading tabs from the body and the delimiter line.
+	stripTabs bool
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// Compares in place, since copying the rest of the input on every call would
+// make parsing quadratic.
+func (p *parser) hasPrefix(s string) bool {
+	i := p.pos
+	for _, c := range s {
+		if i >= len(p.src) || p.src[i] != c {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// Skips spaces, tabs, escaped newlines and comments, but not newlines.
+func (p *parser) skipBlanks() {
+	for !p.eof() {
+		switch {
+		case p.peek() == ' ' || p.peek() == '\t':
+			p.pos++
+		case p.hasPrefix("\\\n"):
+			p.pos += 2
+		case p.peek() == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) skipBlanksAndNewlines() {
+	for {
+		p.skipBlanks()
+		if p.peek() != '\n' {
+			return
+		}
+		p.newline()
+	}
+}
+
+// Consumes a newline, and then the bodies of any here-documents started on
+// the line it ends.
+func (p *parser) newline() {
+	p.pos++
+	for _, doc := range p.heredocs {
+		body := p.heredocBody(doc)
+		if doc.quoted {
+			doc.redirect.Target = Word{&Lit{Value: body, Quoted: true}}
+			continue
+		}
+		sub := &parser{src: []rune(body)}
+		doc.redirect.Target = sub.expandingBody()
+	}
+	p.heredocs = nil
+}
+
+// Reads the lines of a here-document up to its delimiter line, or to the
+// end of the input.
+func (p *parser) heredocBody(doc *heredoc) string {
+	var body strings.Builder
+	for !p.eof() {
+		end := p.pos
+		for end < len(p.src) && p.src[end] != '\n' {
+			end++
+		}
+		line := string(p.src[p.pos:end])
+		p.pos = end
+		if !p.eof() {
+			p.pos++
+		}
+		if doc.stripTabs {
+			line = strings.TrimLeft(line, "\t")
+		}
+		if line == doc.delim {
+			break
+		}
+		body.WriteString(line + "\n")
+	}
+	return body.String()
+}
+
+// Parses the body of a here-document with an unquoted delimiter, in which
+// parameters and commands are expanded but nothing is split.
+func (p *parser) expandingBody() Word {
+	word := &wordBuilder{}
+	word.lit("", true)
+	for !p.eof() {
+		c := p.peek()
+		switch {
+		case c == '\\' && p.pos+1 < len(p.src):
+			next := p.src[p.pos+1]
+			p.pos += 2
+			switch next {
+			case '\n':
+			case '$', '`', '\\':
+				word.lit(string(next), true)
+			default:
+				word.lit("\\"+string(next), true)
+			}
+		case p.hasPrefix("$("), c == '`':
+			if subst, err := p.substitution(true); err == nil {
+				word.part(subst)
+				continue
+			}
+			// Like bash, keep what doesn't parse as it is.
+			word.lit(string(p.src[p.pos:]), true)
+			p.pos = len(p.src)
+		case c == '$' && p.atParam():
+			start := p.pos
+			if param, err := p.paramExp(true); err == nil {
+				word.part(param)
+				continue
+			}
+			p.pos = start
+			word.lit("$", true)
+			p.pos++
+		default:
+			word.lit(string(c), true)
+			p.pos++
+		}
+	}
+	return word.done()
+}
+
+// The operator at the current position, for error messages.
+func (p *parser) operator() string {
+	for _, op := range []string{"&&", "||", ";;", "&>>", ">>", "<<", "&>", ">&", "<&"} {
+		if p.hasPrefix(op) {
+			return op
+		}
+	}
+	if p.eof() || p.peek() == '\n' {
+		return "newline"
+	}
+	return string(p.peek())
+}
+
+func isMeta(c rune) bool {
+	return strings.ContainsRune(" \t\n;&|<>()", c)
+}
+
+func (p *parser) list(inParens bool) (*List, error) {
+	list := &List{}
+	for {
+		p.skipBlanksAndNewlines()
+		if p.eof() || (inParens && p.peek() == ')') {
+			break
+		}
+		andOr, err := p.andOr()
+		if err != nil {
+			return nil, err
+		}
+		list.Items = append(list.Items, andOr)
+		p.skipBlanks()
+		if p.hasPrefix(";;") {
+			return nil, unexpectedToken(";;")
+		}
+		if p.peek() == '\n' {
+			p.newline()
+			continue
+		}
+		if p.peek() == ';' || (p.peek() == '&' && !p.hasPrefix("&&") && !p.hasPrefix("&>")) {
+			// Background jobs run in the foreground here.
+			p.pos++
+			continue
+		}
+		break
+	}
+	return list, nil
+}
+
+func (p *parser) andOr() (*AndOr, error) {
+	pipeline, err := p.pipeline()
+	if err != nil {
+		return nil, err
+	}
+	andOr := &AndOr{Pipelines: []*Pipeline{pipeline}}
+	for {
+		p.skipBlanks()
+		op := ""
+		if p.hasPrefix("&&") {
+			op = "&&"
+		} else if p.hasPrefix("||") {
+			op = "||"
+		} else {
+			return andOr, nil
+		}
+		p.pos += 2
+		p.skipBlanksAndNewlines()
+		if p.eof() {
+			return nil, errUnexpectedEnd
+		}
+		pipeline, err := p.pipeline()
+		if err != nil {
+			return nil, err
+		}
+		andOr.Ops = append(andOr.Ops, op)
+		andOr.Pipelines = append(andOr.Pipelines, pipeline)
+	}
+}
+
+func (p *parser) pipeline() (*Pipeline, error) {
+	pipeline := &Pipeline{}
+	p.skipBlanks()
+	if p.peek() == '!' && p.pos+1 < len(p.src) && (p.src[p.pos+1] == ' ' || p.src[p.pos+1] == '\t') {
+		pipeline.Negated = true
+		p.pos++
+	}
+	for {
+		cmd, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		pipeline.Commands = append(pipeline.Commands, cmd)
+		p.skipBlanks()
+		if p.peek() != '|' || p.hasPrefix("||") {
+			return pipeline, nil
+		}
+		p.pos++
+		p.skipBlanksAndNewlines()
+		if p.eof() {
+			return nil, errUnexpectedEnd
+		}
+	}
+}
+
+func (p *parser) command() (Command, error) {
+	p.skipBlanks()
+	if p.peek() == '(' {
+		p.pos++
+		list, err := p.list(true)
+		if err != nil {
+			return nil, err
+		}
+		if p.eof() {
+			return nil, errUnexpectedEnd
+		}
+		p.pos++
+		if len(list.Items) == 0 {
+			return nil, unexpectedToken(")")
+		}
+		subshell := &Subshell{List: list}
+		for {
+			p.skipBlanks()
+			redirect, err := p.redirect()
+			if err != nil {
+				return nil, err
+			}
+			if redirect == nil {
+				break
+			}
+			subshell.Redirects = append(subshell.Redirects, redirect)
+		}
+		return subshell, nil
+	}
+	cmd := &SimpleCommand{}
+	for {
+		p.skipBlanks()
+		if p.eof() {
+			break
+		}
+		redirect, err := p.redirect()
+		if err != nil {
+			return nil, err
+		}
+		if redirect != nil {
+			cmd.Redirects = append(cmd.Redirects, redirect)
+			continue
+		}
+		if p.peek() == '(' && len(cmd.Args) > 0 {
+			return nil, unexpectedToken("(")
+		}
+		if isMeta(p.peek()) {
+			break
+		}
+		word, err := p.word()
+		if err != nil {
+			return nil, err
+		}
+		if assign := assignment(word); assign != nil && len(cmd.Args) == 0 {
+			cmd.Assigns = append(cmd.Assigns, assign)
+			continue
+		}
+		cmd.Args = append(cmd.Args, word)
+	}
+	if len(cmd.Assigns) == 0 && len(cmd.Args) == 0 && len(cmd.Redirects) == 0 {
+		if p.eof() {
+			return nil, errUnexpectedEnd
+		}
+		return nil, unexpectedToken(p.operator())
+	}
+	return cmd, nil
+}
+
+// Splits NAME=value into an assignment, if the word is one.
+func assignment(word Word) *Assign {
+	if len(word) == 0 {
+		return nil
+	}
+	lit, ok := word[0].(*Lit)
+	if !ok || lit.Quoted {
+		return nil
+	}
+	eq := strings.IndexByte(lit.Value, '=')
+	if eq <= 0 {
+		return nil
+	}
+	name := lit.Value[:eq]
+	for i, c := range name {
+		if !isNameChar(c) || (i == 0 && !isNameStart(c)) {
+			return nil
+		}
+	}
+	value := Word{}
+	if rest := lit.Value[eq+1:]; rest != "" {
+		value = append(value, &Lit{Value: rest})
+	}
+	return &Assign{Name: name, Value: append(value, word[1:]...)}
+}
+
+// Parses a redirection if there is one here, or returns nil.
+func (p *parser) redirect() (*Redirect, error) {
+	start := p.pos
+	fd := -1
+	digits := 0
+	for p.pos+digits < len(p.src) && p.src[p.pos+digits] >= '0' && p.src[p.pos+digits] <= '9' {
+		digits++
+	}
+	if digits > 0 {
+		next := p.pos + digits
+		if next >= len(p.src) || (p.src[next] != '<' && p.src[next] != '>') {
+			return nil, nil
+		}
+		fd, _ = strconv.Atoi(string(p.src[p.pos:next]))
+		p.pos = next
+	}
+	op := ""
+	for _, candidate := range []string{"&>>", "&>", ">>", ">&", ">|", "<&", "<<<", "<<-", "<<", ">", "<"} {
+		if p.hasPrefix(candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" || (op[0] == '&' && fd >= 0) {
+		p.pos = start
+		return nil, nil
+	}
+	p.pos += len(op)
+	if op == ">|" {
+		op = ">"
+	}
+	if fd < 0 {
+		fd = 1
+		if op[0] == '<' {
+			fd = 0
+		}
+	}
+	p.skipBlanks()
+	if p.eof() || isMeta(p.peek()) {
+		return nil, unexpectedToken(p.operator())
+	}
+	if op == "<<" || op == "<<-" {
+		delim, quoted, err := p.delimiter()
+		if err != nil {
+			return nil, err
+		}
+		// The body is filled in once the line ends.
+		redirect := &Redirect{Fd: fd, Op: "<<", Target: Word{}}
+		p.heredocs = append(p.heredocs, &heredoc{
+			redirect:  redirect,
+			delim:     delim,
+			quoted:    quoted,
+			stripTabs: op == "<<-",
+		})
+		return redirect, nil
+	}
+	target, err := p.word()
+	if err != nil {
+		return nil, err
+	}
+	return &Redirect{Fd: fd, Op: op, Target: target}, nil
+}
+
+// Reads a here-document delimiter, which is taken as it is written apart
+// from quote removal, and says whether any of it was quoted.
+func (p *parser) delimiter() (string, bool, error) {
+	var delim strings.Builder
+	quoted := false
+	for !p.eof() && !isMeta(p.peek()) {
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '\\':
+			quoted = true
+			if !p.eof() {
+				delim.WriteRune(p.peek())
+				p.pos++
+			}
+		case '\'', '"':
+			quoted = true
+			end := p.pos
+			for end < len(p.src) && p.src[end] != c {
+				end++
+			}
+			if end == len(p.src) {
+				return "", false, unexpectedEOF(string(c))
+			}
+			delim.WriteString(string(p.src[p.pos:end]))
+			p.pos = end + 1
+		default:
+			delim.WriteRune(c)
+		}
+	}
+	return delim.String(), quoted, nil
+}
+
+// Appends text to the word, merging it with the last part when that has the
+// same quoting.
+// wordBuilder puts a Word together, running adjacent literal text of the
+// same quoting into one Lit. The text is buffered until the Lit is done
+// with, since growing a string piece by piece copies it every time.
+type wordBuilder struct {
+	word   Word
+	text   strings.Builder
+	quoted bool
+	// Whether text holds a Lit still to be added, even an empty one.
+	open bool
+}
+
+func (b *wordBuilder) lit(text string, quoted bool) {
+	if b.open && b.quoted != quoted {
+		b.flush()
+	}
+	b.open, b.quoted = true, quoted
+	b.text.WriteString(text)
+}
+
+func (b *wordBuilder) part(part WordPart) {
+	b.flush()
+	b.word = append(b.word, part)
+}
+
+func (b *wordBuilder) flush() {
+	if b.open {
+		b.word = append(b.word, &Lit{Value: b.text.String(), Quoted: b.quoted})
+		b.text.Reset()
+		b.open = false
+	}
+}
+
+func (b *wordBuilder) done() Word {
+	b.flush()
+	if b.word == nil {
+		return Word{}
+	}
+	return b.word
+}
+
+func (p *parser) word() (Word, error) {
+	word := &wordBuilder{}
+	for !p.eof() && !isMeta(p.peek()) {
+		c := p.peek()
+		switch {
+		case c == '\\':
+			p.pos++
+			if p.eof() {
+				word.lit("\\", false)
+			} else if p.peek() == '\n' {
+				p.pos++
+			} else {
+				word.lit(string(p.peek()), true)
+				p.pos++
+			}
+		case c == '\'':
+			p.pos++
+			end := p.pos
+			for end < len(p.src) && p.src[end] != '\'' {
+				end++
+			}
+			if end == len(p.src) {
+				return nil, unexpectedEOF("'")
+			}
+			word.lit(string(p.src[p.pos:end]), true)
+			p.pos = end + 1
+		case c == '"':
+			if err := p.doubleQuoted(word); err != nil {
+				return nil, err
+			}
+		case p.hasPrefix("$'"):
+			p.pos += 2
+			text, err := p.ansiC()
+			if err != nil {
+				return nil, err
+			}
+			word.lit(text, true)
+		case p.hasPrefix("$("), c == '`':
+			subst, err := p.substitution(false)
+			if err != nil {
+				return nil, err
+			}
+			word.part(subst)
+		case c == '$' && p.atParam():
+			param, err := p.paramExp(false)
+			if err != nil {
+				return nil, err
+			}
+			word.part(param)
+		default:
+			word.lit(string(c), false)
+			p.pos++
+		}
+	}
+	return word.done(), nil
+}
+
+func (p *parser) doubleQuoted(word *wordBuilder) error {
+	p.pos++
+	// An empty "" still makes an argument.
+	word.lit("", true)
+	for {
+		if p.eof() {
+			return unexpectedEOF("\"")
+		}
+		c := p.peek()
+		switch {
+		case c == '"':
+			p.pos++
+			return nil
+		case c == '\\':
+			p.pos++
+			if p.eof() {
+				return unexpectedEOF("\"")
+			}
+			next := p.peek()
+			p.pos++
+			switch next {
+			case '\n':
+			case '$', '`', '"', '\\':
+				word.lit(string(next), true)
+			default:
+				word.lit("\\"+string(next), true)
+			}
+		case p.hasPrefix("$("), c == '`':
+			subst, err := p.substitution(true)
+			if err != nil {
+				return err
+			}
+			word.part(subst)
+		case c == '$' && p.atParam():
+			param, err := p.paramExp(true)
+			if err != nil {
+				return err
+			}
+			word.part(param)
+		default:
+			word.lit(string(c), true)
+			p.pos++
+		}
+	}
+}
+
+func isNameStart(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c rune) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// Special parameters, like $? and $$.
+const specialParams = "?$#!@*-0123456789"
+
+// Whether the "$" here starts a parameter expansion rather than being a
+// literal dollar sign.
+func (p *parser) atParam() bool {
+	if p.pos+1 >= len(p.src) {
+		return false
+	}
+	next := p.src[p.pos+1]
+	return next == '{' || isNameStart(next) || strings.ContainsRune(specialParams, next)
+}
+
+// Parses $NAME, $? and the like, or ${...}.
+func (p *parser) paramExp(quoted bool) (*ParamExp, error) {
+	p.pos++
+	param := &ParamExp{Quoted: quoted}
+	if p.peek() != '{' {
+		param.Name = p.paramName()
+		return param, nil
+	}
+	start := p.pos - 1
+	p.pos++
+	if p.peek() == '#' && p.pos+1 < len(p.src) && p.src[p.pos+1] != '}' {
+		param.Length = true
+		p.pos++
+	}
+	param.Name = p.paramName()
+	for _, op := range []string{":-", ":=", ":+", ":?", ":", "-", "=", "+", "?", "##", "#", "%%", "%", "//", "/#", "/%", "/"} {
+		if p.hasPrefix(op) && !param.Length && param.Name != "" {
+			param.Op = op
+			p.pos += len(op)
+			break
+		}
+	}
+	var err error
+	switch param.Op {
+	case "":
+	case ":":
+		param.Word, err = p.braceWord(false, ":}")
+		if err == nil && p.peek() == ':' {
+			p.pos++
+			param.Arg, err = p.braceWord(false, "}")
+		}
+	case "#", "##", "%", "%%":
+		// Patterns stay patterns inside double quotes.
+		param.Word, err = p.braceWord(false, "}")
+	case "/", "//", "/#", "/%":
+		param.Word, err = p.braceWord(false, "/}")
+		if err == nil && p.peek() == '/' {
+			p.pos++
+			param.Arg, err = p.braceWord(quoted, "}")
+		}
+	default:
+		param.Word, err = p.braceWord(quoted, "}")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != '}' || param.Name == "" {
+		end := p.pos
+		for end < len(p.src) && p.src[end] != '}' {
+			end++
+		}
+		if end == len(p.src) {
+			return nil, unexpectedEOF("}")
+		}
+		p.pos = end + 1
+		return &ParamExp{Quoted: quoted, Invalid: string(p.src[start:p.pos])}, nil
+	}
+	p.pos++
+	return param, nil
+}
+
+func (p *parser) paramName() string {
+	if p.eof() {
+		return ""
+	}
+	c := p.peek()
+	if !isNameStart(c) {
+		if strings.ContainsRune(specialParams, c) {
+			p.pos++
+			return string(c)
+		}
+		return ""
+	}
+	start := p.pos
+	for !p.eof() && isNameChar(p.peek()) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// Parses the word in ${NAME:-word}, up to the closing brace or another of
+// the stop characters.
+func (p *parser) braceWord(quoted bool, stop string) (Word, error) {
+	word := &wordBuilder{}
+	for !p.eof() && !strings.ContainsRune(stop, p.peek()) {
+		c := p.peek()
+		switch {
+		case c == '\\':
+			p.pos++
+			if !p.eof() {
+				word.lit(string(p.peek()), true)
+				p.pos++
+			}
+		case c == '\'' && !quoted:
+			p.pos++
+			end := p.pos
+			for end < len(p.src) && p.src[end] != '\'' {
+				end++
+			}
+			if end == len(p.src) {
+				return nil, unexpectedEOF("'")
+			}
+			word.lit(string(p.src[p.pos:end]), true)
+			p.pos = end + 1
+		case c == '"':
+			if err := p.doubleQuoted(word); err != nil {
+				return nil, err
+			}
+		case p.hasPrefix("$("), c == '`':
+			subst, err := p.substitution(quoted)
+			if err != nil {
+				return nil, err
+			}
+			word.part(subst)
+		case c == '$' && p.atParam():
+			param, err := p.paramExp(quoted)
+			if err != nil {
+				return nil, err
+			}
+			word.part(param)
+		default:
+			word.lit(string(c), quoted)
+			p.pos++
+		}
+	}
+	return word.done(), nil
+}
+
+// Parses $((...)), or $(...) or `...`. As in bash, what starts like an
+// arithmetic expansion but doesn't end in "))" is a command substitution
+// of a subshell.
+func (p *parser) substitution(quoted bool) (WordPart, error) {
+	if !p.hasPrefix("$((") {
+		return p.cmdSubst(quoted)
+	}
+	key := substKey{p.pos, quoted}
+	if res, ok := p.substs[key]; ok {
+		p.pos = res.end
+		return res.part, res.err
+	}
+	var res substResult
+	if arith, ok := p.arithExp(quoted); ok {
+		res.part = arith
+	} else if p.eof() {
+		res.err = unexpectedEOF(")")
+	} else {
+		p.pos = key.pos
+		res.part, res.err = p.cmdSubst(quoted)
+	}
+	res.end = p.pos
+	if p.substs == nil {
+		p.substs = map[substKey]substResult{}
+	}
+	p.substs[key] = res
+	return res.part, res.err
+}
+
+// Parses $((...)) if it is one. The expression is taken as if in double
+// quotes, but the closing parentheses have to balance the opening ones.
+func (p *parser) arithExp(quoted bool) (*ArithExp, bool) {
+	p.pos += 3
+	expr := &wordBuilder{}
+	depth := 0
+	for !p.eof() {
+		c := p.peek()
+		switch {
+		case c == ')' && depth == 0:
+			if !p.hasPrefix("))") {
+				return nil, false
+			}
+			p.pos += 2
+			return &ArithExp{Expr: expr.done(), Quoted: quoted}, true
+		case c == '(' || c == ')':
+			if c == '(' {
+				depth++
+			} else {
+				depth--
+			}
+			expr.lit(string(c), true)
+			p.pos++
+		case c == '\\':
+			p.pos++
+			if !p.eof() {
+				expr.lit(string(p.peek()), true)
+				p.pos++
+			}
+		case c == '"':
+			if err := p.doubleQuoted(expr); err != nil {
+				return nil, false
+			}
+		case p.hasPrefix("$("), c == '`':
+			subst, err := p.substitution(true)
+			if err != nil {
+				return nil, false
+			}
+			expr.part(subst)
+		case c == '$' && p.atParam():
+			param, err := p.paramExp(true)
+			if err != nil {
+				return nil, false
+			}
+			expr.part(param)
+		default:
+			expr.lit(string(c), true)
+			p.pos++
+		}
+	}
+	return nil, false
+}
+
+func (p *parser) cmdSubst(quoted bool) (*CmdSubst, error) {
+	if p.peek() == '`' {
+		p.pos++
+		var inner strings.Builder
+		for {
+			if p.eof() {
+				return nil, unexpectedEOF("`")
+			}
+			c := p.peek()
+			p.pos++
+			if c == '`' {
+				break
+			}
+			if c == '\\' && !p.eof() && strings.ContainsRune("$`\\", p.peek()) {
+				c = p.peek()
+				p.pos++
+			}
+			inner.WriteRune(c)
+		}
+		list, err := Parse(inner.String())
+		if err != nil {
+			return nil, err
+		}
+		return &CmdSubst{List: list, Quoted: quoted}, nil
+	}
+	p.pos += 2
+	list, err := p.list(true)
+	if err != nil {
+		return nil, err
+	}
+	if p.eof() {
+		return nil, unexpectedEOF(")")
+	}
+	p.pos++
+	return &CmdSubst{List: list, Quoted: quoted}, nil
+}
+
+// Decodes the body of a $'...' string, up to and including the closing
+// quote.
+func (p *parser) ansiC() (string, error) {
+	var out strings.Builder
+	for {
+		if p.eof() {
+			return "", unexpectedEOF("'")
+		}
+		c := p.peek()
+		p.pos++
+		if c == '\'' {
+			return out.String(), nil
+		}
+		if c != '\\' || p.eof() {
+			out.WriteRune(c)
+			continue
+		}
+		c = p.peek()
+		p.pos++
+		switch c {
+		case 'a':
+			out.WriteByte('\a')
+		case 'b':
+			out.WriteByte('\b')
+		case 'e', 'E':
+			out.WriteByte('\x1b')
+		case 'f':
+			out.WriteByte('\f')
+		case 'n':
+			out.WriteByte('\n')
+		case 'r':
+			out.WriteByte('\r')
+		case 't':
+			out.WriteByte('\t')
+		case 'v':
+			out.WriteByte('\v')
+		case '\\', '\'', '"', '?':
+			out.WriteRune(c)
+		case 'c':
+			if !p.eof() {
+				out.WriteByte(byte(p.peek()) & 0x1f)
+				p.pos++
+			}
+		case 'x', 'u', 'U':
+			maxDigits := map[rune]int{'x': 2, 'u': 4, 'U': 8}[c]
+			n := p.digits(16, maxDigits)
+			if n == "" {
+				out.WriteRune('\\')
+				out.WriteRune(c)
+				continue
+			}
+			value, _ := strconv.ParseUint(n, 16, 32)
+			if c == 'x' {
+				out.WriteByte(byte(value))
+			} else if utf8.ValidRune(rune(value)) {
+				out.WriteRune(rune(value))
+			}
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			p.pos--
+			value, _ := strconv.ParseUint(p.digits(8, 3), 8, 32)
+			out.WriteByte(byte(value))
+		default:
+			out.WriteRune('\\')
+			out.WriteRune(c)
+		}
+	}
+}
+
+// Consumes up to max digits in the given base.
+func (p *parser) digits(base int, max int) string {
+	start := p.pos
+	for p.pos-start < max && !p.eof() {
+		if _, err := strconv.ParseUint(string(p.peek()), base, 8); err != nil {
+			break
+		}
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
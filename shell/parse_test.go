@@ -0,0 +1,258 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Writes a parsed list back out in a form that shows its structure: words
+// in brackets, quoted text in Go quotes, and $(...) and ${...} as parsed.
+func dumpList(list *List) string {
+	items := []string{}
+	for _, andOr := range list.Items {
+		s := dumpPipeline(andOr.Pipelines[0])
+		for i, op := range andOr.Ops {
+			s += " " + op + " " + dumpPipeline(andOr.Pipelines[i+1])
+		}
+		items = append(items, s)
+	}
+	return strings.Join(items, "; ")
+}
+
+func dumpPipeline(pipeline *Pipeline) string {
+	commands := []string{}
+	for _, cmd := range pipeline.Commands {
+		commands = append(commands, dumpCommand(cmd))
+	}
+	s := strings.Join(commands, " | ")
+	if pipeline.Negated {
+		s = "! " + s
+	}
+	return s
+}
+
+func dumpCommand(cmd Command) string {
+	parts := []string{}
+	var redirects []*Redirect
+	switch cmd := cmd.(type) {
+	case *SimpleCommand:
+		for _, assign := range cmd.Assigns {
+			parts = append(parts, assign.Name+"="+dumpWord(assign.Value))
+		}
+		for _, arg := range cmd.Args {
+			parts = append(parts, dumpWord(arg))
+		}
+		redirects = cmd.Redirects
+	case *Subshell:
+		parts = append(parts, "("+dumpList(cmd.List)+")")
+		redirects = cmd.Redirects
+	}
+	for _, redirect := range redirects {
+		parts = append(parts, fmt.Sprintf("%d%s%s", redirect.Fd, redirect.Op, dumpWord(redirect.Target)))
+	}
+	return strings.Join(parts, " ")
+}
+
+func dumpWord(word Word) string {
+	return "[" + dumpParts(word) + "]"
+}
+
+func dumpParts(word Word) string {
+	s := ""
+	for _, part := range word {
+		switch part := part.(type) {
+		case *Lit:
+			if part.Quoted {
+				s += strconv.Quote(part.Value)
+			} else {
+				s += part.Value
+			}
+		case *CmdSubst:
+			s += "$(" + dumpList(part.List) + ")"
+		case *ArithExp:
+			s += "$((" + dumpParts(part.Expr) + "))"
+		case *ParamExp:
+			if part.Invalid != "" {
+				s += "<bad " + part.Invalid + ">"
+				continue
+			}
+			name := part.Name
+			if part.Length {
+				name = "#" + name
+			}
+			s += "${" + name + part.Op + dumpParts(part.Word)
+			if part.Arg != nil {
+				s += "|" + dumpParts(part.Arg)
+			}
+			s += "}"
+		}
+	}
+	return s
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		// Quotes and escapes.
+		{`echo hello world`, `[echo] [hello] [world]`},
+		{`echo 'a  b' "c  d"`, `[echo] ["a  b"] ["c  d"]`},
+		{`echo ''`, `[echo] [""]`},
+		{`echo a'b'"c"`, `[echo] [a"bc"]`},
+		{`echo 'a\nb' "\$x \" \\ \a"`, `[echo] ["a\\nb"] ["$x \" \\ \\a"]`},
+		{`echo a\ b \$x`, `[echo] [a" "b] ["$"x]`},
+		{"echo a\\\nb", `[echo] [ab]`},
+		{`echo $'a\tb\x41\101\u00e9\'\\'`, `[echo] ["a\tbAAé'\\"]`},
+		{`echo $'\e[0m' $'\cA'`, `[echo] ["\x1b[0m"] ["\x01"]`},
+		{`echo a # comment`, `[echo] [a]`},
+		{`echo a#b`, `[echo] [a#b]`},
+		// Lists, and-or lists and pipelines.
+		{`a; b`, `[a]; [b]`},
+		{"a\nb", `[a]; [b]`},
+		{`a & b`, `[a]; [b]`},
+		{`a && b || c`, `[a] && [b] || [c]`},
+		{`a | b | c`, `[a] | [b] | [c]`},
+		{"a |\nb", `[a] | [b]`},
+		{`! a | b`, `! [a] | [b]`},
+		{`a && b | c; d`, `[a] && [b] | [c]; [d]`},
+		// Redirections.
+		{`echo a > f`, `[echo] [a] 1>[f]`},
+		{`echo a >> f`, `[echo] [a] 1>>[f]`},
+		{`echo a >| f`, `[echo] [a] 1>[f]`},
+		{`cat < f`, `[cat] 0<[f]`},
+		{`cat <f >g`, `[cat] 0<[f] 1>[g]`},
+		{`cmd 2>&1`, `[cmd] 2>&[1]`},
+		{`cmd 2>/dev/null`, `[cmd] 2>[/dev/null]`},
+		{`cmd &> f`, `[cmd] 1&>[f]`},
+		{`cmd &>> f`, `[cmd] 1&>>[f]`},
+		{`cmd >&2`, `[cmd] 1>&[2]`},
+		{`cmd <&0`, `[cmd] 0<&[0]`},
+		{`cmd 3<&-`, `[cmd] 3<&[-]`},
+		{`cmd <<< "a b"`, `[cmd] 0<<<["a b"]`},
+		{`echo 2>f a`, `[echo] [a] 2>[f]`},
+		{`echo a2>f`, `[echo] [a2] 1>[f]`},
+		{`> f`, `1>[f]`},
+		// Here-documents.
+		{"cat <<EOF\nhello $USER\nEOF\necho done", `[cat] 0<<["hello "${USER}"\n"]; [echo] [done]`},
+		{"cat <<'EOF'\nhello $USER\nEOF", `[cat] 0<<["hello $USER\n"]`},
+		{"cat <<\"EOF\"\n$(id)\nEOF", `[cat] 0<<["$(id)\n"]`},
+		{"cat <<E\\OF\n\\$x\nEOF", `[cat] 0<<["\\$x\n"]`},
+		{"cat <<EOF\n\\$x \\a $(id)\nEOF", `[cat] 0<<["$x \\a "$([id])"\n"]`},
+		{"cat <<-EOF\n\t\tindented\n\tEOF", `[cat] 0<<["indented\n"]`},
+		{"cat <<EOF\n\tkept\nEOF", `[cat] 0<<["\tkept\n"]`},
+		{"cat <<A <<B\na\nA\nb\nB", `[cat] 0<<["a\n"] 0<<["b\n"]`},
+		{"cat <<EOF | wc -l\na\nb\nEOF", `[cat] 0<<["a\nb\n"] | [wc] [-l]`},
+		{"cat <<EOF\nno end", `[cat] 0<<["no end\n"]`},
+		{"cat <<EOF", `[cat] 0<<[]`},
+		{"cat <<EOF; echo a\nbody\nEOF\necho b", `[cat] 0<<["body\n"]; [echo] [a]; [echo] [b]`},
+		// Subshells and command substitution.
+		{`(cd /; ls)`, `([cd] [/]; [ls])`},
+		{`(a) > f`, `([a]) 1>[f]`},
+		{`( (a) )`, `(([a]))`},
+		{`echo $(id -u) "$(pwd)"`, `[echo] [$([id] [-u])] [""$([pwd])]`},
+		{"echo `id`", `[echo] [$([id])]`},
+		{`echo $(echo $(id))`, `[echo] [$([echo] [$([id])])]`},
+		{`echo $(a; b)x`, `[echo] [$([a]; [b])x]`},
+		// Arithmetic expansion.
+		{`echo $((1+2))`, `[echo] [$(("1+2"))]`},
+		{`echo $(( (1+2) * 3 ))x`, `[echo] [$((" (1+2) * 3 "))x]`},
+		{`echo "$((x*2))"`, `[echo] [""$(("x*2"))]`},
+		{`echo $(($x + $(id -u) + $((1))))`, `[echo] [$((${x}" + "$([id] [-u])" + "$(("1"))))]`},
+		{`echo $((cd /; ls) )`, `[echo] [$(([cd] [/]; [ls]))]`},
+		{`echo $(( (a) ) )`, `[echo] [$((([a])))]`},
+		{`echo $(())`, `[echo] [$(())]`},
+		// Parameters and assignments.
+		{`echo $HOME ${HOME} $? $$ $1`, `[echo] [${HOME}] [${HOME}] [${?}] [${$}] [${1}]`},
+		{`echo "${x:-a b}" ${#x}`, `[echo] [""${x:-"a b"}] [${#x}]`},
+		{`echo $ "$"`, `[echo] [$] ["$"]`},
+		{`echo ${x#*.} ${x##*.} ${x%.*} ${x%%.*}`, `[echo] [${x#*.}] [${x##*.}] [${x%.*}] [${x%%.*}]`},
+		{`echo "${x#*.}"`, `[echo] [""${x#*.}]`},
+		{`echo ${x#"*"}`, `[echo] [${x#"*"}]`},
+		{`echo ${x/a/b} ${x//a/b} ${x/#a/b} ${x/%a/b}`, `[echo] [${x/a|b}] [${x//a|b}] [${x/#a|b}] [${x/%a|b}]`},
+		{`echo ${x/a} ${x/a/}`, `[echo] [${x/a}] [${x/a|}]`},
+		{`echo "${x/a/b c}"`, `[echo] [""${x/a|"b c"}]`},
+		{`echo ${x/$y/$(id)}`, `[echo] [${x/${y}|$([id])}]`},
+		{`echo ${x:1} ${x:0:3} ${x: -2:1} ${x:1:}`, `[echo] [${x:1}] [${x:0|3}] [${x: -2|1}] [${x:1|}]`},
+		{`echo ${x:-1}`, `[echo] [${x:-1}]`},
+		{`echo ${} ${x@Z} ${#x:-a} a`, `[echo] [<bad ${}>] [<bad ${x@Z}>] [<bad ${#x:-a}>] [a]`},
+		{`echo "${x!y}"`, `[echo] [""<bad ${x!y}>]`},
+		{`X=1 Y= cmd Z=2`, `X=[1] Y=[] [cmd] [Z=2]`},
+		{`X="a b"`, `X=["a b"]`},
+		{`1X=a`, `[1X=a]`},
+	}
+	for _, test := range tests {
+		list, err := Parse(test.src)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.src, err)
+			continue
+		}
+		if got := dumpList(list); got != test.want {
+			t.Errorf("Parse(%q) = %s, want %s", test.src, got, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`echo 'a`, "unexpected EOF while looking for matching `''"},
+		{`echo "a`, "unexpected EOF while looking for matching `\"'"},
+		{`echo $'a`, "unexpected EOF while looking for matching `''"},
+		{`echo $(a`, "unexpected EOF while looking for matching `)'"},
+		{"echo `a", "unexpected EOF while looking for matching ``'"},
+		{`echo ${x`, "unexpected EOF while looking for matching `}'"},
+		{`echo ${x/a`, "unexpected EOF while looking for matching `}'"},
+		{`echo ${x@Z`, "unexpected EOF while looking for matching `}'"},
+		{`echo $((1+2`, "unexpected EOF while looking for matching `)'"},
+		{`(a`, "syntax error: unexpected end of file"},
+		{`a &&`, "syntax error: unexpected end of file"},
+		{`a |`, "syntax error: unexpected end of file"},
+		{`| a`, "syntax error near unexpected token `|'"},
+		{`a ;; b`, "syntax error near unexpected token `;;'"},
+		{`a > `, "syntax error near unexpected token `newline'"},
+		{`a > | b`, "syntax error near unexpected token `|'"},
+		{`a << `, "syntax error near unexpected token `newline'"},
+		{`a <<'EOF`, "unexpected EOF while looking for matching `''"},
+		{`()`, "syntax error near unexpected token `)'"},
+		{`a (b)`, "syntax error near unexpected token `('"},
+		{`a )`, "syntax error near unexpected token `)'"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.src)
+		if _, ok := err.(*SyntaxError); !ok || err.Error() != test.want {
+			t.Errorf("Parse(%q) error = %v, want %q", test.src, err, test.want)
+		}
+	}
+}
+
+// Long lines, as pasted payloads make, parse in linear time.
+func TestParseLongLine(t *testing.T) {
+	const size = 1 << 20
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"one word", "echo " + strings.Repeat("a", size)},
+		{"many words", "echo" + strings.Repeat(" a", size/2)},
+		{"double quoted", `echo "` + strings.Repeat("a$b", size/3) + `"`},
+		{"single quoted", "echo '" + strings.Repeat("a", size) + "'"},
+		{"pipeline", "a" + strings.Repeat(" | a", size/4)},
+		{"here-document", "cat <<EOF\n" + strings.Repeat("a $b\n", size/5) + "EOF"},
+		{"nested $((", "echo " + strings.Repeat("$(( ", 2000) + "1" + strings.Repeat(" ) )", 2000)},
+	}
+	for _, test := range tests {
+		start := time.Now()
+		if _, err := Parse(test.src); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: parsing 1 MB took %s", test.name, elapsed)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeystats/ssh/shell"
+)
+
+// Parses src as a single command and returns its words.
+func parseWords(t *testing.T, src string) []shell.Word {
+	t.Helper()
+	list, err := shell.Parse(src)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", src, err)
+	}
+	return list.Items[0].Pipelines[0].Commands[0].(*shell.SimpleCommand).Args
+}
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{`a{b,c}d`, []string{"abd", "acd"}},
+		{`"{a,b}"`, []string{"{a,b}"}},
+		{`{a,b}"x"{1,2}`, []string{"ax1", "ax2", "bx1", "bx2"}},
+		{`{1..9999}""{1..9999}`, []string{"{1..9999}{1..9999}"}},
+		{`{{1..9999},x}{1..9999}`, []string{`{{1..9999},x}{1..9999}`}},
+		{`{1..9999999999999999999}`, []string{"{1..9999999999999999999}"}},
+	}
+	for _, test := range tests {
+		got := expandBraces(parseWords(t, test.src)[0])
+		if len(got) != len(test.want) {
+			t.Errorf("expandBraces(%q) gave %d words, want %d", test.src, len(got), len(test.want))
+			continue
+		}
+		for i, word := range got {
+			if text := wordText(word); text != test.want[i] {
+				t.Errorf("expandBraces(%q)[%d] = %q, want %q", test.src, i, text, test.want[i])
+			}
+		}
+	}
+}
+
+func TestShellRun(t *testing.T) {
+	tests := []struct {
+		line       string
+		wantStdout string
+		wantStderr string
+		wantStatus int
+	}{
+		{`echo 'a  b' "c  d" e\ f`, "a  b c  d e f\n", "", 0},
+		{`echo $'a\tb'`, "a\tb\n", "", 0},
+		{`echo a; echo b`, "a\nb\n", "", 0},
+		{`cat /nowhere && echo yes || echo no`, "no\n", "cat: /nowhere: No such file or directory\n", 0},
+		{`echo hi | cat | cat`, "hi\n", "", 0},
+		{`! cat /nowhere 2>/dev/null`, "", "", 0},
+		{`echo hi > /a/f; echo again >> /a/f; cat < /a/f`, "hi\nagain\n", "", 0},
+		{`cat /nowhere 2>&1 | cat >/dev/null`, "", "", 0},
+		{`cat /nowhere 2>/dev/null; echo $?`, "1\n", "", 0},
+		{`cat < /nowhere`, "", "bash: /nowhere: No such file or directory\n", 1},
+		{`(cd /etc; pwd); pwd`, "/etc\n/\n", "", 0},
+		{`echo $(echo a; echo b) "$(echo c)"`, "a b c\n", "", 0},
+		// Here-documents and here-strings.
+		{"cat <<EOF\nhome $HOME\n$(echo sub)\nEOF", "home /root\nsub\n", "", 0},
+		{"cat <<'EOF'\nhome $HOME\nEOF", "home $HOME\n", "", 0},
+		{"cat <<-EOF\n\tindented\n\tEOF\necho after", "indented\nafter\n", "", 0},
+		{"cat <<EOF | cat\npiped\nEOF", "piped\n", "", 0},
+		{`cat <<< "a  b"`, "a  b\n", "", 0},
+		// Duplicating standard input.
+		{`echo x | cat <&0`, "x\n", "", 0},
+		{`echo x | cat <&-`, "", "", 0},
+		{`cat <&7`, "", "bash: 7: Bad file descriptor\n", 1},
+		{`cat <&file`, "", "bash: file: ambiguous redirect\n", 1},
+		// Each command of a pipeline runs in a subshell.
+		{`cd /etc | cat; pwd`, "/\n", "", 0},
+		{`exit 3 | cat; echo still`, "still\n", "", 0},
+		{`echo a | exit 4; echo $?`, "4\n", "", 0},
+	}
+	for _, test := range tests {
+		stdout, stderr, status := runTestShell(newTestState(), test.line)
+		if stdout != test.wantStdout || stderr != test.wantStderr || status != test.wantStatus {
+			t.Errorf("%q: got %q, %q, status %d, want %q, %q, status %d",
+				test.line, stdout, stderr, status, test.wantStdout, test.wantStderr, test.wantStatus)
+		}
+	}
+}
+
+func TestExitInPipeline(t *testing.T) {
+	tests := []struct {
+		line       string
+		wantExited bool
+	}{
+		{"exit", true},
+		{"exit | cat", false},
+		{"echo | exit", false},
+		{"(exit)", false},
+		{"pwd && exit", true},
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		sh := newShell(newTestContext("root", "session"), newTestState(), true)
+		sh.Run(test.line, &out, &out)
+		if sh.exited != test.wantExited {
+			t.Errorf("%q: exited = %v, want %v", test.line, sh.exited, test.wantExited)
+		}
+	}
+}
+
+func TestParamExpansion(t *testing.T) {
+	tests := []struct {
+		line       string
+		wantStdout string
+		wantStderr string
+		wantStatus int
+	}{
+		{`f=archive.tar.gz; echo ${f#*.} ${f##*.} ${f%.*} ${f%%.*}`, "tar.gz gz archive.tar archive\n", "", 0},
+		{`f=archive.tar.gz; echo "${f#"*."}" ${f#\*} ${f%.gz}x`, "archive.tar.gz archive.tar.gz archive.tarx\n", "", 0},
+		{`p=/usr/local/bin; echo ${p##*/} "${p%/*}" ${p#/}`, "bin /usr/local usr/local/bin\n", "", 0},
+		{`x=a-b-c; echo ${x/-/+} ${x//-/+} ${x/#a/A} ${x/%c/C} ${x/#b/B} ${x//-} ${x/-/}`, "a+b-c a+b+c A-b-c a-b-C a-b-c abc ab-c\n", "", 0},
+		{`x=aaa; echo ${x//a*/b} ${x/a?/b} ${x//[a]/b} ${x/#/<} ${x/%/>}`, "b ba bbb <aaa aaa>\n", "", 0},
+		{`x='a b'; echo "${x/ /  }" ${x// /_}`, "a  b a_b\n", "", 0},
+		{`x=hello; echo ${x:1} ${x:0:3} ${x: -2} ${x: -3:2} ${x:1:-1} ${x:9} ${x:2:0}x`, "ello hel lo ll ell x\n", "", 0},
+		{`n=2; x=hello; echo ${x:n:n}`, "ll\n", "", 0},
+		{`x=héllo; echo ${x:1:2} ${#x} ${x#h?}`, "él 5 llo\n", "", 0},
+		// Assignments and ${NAME:-word} aren't split.
+		{`X=$(echo 'a  b'); echo "$X"`, "a  b\n", "", 0},
+		{`Y=${unset:-$(echo 'c  d')}; echo "$Y"`, "c  d\n", "", 0},
+		{`echo "${unset:-a  b}" ${unset:-e  f}`, "a  b e f\n", "", 0},
+		{`Z=${unset:-"q  r"}; echo "$Z"`, "q  r\n", "", 0},
+		{`echo ${W:=$(echo 's  t')} >/dev/null; echo "$W"`, "s  t\n", "", 0},
+		// A bad substitution fails just the command it is in.
+		{`echo ${x!y} a; echo next $?`, "next 1\n", "bash: ${x!y}: bad substitution\n", 0},
+		{`echo "${}"`, "", "bash: ${}: bad substitution\n", 1},
+		{`x=abc; echo ${x:1:-5}; echo next`, "next\n", "bash: -5: substring expression < 0\n", 0},
+		{`x=abc; echo ${x:1+}; echo next`, "", "bash: x: 1+: syntax error: operand expected (error token is \"+\")\n", 1},
+		{`x=abcdef; echo ${x:1+1:2*2} ${x:9223372036854775807}x ${x:1:9223372036854775807}`, "cdef x bcdef\n", "", 0},
+		// ${NAME:?} still stops a non-interactive shell.
+		{`echo ${unset:?gone}; echo next`, "", "bash: unset: gone\n", 1},
+	}
+	for _, test := range tests {
+		stdout, stderr, status := runTestShell(newTestState(), test.line)
+		if stdout != test.wantStdout || stderr != test.wantStderr || status != test.wantStatus {
+			t.Errorf("%q: got %q, %q, status %d, want %q, %q, status %d",
+				test.line, stdout, stderr, status, test.wantStdout, test.wantStderr, test.wantStatus)
+		}
+	}
+}
+
+// Patterns full of stars that nearly match stay quick in every operator
+// that matches them against part of a value.
+func TestPatternManyStars(t *testing.T) {
+	pattern := strings.Repeat("*a", 8) + "*b"
+	line := "x=" + strings.Repeat("a", 40) + "; echo ${x#" + pattern + "} ${x##" + pattern + "} ${x%" + pattern + "} ${x%%" + pattern + "} ${x/" + pattern + "/y} ${x//" + pattern + "/y}"
+	want := strings.TrimSuffix(strings.Repeat(strings.Repeat("a", 40)+" ", 6), " ") + "\n"
+	start := time.Now()
+	stdout, stderr, status := runTestShell(newTestState(), line)
+	if stdout != want || stderr != "" || status != 0 {
+		t.Errorf("got %q, %q, status %d, want %q", stdout, stderr, status, want)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expanding took %v", elapsed)
+	}
+}
+
+func TestArithmeticExpansion(t *testing.T) {
+	tests := []struct {
+		line       string
+		wantStdout string
+		wantStderr string
+		wantStatus int
+	}{
+		{`echo $((1+2)) $(( (1+2) * 3 )) $((2**10)) $((7%3)) $((-3/2))`, "3 9 1024 1 -1\n", "", 0},
+		{`x=3; echo $((x*2+1)) $(($x*2)) "$((x>2 ? 10 : 20))"`, "7 6 10\n", "", 0},
+		{`x=2+3; echo $((x*2))`, "10\n", "", 0},
+		{`echo $((y)) $((0x10 + 010 + 2#101))`, "0 29\n", "", 0},
+		{`echo $((x=4)) $x; echo $((x+=2)) $x`, "4 4\n6 6\n", "", 0},
+		{`i=0; i=$((i+1)); echo $i`, "1\n", "", 0},
+		{`echo $(($(echo 6) * 7))`, "42\n", "", 0},
+		{`echo $((9223372036854775807+1))`, "-9223372036854775808\n", "", 0},
+		{`echo $((0 && 1/0)) $((1 || 1/0))`, "0 1\n", "", 0},
+		// As bash does, this is a command substitution of a subshell.
+		{`echo $((echo hi) )`, "hi\n", "", 0},
+		// Errors stop a non-interactive shell.
+		{`echo $((1/0)); echo next`, "", "bash: 1/0: division by 0 (error token is \"0\")\n", 1},
+		{`echo $((1 +)); echo next`, "", "bash: 1 +: syntax error: operand expected (error token is \"+\")\n", 1},
+		{`x=$((1 2)); echo next`, "", "bash: 1 2: syntax error in expression (error token is \"2\")\n", 1},
+		{`echo $((08))`, "", "bash: 08: value too great for base (error token is \"08\")\n", 1},
+	}
+	for _, test := range tests {
+		stdout, stderr, status := runTestShell(newTestState(), test.line)
+		if stdout != test.wantStdout || stderr != test.wantStderr || status != test.wantStatus {
+			t.Errorf("%q: got %q, %q, status %d, want %q, %q, status %d",
+				test.line, stdout, stderr, status, test.wantStdout, test.wantStderr, test.wantStatus)
+		}
+	}
+}
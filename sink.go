@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/sirupsen/logrus"
+)
+
+// EventSink is a destination for SSHDocs. Emit may buffer; Flush forces
+// anything buffered out, and Close flushes and releases the sink.
+type EventSink interface {
+	Emit(doc SSHDoc) error
+	Flush() error
+	Close() error
+}
+
+type sinkFactory func() (EventSink, error)
+
+// Sinks available to the SINKS environment variable, keyed by name.
+var sinkFactories = map[string]sinkFactory{}
+
+func registerSink(name string, factory sinkFactory) {
+	sinkFactories[name] = factory
+}
+
+// MultiSink fans every event out to all of its sinks. One sink failing does
+// not stop the others from receiving the event.
+type MultiSink struct {
+	Names []string
+	Sinks []EventSink
+}
+
+func (m *MultiSink) each(op string, fn func(EventSink) error) error {
+	var failed []string
+	for i, sink := range m.Sinks {
+		if err := fn(sink); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"sink": m.Names[i],
+				"err":  err,
+			}).Errorf("Error during sink %s", op)
+			failed = append(failed, m.Names[i])
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("sink %s failed for: %s", op, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (m *MultiSink) Emit(doc SSHDoc) error {
+	return m.each("emit", func(s EventSink) error {
+		return s.Emit(doc)
+	})
+}
+
+func (m *MultiSink) Flush() error {
+	return m.each("flush", func(s EventSink) error {
+		return s.Flush()
+	})
+}
+
+func (m *MultiSink) Close() error {
+	return m.each("close", func(s EventSink) error {
+		return s.Close()
+	})
+}
+
+var SINK EventSink
+
+var sinkCloseOnce sync.Once
+
+// Reads the comma separated SINKS environment variable (default
+// "elasticsearch") and builds every sink named there.
+func setupSinks() {
+	names := envOrDefault("SINKS", "elasticsearch")
+	multi := &MultiSink{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		factory, ok := sinkFactories[name]
+		if !ok {
+			logrus.WithField("sink", name).Fatal("Unknown sink in SINKS")
+		}
+		sink, err := factory()
+		if err == nil {
+			sink, err = maybeSpool(name, sink)
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"sink": name,
+				"err":  err,
+			}).Fatal("Error setting up sink")
+		}
+		logrus.WithField("sink", name).Infoln("Event sink enabled")
+		multi.Names = append(multi.Names, name)
+		multi.Sinks = append(multi.Sinks, sink)
+	}
+	if len(multi.Sinks) == 0 {
+		logrus.Warnln("No event sinks configured, events will be discarded")
+	}
+	SINK = multi
+}
+
+func closeSinks() {
+	sinkCloseOnce.Do(func() {
+		if SINK == nil {
+			return
+		}
+		if err := SINK.Close(); err != nil {
+			logrus.WithError(err).Errorln("Error closing event sinks")
+		}
+	})
+}
+
+// The client's IP and port, for IPv4 and IPv6 alike.
+func sourceAddr(ctx ssh.Context) (string, string) {
+	host, port, err := net.SplitHostPort(ctx.RemoteAddr().String())
+	if err != nil {
+		return "", ""
+	}
+	return host, port
+}
+
+func newSSHDoc(ctx ssh.Context, state *SessionState, doc SubDocument) SSHDoc {
+	cwd, passwords, keys := state.snapshot()
+	toplevelDoc := SSHDoc{
+		Timestamp: time.Now(),
+		Action:    doc.action(),
+		Cwd:       cwd,
+		Passwords: passwords,
+		Keys:      keys,
+		Fields:    doc,
+		SessionID: ctx.SessionID(),
+		Username:  ctx.User(),
+	}
+	toplevelDoc.SourceIP, toplevelDoc.SourcePort = sourceAddr(ctx)
+	if referrer, ok := doc.(payloadReferrer); ok {
+		toplevelDoc.Payloads = referrer.payloadRefs()
+	}
+	if DEBUG {
+		toplevelDoc.SourceIP = randSourceIP()
+	}
+	return toplevelDoc
+}
+
+// Builds the SSHDoc for an event and hands it to the configured sinks.
+func emitEvent(ctx ssh.Context, state *SessionState, doc SubDocument) {
+	emitDoc(newSSHDoc(ctx, state, doc))
+}
+
+// Hands an SSHDoc built earlier to the configured sinks, for events whose
+// details are only known after the fact.
+func emitDoc(doc SSHDoc) {
+	if SINK == nil {
+		return
+	}
+	if err := SINK.Emit(doc); err != nil {
+		logrus.WithError(err).Errorln("Error emitting event")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// memorySink keeps what it is sent, failing every call while err is set.
+type memorySink struct {
+	mu      sync.Mutex
+	docs    []SSHDoc
+	flushes int
+	closed  bool
+	err     error
+}
+
+func (m *memorySink) Emit(doc SSHDoc) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.docs = append(m.docs, doc)
+	return nil
+}
+
+func (m *memorySink) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flushes++
+	return m.err
+}
+
+func (m *memorySink) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return m.err
+}
+
+func (m *memorySink) emitted() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.docs)
+}
+
+func TestMultiSink(t *testing.T) {
+	failure := errors.New("down")
+	tests := []struct {
+		name    string
+		errs    []error
+		wantErr bool
+	}{
+		{"no sinks", nil, false},
+		{"all working", []error{nil, nil}, false},
+		{"one failing", []error{failure, nil}, true},
+		{"all failing", []error{failure, failure}, true},
+	}
+	for _, test := range tests {
+		multi := &MultiSink{}
+		sinks := []*memorySink{}
+		for i, err := range test.errs {
+			sink := &memorySink{err: err}
+			sinks = append(sinks, sink)
+			multi.Names = append(multi.Names, string(rune('a'+i)))
+			multi.Sinks = append(multi.Sinks, sink)
+		}
+		if err := multi.Emit(SSHDoc{Action: "login"}); (err != nil) != test.wantErr {
+			t.Errorf("%s: Emit error = %v, want error %v", test.name, err, test.wantErr)
+		}
+		if err := multi.Flush(); (err != nil) != test.wantErr {
+			t.Errorf("%s: Flush error = %v, want error %v", test.name, err, test.wantErr)
+		}
+		if err := multi.Close(); (err != nil) != test.wantErr {
+			t.Errorf("%s: Close error = %v, want error %v", test.name, err, test.wantErr)
+		}
+		for i, sink := range sinks {
+			want := 1
+			if test.errs[i] != nil {
+				want = 0
+			}
+			if got := sink.emitted(); got != want {
+				t.Errorf("%s: sink %d got %d events, want %d", test.name, i, got, want)
+			}
+			if sink.flushes != 1 || !sink.closed {
+				t.Errorf("%s: sink %d flushed %d times, closed %v", test.name, i, sink.flushes, sink.closed)
+			}
+		}
+	}
+}
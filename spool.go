@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ReplayableSink is implemented by sinks that deliver asynchronously. Instead
+// of dropping documents they fail to deliver, they hand them to the failure
+// handler, and they can be asked to deliver documents synchronously.
+type ReplayableSink interface {
+	EventSink
+	// Deliver sends docs and returns the ones that could not be delivered.
+	Deliver(docs []json.RawMessage) ([]json.RawMessage, error)
+	SetFailureHandler(fn func(docs []json.RawMessage, err error))
+}
+
+const spoolSegmentPrefix = "spool-"
+const spoolSegmentSuffix = ".jsonl"
+
+// Spool is an on-disk queue of JSON documents, one per line, split across
+// numbered segment files. Every append is fsynced before it returns. When the
+// spool grows past MaxSize, or a segment is older than MaxAge, the oldest
+// segments are deleted first, except one that is being replayed.
+type Spool struct {
+	Dir         string
+	SegmentSize int64
+	MaxSize     int64
+	MaxAge      time.Duration
+
+	mu          sync.Mutex
+	current     *os.File
+	currentSeq  uint64
+	currentSize int64
+	// Segments handed out by Oldest and not yet finished.
+	inFlight map[uint64]bool
+}
+
+type spoolSegment struct {
+	Seq     uint64
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+func OpenSpool(dir string, segmentSize int64, maxSize int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	spool := &Spool{
+		Dir:         dir,
+		SegmentSize: segmentSize,
+		MaxSize:     maxSize,
+		MaxAge:      maxAge,
+		inFlight:    map[uint64]bool{},
+	}
+	segments, err := spool.segments()
+	if err != nil {
+		return nil, err
+	}
+	// Never append to a segment left over from a previous run, it may end
+	// in a partially written line.
+	if len(segments) > 0 {
+		spool.currentSeq = segments[len(segments)-1].Seq
+	}
+	return spool, nil
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("%s%020d%s", spoolSegmentPrefix, seq, spoolSegmentSuffix))
+}
+
+// Lists segments on disk, oldest first.
+func (s *Spool) segments() ([]spoolSegment, error) {
+	infos, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := []spoolSegment{}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, spoolSegmentPrefix) || !strings.HasSuffix(name, spoolSegmentSuffix) {
+			continue
+		}
+		var seq uint64
+		_, err := fmt.Sscanf(strings.TrimPrefix(name, spoolSegmentPrefix), "%d", &seq)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, spoolSegment{
+			Seq:     seq,
+			Path:    filepath.Join(s.Dir, name),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Seq < segments[j].Seq
+	})
+	return segments, nil
+}
+
+func (s *Spool) rotateLocked() error {
+	if s.current == nil {
+		return nil
+	}
+	err := s.current.Close()
+	s.current = nil
+	s.currentSize = 0
+	return err
+}
+
+// Append writes docs to the current segment and fsyncs it.
+func (s *Spool) Append(docs []json.RawMessage) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current != nil && s.currentSize >= s.SegmentSize {
+		if err := s.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	if s.current == nil {
+		s.currentSeq++
+		f, err := os.OpenFile(s.segmentPath(s.currentSeq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		s.current = f
+	}
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		buf.Write(doc)
+		buf.WriteByte('\n')
+	}
+	n, err := s.current.Write(buf.Bytes())
+	s.currentSize += int64(n)
+	if err != nil {
+		return err
+	}
+	if err := s.current.Sync(); err != nil {
+		return err
+	}
+	return s.enforceLimitsLocked()
+}
+
+// Deletes the oldest segments until the spool is within MaxSize and MaxAge.
+// The segment currently being written is never deleted, and neither is one
+// being replayed, which Finish deals with instead.
+func (s *Spool) enforceLimitsLocked() error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, segment := range segments {
+		total += segment.Size
+	}
+	for _, segment := range segments {
+		if segment.Seq == s.currentSeq && s.current != nil {
+			break
+		}
+		if s.inFlight[segment.Seq] {
+			continue
+		}
+		tooBig := s.MaxSize > 0 && total > s.MaxSize
+		tooOld := s.MaxAge > 0 && time.Since(segment.ModTime) > s.MaxAge
+		if !tooBig && !tooOld {
+			break
+		}
+		if err := os.Remove(segment.Path); err != nil {
+			return err
+		}
+		total -= segment.Size
+		logrus.WithFields(logrus.Fields{
+			"segment": segment.Path,
+			"bytes":   segment.Size,
+			"tooBig":  tooBig,
+			"tooOld":  tooOld,
+		}).Warnln("Dropped spool segment")
+	}
+	return nil
+}
+
+// Oldest returns the oldest segment and its documents, sealing the current
+// segment first if it is the only one. ok is false when the spool is empty.
+// The segment is kept until it is passed to Finish.
+func (s *Spool) Oldest() (segment spoolSegment, docs []json.RawMessage, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enforceLimitsLocked(); err != nil {
+		return segment, nil, false, err
+	}
+	segments, err := s.segments()
+	if err != nil || len(segments) == 0 {
+		return segment, nil, false, err
+	}
+	segment = segments[0]
+	if segment.Seq == s.currentSeq && s.current != nil {
+		if err := s.rotateLocked(); err != nil {
+			return segment, nil, false, err
+		}
+	}
+	docs, err = readSpoolSegment(segment.Path)
+	if err != nil {
+		return segment, nil, false, err
+	}
+	s.inFlight[segment.Seq] = true
+	return segment, docs, true, nil
+}
+
+func readSpoolSegment(path string) ([]json.RawMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	docs := []json.RawMessage{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		// A crash mid-write can leave a truncated last line behind.
+		if !json.Valid(line) {
+			logrus.WithField("segment", path).Warnln("Skipping corrupt spool record")
+			continue
+		}
+		docs = append(docs, append(json.RawMessage{}, line...))
+	}
+	return docs, scanner.Err()
+}
+
+// Finish removes a replayed segment, or rewrites it with the documents that
+// are left when only some of them were delivered.
+func (s *Spool) Finish(segment spoolSegment, remaining []json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer delete(s.inFlight, segment.Seq)
+	if len(remaining) == 0 {
+		return os.Remove(segment.Path)
+	}
+	tmpPath := segment.Path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, doc := range remaining {
+		w.Write(doc)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, segment.Path)
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rotateLocked()
+}
+
+// SpoolingSink wraps a ReplayableSink. Documents the inner sink gives up on
+// are written to the spool, and a background loop replays the spool, oldest
+// segment first, whenever the inner sink accepts deliveries again.
+type SpoolingSink struct {
+	Inner          ReplayableSink
+	Spool          *Spool
+	ReplayInterval time.Duration
+	ReplayBatch    int
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func newSpoolingSink(inner ReplayableSink, spool *Spool, replayInterval time.Duration, replayBatch int) *SpoolingSink {
+	ss := &SpoolingSink{
+		Inner:          inner,
+		Spool:          spool,
+		ReplayInterval: replayInterval,
+		ReplayBatch:    replayBatch,
+		done:           make(chan struct{}),
+	}
+	inner.SetFailureHandler(func(docs []json.RawMessage, err error) {
+		logrus.WithFields(logrus.Fields{
+			"count": len(docs),
+			"err":   err,
+		}).Warnln("Spooling undelivered documents to disk")
+		if spoolErr := spool.Append(docs); spoolErr != nil {
+			logrus.WithFields(logrus.Fields{
+				"count": len(docs),
+				"err":   spoolErr,
+			}).Errorln("Error writing to spool, documents lost")
+		}
+	})
+	ss.wg.Add(1)
+	go ss.replayLoop()
+	return ss
+}
+
+func (ss *SpoolingSink) Emit(doc SSHDoc) error {
+	return ss.Inner.Emit(doc)
+}
+
+func (ss *SpoolingSink) Flush() error {
+	return ss.Inner.Flush()
+}
+
+func (ss *SpoolingSink) Close() error {
+	ss.closeOnce.Do(func() {
+		close(ss.done)
+	})
+	ss.wg.Wait()
+	err := ss.Inner.Close()
+	if spoolErr := ss.Spool.Close(); spoolErr != nil && err == nil {
+		err = spoolErr
+	}
+	return err
+}
+
+func (ss *SpoolingSink) replayLoop() {
+	defer ss.wg.Done()
+	ticker := time.NewTicker(ss.ReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ss.done:
+			return
+		case <-ticker.C:
+			ss.replay()
+		}
+	}
+}
+
+// Replays segments until the spool is empty, the inner sink fails, or the
+// sink is closed.
+func (ss *SpoolingSink) replay() {
+	for {
+		select {
+		case <-ss.done:
+			return
+		default:
+		}
+		segment, docs, ok, err := ss.Spool.Oldest()
+		if err != nil {
+			logrus.WithError(err).Errorln("Error reading spool")
+			return
+		}
+		if !ok {
+			return
+		}
+		remaining := []json.RawMessage{}
+		var deliverErr error
+		for start := 0; start < len(docs); start += ss.ReplayBatch {
+			end := start + ss.ReplayBatch
+			if end > len(docs) {
+				end = len(docs)
+			}
+			failed, err := ss.Inner.Deliver(docs[start:end])
+			if len(failed) > 0 {
+				deliverErr = err
+				remaining = append(remaining, failed...)
+				remaining = append(remaining, docs[end:]...)
+				break
+			}
+		}
+		if err := ss.Spool.Finish(segment, remaining); err != nil {
+			logrus.WithError(err).Errorln("Error updating spool segment")
+			return
+		}
+		if deliverErr != nil {
+			logrus.WithFields(logrus.Fields{
+				"remaining": len(remaining),
+				"err":       deliverErr,
+			}).Warnln("Sink still unavailable, will retry spool replay")
+			return
+		}
+		logrus.WithFields(logrus.Fields{
+			"segment": segment.Path,
+			"count":   len(docs),
+		}).Infoln("Replayed spool segment")
+	}
+}
+
+// Wraps sink in a SpoolingSink when SPOOL_DIR is set and the sink supports
+// replay. Each sink gets its own subdirectory of SPOOL_DIR.
+func maybeSpool(name string, sink EventSink) (EventSink, error) {
+	dir := envOrDefault("SPOOL_DIR", "")
+	if dir == "" {
+		return sink, nil
+	}
+	replayable, ok := sink.(ReplayableSink)
+	if !ok {
+		return sink, nil
+	}
+	spool, err := OpenSpool(
+		filepath.Join(dir, name),
+		int64(envIntOrDefault("SPOOL_SEGMENT_SIZE", 16*1024*1024)),
+		int64(envIntOrDefault("SPOOL_MAX_SIZE", 1024*1024*1024)),
+		envDurationOrDefault("SPOOL_MAX_AGE", 0),
+	)
+	if err != nil {
+		return nil, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"sink": name,
+		"dir":  spool.Dir,
+	}).Infoln("Spooling enabled for sink")
+	return newSpoolingSink(
+		replayable,
+		spool,
+		envPositiveDurationOrDefault("SPOOL_REPLAY_INTERVAL", 30*time.Second),
+		envPositiveIntOrDefault("SPOOL_REPLAY_BATCH", 500),
+	), nil
+}
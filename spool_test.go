@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func spoolDocs(from int, count int) []json.RawMessage {
+	docs := []json.RawMessage{}
+	for i := from; i < from+count; i++ {
+		docs = append(docs, json.RawMessage(fmt.Sprintf(`{"n":%d}`, i)))
+	}
+	return docs
+}
+
+func openTestSpool(t *testing.T, segmentSize int64, maxSize int64) *Spool {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	spool, err := OpenSpool(dir, segmentSize, maxSize, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spool
+}
+
+func TestSpoolReplaysInOrder(t *testing.T) {
+	tests := []struct {
+		name        string
+		segmentSize int64
+		appends     []int
+		wantSegs    int
+	}{
+		{"one segment", 1 << 20, []int{3, 2}, 1},
+		{"segment per append", 1, []int{3, 2, 4}, 3},
+	}
+	for _, test := range tests {
+		spool := openTestSpool(t, test.segmentSize, 0)
+		n := 0
+		for _, count := range test.appends {
+			if err := spool.Append(spoolDocs(n, count)); err != nil {
+				t.Fatal(err)
+			}
+			n += count
+		}
+		got := 0
+		segments := 0
+		for {
+			segment, docs, ok, err := spool.Oldest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			for _, doc := range docs {
+				if want := fmt.Sprintf(`{"n":%d}`, got); string(doc) != want {
+					t.Errorf("%s: document %d is %s, want %s", test.name, got, doc, want)
+				}
+				got++
+			}
+			segments++
+			if err := spool.Finish(segment, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got != n || segments != test.wantSegs {
+			t.Errorf("%s: replayed %d documents in %d segments, want %d in %d", test.name, got, segments, n, test.wantSegs)
+		}
+	}
+}
+
+func TestSpoolFinishKeepsRemaining(t *testing.T) {
+	spool := openTestSpool(t, 1<<20, 0)
+	spool.Append(spoolDocs(0, 5))
+	segment, docs, _, _ := spool.Oldest()
+	if err := spool.Finish(segment, docs[3:]); err != nil {
+		t.Fatal(err)
+	}
+	_, docs, ok, err := spool.Oldest()
+	if err != nil || !ok || len(docs) != 2 || string(docs[0]) != `{"n":3}` {
+		t.Errorf("after a partial replay the spool holds %s, want documents 3 and 4", docs)
+	}
+}
+
+func TestSpoolMaxSize(t *testing.T) {
+	docSize := int64(len(spoolDocs(0, 1)[0]) + 1)
+	tests := []struct {
+		name     string
+		maxSize  int64
+		appends  int
+		wantDocs int
+	}{
+		{"unlimited", 0, 5, 5},
+		{"within limit", 10 * docSize, 5, 5},
+		{"oldest dropped", 3 * docSize, 5, 3},
+	}
+	for _, test := range tests {
+		spool := openTestSpool(t, 1, test.maxSize)
+		for i := 0; i < test.appends; i++ {
+			spool.Append(spoolDocs(i, 1))
+		}
+		segments, err := spool.segments()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(segments) != test.wantDocs {
+			t.Errorf("%s: %d segments left, want %d", test.name, len(segments), test.wantDocs)
+		}
+	}
+}
+
+func TestSpoolKeepsSegmentBeingReplayed(t *testing.T) {
+	docSize := int64(len(spoolDocs(0, 1)[0]) + 1)
+	spool := openTestSpool(t, 1, 2*docSize)
+	spool.Append(spoolDocs(0, 1))
+	segment, _, ok, err := spool.Oldest()
+	if err != nil || !ok {
+		t.Fatal("no segment to replay", err)
+	}
+	for i := 1; i < 5; i++ {
+		spool.Append(spoolDocs(i, 1))
+	}
+	if _, err := os.Stat(segment.Path); err != nil {
+		t.Fatalf("segment being replayed was dropped: %v", err)
+	}
+	if err := spool.Finish(segment, spoolDocs(0, 1)); err != nil {
+		t.Fatalf("finishing the segment: %v", err)
+	}
+	spool.Append(spoolDocs(5, 1))
+	if _, err := os.Stat(segment.Path); !os.IsNotExist(err) {
+		t.Error("finished segment was not dropped once over the limit")
+	}
+}
+
+// replaySink is a ReplayableSink that delivers into a memorySink while up is
+// set.
+type replaySink struct {
+	memorySink
+	up        bool
+	onFailure func(docs []json.RawMessage, err error)
+	delivered []json.RawMessage
+}
+
+func (r *replaySink) Deliver(docs []json.RawMessage) ([]json.RawMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.up {
+		return docs, errors.New("down")
+	}
+	r.delivered = append(r.delivered, docs...)
+	return nil, nil
+}
+
+func (r *replaySink) SetFailureHandler(fn func(docs []json.RawMessage, err error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onFailure = fn
+}
+
+func TestSpoolingSinkReplays(t *testing.T) {
+	spool := openTestSpool(t, 1<<20, 0)
+	inner := &replaySink{}
+	ss := newSpoolingSink(inner, spool, 5*time.Millisecond, 2)
+	defer ss.Close()
+	inner.onFailure(spoolDocs(0, 5), errors.New("down"))
+	time.Sleep(20 * time.Millisecond)
+	inner.mu.Lock()
+	if len(inner.delivered) != 0 {
+		t.Error("documents were delivered while the sink was down")
+	}
+	inner.up = true
+	inner.mu.Unlock()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		inner.mu.Lock()
+		delivered := len(inner.delivered)
+		inner.mu.Unlock()
+		if delivered == 5 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("spooled documents were not replayed once the sink came back")
+}
+
+func TestMaybeSpoolSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		interval, batch string
+		wantInterval    time.Duration
+		wantBatch       int
+	}{
+		{"", "", 30 * time.Second, 500},
+		{"1s", "10", time.Second, 10},
+		{"0s", "0", 30 * time.Second, 500},
+		{"-1s", "-1", 30 * time.Second, 500},
+	}
+	for _, test := range tests {
+		t.Setenv("SPOOL_DIR", dir)
+		t.Setenv("SPOOL_REPLAY_INTERVAL", test.interval)
+		t.Setenv("SPOOL_REPLAY_BATCH", test.batch)
+		sink, err := maybeSpool("test", &replaySink{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ss := sink.(*SpoolingSink)
+		ss.Close()
+		if ss.ReplayInterval != test.wantInterval || ss.ReplayBatch != test.wantBatch {
+			t.Errorf("SPOOL_REPLAY_INTERVAL=%q SPOOL_REPLAY_BATCH=%q gave %s and %d, want %s and %d",
+				test.interval, test.batch, ss.ReplayInterval, ss.ReplayBatch, test.wantInterval, test.wantBatch)
+		}
+	}
+}
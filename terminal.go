@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/gliderlabs/ssh"
+	"github.com/honeystats/ssh/asciicast"
+)
+
+// Keep at most this many resizes per session, so a client can't grow the
+// session state without bound.
+const maxResizes = 100
+
+type WindowSize struct {
+	Width  int       `json:"width"`
+	Height int       `json:"height"`
+	Time   time.Time `json:"time"`
+}
+
+// Terminal is what the client told us about its terminal in the PTY request
+// and window changes since.
+type Terminal struct {
+	Term    string       `json:"term"`
+	Width   int          `json:"width"`
+	Height  int          `json:"height"`
+	Resizes []WindowSize `json:"resizes"`
+}
+
+type DocPtyRequest struct {
+	Term   string `json:"term"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+func (_ DocPtyRequest) action() string {
+	return "pty_request"
+}
+
+type DocWindowChange struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (_ DocWindowChange) action() string {
+	return "window_change"
+}
+
+// How command output should look for a session's terminal.
+type outputStyle struct {
+	// Width to lay out columns in. Zero means there's no terminal, so lists
+	// are printed one entry per line.
+	Width  int
+	Colour bool
+}
+
+// Records the PTY request, if the client made one, and follows window
+// changes for as long as the session lasts. Returns whether there's a PTY.
+func setupTerminal(s ssh.Session, state *SessionState, rec *Recording) bool {
+	ctx := s.Context().(ssh.Context)
+	pty, winCh, ok := s.Pty()
+	if !ok {
+		return false
+	}
+	state.setTerminal(pty.Term, pty.Window.Width, pty.Window.Height)
+	emitEvent(ctx, state, DocPtyRequest{
+		Term:   pty.Term,
+		Width:  pty.Window.Width,
+		Height: pty.Window.Height,
+	})
+	go func() {
+		first := true
+		for win := range winCh {
+			// The channel starts with the size from the PTY request.
+			if first {
+				first = false
+				continue
+			}
+			state.resize(win.Width, win.Height)
+			if rec != nil {
+				rec.record(asciicast.Resize, []byte(fmt.Sprintf("%dx%d", win.Width, win.Height)))
+			}
+			emitEvent(ctx, state, DocWindowChange{
+				Width:  win.Width,
+				Height: win.Height,
+			})
+		}
+	}()
+	return true
+}
+
+// Turns bare "\n" into "\r\n", as the tty line discipline does for output
+// when there's a PTY.
+type crlfWriter struct {
+	w      io.Writer
+	lastCR bool
+}
+
+func (c *crlfWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, 0, len(p)+bytes.Count(p, []byte{'\n'}))
+	for _, b := range p {
+		if b == '\n' && !c.lastCR {
+			buf = append(buf, '\r')
+		}
+		buf = append(buf, b)
+		c.lastCR = b == '\r'
+	}
+	if _, err := c.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Wraps text in colour escapes, but only for sessions with a terminal.
+// fatih/color decides globally from the server's own stdout, which says
+// nothing about the client's.
+func (style outputStyle) paint(text string, attrs ...color.Attribute) string {
+	if !style.Colour {
+		return text
+	}
+	c := color.New(attrs...)
+	c.EnableColor()
+	return c.Sprint(text)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestCrlfWriter(t *testing.T) {
+	tests := []struct {
+		writes []string
+		want   string
+	}{
+		{[]string{"a\nb\n"}, "a\r\nb\r\n"},
+		{[]string{"a\r\nb"}, "a\r\nb"},
+		{[]string{"a\r", "\nb"}, "a\r\nb"},
+		{[]string{"a", "\n", "\n"}, "a\r\n\r\n"},
+		{[]string{"\r\r\n"}, "\r\r\n"},
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		w := &crlfWriter{w: &out}
+		for _, write := range test.writes {
+			if n, err := w.Write([]byte(write)); n != len(write) || err != nil {
+				t.Errorf("Write(%q) = %d, %v", write, n, err)
+			}
+		}
+		if out.String() != test.want {
+			t.Errorf("%q became %q, want %q", test.writes, out.String(), test.want)
+		}
+	}
+}
+
+func TestFormatColumns(t *testing.T) {
+	plain := func(i int, name string) string { return name }
+	tests := []struct {
+		names []string
+		width int
+		want  string
+	}{
+		{nil, 80, ""},
+		{[]string{"a", "b", "c"}, 0, "a\nb\nc\n"},
+		{[]string{"a", "b", "c"}, 80, "a  b  c\n"},
+		{[]string{"one", "two", "three", "four"}, 9, "one\ntwo\nthree\nfour\n"},
+		{[]string{"one", "two", "three", "four"}, 10, "one  three\ntwo  four\n"},
+		{[]string{"one", "two", "three", "four"}, 21, "one  two  three  four\n"},
+		{[]string{"toolongforthescreen", "b"}, 10, "toolongforthescreen\nb\n"},
+		{[]string{"é", "b"}, 4, "é  b\n"},
+	}
+	for _, test := range tests {
+		if got := formatColumns(test.names, test.width, plain); got != test.want {
+			t.Errorf("formatColumns(%q, %d) = %q, want %q", test.names, test.width, got, test.want)
+		}
+	}
+	// Colour escapes don't count towards the width.
+	style := outputStyle{Width: 9, Colour: true}
+	got := formatColumns([]string{"dir", "file"}, style.Width, func(i int, name string) string {
+		if i == 0 {
+			return style.paint(name, color.FgBlue)
+		}
+		return name
+	})
+	if want := "\x1b[34mdir\x1b[0m  file\n"; got != want {
+		t.Errorf("painted columns %q, want %q", got, want)
+	}
+}
+
+func TestOutputStyle(t *testing.T) {
+	tests := []struct {
+		name  string
+		pty   bool
+		width int
+		want  outputStyle
+	}{
+		{"no pty", false, 0, outputStyle{}},
+		{"pty", true, 120, outputStyle{Width: 120, Colour: true}},
+		{"pty without a size", true, 0, outputStyle{Width: 80, Colour: true}},
+	}
+	for _, test := range tests {
+		state := newTestState()
+		if test.pty {
+			state.setTerminal("xterm", test.width, 24)
+		}
+		if got := state.outputStyle(); got != test.want {
+			t.Errorf("%s: style %+v, want %+v", test.name, got, test.want)
+		}
+		if got := state.outputStyle().paint("x", color.Bold); (got != "x") != test.want.Colour {
+			t.Errorf("%s: painted %q", test.name, got)
+		}
+	}
+}
+
+func TestResizesAreCapped(t *testing.T) {
+	state := newTestState()
+	state.resize(10, 10)
+	if state.Terminal != nil {
+		t.Fatal("resize without a PTY made a terminal")
+	}
+	state.setTerminal("xterm", 80, 24)
+	for i := 0; i < maxResizes+10; i++ {
+		state.resize(81+i, 24)
+	}
+	if len(state.Terminal.Resizes) != maxResizes {
+		t.Errorf("%d resizes kept, want %d", len(state.Terminal.Resizes), maxResizes)
+	}
+	if state.Terminal.Width != 80+maxResizes+10 {
+		t.Errorf("width %d, want the latest", state.Terminal.Width)
+	}
+}
+
+func TestTerminalEvents(t *testing.T) {
+	addr, sink := startTestServer(t)
+	client := dialTestServer(t, addr)
+	sink.mu.Lock()
+	before := len(sink.docs)
+	sink.mu.Unlock()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.RequestPty("vt100", 30, 100, gossh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+	stdin, _ := session.StdinPipe()
+	var stdout strings.Builder
+	session.Stdout = &stdout
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	// A window change that beats the handler to the PTY would be read as
+	// part of the request.
+	waitFor(t, "the PTY request", func() bool {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		for _, doc := range sink.docs[before:] {
+			if doc.Action == "pty_request" {
+				return true
+			}
+		}
+		return false
+	})
+	session.WindowChange(40, 120)
+	waitFor(t, "the window change", func() bool {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		for _, doc := range sink.docs[before:] {
+			if doc.Action == "window_change" {
+				return true
+			}
+		}
+		return false
+	})
+	stdin.Write([]byte("echo a; echo b\rexit\r"))
+	session.Wait()
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	var pty DocPtyRequest
+	var win DocWindowChange
+	for _, doc := range sink.docs[before:] {
+		switch fields := doc.Fields.(type) {
+		case DocPtyRequest:
+			pty = fields
+		case DocWindowChange:
+			win = fields
+		}
+	}
+	if pty != (DocPtyRequest{Term: "vt100", Width: 100, Height: 30}) {
+		t.Errorf("PTY request recorded as %+v", pty)
+	}
+	if win != (DocWindowChange{Width: 120, Height: 40}) {
+		t.Errorf("window change recorded as %+v", win)
+	}
+	if !strings.Contains(stdout.String(), "a\r\nb\r\n") {
+		t.Errorf("output %q doesn't use CRLF", stdout.String())
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/honeystats/ssh/files"
+)
+
+func init() {
+	registerCommand(exportCommand{commandSpec{
+		name:       "export",
+		builtin:    true,
+		completion: completeVariables,
+		help:       "export [-fn] [name[=value] ...] or export -p\nSet export attribute for shell variables.\n",
+	}})
+	registerCommand(unsetCommand{commandSpec{
+		name:       "unset",
+		builtin:    true,
+		completion: completeVariables,
+		help:       "unset [-f] [-v] [-n] [name ...]\nUnset values and attributes of shell variables and functions.\n",
+	}})
+	registerCommand(setCommand{commandSpec{
+		name:       "set",
+		builtin:    true,
+		completion: completeNothing,
+		help:       "set [-abefhkmnptuvxBCHP] [-o option-name] [--] [arg ...]\nSet or unset values of shell options and positional parameters.\n",
+	}})
+	registerCommand(envCommand{commandSpec{
+		name:       "env",
+		dir:        binDir,
+		completion: completeCommands,
+		help:       "env [OPTION]... [-] [NAME=VALUE]... [COMMAND [ARG]...]\nSet each NAME to VALUE in the environment and run COMMAND.\n",
+	}})
+	registerCommand(printenvCommand{commandSpec{
+		name:       "printenv",
+		dir:        binDir,
+		completion: completeVariables,
+		help:       "printenv [OPTION]... [VARIABLE]...\nPrint the values of the specified environment VARIABLE(s).\nIf no VARIABLE is specified, print name and value pairs for them all.\n",
+	}})
+}
+
+type exportCommand struct{ commandSpec }
+
+func (exportCommand) Run(inv *Invocation) int {
+	return inv.result(runExport(inv.State, inv.Args))
+}
+
+type unsetCommand struct{ commandSpec }
+
+func (unsetCommand) Run(inv *Invocation) int {
+	return inv.result(runUnset(inv.State, inv.Args))
+}
+
+type setCommand struct{ commandSpec }
+
+func (setCommand) Run(inv *Invocation) int {
+	return inv.result(runSet(inv.State, inv.Args))
+}
+
+type envCommand struct{ commandSpec }
+
+func (envCommand) Run(inv *Invocation) int {
+	return runEnv(inv)
+}
+
+type printenvCommand struct{ commandSpec }
+
+func (printenvCommand) Run(inv *Invocation) int {
+	return inv.result(runPrintenv(inv.Env, inv.Args))
+}
+
+// A shell variable. Variables can be exported before they have a value, as
+// OLDPWD is in a new login shell.
+type shellVar struct {
+	value    string
+	set      bool
+	exported bool
+}
+
+// Ubuntu's defaults from /etc/environment and /root/.profile.
+const (
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin:/usr/games:/usr/local/games:/snap/bin"
+	rootPath    = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin:/snap/bin"
+	defaultPS1  = `\[\e[92m\]\u@\h\[\e[0m\]:\[\e[94m\]\w\[\e[0m\]\[\e[37m\]\$ \[\e[0m\]`
+)
+
+// Gives the session the variables a login shell for user would start with,
+// unless it already has them.
+func (state *SessionState) seedVars(user string, interactive bool) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.vars != nil {
+		return
+	}
+	home, loginShell := passwdEntry(state.Root, user)
+	path := defaultPath
+	if user == "root" {
+		path = rootPath
+	}
+	state.vars = map[string]*shellVar{}
+	exported := map[string]string{
+		"HOME":    home,
+		"USER":    user,
+		"LOGNAME": user,
+		"SHELL":   loginShell,
+		"PATH":    path,
+		"PWD":     state.Pwd,
+		"LANG":    "C.UTF-8",
+		"SHLVL":   "1",
+	}
+	if state.Terminal != nil {
+		exported["TERM"] = state.Terminal.Term
+	}
+	for name, value := range exported {
+		state.vars[name] = &shellVar{value: value, set: true, exported: true}
+	}
+	state.vars["OLDPWD"] = &shellVar{exported: true}
+	if interactive {
+		state.vars["PS1"] = &shellVar{value: defaultPS1, set: true}
+	}
+}
+
+// Finds user's home directory and shell in the fake /etc/passwd, falling
+// back to the usual ones.
+func passwdEntry(root *files.FilesystemDir, user string) (string, string) {
+	home := "/home/" + user
+	if user == "root" {
+		home = "/root"
+	}
+	loginShell := "/bin/bash"
+	err, f := root.GetFileOrDir(root, "/etc/passwd")
+	if err != nil {
+		return home, loginShell
+	}
+	file, ok := f.(*files.FilesystemFile)
+	if !ok {
+		return home, loginShell
+	}
+	for _, line := range strings.Split(file.Content, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) == 7 && fields[0] == user {
+			return fields[5], fields[6]
+		}
+	}
+	return home, loginShell
+}
+
+func (state *SessionState) getVar(name string) (string, bool) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	v, exists := state.vars[name]
+	if !exists || !v.set {
+		return "", false
+	}
+	return v.value, true
+}
+
+func (state *SessionState) setVar(name string, value string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.setVarLocked(name, value)
+}
+
+func (state *SessionState) setVarLocked(name string, value string) {
+	if state.vars == nil {
+		state.vars = map[string]*shellVar{}
+	}
+	if v, exists := state.vars[name]; exists {
+		v.value = value
+		v.set = true
+		return
+	}
+	state.vars[name] = &shellVar{value: value, set: true}
+}
+
+// Marks a variable exported, creating it without a value if need be.
+func (state *SessionState) exportVar(name string, exported bool) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.vars == nil {
+		state.vars = map[string]*shellVar{}
+	}
+	if v, exists := state.vars[name]; exists {
+		v.exported = exported
+		return
+	}
+	if exported {
+		state.vars[name] = &shellVar{exported: true}
+	}
+}
+
+func (state *SessionState) unsetVar(name string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	delete(state.vars, name)
+}
+
+// The session's variables by name, copied so they can be put back after a
+// subshell.
+func (state *SessionState) copyVars() map[string]*shellVar {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	vars := map[string]*shellVar{}
+	for name, v := range state.vars {
+		copied := *v
+		vars[name] = &copied
+	}
+	return vars
+}
+
+func (state *SessionState) restoreVars(vars map[string]*shellVar) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.vars = vars
+}
+
+// Names of variables, sorted, picked by keep.
+func (state *SessionState) varNames(keep func(v *shellVar) bool) []string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	names := []string{}
+	for name, v := range state.vars {
+		if keep(v) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// An environment as NAME=value lines, sorted, as env prints it.
+func environ(env map[string]string) string {
+	names := []string{}
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := ""
+	for _, name := range names {
+		out += fmt.Sprintf("%s=%s\n", name, env[name])
+	}
+	return out
+}
+
+var plainWord = regexp.MustCompile(`^[A-Za-z0-9_./:@%+=,-]+$`)
+
+// Quotes a value the way set and export -p print it.
+func shellQuote(value string) string {
+	if plainWord.MatchString(value) {
+		return value
+	}
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func runExport(state *SessionState, args []string) CmdResult {
+	unexport := false
+	names := []string{}
+	for _, arg := range args {
+		switch arg {
+		case "-n":
+			unexport = true
+		case "-p", "-f", "--":
+		default:
+			names = append(names, arg)
+		}
+	}
+	if len(names) == 0 {
+		out := ""
+		for _, name := range state.varNames(func(v *shellVar) bool { return v.exported }) {
+			value, set := state.getVar(name)
+			if set {
+				out += fmt.Sprintf("declare -x %s=\"%s\"\n", name, value)
+			} else {
+				out += fmt.Sprintf("declare -x %s\n", name)
+			}
+		}
+		return CmdResult{Stdout: out}
+	}
+	res := CmdResult{}
+	for _, arg := range names {
+		name, value := arg, ""
+		hasValue := false
+		if eq := strings.IndexByte(arg, '='); eq >= 0 {
+			name, value, hasValue = arg[:eq], arg[eq+1:], true
+		}
+		if !varName.MatchString(name) {
+			res.Stderr += fmt.Sprintf("bash: export: `%s': not a valid identifier\n", arg)
+			res.ExitCode = 1
+			continue
+		}
+		if hasValue {
+			state.setVar(name, value)
+		}
+		state.exportVar(name, !unexport)
+	}
+	return res
+}
+
+func runUnset(state *SessionState, args []string) CmdResult {
+	res := CmdResult{}
+	for _, name := range args {
+		if name == "-v" || name == "-f" {
+			continue
+		}
+		if !varName.MatchString(name) {
+			res.Stderr += fmt.Sprintf("bash: unset: `%s': not a valid identifier\n", name)
+			res.ExitCode = 1
+			continue
+		}
+		state.unsetVar(name)
+	}
+	return res
+}
+
+func runPrintenv(env map[string]string, args []string) CmdResult {
+	if len(args) == 0 {
+		return CmdResult{Stdout: environ(env)}
+	}
+	res := CmdResult{}
+	for _, name := range args {
+		value, set := env[name]
+		if !set {
+			res.ExitCode = 1
+			continue
+		}
+		res.Stdout += value + "\n"
+	}
+	return res
+}
+
+// set with no arguments lists every variable. Options are accepted and
+// ignored.
+func runSet(state *SessionState, args []string) CmdResult {
+	if len(args) > 0 {
+		return CmdResult{}
+	}
+	out := ""
+	for _, name := range state.varNames(func(v *shellVar) bool { return v.set }) {
+		value, _ := state.getVar(name)
+		out += fmt.Sprintf("%s=%s\n", name, shellQuote(value))
+	}
+	return CmdResult{Stdout: out}
+}
+
+// Runs fn with the given variables set and exported, then puts them back as
+// they were.
+func withVars(state *SessionState, assigns map[string]string, fn func() CmdResult) CmdResult {
+	if len(assigns) == 0 {
+		return fn()
+	}
+	state.mu.Lock()
+	saved := map[string]*shellVar{}
+	for name, value := range assigns {
+		if v, exists := state.vars[name]; exists {
+			copied := *v
+			saved[name] = &copied
+		}
+		state.setVarLocked(name, value)
+		state.vars[name].exported = true
+	}
+	state.mu.Unlock()
+	res := fn()
+	state.mu.Lock()
+	for name := range assigns {
+		if v, exists := saved[name]; exists {
+			state.vars[name] = v
+		} else {
+			delete(state.vars, name)
+		}
+	}
+	state.mu.Unlock()
+	return res
+}
+
+// env prints the environment, or runs a command with variables added to
+// it.
+func runEnv(inv *Invocation) int {
+	args := inv.Args
+	assigns := map[string]string{}
+	for name, value := range inv.Env {
+		assigns[name] = value
+	}
+	for len(args) > 0 {
+		arg := args[0]
+		if eq := strings.IndexByte(arg, '='); eq > 0 {
+			assigns[arg[:eq]] = arg[eq+1:]
+		} else if arg != "-" && arg != "-i" && arg != "--" {
+			break
+		}
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		if _, ok := findCommand(inv.State, args[0]); !ok {
+			fmt.Fprintf(inv.Stderr, "env: '%s': No such file or directory\n", args[0])
+			return 127
+		}
+	}
+	if len(args) == 0 {
+		io.WriteString(inv.Stdout, environ(assigns))
+		return 0
+	}
+	st := streams{stdin: inv.Stdin, stdout: inv.Stdout, stderr: inv.Stderr}
+	// A program can't end the shell that started env.
+	status, _ := runCmd(inv.Ctx, inv.State, args, st, assigns)
+	return status
+}